@@ -0,0 +1,63 @@
+package main
+
+import (
+    "strings"
+)
+
+// Accessible mode (-accessible or accessible = true) is for screen readers: no
+// status bar, colors or redrawn lines. pianobar's output is passed on as whole
+// lines without its decorations, leaving out the countdown, and pianotrap
+// announces recordings in plain sentences such as "Now recording: X by Y".
+
+// accessible is set from Config.Accessible on startup
+var accessible bool
+
+// announcements are the events spoken in accessible mode; notices already cover
+// the others
+var announcements = map[string]string{
+    eventRecording: "Now recording",
+    eventSaved:     "Saved",
+}
+
+// pianobarMarkers start pianobar's lines; read aloud they are only noise
+var pianobarMarkers = []string{"|>", "(i)", "[?]", "/!\\", "#"}
+
+// accessibleLine is how a line of pianobar's output is announced; countdown
+// lines and empty ones are left out
+func accessibleLine(line string) string {
+    line = strings.TrimSpace(line)
+    if countdownRe.MatchString(line) {
+        return ""
+    }
+    for _, marker := range pianobarMarkers {
+        if strings.HasPrefix(line, marker) {
+            line = strings.TrimSpace(strings.TrimPrefix(line, marker))
+            break
+        }
+    }
+    return line
+}
+
+// promptAnnounced is set once the prompt on the current line was read out, so
+// typing an answer doesn't repeat it
+var promptAnnounced bool
+
+// announceOutput passes pianobar's finished lines, and prompts waiting for an
+// answer, on in accessible mode
+func announceOutput(lines []string, current string, changed bool) {
+    if !accessible || quiet {
+        return
+    }
+    for _, line := range lines {
+        if text := accessibleLine(line); text != "" {
+            plainLine(text)
+        }
+    }
+    if len(lines) > 0 {
+        promptAnnounced = false
+    }
+    if changed && !promptAnnounced && strings.Contains(current, "[?]") {
+        promptAnnounced = true
+        plainLine(accessibleLine(current))
+    }
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestAccessibleLine(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {`|>  "Weightless" by "Marconi Union" on "Weightless" <3`, `"Weightless" by "Marconi Union" on "Weightless" <3`},
+        {"(i) Login... Ok.", "Login... Ok."},
+        {"[?] Select station: ", "Select station:"},
+        {"#   -03:46/03:48", ""},
+        {"   ", ""},
+        {"\t 0) q   Deep Focus", "0) q   Deep Focus"},
+    }
+    for _, tt := range tests {
+        if got := accessibleLine(tt.in); got != tt.want {
+            t.Errorf("accessibleLine(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// Spoken announcements ("Recorded from Deep Focus, June 5th") synthesized with
+// espeak or piper, prepended to mixtape tracks and, optionally, to archive copies of
+// each recording.
+
+// announcer holds the text-to-speech settings
+type announcer struct {
+    Engine   string
+    Voice    string
+    Template string
+}
+
+// loadAnnounceConfig reads announce (espeak or piper), announce_voice,
+// announce_template and announce_archive
+func loadAnnounceConfig(values map[string]string, cfg *Config) error {
+    cfg.Announce = announcer{
+        Engine:   values["announce"],
+        Voice:    values["announce_voice"],
+        Template: values["announce_template"],
+    }
+    if cfg.Announce.Template == "" {
+        cfg.Announce.Template = "Recorded from {station}, {date}"
+    }
+    switch cfg.Announce.Engine {
+    case "", "espeak":
+    case "piper":
+        if cfg.Announce.Voice == "" {
+            return fmt.Errorf("announce = piper needs announce_voice set to a voice model")
+        }
+    default:
+        return fmt.Errorf("invalid value for announce: %q (want espeak or piper)", cfg.Announce.Engine)
+    }
+    cfg.AnnounceArchive = values["announce_archive"]
+    if cfg.AnnounceArchive != "" && cfg.Announce.Engine == "" {
+        return fmt.Errorf("announce_archive needs announce set to espeak or piper")
+    }
+    return nil
+}
+
+// text fills the announcement template for a recording
+func (a announcer) text(e playlistEntry) string {
+    return strings.NewReplacer(
+        "{station}", e.Station,
+        "{date}", e.Detected.Local().Format("January 2"),
+        "{title}", e.Title,
+        "{artist}", e.Artist,
+    ).Replace(a.Template)
+}
+
+// synthesize speaks text into a WAV file
+func (a announcer) synthesize(text, wavFile string) error {
+    var cmd *exec.Cmd
+    switch a.Engine {
+    case "piper":
+        cmd = exec.Command("piper", "--model", a.Voice, "--output_file", wavFile)
+        cmd.Stdin = strings.NewReader(text)
+    default:
+        engine := "espeak-ng"
+        if _, err := exec.LookPath(engine); err != nil {
+            engine = "espeak"
+        }
+        args := []string{"-w", wavFile}
+        if a.Voice != "" {
+            args = append(args, "-v", a.Voice)
+        }
+        cmd = exec.Command(engine, append(args, text)...)
+    }
+    if out, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("%s failed: %v: %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+// archive is where recordings are copied with their announcement while pianotrap
+// runs; dir is empty when archive copies are disabled
+var archive struct {
+    announcer
+    saveDir string
+    dir     string
+}
+
+// archiveAnnounced writes a copy of a saved recording with its announcement in front,
+// keeping its path relative to the save directory
+func archiveAnnounced(e playlistEntry) error {
+    rel, err := filepath.Rel(archive.saveDir, e.File)
+    if err != nil || strings.HasPrefix(rel, "..") {
+        rel = filepath.Join(e.Station, filepath.Base(e.File))
+    }
+    output := filepath.Join(archive.dir, rel)
+    if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+        return fmt.Errorf("failed to create archive directory: %v", err)
+    }
+
+    dir, err := ioutil.TempDir("", "pianotrap-announce")
+    if err != nil {
+        return fmt.Errorf("failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dir)
+    intro := filepath.Join(dir, "intro.wav")
+    if err := archive.synthesize(archive.text(e), intro); err != nil {
+        return err
+    }
+
+    tmp := output + ".tmp" + filepath.Ext(output)
+    cmd := exec.Command(ffmpegBinary(), "-v", "error", "-i", intro, "-i", e.File,
+        "-filter_complex", "[0:a]"+mixFormat+"[i];[1:a]"+mixFormat+"[s];[i][s]concat=n=2:v=0:a=1[out]",
+        "-map", "[out]", "-map_metadata", "1", "-y", tmp)
+    if out, err := cmd.CombinedOutput(); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("ffmpeg failed to write %s: %v: %s", output, err, strings.TrimSpace(string(out)))
+    }
+    return os.Rename(tmp, output)
+}
+
+// mixFormat brings speech and music to a common format before they are joined
+const mixFormat = "aresample=44100,aformat=sample_fmts=fltp:channel_layouts=stereo"
+
+// archiveRecording makes the announced archive copy of a recording detected at
+// detected, when configured
+func archiveRecording(fileName string, tags Tags, detected time.Time) error {
+    if archive.dir == "" {
+        return errNotConfigured
+    }
+    e := playlistEntry{File: fileName, Title: tags.Title, Station: tags.Custom["STATION"], Detected: detected}
+    if len(tags.Artists) > 0 {
+        e.Artist = tags.Artists[0]
+    }
+    if err := archiveAnnounced(e); err != nil {
+        return fmt.Errorf("announced copy of %s: %v", fileName, err)
+    }
+    return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+    "crypto/subtle"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// With http_listen set, pianotrap serves a small JSON API for scripts and phones:
+//
+//     GET  /api/status                  what is playing and whether it's recorded
+//     GET  /api/recordings?limit=20     the most recent songs, newest first
+//     POST /api/skip, /api/pause, /api/love
+//     POST /api/station?name=Jazz       switch to the best matching station
+//     POST /api/recording?enabled=false pause or resume recording; toggles without enabled
+//     POST /api/keep, /api/discard      the keep and discard keys
+//     GET  /api/stats                   what this session recorded
+//     GET  /api/cover                   the cover art of the song playing
+//     GET  /                            a dashboard using all of the above
+//
+// Controls type into pianobar like the MQTT commands do. The same address serves
+// them over gRPC too, see grpc.go. With http_token or http_username and
+// http_password set, every request needs them, the dashboard's included: the token
+// as a bearer token or as the password of a browser's login prompt, the user name
+// and password with basic authentication. Browsers may only POST from pages the API
+// served itself, so that no other site can press the keys.
+
+// loadHTTPConfig reads the http_* options
+func loadHTTPConfig(values map[string]string, cfg *Config) error {
+    cfg.HTTPListen = values["http_listen"]
+    if cfg.HTTPListen != "" {
+        if _, _, err := net.SplitHostPort(cfg.HTTPListen); err != nil {
+            return fmt.Errorf("invalid value for http_listen: %q (want host:port, e.g. 127.0.0.1:8337)", cfg.HTTPListen)
+        }
+    }
+    cfg.HTTPMDNS = values["http_mdns"] != "false"
+    cfg.HTTPToken = values["http_token"]
+    cfg.HTTPUsername = values["http_username"]
+    cfg.HTTPPassword = values["http_password"]
+    if (cfg.HTTPUsername == "") != (cfg.HTTPPassword == "") {
+        return fmt.Errorf("http_username and http_password go together")
+    }
+    return nil
+}
+
+// secureCompare compares a credential without giving away through timing how much
+// of it matched
+func secureCompare(given, want string) bool {
+    return want != "" && subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// authorized reports whether r carries the credentials cfg asks for, if any
+func authorized(cfg Config, r *http.Request) bool {
+    if cfg.HTTPToken == "" && cfg.HTTPUsername == "" {
+        return true
+    }
+    if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureCompare(token, cfg.HTTPToken) {
+        return true
+    }
+    username, password, ok := r.BasicAuth()
+    if !ok {
+        return false
+    }
+    // Both are compared either way, so timing doesn't tell which was wrong
+    user := secureCompare(username, cfg.HTTPUsername)
+    pass := secureCompare(password, cfg.HTTPPassword)
+    return user && pass || secureCompare(password, cfg.HTTPToken)
+}
+
+// requireAuth turns away requests without the credentials of the config in use, so
+// that changing them takes effect on reload
+func requireAuth(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if authorized(currentConfig(), r) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+            w.Header().Set("Content-Type", "application/grpc")
+            writeGRPCStatus(w, &grpcError{grpcUnauthenticated, "missing or wrong credentials"})
+            return
+        }
+        w.Header().Set("WWW-Authenticate", `Basic realm="pianotrap", charset="UTF-8"`)
+        writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong credentials"))
+    })
+}
+
+// sameOrigin turns away requests that change something when a browser sends them
+// from another site's page. Without it any page could make the browser POST to
+// the API, with the credentials it remembers for it.
+func sameOrigin(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "GET" || r.Method == "HEAD" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if origin := r.Header.Get("Origin"); origin != "" {
+            if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+                writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %s", origin))
+                return
+            }
+        } else if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+            writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// apiRecording is a song of the database as the API returns it
+type apiRecording struct {
+    ID       int64      `json:"id"`
+    Title    string     `json:"title"`
+    Artist   string     `json:"artist"`
+    Album    string     `json:"album"`
+    Station  string     `json:"station"`
+    Loved    bool       `json:"loved"`
+    Detected time.Time  `json:"detected_at"`
+    Finished *time.Time `json:"finished_at,omitempty"`
+    File     string     `json:"file,omitempty"`
+    Outcome  string     `json:"outcome"`
+}
+
+// writeJSON sends v as the response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends err as a JSON error response
+func writeError(w http.ResponseWriter, code int, err error) {
+    writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// apiHandler serves the API
+func apiHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, currentStatus())
+    })
+    mux.HandleFunc("GET /api/recordings", handleRecordings)
+    mux.HandleFunc("GET /api/stats", handleStats)
+    mux.HandleFunc("GET /api/cover", handleCover)
+    mux.HandleFunc("GET /{$}", handleDashboard)
+    mux.HandleFunc("POST /"+grpcService+"/{method}", handleGRPC)
+    for name, keys := range mqttCommands {
+        mux.HandleFunc("POST /api/"+name, func(w http.ResponseWriter, r *http.Request) {
+            if err := sendKeys(keys); err != nil {
+                writeError(w, http.StatusServiceUnavailable, err)
+                return
+            }
+            writeJSON(w, http.StatusOK, currentStatus())
+        })
+    }
+    mux.HandleFunc("POST /api/station", func(w http.ResponseWriter, r *http.Request) {
+        name := strings.TrimSpace(r.FormValue("name"))
+        if name == "" {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("missing station name"))
+            return
+        }
+        if err := switchStation(name); err != nil {
+            writeError(w, http.StatusServiceUnavailable, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, currentStatus())
+    })
+    mux.HandleFunc("POST /api/recording", func(w http.ResponseWriter, r *http.Request) {
+        if raw := r.FormValue("enabled"); raw != "" {
+            enabled, err := strconv.ParseBool(raw)
+            if err != nil {
+                writeError(w, http.StatusBadRequest, fmt.Errorf("invalid value for enabled: %q", raw))
+                return
+            }
+            setCapture(enabled)
+        } else {
+            toggleCapture()
+        }
+        writeJSON(w, http.StatusOK, currentStatus())
+    })
+    mux.HandleFunc("POST /api/keep", func(w http.ResponseWriter, r *http.Request) {
+        keepCurrent()
+        writeJSON(w, http.StatusOK, currentStatus())
+    })
+    mux.HandleFunc("POST /api/discard", func(w http.ResponseWriter, r *http.Request) {
+        discardCurrent()
+        writeJSON(w, http.StatusOK, currentStatus())
+    })
+    return requireAuth(sameOrigin(mux))
+}
+
+// setCapture pauses or resumes recording unless it already is
+func setCapture(enabled bool) {
+    if enabled == captureIsPaused() {
+        toggleCapture()
+    }
+}
+
+// recentSongs returns the songs matching f, newest first
+func recentSongs(f songFilter) ([]songRecord, error) {
+    songs, err := querySongs(f)
+    for i, j := 0, len(songs)-1; i < j; i, j = i+1, j-1 {
+        songs[i], songs[j] = songs[j], songs[i]
+    }
+    return songs, err
+}
+
+// handleRecordings lists the most recent songs of the database, newest first
+func handleRecordings(w http.ResponseWriter, r *http.Request) {
+    if db == nil {
+        writeError(w, http.StatusServiceUnavailable, fmt.Errorf("song history is disabled"))
+        return
+    }
+    limit := 20
+    if raw := r.FormValue("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n < 1 || n > 1000 {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q (1-1000)", raw))
+            return
+        }
+        limit = n
+    }
+    songs, err := recentSongs(songFilter{Outcome: r.FormValue("outcome"), Station: r.FormValue("station"), Limit: limit})
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err)
+        return
+    }
+    recordings := []apiRecording{}
+    for _, s := range songs {
+        rec := apiRecording{s.ID, s.Title, s.Artist, s.Album, s.Station, s.Loved, s.DetectedAt, nil, s.File, s.Outcome}
+        if s.FinishedAt.Valid {
+            rec.Finished = &s.FinishedAt.Time
+        }
+        recordings = append(recordings, rec)
+    }
+    writeJSON(w, http.StatusOK, recordings)
+}
+
+// isLoopback reports whether host only accepts connections from this machine
+func isLoopback(host string) bool {
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// startHTTP serves the API for the rest of the session
+func startHTTP(cfg Config) {
+    if cfg.HTTPListen == "" {
+        return
+    }
+    var cert tls.Certificate
+    if cfg.httpsEnabled() {
+        var err error
+        if cert, err = apiCertificate(cfg); err != nil {
+            fmt.Printf("\r\nWarning: HTTP API unavailable: %v\r\n", err)
+            logger.Printf("HTTP API unavailable: %v", err)
+            return
+        }
+    }
+    listener, err := net.Listen("tcp", cfg.HTTPListen)
+    if err != nil {
+        fmt.Printf("\r\nWarning: HTTP API unavailable: %v\r\n", err)
+        logger.Printf("HTTP API unavailable: %v", err)
+        return
+    }
+    if cfg.httpsEnabled() {
+        logger.Printf("HTTP API listening on %s with HTTPS, certificate SHA-256 %s", listener.Addr(), certificateFingerprint(cert))
+    } else {
+        logger.Printf("HTTP API listening on %s", listener.Addr())
+    }
+    startMDNS(cfg, listener.Addr().(*net.TCPAddr).Port)
+    if host, _, _ := net.SplitHostPort(cfg.HTTPListen); cfg.HTTPToken == "" && cfg.HTTPUsername == "" && !isLoopback(host) {
+        fmt.Printf("\r\nWarning: the HTTP API on %s has no http_token or http_password; anyone on the network can control pianobar\r\n", cfg.HTTPListen)
+        logger.Printf("HTTP API on %s has no credentials set", cfg.HTTPListen)
+    }
+    httpStarted = time.Now()
+    server := &http.Server{Handler: apiHandler(), ReadHeaderTimeout: 10 * time.Second, Protocols: new(http.Protocols)}
+    server.Protocols.SetHTTP1(true)
+    if cfg.httpsEnabled() {
+        server.Protocols.SetHTTP2(true)
+        server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+        go server.ServeTLS(listener, "", "")
+        return
+    }
+    // gRPC clients connect with HTTP/2 without TLS
+    server.Protocols.SetUnencryptedHTTP2(true)
+    go server.Serve(listener)
+}
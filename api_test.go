@@ -0,0 +1,151 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestAPI(t *testing.T) {
+    handler := apiHandler()
+    request := func(method, target string) (int, string) {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+        return rec.Code, rec.Body.String()
+    }
+
+    if code, body := request("GET", "/api/recordings"); code != http.StatusServiceUnavailable {
+        t.Errorf("recordings without a database = %d %s", code, body)
+    }
+    openTestDB(t)
+    logDetectedSong(songInfo{Title: "One", Artist: "Alpha"}, "Jazz Radio", "/music/1.mp3", outcomeSaved)
+    logDetectedSong(songInfo{Title: "Two", Artist: "Beta"}, "Rock Radio", "/music/2.mp3", outcomeSaved)
+    logDetectedSong(songInfo{Title: "Three", Artist: "Gamma"}, "Rock Radio", "/music/3.mp3", outcomeSaved)
+
+    code, body := request("GET", "/api/recordings?limit=2")
+    var recordings []apiRecording
+    if err := json.Unmarshal([]byte(body), &recordings); err != nil || code != http.StatusOK {
+        t.Fatalf("recordings = %d %s", code, body)
+    }
+    if len(recordings) != 2 || recordings[0].Title != "Three" || recordings[1].Title != "Two" {
+        t.Errorf("recent recordings = %+v", recordings)
+    }
+    if code, _ := request("GET", "/api/recordings?limit=0"); code != http.StatusBadRequest {
+        t.Errorf("limit=0 answered %d", code)
+    }
+
+    code, body = request("GET", "/api/stats")
+    var stats apiStats
+    if err := json.Unmarshal([]byte(body), &stats); err != nil || code != http.StatusOK || stats.Outcomes[outcomeSaved] != 3 {
+        t.Errorf("stats = %d %s", code, body)
+    }
+    if code, _ := request("GET", "/api/cover"); code != http.StatusNotFound {
+        t.Errorf("cover with nothing playing answered %d", code)
+    }
+    if code, body := request("GET", "/"); code != http.StatusOK || !strings.Contains(body, "/api/status") {
+        t.Errorf("dashboard = %d", code)
+    }
+
+    code, body = request("GET", "/api/status")
+    var status playerStatus
+    if err := json.Unmarshal([]byte(body), &status); err != nil || code != http.StatusOK || status.State != "idle" {
+        t.Errorf("status = %d %s", code, body)
+    }
+    art := playTestSong(t, "So What", "Miles Davis")
+    if code, body := request("GET", "/api/cover"); code != http.StatusOK || body != string(art) {
+        t.Errorf("cover = %d %q", code, body)
+    }
+    if code, body := request("POST", "/api/skip"); code != http.StatusServiceUnavailable || !strings.Contains(body, "not running") {
+        t.Errorf("skip without pianobar = %d %s", code, body)
+    }
+    // A form on another site's page must not press keys
+    for origin, want := range map[string]int{"http://evil.example": http.StatusForbidden, "null": http.StatusForbidden, "http://example.com": http.StatusOK} {
+        rec := httptest.NewRecorder()
+        req := httptest.NewRequest("POST", "/api/keep", strings.NewReader("x=1"))
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+        req.Header.Set("Origin", origin)
+        handler.ServeHTTP(rec, req)
+        if rec.Code != want {
+            t.Errorf("POST from %s answered %d, want %d", origin, rec.Code, want)
+        }
+    }
+    rec := httptest.NewRecorder()
+    req := httptest.NewRequest("POST", "/api/keep", nil)
+    req.Header.Set("Sec-Fetch-Site", "cross-site")
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusForbidden {
+        t.Errorf("cross-site POST without Origin answered %d", rec.Code)
+    }
+    if code, _ := request("GET", "/api/skip"); code != http.StatusMethodNotAllowed {
+        t.Errorf("GET /api/skip answered %d", code)
+    }
+    if code, _ := request("POST", "/api/station"); code != http.StatusBadRequest {
+        t.Errorf("station without a name answered %d", code)
+    }
+
+    defer func(paused bool) { capturePaused = paused }(capturePaused)
+    capturePaused = false
+    for _, tt := range []struct {
+        target string
+        paused bool
+    }{
+        {"/api/recording?enabled=false", true},
+        {"/api/recording?enabled=false", true},
+        {"/api/recording", false},
+        {"/api/recording?enabled=true", false},
+    } {
+        if code, body := request("POST", tt.target); code != http.StatusOK || captureIsPaused() != tt.paused {
+            t.Errorf("%s = %d %s, paused %v", tt.target, code, body, captureIsPaused())
+        }
+    }
+}
+
+func TestAPIAuth(t *testing.T) {
+    old := currentConfig()
+    defer func() {
+        mu.Lock()
+        liveConfig = old
+        mu.Unlock()
+    }()
+    mu.Lock()
+    liveConfig.HTTPToken = "s3cret"
+    liveConfig.HTTPUsername, liveConfig.HTTPPassword = "me", "hunter2"
+    mu.Unlock()
+
+    handler := apiHandler()
+    for _, tt := range []struct {
+        target, contentType string
+        auth                func(r *http.Request)
+        code                int
+    }{
+        {"/api/status", "", func(r *http.Request) {}, http.StatusUnauthorized},
+        {"/", "", func(r *http.Request) {}, http.StatusUnauthorized},
+        {"/api/status", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+        {"/api/status", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+        {"/api/status", "", func(r *http.Request) { r.SetBasicAuth("me", "hunter2") }, http.StatusOK},
+        {"/api/status", "", func(r *http.Request) { r.SetBasicAuth("you", "hunter2") }, http.StatusUnauthorized},
+        {"/", "", func(r *http.Request) { r.SetBasicAuth("anyone", "s3cret") }, http.StatusOK},
+        {"/" + grpcService + "/GetStatus", "application/grpc", func(r *http.Request) {}, http.StatusOK},
+    } {
+        rec := httptest.NewRecorder()
+        method := "GET"
+        if tt.contentType != "" {
+            method = "POST"
+        }
+        req := httptest.NewRequest(method, tt.target, nil)
+        req.Header.Set("Content-Type", tt.contentType)
+        tt.auth(req)
+        handler.ServeHTTP(rec, req)
+        if rec.Code != tt.code {
+            t.Errorf("%s %s with %q = %d, want %d", method, tt.target, req.Header.Get("Authorization"), rec.Code, tt.code)
+        }
+        if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+            t.Errorf("%s: no WWW-Authenticate", tt.target)
+        }
+        if status := rec.Result().Trailer.Get("Grpc-Status"); tt.contentType != "" && status != "16" {
+            t.Errorf("unauthenticated gRPC call: grpc-status %q", status)
+        }
+    }
+}
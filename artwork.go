@@ -0,0 +1,63 @@
+package main
+
+import (
+    "crypto/sha1"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "time"
+)
+
+// fetchCoverArt downloads the artwork at artURL into the cache directory, reusing a
+// previously downloaded copy when present
+func fetchCoverArt(artURL string) (string, error) {
+    cacheDir, err := os.UserCacheDir()
+    if err != nil {
+        return "", fmt.Errorf("failed to locate cache directory: %v", err)
+    }
+    artDir := filepath.Join(cacheDir, "pianotrap", "art")
+    if err := os.MkdirAll(artDir, 0755); err != nil {
+        return "", fmt.Errorf("failed to create art cache: %v", err)
+    }
+
+    ext := ".jpg"
+    if u, err := url.Parse(artURL); err == nil && path.Ext(u.Path) != "" {
+        ext = path.Ext(u.Path)
+    }
+    artFile := filepath.Join(artDir, fmt.Sprintf("%x%s", sha1.Sum([]byte(artURL)), ext))
+    if info, err := os.Stat(artFile); err == nil && info.Size() > 0 {
+        return artFile, nil
+    }
+
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Get(artURL)
+    if err != nil {
+        return "", fmt.Errorf("failed to download cover art: %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("failed to download cover art: %s", resp.Status)
+    }
+
+    // Two callers may download the same art at once
+    out, err := ioutil.TempFile(artDir, "*.tmp")
+    if err != nil {
+        return "", fmt.Errorf("failed to write cover art: %v", err)
+    }
+    tmpFile := out.Name()
+    if _, err := io.Copy(out, resp.Body); err != nil {
+        out.Close()
+        os.Remove(tmpFile)
+        return "", fmt.Errorf("failed to write cover art: %v", err)
+    }
+    out.Close()
+    if err := os.Rename(tmpFile, artFile); err != nil {
+        return "", fmt.Errorf("failed to write cover art: %v", err)
+    }
+    return artFile, nil
+}
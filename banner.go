@@ -0,0 +1,99 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// printStartupSummary prints and logs the effective configuration, so the first lines
+// of output show where recordings go and what is enabled
+func printStartupSummary(cfg Config, monitorSource string) {
+    ext := cfg.captureFormat().Ext
+    capture := []string{strings.ToUpper(strings.TrimPrefix(ext, "."))}
+    if cfg.SampleRate > 0 {
+        capture = append(capture, fmt.Sprintf("%d Hz", cfg.SampleRate))
+    }
+    if cfg.Channels > 0 {
+        capture = append(capture, fmt.Sprintf("%d ch", cfg.Channels))
+    }
+    if cfg.BitDepth > 0 {
+        capture = append(capture, fmt.Sprintf("%d-bit", cfg.BitDepth))
+    }
+    if cfg.Gain != 0 {
+        capture = append(capture, fmt.Sprintf("%+.1f dB", cfg.Gain))
+    }
+    if cfg.StartOffset > 0 {
+        capture = append(capture, fmt.Sprintf("start offset %v", cfg.StartOffset))
+    }
+
+    layout := "Station/Title - Artist - Album (Year)" + ext
+    switch cfg.GroupBy {
+    case groupByDate:
+        layout = "Station/Date/Title - Artist - Album (Year)" + ext
+    case groupBySession:
+        layout = "Station/Session/Title - Artist - Album (Year)" + ext
+    }
+    if cfg.PathTemplate != nil {
+        layout = cfg.PathTemplate.Root.String()
+    }
+    profile := cfg.FileNames.Name
+    if profile == "" {
+        profile = "default"
+    }
+
+    database := cfg.Database
+    if db == nil {
+        database += " (not available)"
+    }
+
+    var enabled []string
+    add := func(on bool, name string) {
+        if on {
+            enabled = append(enabled, name)
+        }
+    }
+    add(cfg.MQTTBroker != "", "MQTT "+cfg.MQTTBroker)
+    add(cfg.HTTPListen != "" && !cfg.httpsEnabled(), "HTTP API "+cfg.HTTPListen)
+    add(cfg.HTTPListen != "" && cfg.httpsEnabled(), "HTTPS API "+cfg.HTTPListen)
+    add(cfg.Schedule != "", "schedule")
+    add(len(cfg.RotateStations) > 0, "rotation")
+    add(cfg.NewOnly, "new only")
+    add(cfg.ArtistCap > 0, fmt.Sprintf("artist cap %d", cfg.ArtistCap))
+    add(cfg.ListenBrainzToken != "", "ListenBrainz")
+    add(len(cfg.Notify) > 0, notifyEvents(cfg))
+    add(len(cfg.Webhooks) > 0, fmt.Sprintf("webhooks (%d)", len(cfg.Webhooks)))
+    add(cfg.TelegramToken != "", "Telegram bot")
+    add(cfg.NowPlayingFile != "" || cfg.NowPlayingJSON != "", "now-playing files")
+    add(cfg.AcoustIDKey != "", "AcoustID")
+    add(cfg.Enrich, "enrichment")
+    add(len(cfg.BestOf) > 0, "best-of playlists")
+    add(cfg.retentionEnabled(), "retention")
+    add(cfg.Announce.Engine != "", "announcements ("+cfg.Announce.Engine+")")
+    add(cfg.ScrubEvery > 0, "scrub")
+    add(cfg.BeetsLog != "", "beets log")
+    add(cfg.XDGTrash, "XDG trash")
+    if len(enabled) == 0 {
+        enabled = append(enabled, "none")
+    }
+
+    incomplete := fmt.Sprintf("> %v left", cfg.IncompleteAfter)
+    if cfg.IncompletePercent > 0 {
+        incomplete += fmt.Sprintf(" or > %g%%", cfg.IncompletePercent)
+    }
+
+    lines := []string{
+        "pianotrap configuration:",
+        "  config file:  " + cfg.ConfigFile,
+        "  save dir:     " + cfg.SaveDir,
+        "  layout:       " + layout + " (" + profile + " file names)",
+        "  source:       PulseAudio " + monitorSource,
+        "  format:       " + strings.Join(capture, ", "),
+        "  incomplete:   " + incomplete + ", " + cfg.OnIncomplete + ", on collision " + cfg.OnCollision,
+        "  database:     " + database,
+        "  enabled:      " + strings.Join(enabled, ", "),
+    }
+    for _, line := range lines {
+        fmt.Printf("\r%s\n", line)
+        logger.Printf("%s", line)
+    }
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// With beets_log set, songs skipped as already owned and recordings removed as
+// duplicates are appended to a file in the format of beets' import log
+// ("import started <date>", then "<action> <path>" lines), so libraries managed
+// with beets can be reconciled with what pianotrap did.
+
+var (
+    beetsLog        string
+    beetsLogStarted sync.Once
+)
+
+// logBeets appends an import log entry for paths
+func logBeets(action string, paths ...string) {
+    if beetsLog == "" {
+        return
+    }
+    f, err := os.OpenFile(beetsLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        logger.Printf("Failed to open beets log %s: %v", beetsLog, err)
+        return
+    }
+    defer f.Close()
+    beetsLogStarted.Do(func() {
+        fmt.Fprintf(f, "import started %s\n", time.Now().Format(time.ANSIC))
+    })
+    if _, err := fmt.Fprintf(f, "%s %s\n", action, strings.Join(paths, "; ")); err != nil {
+        logger.Printf("Failed to write beets log %s: %v", beetsLog, err)
+    }
+}
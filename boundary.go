@@ -0,0 +1,103 @@
+package main
+
+import (
+    "encoding/json"
+    "sync"
+    "time"
+)
+
+// pianotrap learns where songs start and end in several ways: the song banner in
+// pianobar's output, pianobar's songstart and songfinish events, and the countdown
+// reaching zero. Each is passed on as a boundary with its source and how far it can
+// be trusted, so scrobblers or splitters can apply their own policy instead of
+// relying on the one pianotrap uses to cut recordings. They are published to MQTT
+// under <topic>/<node>/boundary.
+
+// Boundary sources
+const (
+    boundaryRegex     = "regex"     // song banner in pianobar's output
+    boundaryEventCmd  = "eventcmd"  // pianobar's event_command
+    boundaryCountdown = "countdown" // pianobar's countdown reaching zero
+)
+
+// Boundary kinds
+const (
+    boundaryStart = "start"
+    boundaryEnd   = "end"
+)
+
+// songBoundary is the detected start or end of a song
+type songBoundary struct {
+    Kind       string    `json:"kind"`
+    Source     string    `json:"source"`
+    Confidence float64   `json:"confidence"`
+    Title      string    `json:"title"`
+    Artist     string    `json:"artist"`
+    Station    string    `json:"station,omitempty"`
+    At         time.Time `json:"at"`
+}
+
+var (
+    boundaryTapsMu sync.Mutex
+    boundaryTaps   []chan songBoundary
+)
+
+// tapBoundaries registers a channel that receives every boundary until the returned
+// function is called
+func tapBoundaries() (<-chan songBoundary, func()) {
+    ch := make(chan songBoundary, 100)
+    boundaryTapsMu.Lock()
+    boundaryTaps = append(boundaryTaps, ch)
+    boundaryTapsMu.Unlock()
+    return ch, func() {
+        boundaryTapsMu.Lock()
+        defer boundaryTapsMu.Unlock()
+        for i, tap := range boundaryTaps {
+            if tap == ch {
+                boundaryTaps = append(boundaryTaps[:i], boundaryTaps[i+1:]...)
+                break
+            }
+        }
+    }
+}
+
+// bannerConfidence is how far a parsed song banner can be trusted: garbled or
+// missing fields make it likely the line was cut or misread
+func bannerConfidence(info songInfo) float64 {
+    if tagsLookIncomplete(info.Title, info.Artist, info.Album) {
+        return 0.5
+    }
+    return 0.9
+}
+
+// emitBoundary passes a boundary to every tap without blocking
+func emitBoundary(kind, source string, confidence float64, title, artist, station string) {
+    b := songBoundary{Kind: kind, Source: source, Confidence: confidence,
+        Title: title, Artist: artist, Station: station, At: time.Now()}
+    logger.Printf("Song %s from %s (confidence %.2f): %s", kind, source, confidence, songKey(title, artist))
+    boundaryTapsMu.Lock()
+    defer boundaryTapsMu.Unlock()
+    for _, tap := range boundaryTaps {
+        select {
+        case tap <- b:
+        default:
+        }
+    }
+}
+
+// publishBoundaries sends boundaries to MQTT until stop is closed
+func publishBoundaries(client *mqttClient, base string, stop chan struct{}) {
+    boundaries, untap := tapBoundaries()
+    defer untap()
+    for {
+        select {
+        case <-stop:
+            return
+        case b := <-boundaries:
+            payload, _ := json.Marshal(b)
+            if err := client.Publish(base+"/boundary", payload, false); err != nil {
+                return
+            }
+        }
+    }
+}
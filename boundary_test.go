@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestBoundaries(t *testing.T) {
+    boundaries, untap := tapBoundaries()
+    emitBoundary(boundaryStart, boundaryRegex, bannerConfidence(songInfo{Title: "Song", Artist: "A", Album: "B"}), "Song", "A", "Jazz")
+    untap()
+    emitBoundary(boundaryEnd, boundaryCountdown, 0.8, "Song", "A", "Jazz")
+
+    b := <-boundaries
+    if b.Kind != boundaryStart || b.Source != boundaryRegex || b.Confidence != 0.9 || b.Station != "Jazz" {
+        t.Errorf("boundary = %+v", b)
+    }
+    select {
+    case b := <-boundaries:
+        t.Errorf("boundary %+v after untap", b)
+    default:
+    }
+    if c := bannerConfidence(songInfo{Title: `Half "quoted`, Artist: "A", Album: "B"}); c != 0.5 {
+        t.Errorf("confidence of a garbled banner = %v, want 0.5", c)
+    }
+}
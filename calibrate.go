@@ -0,0 +1,188 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "math"
+    "os/exec"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The start offset shifts where recordings begin relative to pianobar's song
+// banner, compensating for audio that reaches the monitor source later than the
+// banner reaches the terminal, and the gain corrects the capture level.
+// "pianotrap calibrate" measures both by playing a test tone of known level into a
+// scratch PulseAudio sink and timing its arrival on the monitor.
+
+const (
+    calibrateRate = 8000
+    calibrateSink = "PianotrapCalibrate"
+
+    // toneLevel is the peak of ffmpeg's sine source (amplitude 1/8) in dBFS
+    toneLevel = -18.06
+)
+
+// loadCalibrationConfig reads start_offset and gain
+func loadCalibrationConfig(values map[string]string, cfg *Config) error {
+    if raw := values["start_offset"]; raw != "" {
+        d, err := time.ParseDuration(raw)
+        if err != nil {
+            return fmt.Errorf("invalid value for start_offset: %q", raw)
+        }
+        cfg.StartOffset = d
+    }
+    if raw := strings.TrimSuffix(values["gain"], "dB"); raw != "" {
+        g, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+        if err != nil || g < -30 || g > 30 {
+            return fmt.Errorf("invalid value for gain: %q (-30 to 30 dB)", values["gain"])
+        }
+        cfg.Gain = g
+    }
+    return validateStartOffset(cfg.StartOffset)
+}
+
+// validateStartOffset rejects offsets that can't be applied. Starting early would
+// need audio from before the banner, which is never captured.
+func validateStartOffset(d time.Duration) error {
+    if d < 0 {
+        return fmt.Errorf("start_offset can't be negative: recording can't start before the song banner")
+    }
+    if d > 10*time.Second {
+        return fmt.Errorf("start_offset %v is too large (at most 10s)", d)
+    }
+    return nil
+}
+
+// toneMeasurement is one round of calibration
+type toneMeasurement struct {
+    Latency time.Duration
+    Peak    float64 // dBFS
+}
+
+// measureTone plays a short tone into the scratch sink and measures how long it took
+// to show up on the monitor and how loud it arrived
+func measureTone() (toneMeasurement, error) {
+    var m toneMeasurement
+    capture := exec.Command(ffmpegBinary(), "-loglevel", "error", "-f", "pulse", "-i", calibrateSink+".monitor",
+        "-t", "3", "-ac", "1", "-ar", fmt.Sprintf("%d", calibrateRate), "-f", "s16le", "-")
+    out, err := capture.StdoutPipe()
+    if err != nil {
+        return m, err
+    }
+    if err := capture.Start(); err != nil {
+        return m, fmt.Errorf("failed to start ffmpeg: %v", err)
+    }
+    defer capture.Wait()
+
+    // The capture is running once the first samples arrive
+    first := make([]byte, 2)
+    if _, err := io.ReadFull(out, first); err != nil {
+        return m, fmt.Errorf("no audio from %s.monitor: %v", calibrateSink, err)
+    }
+    captureStart := time.Now()
+    time.Sleep(500 * time.Millisecond)
+
+    played := time.Now()
+    play := exec.Command(ffmpegBinary(), "-loglevel", "error", "-f", "lavfi", "-i", "sine=frequency=1000:duration=0.5",
+        "-f", "pulse", calibrateSink)
+    if err := play.Start(); err != nil {
+        return m, fmt.Errorf("failed to play test tone: %v", err)
+    }
+    rest, err := io.ReadAll(out)
+    play.Wait()
+    if err != nil {
+        return m, err
+    }
+    samples := append(first, rest...)
+
+    onset, peak := -1, 0
+    for i := 0; i+1 < len(samples); i += 2 {
+        v := int(int16(uint16(samples[i]) | uint16(samples[i+1])<<8))
+        if v < 0 {
+            v = -v
+        }
+        if v > 300 && onset < 0 {
+            onset = i / 2
+        }
+        if v > peak {
+            peak = v
+        }
+    }
+    if onset < 0 {
+        return m, fmt.Errorf("test tone not heard on %s.monitor", calibrateSink)
+    }
+    heard := captureStart.Add(time.Duration(onset) * time.Second / calibrateRate)
+    m.Latency = heard.Sub(played)
+    m.Peak = 20 * math.Log10(float64(peak)/32768)
+    return m, nil
+}
+
+// runCalibrate implements "pianotrap calibrate"
+func runCalibrate(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+    rounds := fs.Int("rounds", 5, "number of measurements")
+    write := fs.Bool("write", false, "save the measured start_offset and gain to the config file")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *rounds < 1 {
+        return fmt.Errorf("-rounds must be at least 1")
+    }
+    for _, tool := range []string{ffmpegBinary(), "pactl"} {
+        if _, err := exec.LookPath(tool); err != nil {
+            return fmt.Errorf("%s is required for calibration", tool)
+        }
+    }
+
+    out, err := exec.Command("pactl", "load-module", "module-null-sink", "sink_name="+calibrateSink).Output()
+    if err != nil {
+        return fmt.Errorf("failed to create the calibration sink: %v", err)
+    }
+    module := strings.TrimSpace(string(out))
+    defer exec.Command("pactl", "unload-module", module).Run()
+
+    var latencies []time.Duration
+    var peaks []float64
+    for i := 0; i < *rounds; i++ {
+        m, err := measureTone()
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Round %d: latency %v, level %.1f dBFS\n", i+1, m.Latency.Round(time.Millisecond), m.Peak)
+        latencies = append(latencies, m.Latency)
+        peaks = append(peaks, m.Peak)
+    }
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+    sort.Float64s(peaks)
+    offset := latencies[len(latencies)/2].Round(10 * time.Millisecond)
+    if offset < 0 {
+        offset = 0
+    }
+    if err := validateStartOffset(offset); err != nil {
+        return fmt.Errorf("measured latency is implausible: %v", err)
+    }
+    // Round the gain to half a decibel; smaller differences are measurement noise
+    gain := math.Round((toneLevel-peaks[len(peaks)/2])*2) / 2
+    if peaks[len(peaks)-1] > -0.1 {
+        fmt.Println("Warning: the test tone clipped; lower the PulseAudio volume of the sink")
+    }
+
+    fmt.Printf("\nstart_offset = %v (currently %v)\n", offset, cfg.StartOffset)
+    fmt.Printf("gain = %.1f (currently %.1f)\n", gain, cfg.Gain)
+    if !*write {
+        fmt.Println("\nRun with -write to save these to the config file")
+        return nil
+    }
+    if err := setConfigValue(cfg.ConfigFile, "start_offset", offset.String()); err != nil {
+        return err
+    }
+    if err := setConfigValue(cfg.ConfigFile, "gain", fmt.Sprintf("%.1f", gain)); err != nil {
+        return err
+    }
+    fmt.Printf("Saved to %s\n", cfg.ConfigFile)
+    return nil
+}
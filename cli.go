@@ -0,0 +1,193 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strings"
+)
+
+// pianotrap's features are grouped into subcommands: "pianotrap run" records, and is
+// what a plain "pianotrap" does, the rest work on the config and the song database.
+// -config and -profile before the command apply to all of them, and "pianotrap
+// completion" prints a script so the shell can complete commands.
+
+// subcommand is one "pianotrap <name>"
+type subcommand struct {
+    Run     func(cfg Config, args []string) error
+    Summary string
+    // Words are the commands it takes in turn, offered by shell completion
+    Words []string
+}
+
+// subcommands maps "pianotrap <name>" to its implementation
+var subcommands map[string]subcommand
+
+func init() {
+    // Set up here because help and completion list the map themselves
+    subcommands = map[string]subcommand{
+        "run":        {runRun, "start pianobar and record it (the default)", nil},
+        "init":       {runInit, "write the default config and pianobar's config", nil},
+        "doctor":     {runDoctor, "check that everything a recording needs is in place", nil},
+        "config":     {runConfig, "show the config file and its options", []string{"path", "show", "profiles", "check"}},
+        "library":    {runLibrary, "list, search, verify and import recordings", []string{"list", "search", "show", "scrub", "import"}},
+        "stats":      {runStats, "summarize the song database", nil},
+        "history":    {runHistory, "export the listening history", []string{"export"}},
+        "mixtape":    {runMixtape, "mix the best recent recordings into one file", nil},
+        "report":     {runReport, "write a report on recent recordings", nil},
+        "prune":      {runPrune, "delete recordings past the retention limits", nil},
+        "dedupe":     {runDedupe, "find and remove duplicate recordings", nil},
+        "retag":      {runRetag, "rewrite the tags of existing recordings", nil},
+        "process":    {runProcess, "list and retry failed post-processing steps", nil},
+        "undo":       {runUndo, "undo the last discard or rename", nil},
+        "calibrate":  {runCalibrate, "measure the start offset and capture level", nil},
+        "shell":      {runShell, "inspect and drive a running pianotrap", nil},
+        "attach":     {runAttach, "connect the terminal to a pianotrap started with run -detach", nil},
+        "help":       {runHelp, "list the commands, or show one command's options", nil},
+        "completion": {runCompletion, "print a bash, zsh or fish completion script", []string{"bash", "zsh", "fish"}},
+    }
+}
+
+// commandNames returns the subcommands in alphabetical order
+func commandNames() []string {
+    var names []string
+    for name := range subcommands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// printUsage lists the shared options and the commands
+func printUsage(w io.Writer) {
+    fmt.Fprintf(w, "usage: pianotrap [-config <file>] [-profile <name>] [command] [options]\n\ncommands:\n")
+    for _, name := range commandNames() {
+        fmt.Fprintf(w, "  %-11s %s\n", name, subcommands[name].Summary)
+    }
+    fmt.Fprintf(w, "\nRun \"pianotrap help <command>\" for a command's options.\n")
+}
+
+// globalFlags takes the options shared by all commands off the front of args and
+// returns the config file and profile to use and the remaining arguments
+func globalFlags(args []string, configFile string) (string, string, []string, error) {
+    profile := ""
+    for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+        // Like the flag package, accept -config and --config
+        arg := strings.TrimPrefix(strings.TrimPrefix(args[0], "-"), "-")
+        name, value, hasValue := arg, "", false
+        if i := strings.IndexByte(arg, '='); i >= 0 {
+            name, value, hasValue = arg[:i], arg[i+1:], true
+        }
+        if name != "config" && name != "profile" {
+            break
+        }
+        if !hasValue {
+            if len(args) < 2 {
+                return "", "", nil, fmt.Errorf("-%s needs a value", name)
+            }
+            value, args = args[1], args[1:]
+        }
+        args = args[1:]
+        if name == "config" {
+            configFile = value
+        } else {
+            profile = value
+        }
+    }
+    return configFile, profile, args, nil
+}
+
+// runHelp implements "pianotrap help"
+func runHelp(cfg Config, args []string) error {
+    if len(args) == 0 {
+        printUsage(os.Stdout)
+        return nil
+    }
+    cmd, ok := subcommands[args[0]]
+    if !ok {
+        return fmt.Errorf("unknown command %q", args[0])
+    }
+    if args[0] == "help" {
+        printUsage(os.Stdout)
+        return nil
+    }
+    fmt.Printf("pianotrap %s: %s\n\n", args[0], cmd.Summary)
+    // Every command prints its options when asked
+    if err := cmd.Run(cfg, []string{"-h"}); err != nil && err != flag.ErrHelp {
+        return err
+    }
+    return nil
+}
+
+// runCompletion implements "pianotrap completion"
+func runCompletion(cfg Config, args []string) error {
+    if len(args) != 1 || args[0] == "-h" || args[0] == "-help" {
+        fmt.Fprintf(os.Stderr, "usage: pianotrap completion bash|zsh|fish\n\n" +
+            "bash: source <(pianotrap completion bash), e.g. in ~/.bashrc\n" +
+            "zsh:  source <(pianotrap completion zsh), e.g. in ~/.zshrc\n" +
+            "fish: pianotrap completion fish > ~/.config/fish/completions/pianotrap.fish\n")
+        if len(args) == 1 {
+            return flag.ErrHelp
+        }
+        return fmt.Errorf("missing shell")
+    }
+    script, err := completionScript(args[0])
+    if err != nil {
+        return err
+    }
+    fmt.Print(script)
+    return nil
+}
+
+// completionScript generates the completion script for shell
+func completionScript(shell string) (string, error) {
+    var b strings.Builder
+    switch shell {
+    case "bash", "zsh":
+        if shell == "zsh" {
+            b.WriteString("autoload -U +X bashcompinit && bashcompinit\n\n")
+        }
+        b.WriteString("_pianotrap() {\n")
+        b.WriteString("    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd= i\n")
+        b.WriteString("    [[ $prev == -config || $prev == -profile ]] && return\n")
+        b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+        b.WriteString("        case ${COMP_WORDS[i]} in\n")
+        b.WriteString("        -config|-profile) ((i++)) ;;\n")
+        b.WriteString("        -*) ;;\n")
+        b.WriteString("        *) cmd=${COMP_WORDS[i]}; break ;;\n")
+        b.WriteString("        esac\n")
+        b.WriteString("    done\n")
+        b.WriteString("    case $cmd in\n")
+        fmt.Fprintf(&b, "    \"\") COMPREPLY=($(compgen -W \"-config -profile %s\" -- \"$cur\")) ;;\n", strings.Join(commandNames(), " "))
+        for _, name := range commandNames() {
+            words := subcommands[name].Words
+            if name == "help" {
+                words = commandNames()
+            }
+            if len(words) > 0 {
+                fmt.Fprintf(&b, "    %s) ((i == COMP_CWORD - 1)) && COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", name, strings.Join(words, " "))
+            }
+        }
+        b.WriteString("    esac\n")
+        b.WriteString("}\n")
+        b.WriteString("complete -o default -F _pianotrap pianotrap\n")
+    case "fish":
+        b.WriteString("complete -c pianotrap -n __fish_use_subcommand -o config -r -d 'use another config file'\n")
+        b.WriteString("complete -c pianotrap -n __fish_use_subcommand -o profile -x -d 'use a profile from the config file'\n")
+        for _, name := range commandNames() {
+            fmt.Fprintf(&b, "complete -c pianotrap -n __fish_use_subcommand -f -a %s -d '%s'\n", name, strings.Replace(subcommands[name].Summary, "'", "\\'", -1))
+            words := subcommands[name].Words
+            if name == "help" {
+                words = commandNames()
+            }
+            if len(words) > 0 {
+                fmt.Fprintf(&b, "complete -c pianotrap -n '__fish_seen_subcommand_from %s' -f -a '%s'\n", name, strings.Join(words, " "))
+            }
+        }
+    default:
+        return "", fmt.Errorf("unknown shell %q (use bash, zsh or fish)", shell)
+    }
+    return b.String(), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestGlobalFlags(t *testing.T) {
+    tests := []struct {
+        args    []string
+        file    string
+        profile string
+        rest    []string
+    }{
+        {nil, "default", "", nil},
+        {[]string{"-quiet"}, "default", "", []string{"-quiet"}},
+        {[]string{"-config", "other", "library", "list"}, "other", "", []string{"library", "list"}},
+        {[]string{"--config=other", "-tui"}, "other", "", []string{"-tui"}},
+        {[]string{"config", "show"}, "default", "", []string{"config", "show"}},
+        {[]string{"-profile", "work", "-config=other", "stats"}, "other", "work", []string{"stats"}},
+        {[]string{"--profile=work", "-log"}, "default", "work", []string{"-log"}},
+    }
+    for _, tt := range tests {
+        file, profile, rest, err := globalFlags(tt.args, "default")
+        if err != nil || file != tt.file || profile != tt.profile || !reflect.DeepEqual(rest, tt.rest) {
+            t.Errorf("globalFlags(%q) = %q, %q, %q, %v; want %q, %q, %q", tt.args, file, profile, rest, err, tt.file, tt.profile, tt.rest)
+        }
+    }
+    for _, args := range [][]string{{"-config"}, {"-profile"}} {
+        if _, _, _, err := globalFlags(args, "default"); err == nil {
+            t.Errorf("%s without a value accepted", args[0])
+        }
+    }
+}
+
+func TestCompletionScript(t *testing.T) {
+    for _, shell := range []string{"bash", "zsh", "fish"} {
+        script, err := completionScript(shell)
+        if err != nil {
+            t.Fatalf("%s: %v", shell, err)
+        }
+        for _, name := range commandNames() {
+            if !strings.Contains(script, name) {
+                t.Errorf("%s completion lacks %q", shell, name)
+            }
+        }
+        if !strings.Contains(script, "list search show scrub import") {
+            t.Errorf("%s completion lacks the library commands", shell)
+        }
+    }
+    if _, err := completionScript("tcsh"); err == nil {
+        t.Error("completion for an unknown shell accepted")
+    }
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestCommands(t *testing.T) {
+    var got []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = append(got, r.Method+" "+r.URL.RequestURI())
+        if r.URL.Path == "/api/keep" && r.Header.Get("Authorization") != "Bearer s3cret" {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        switch r.URL.Path {
+        case "/api/status", "/api/skip", "/api/station", "/api/recording":
+            w.Write([]byte(`{"state":"playing","station":"Jazz Radio","title":"So What","artist":"Miles Davis","album":"Kind of Blue","recording":true,"file":"/music/So What.mp3","remaining_seconds":75,"total_seconds":545}`))
+        case "/api/recordings":
+            w.Write([]byte(`[{"title":"So What","artist":"Miles Davis","station":"Jazz Radio","loved":true,"detected_at":"2024-05-01T20:15:00Z","outcome":"saved"}]`))
+        default:
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte(`{"error":"pianobar is not running"}`))
+        }
+    }))
+    defer server.Close()
+
+    for _, tt := range []struct {
+        args    []string
+        request string
+        output  string
+        err     string
+    }{
+        {[]string{"status"}, "GET /api/status", "state:     playing, 1:15 left of 9:05\n", ""},
+        {[]string{"skip"}, "POST /api/skip", "", ""},
+        {[]string{"station", "Jazz", "Radio"}, "POST /api/station?name=Jazz+Radio", "", ""},
+        {[]string{"recording", "off"}, "POST /api/recording?enabled=false", "", ""},
+        {[]string{"recording", "toggle"}, "POST /api/recording", "", ""},
+        {[]string{"recordings", "5"}, "GET /api/recordings?limit=5", "So What by Miles Davis (Jazz Radio) (loved)\n", ""},
+        {[]string{"-json", "skip"}, "POST /api/skip", `"state":"playing"`, ""},
+        {[]string{"love"}, "POST /api/love", "", "pianobar is not running"},
+        {[]string{"keep"}, "POST /api/keep", "", "-token"},
+        {[]string{"-token", "s3cret", "keep"}, "POST /api/keep", "", "pianobar is not running"},
+        {[]string{"recording", "maybe"}, "", "", "on, off or toggle"},
+        {[]string{"rewind"}, "", "", "unknown command"},
+    } {
+        got = nil
+        var out bytes.Buffer
+        err := run(append([]string{"-addr", server.URL}, tt.args...), &out)
+        if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+            t.Errorf("%v: error %v, want %q", tt.args, err, tt.err)
+        }
+        if request := strings.Join(got, ", "); request != tt.request {
+            t.Errorf("%v: requested %q, want %q", tt.args, request, tt.request)
+        }
+        if !strings.Contains(out.String(), tt.output) || tt.output == "" && out.Len() > 0 {
+            t.Errorf("%v: output %q, want %q", tt.args, out.String(), tt.output)
+        }
+    }
+}
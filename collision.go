@@ -0,0 +1,57 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// on_collision decides what happens when a new recording would get the name of an
+// existing file, e.g. the same song captured in another session.
+
+const (
+    collisionNumber    = "number"
+    collisionSkip      = "skip"
+    collisionOverwrite = "overwrite"
+)
+
+// loadCollisionConfig reads on_collision
+func loadCollisionConfig(values map[string]string, cfg *Config) error {
+    cfg.OnCollision = collisionNumber
+    switch raw := values["on_collision"]; raw {
+    case "":
+    case collisionNumber, collisionSkip, collisionOverwrite:
+        cfg.OnCollision = raw
+    default:
+        return fmt.Errorf("invalid value for on_collision: %q (use number, skip or overwrite)", raw)
+    }
+    return nil
+}
+
+// resolveCollision returns the name to record fileName under, or false when the
+// recording should be skipped. Numbered names get " (2)", " (3)" and so on.
+func resolveCollision(policy, fileName string) (string, bool) {
+    taken := func(name string) bool {
+        for _, path := range []string{name, tempName(name)} {
+            if _, err := os.Stat(path); err == nil {
+                return true
+            }
+        }
+        return false
+    }
+    if policy == collisionOverwrite || !taken(fileName) {
+        return fileName, true
+    }
+    if policy == collisionSkip {
+        return fileName, false
+    }
+    ext := filepath.Ext(fileName)
+    base := strings.TrimSuffix(fileName, ext)
+    for n := 2; ; n++ {
+        candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+        if !taken(candidate) {
+            return candidate, true
+        }
+    }
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+)
+
+func TestResolveCollision(t *testing.T) {
+    dir := t.TempDir()
+    name := filepath.Join(dir, "Song - Artist - Album (2024).mp3")
+    ioutil.WriteFile(name, nil, 0644)
+    ioutil.WriteFile(filepath.Join(dir, "Song - Artist - Album (2024) (2).mp3.partial"), nil, 0644)
+
+    tests := []struct {
+        policy string
+        want   string
+        ok     bool
+    }{
+        {collisionOverwrite, name, true},
+        {collisionSkip, name, false},
+        {collisionNumber, filepath.Join(dir, "Song - Artist - Album (2024) (3).mp3"), true},
+    }
+    for _, tt := range tests {
+        got, ok := resolveCollision(tt.policy, name)
+        if got != tt.want || ok != tt.ok {
+            t.Errorf("resolveCollision(%s) = %q, %v, want %q, %v", tt.policy, got, ok, tt.want, tt.ok)
+        }
+    }
+    if got, ok := resolveCollision(collisionSkip, filepath.Join(dir, "new.mp3")); !ok || got != filepath.Join(dir, "new.mp3") {
+        t.Errorf("free name changed to %q, %v", got, ok)
+    }
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// The config file is "key = value" lines, optionally grouped in TOML sections:
+//
+//     savedir = "/home/me/Music"
+//
+//     [mqtt]
+//     broker = "tcp://broker:1883"   # mqtt_broker
+//
+//     [station."Jazz Radio"]
+//     genre = "Jazz"                 # genre.Jazz Radio
+//
+//     [profile.work]
+//     savedir = "/home/me/Work Music"  # only with -profile work
+//
+//     [webhook.n8n]
+//     url = "https://n8n.example.com/webhook/pianotrap"  # webhook.n8n.url
+//
+// In [name] a key is read as name_key, or as key if that is the option meant, e.g.
+// samplerate in [capture]. A [profile.name] takes flat option names that replace the
+// ones above when "-profile name" is given. Values may be TOML strings or arrays;
+// bare values are taken as they are up to a " #" comment, so flat configs from older
+// versions load unchanged. Everything is then handled as flat option names.
+
+// knownOptions are the options pianotrap reads, besides genre.<station> and the
+// webhook.<name>.<key> options
+var knownOptions = map[string]bool{}
+
+func init() {
+    for _, key := range strings.Fields(`savedir format samplerate channels bitdepth gain start_offset
+        incomplete_seconds incomplete_percent on_incomplete on_collision
+        group_by session_gap path_template filename_profile filename_normalization max_filename_length
+        database playlists trash beets_log derivegenre
+        min_free_mb low_space_prune retain_max_age retain_station_gb retain_loved_only scrub_every
+        acoustid_key enrich discogs_token new_only new_only_grace artist_cap artist_cap_action
+        schedule schedule_refresh rotate rotate_every bestof bestof_size bestof_mixtape
+        announce announce_voice announce_template announce_archive
+        status_bar tui quiet accessible color message_prefix
+        color_info color_start color_stop color_skip color_delete
+        record_key discard_key keep_key silent_source watchdog_warn watchdog_timeout pianobar_locale
+        pandora_user pandora_password pianobar_command pianobar_args pianobar_env pianobar_dir
+        ffmpeg_command ffmpeg_args http_listen http_token http_username http_password
+        http_tls http_cert http_key http_mdns mpris listenbrainz_token listenbrainz_url
+        telegram_token telegram_users telegram_chat telegram_notify
+        nowplaying_file nowplaying_json nowplaying_template
+        notify notify_song notify_saved notify_deleted notify_errors
+        mqtt_broker mqtt_username mqtt_password mqtt_topic mqtt_discovery_prefix mqtt_node_id
+        report report_email report_from smtp_server smtp_username smtp_password`) {
+        knownOptions[key] = true
+    }
+}
+
+// knownOption reports whether pianotrap reads key
+func knownOption(key string) bool {
+    return knownOptions[key] || strings.HasPrefix(key, "genre.") || isWebhookOption(key)
+}
+
+// configSection is the section option lines belong to
+type configSection struct {
+    name    string // [name]
+    station string // [station."Name"]
+    profile string // [profile.name]
+    webhook string // [webhook.name]
+}
+
+// parseSection parses a "[...]" line
+func parseSection(line string) (configSection, error) {
+    if !strings.HasSuffix(line, "]") {
+        return configSection{}, fmt.Errorf("unterminated section %s", line)
+    }
+    inner := strings.TrimSpace(line[1 : len(line)-1])
+    if station := strings.TrimPrefix(inner, "station."); station != inner {
+        station = strings.Trim(strings.TrimSpace(station), `"'`)
+        if station == "" {
+            return configSection{}, fmt.Errorf("missing station name in %s", line)
+        }
+        return configSection{station: station}, nil
+    }
+    if profile := strings.TrimPrefix(inner, "profile."); profile != inner {
+        profile = strings.Trim(strings.TrimSpace(profile), `"'`)
+        if profile == "" || strings.ContainsAny(profile, ". ") {
+            return configSection{}, fmt.Errorf("invalid profile name in %s", line)
+        }
+        return configSection{profile: profile}, nil
+    }
+    if webhook := strings.TrimPrefix(inner, "webhook."); webhook != inner {
+        webhook = strings.Trim(strings.TrimSpace(webhook), `"'`)
+        if webhook == "" || strings.ContainsAny(webhook, ". ") {
+            return configSection{}, fmt.Errorf("invalid webhook name in %s", line)
+        }
+        return configSection{webhook: webhook}, nil
+    }
+    for _, r := range inner {
+        if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+            return configSection{}, fmt.Errorf("invalid section %s", line)
+        }
+    }
+    if inner == "" {
+        return configSection{}, fmt.Errorf("invalid section %s", line)
+    }
+    return configSection{name: inner}, nil
+}
+
+// optionKey is the flat option name of key within s
+func (s configSection) optionKey(key string) string {
+    switch {
+    case s.station != "":
+        return key + "." + s.station
+    case s.profile != "":
+        return "profile." + s.profile + "." + key
+    case s.webhook != "":
+        return "webhook." + s.webhook + "." + key
+    case s.name == "":
+        return key
+    case knownOption(s.name+"_"+key) || !knownOption(key):
+        return s.name + "_" + key
+    }
+    return key
+}
+
+// splitOption splits a "key = value" line into the key, without quotes, and the raw value
+func splitOption(line string) (string, string, bool) {
+    parts := strings.SplitN(line, "=", 2)
+    if len(parts) != 2 {
+        return "", "", false
+    }
+    key := strings.Replace(strings.TrimSpace(parts[0]), `"`, "", -1)
+    return key, strings.TrimSpace(parts[1]), key != ""
+}
+
+// parseString parses the TOML string at the start of s and returns the rest of s
+func parseString(s string) (string, string, error) {
+    if s[0] == '\'' {
+        end := strings.IndexByte(s[1:], '\'')
+        if end < 0 {
+            return "", "", fmt.Errorf("unterminated string %s", s)
+        }
+        return s[1 : end+1], s[end+2:], nil
+    }
+    for i := 1; i < len(s); i++ {
+        switch s[i] {
+        case '\\':
+            i++
+        case '"':
+            v, err := strconv.Unquote(s[:i+1])
+            if err != nil {
+                return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+            }
+            return v, s[i+1:], nil
+        }
+    }
+    return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// parseValue parses the value of an option. Arrays keep their items, quoted, as in
+// ["a", "b, c"], for configList to take apart again.
+func parseValue(raw string) (string, error) {
+    // trailing reports whether only a comment follows the value
+    trailing := func(rest string) error {
+        if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+            return fmt.Errorf("unexpected %s after the value", rest)
+        }
+        return nil
+    }
+    switch {
+    case strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'"):
+        value, rest, err := parseString(raw)
+        if err != nil {
+            return "", err
+        }
+        return value, trailing(rest)
+    case strings.HasPrefix(raw, "["):
+        items, rest, err := parseArray(raw)
+        if err != nil {
+            return "", err
+        }
+        return formatArray(items), trailing(rest)
+    }
+    if i := strings.Index(raw, " #"); i >= 0 {
+        raw = raw[:i]
+    }
+    return strings.TrimSpace(raw), nil
+}
+
+// parseArray parses the TOML array at the start of raw and returns the rest of raw
+func parseArray(raw string) ([]string, string, error) {
+    var items []string
+    rest := strings.TrimSpace(raw[1:])
+    for !strings.HasPrefix(rest, "]") {
+        var item string
+        var err error
+        switch {
+        case rest == "":
+            return nil, "", fmt.Errorf("unterminated array %s", raw)
+        case rest[0] == '"' || rest[0] == '\'':
+            if item, rest, err = parseString(rest); err != nil {
+                return nil, "", err
+            }
+        default:
+            end := strings.IndexAny(rest, ",]")
+            if end < 0 {
+                return nil, "", fmt.Errorf("unterminated array %s", raw)
+            }
+            item, rest = strings.TrimSpace(rest[:end]), rest[end:]
+        }
+        items = append(items, item)
+        rest = strings.TrimSpace(rest)
+        if strings.HasPrefix(rest, ",") {
+            rest = strings.TrimSpace(rest[1:])
+        } else if !strings.HasPrefix(rest, "]") {
+            return nil, "", fmt.Errorf("expected , or ] in %s", raw)
+        }
+    }
+    return items, rest[1:], nil
+}
+
+// formatArray writes items as an array that parseArray reads back
+func formatArray(items []string) string {
+    quoted := make([]string, len(items))
+    for i, item := range items {
+        quoted[i] = strconv.Quote(item)
+    }
+    return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// configList reads a list option: an array item by item, or else a plain value
+// split at its commas
+func configList(raw string) []string {
+    if strings.HasPrefix(raw, "[") {
+        if items, rest, err := parseArray(raw); err == nil && strings.TrimSpace(rest) == "" {
+            return items
+        }
+    }
+    var items []string
+    for _, item := range strings.Split(raw, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// parseConfig parses the contents of a config file into flat options
+func parseConfig(data string) (map[string]string, error) {
+    values, _, err := parseConfigLines(data)
+    return values, err
+}
+
+// parseConfigLines is parseConfig that also returns the line each option is set on
+func parseConfigLines(data string) (map[string]string, map[string]int, error) {
+    values := make(map[string]string)
+    lines := make(map[string]int)
+    var section configSection
+    for i, line := range strings.Split(data, "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if strings.HasPrefix(line, "[") {
+            s, err := parseSection(line)
+            if err != nil {
+                return nil, nil, fmt.Errorf("line %d: %v", i+1, err)
+            }
+            section = s
+            continue
+        }
+        key, raw, ok := splitOption(line)
+        if !ok {
+            return nil, nil, fmt.Errorf("line %d: expected key = value", i+1)
+        }
+        value, err := parseValue(raw)
+        if err != nil {
+            return nil, nil, fmt.Errorf("line %d: %v", i+1, err)
+        }
+        values[section.optionKey(key)] = value
+        lines[section.optionKey(key)] = i + 1
+    }
+    return values, lines, nil
+}
+
+// unknownOptions lists the options in values that pianotrap doesn't read, in order
+func unknownOptions(values map[string]string) []string {
+    var unknown []string
+    for key := range values {
+        option := key
+        if parts := strings.SplitN(key, ".", 3); len(parts) == 3 && parts[0] == "profile" {
+            option = parts[2]
+        }
+        if !knownOption(option) {
+            unknown = append(unknown, key)
+        }
+    }
+    sort.Strings(unknown)
+    return unknown
+}
+
+// readConfigValues parses the config file into flat options
+func readConfigValues(configFile string) (map[string]string, error) {
+    data, err := ioutil.ReadFile(configFile)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return make(map[string]string), nil
+        }
+        return nil, fmt.Errorf("failed to read config file: %v", err)
+    }
+    values, err := parseConfig(string(data))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", configFile, err)
+    }
+    return values, nil
+}
+
+// profileNames lists the profiles defined in values, in order
+func profileNames(values map[string]string) []string {
+    seen := make(map[string]bool)
+    var names []string
+    for key := range values {
+        if parts := strings.SplitN(key, ".", 3); len(parts) == 3 && parts[0] == "profile" {
+            name := parts[1]
+            if !seen[name] {
+                seen[name] = true
+                names = append(names, name)
+            }
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// selectProfile returns the top-level options of values with those of profile, if
+// any, applied over them
+func selectProfile(values map[string]string, profile string) (map[string]string, error) {
+    selected := make(map[string]string)
+    prefix := "profile." + profile + "."
+    found := false
+    for key, value := range values {
+        if !strings.HasPrefix(key, "profile.") {
+            if _, ok := selected[key]; !ok {
+                selected[key] = value
+            }
+        } else if profile != "" && strings.HasPrefix(key, prefix) {
+            selected[strings.TrimPrefix(key, prefix)] = value
+            found = true
+        }
+    }
+    if profile != "" && !found {
+        names := profileNames(values)
+        if len(names) == 0 {
+            return nil, fmt.Errorf("unknown profile %q: the config file has no [profile.<name>] sections", profile)
+        }
+        return nil, fmt.Errorf("unknown profile %q (have %s)", profile, strings.Join(names, ", "))
+    }
+    return selected, nil
+}
+
+// configFromValues turns the options read from configFile into a Config; saveDir
+// applies if the file sets none
+func configFromValues(values map[string]string, configFile, saveDir string) (Config, error) {
+    if values["savedir"] != "" {
+        saveDir = values["savedir"]
+    }
+    cfg := Config{SaveDir: saveDir, ConfigFile: configFile}
+    if err := loadCaptureConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    loadGenreConfig(values, &cfg)
+    loadMQTTConfig(values, &cfg)
+    cfg.AcoustIDKey = values["acoustid_key"]
+    cfg.Enrich = values["enrich"] == "true"
+    cfg.DiscogsToken = values["discogs_token"]
+    cfg.Database = values["database"]
+    cfg.BeetsLog = values["beets_log"]
+    cfg.PandoraUser = values["pandora_user"]
+    cfg.PandoraPassword = values["pandora_password"]
+    if cfg.Database == "" {
+        cfg.Database = filepath.Join(filepath.Dir(configFile), "pianotrap.db")
+    }
+    if err := loadScheduleConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadRotationConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadNewOnlyConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadArtistCapConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadBestOfConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    cfg.Playlists = values["playlists"] != "false"
+    cfg.StatusBar = values["status_bar"] != "false"
+    cfg.TUI = values["tui"] == "true"
+    cfg.Quiet = values["quiet"] == "true"
+    cfg.Accessible = values["accessible"] == "true"
+    cfg.MPRIS = values["mpris"] != "false"
+    if err := loadRetentionConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadAnnounceConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadDiskSpaceConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadScrubConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadIncompleteConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadCalibrationConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadTrashConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadCollisionConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadPathTemplateConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadFileNameConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadWatchdogConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadSourceConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadThemeConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadKeysConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadLocaleConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadPianobarConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadFFmpegConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadHTTPConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadTLSConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadListenBrainzConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadNotifyConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadWebhookConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadTelegramConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadNowPlayingConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    if err := loadReportConfig(values, &cfg); err != nil {
+        return cfg, err
+    }
+    return cfg, nil
+}
+
+// formatValue writes value so that parseValue reads it back
+func formatValue(value string) string {
+    if value == "" || value != strings.TrimSpace(value) || strings.Contains(value, " #") || strings.ContainsAny(value[:1], `"'[`) {
+        return strconv.Quote(value)
+    }
+    return value
+}
+
+// setConfigValue replaces the line setting key, in whichever section, or adds one
+// before the first section, or at the end of its profile's section
+func setConfigValue(configFile, key, value string) error {
+    data, err := ioutil.ReadFile(configFile)
+    if err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to read config file: %v", err)
+    }
+    lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+    if len(lines) == 1 && lines[0] == "" {
+        lines = nil
+    }
+    found := false
+    firstSection, profileEnd := -1, -1
+    var section configSection
+    for i, l := range lines {
+        l = strings.TrimSpace(l)
+        if strings.HasPrefix(l, "[") {
+            if s, err := parseSection(l); err == nil {
+                section = s
+            }
+            if firstSection < 0 {
+                firstSection = i
+            }
+            if section.profile != "" && strings.HasPrefix(key, "profile."+section.profile+".") {
+                profileEnd = i + 1
+            }
+            continue
+        }
+        if strings.HasPrefix(l, "#") {
+            continue
+        }
+        if k, _, ok := splitOption(l); ok && section.optionKey(k) == key {
+            lines[i] = fmt.Sprintf("%s = %s", strings.TrimSpace(strings.SplitN(l, "=", 2)[0]), formatValue(value))
+            found = true
+        }
+        if l != "" && section.profile != "" && strings.HasPrefix(key, "profile."+section.profile+".") {
+            profileEnd = i + 1
+        }
+    }
+    if !found && profileEnd >= 0 {
+        line := fmt.Sprintf("%s = %s", strings.SplitN(key, ".", 3)[2], formatValue(value))
+        lines = append(lines[:profileEnd], append([]string{line}, lines[profileEnd:]...)...)
+    } else if !found {
+        line := fmt.Sprintf("%s = %s", key, formatValue(value))
+        if firstSection < 0 {
+            lines = append(lines, line)
+        } else {
+            // Keep the comments that introduce the section with it
+            at := firstSection
+            for at > 0 && (strings.TrimSpace(lines[at-1]) == "" || strings.HasPrefix(strings.TrimSpace(lines[at-1]), "#")) {
+                at--
+            }
+            lines = append(lines[:at], append([]string{line}, lines[at:]...)...)
+        }
+    }
+    // A new config file may get passwords and tokens, so only the user reads it
+    if err := ioutil.WriteFile(configFile, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+        return fmt.Errorf("failed to write config file: %v", err)
+    }
+    return nil
+}
+
+// configInt reads an integer option, returning def when the key is absent
+func configInt(values map[string]string, key string, def int) (int, error) {
+    raw, ok := values[key]
+    if !ok || raw == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(raw)
+    if err != nil {
+        return 0, fmt.Errorf("invalid value for %s: %q", key, raw)
+    }
+    return n, nil
+}
+
+// captureFormat is a format recordings can be encoded to
+type captureFormat struct {
+    Ext   string // the file extension
+    Codec string // the ffmpeg encoder
+    Muxer string // the ffmpeg output format
+    // SampleFormats are the encoder's sample formats for 16-bit and for 24 or 32-bit
+    // captures; encoders that only take floats have none
+    SampleFormats [2]string
+}
+
+// captureFormats are the values of the format option
+var captureFormats = map[string]captureFormat{
+    "mp3":  {".mp3", "mp3", "mp3", [2]string{"s16p", "s32p"}},
+    "flac": {".flac", "flac", "flac", [2]string{"s16", "s32"}},
+    "ogg":  {".ogg", "libvorbis", "ogg", [2]string{}},
+    "opus": {".opus", "libopus", "opus", [2]string{}},
+    "m4a":  {".m4a", "aac", "ipod", [2]string{}},
+    "aac":  {".aac", "aac", "adts", [2]string{}},
+}
+
+// captureFormatOf returns the format of a recording from its extension
+func captureFormatOf(fileName string) (captureFormat, bool) {
+    ext := strings.ToLower(filepath.Ext(fileName))
+    for _, f := range captureFormats {
+        if f.Ext == ext {
+            return f, true
+        }
+    }
+    return captureFormat{}, false
+}
+
+// captureFormat returns the format new recordings are encoded to
+func (cfg Config) captureFormat() captureFormat {
+    if f, ok := captureFormats[cfg.Format]; ok {
+        return f
+    }
+    return captureFormats["mp3"]
+}
+
+// loadCaptureConfig fills the capture format options from the config values
+func loadCaptureConfig(values map[string]string, cfg *Config) error {
+    cfg.Format = strings.ToLower(values["format"])
+    if cfg.Format == "" {
+        cfg.Format = "mp3"
+    }
+    var err error
+    if cfg.SampleRate, err = configInt(values, "samplerate", 0); err != nil {
+        return err
+    }
+    if cfg.Channels, err = configInt(values, "channels", 0); err != nil {
+        return err
+    }
+    if cfg.BitDepth, err = configInt(values, "bitdepth", 0); err != nil {
+        return err
+    }
+    return nil
+}
+
+// validateCapture checks the capture format options; zero values keep the source defaults
+func (cfg Config) validateCapture() error {
+    format, ok := captureFormats[cfg.Format]
+    if !ok {
+        var names []string
+        for name := range captureFormats {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        return fmt.Errorf("unknown format %q (use %s)", cfg.Format, strings.Join(names, ", "))
+    }
+    if cfg.SampleRate != 0 && (cfg.SampleRate < 8000 || cfg.SampleRate > 192000) {
+        return fmt.Errorf("sample rate %d out of range (8000-192000)", cfg.SampleRate)
+    }
+    if cfg.Channels < 0 || cfg.Channels > 8 {
+        return fmt.Errorf("channel count %d out of range (1-8)", cfg.Channels)
+    }
+    switch cfg.BitDepth {
+    case 0, 16, 24, 32:
+    default:
+        return fmt.Errorf("unsupported bit depth %d (use 16, 24 or 32)", cfg.BitDepth)
+    }
+    if cfg.BitDepth != 0 && format.SampleFormats[0] == "" {
+        return fmt.Errorf("%s recordings have no bit depth to set", cfg.Format)
+    }
+    return nil
+}
+
+// captureArgs returns the ffmpeg input and output options for the configured capture format
+func (cfg Config) captureArgs() (input, output []string) {
+    if cfg.SampleRate > 0 {
+        input = append(input, "-sample_rate", strconv.Itoa(cfg.SampleRate))
+    }
+    if cfg.Channels > 0 {
+        input = append(input, "-channels", strconv.Itoa(cfg.Channels))
+    }
+    if cfg.Gain != 0 {
+        output = append(output, "-af", fmt.Sprintf("volume=%.1fdB", cfg.Gain))
+    }
+    formats := cfg.captureFormat().SampleFormats
+    switch {
+    case cfg.BitDepth == 16 && formats[0] != "":
+        output = append(output, "-sample_fmt", formats[0])
+    case cfg.BitDepth > 16 && formats[1] != "":
+        // The encoders have no 24-bit format, so 24-bit captures encode from 32-bit samples
+        output = append(output, "-sample_fmt", formats[1])
+    }
+    return input, output
+}
+
+// secretOptions are the options "pianotrap config show" doesn't print
+var secretOptions = map[string]bool{
+    "acoustid_key":       true,
+    "discogs_token":      true,
+    "http_password":      true,
+    "http_token":         true,
+    "listenbrainz_token": true,
+    "mqtt_password":      true,
+    "pandora_password":   true,
+    "smtp_password":      true,
+    "telegram_token":     true,
+}
+
+const configUsage = `usage: pianotrap config <command>
+
+commands:
+  path      print where the config file is
+  show      print the options in effect, with -profile applied, without passwords and keys
+  profiles  list the profiles defined in the config file
+  check     check every option and report the problems by line
+`
+
+// runConfig implements "pianotrap config"
+func runConfig(cfg Config, args []string) error {
+    if len(args) != 1 {
+        fmt.Fprint(os.Stderr, configUsage)
+        return fmt.Errorf("expected one config command")
+    }
+    switch args[0] {
+    case "-h", "-help":
+        fmt.Fprint(os.Stderr, configUsage)
+        return flag.ErrHelp
+    case "path":
+        fmt.Println(cfg.ConfigFile)
+        return nil
+    case "show":
+        values, err := readConfigValues(cfg.ConfigFile)
+        if err != nil {
+            return err
+        }
+        if values, err = selectProfile(values, cfg.Profile); err != nil {
+            return err
+        }
+        var keys []string
+        for key := range values {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        if cfg.Profile != "" {
+            fmt.Printf("# %s, profile %s\n", cfg.ConfigFile, cfg.Profile)
+        } else {
+            fmt.Printf("# %s\n", cfg.ConfigFile)
+        }
+        for _, key := range keys {
+            value := values[key]
+            if (secretOptions[key] || isWebhookSecret(key)) && value != "" {
+                value = "(hidden)"
+            }
+            fmt.Printf("%s = %s\n", key, value)
+        }
+        return nil
+    case "check":
+        return runConfigCheck(cfg)
+    case "profiles":
+        values, err := readConfigValues(cfg.ConfigFile)
+        if err != nil {
+            return err
+        }
+        for _, name := range profileNames(values) {
+            fmt.Println(name)
+        }
+        return nil
+    default:
+        fmt.Fprint(os.Stderr, configUsage)
+        return fmt.Errorf("unknown config command %q", args[0])
+    }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+func TestSetConfigValue(t *testing.T) {
+    configFile := filepath.Join(t.TempDir(), "config")
+    ioutil.WriteFile(configFile, []byte("savedir = /music\n# gain = 1\ngain = 2\n"), 0644)
+    if err := setConfigValue(configFile, "gain", "-1.5"); err != nil {
+        t.Fatal(err)
+    }
+    if err := setConfigValue(configFile, "start_offset", "250ms"); err != nil {
+        t.Fatal(err)
+    }
+    data, _ := ioutil.ReadFile(configFile)
+    want := "savedir = /music\n# gain = 1\ngain = -1.5\nstart_offset = 250ms\n"
+    if string(data) != want {
+        t.Errorf("config is %q, want %q", data, want)
+    }
+
+    newFile := filepath.Join(t.TempDir(), "new", "config")
+    os.Mkdir(filepath.Dir(newFile), 0755)
+    if err := setConfigValue(newFile, "http_token", "s3cret"); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(newFile); err != nil {
+        t.Error(err)
+    } else if info.Mode().Perm() != 0600 {
+        t.Errorf("new config file has mode %v", info.Mode())
+    }
+}
+
+func TestParseConfig(t *testing.T) {
+    values, err := parseConfig(`savedir = /home/me/My Music
+gain = 1.5 # louder
+message_prefix = "pt: "   # trailing space kept
+
+[mqtt]
+broker = 'tcp://broker:1883'
+password = "a # b"
+
+[capture]
+samplerate = 48000
+
+[stations]
+rotate = ["Jazz Radio", Deep Focus, "Tom, Dick & Harry Radio"]
+
+[station."Jazz Radio"]
+genre = "Jazz"
+
+[mqtt]
+brokr = typo
+`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string]string{
+        "savedir":          "/home/me/My Music",
+        "gain":             "1.5",
+        "message_prefix":   "pt: ",
+        "mqtt_broker":      "tcp://broker:1883",
+        "mqtt_password":    "a # b",
+        "samplerate":       "48000",
+        "rotate":           `["Jazz Radio", "Deep Focus", "Tom, Dick & Harry Radio"]`,
+        "genre.Jazz Radio": "Jazz",
+        "mqtt_brokr":       "typo",
+    }
+    if !reflect.DeepEqual(values, want) {
+        t.Errorf("parseConfig = %v, want %v", values, want)
+    }
+    if unknown := unknownOptions(values); !reflect.DeepEqual(unknown, []string{"mqtt_brokr"}) {
+        t.Errorf("unknown options %v", unknown)
+    }
+    if got := configList(values["rotate"]); !reflect.DeepEqual(got, []string{"Jazz Radio", "Deep Focus", "Tom, Dick & Harry Radio"}) {
+        t.Errorf("rotate array = %q", got)
+    }
+    if got := configList("Jazz Radio, Deep Focus,"); !reflect.DeepEqual(got, []string{"Jazz Radio", "Deep Focus"}) {
+        t.Errorf("rotate list = %q", got)
+    }
+
+    for _, bad := range []string{"[mqtt", "[My Section]", "[station.\"\"]", "just words", `a = "open`, `a = ["x", "y"`, `a = "x" y`} {
+        if _, err := parseConfig(bad); err == nil {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+}
+
+func TestCaptureFormat(t *testing.T) {
+    var cfg Config
+    if err := loadCaptureConfig(map[string]string{"format": "FLAC", "bitdepth": "24"}, &cfg); err != nil || cfg.validateCapture() != nil {
+        t.Fatalf("format = flac: %v, %v", err, cfg.validateCapture())
+    }
+    args := buildFFmpegArgs(cfg, "src.monitor", "/music/song.flac", 0)
+    want := []string{"-f", "pulse", "-i", "src.monitor", "-acodec", "flac", "-sample_fmt", "s32", "-f", "flac", "-y", "/music/song.flac"}
+    if !reflect.DeepEqual(args, want) {
+        t.Errorf("args = %q, want %q", args, want)
+    }
+    if f, ok := captureFormatOf("/music/Song.FLAC"); !ok || f != cfg.captureFormat() {
+        t.Errorf("captureFormatOf = %+v, %v", f, ok)
+    }
+
+    for _, tt := range []struct {
+        values map[string]string
+        ok     bool
+    }{
+        {map[string]string{}, true},
+        {map[string]string{"format": "opus"}, true},
+        {map[string]string{"format": "wav"}, false},
+        {map[string]string{"format": "ogg", "bitdepth": "16"}, false},
+    } {
+        var cfg Config
+        if err := loadCaptureConfig(tt.values, &cfg); err != nil {
+            t.Fatal(err)
+        }
+        if err := cfg.validateCapture(); (err == nil) != tt.ok {
+            t.Errorf("%v: validateCapture = %v", tt.values, err)
+        }
+    }
+    if !dedupeAudioExts[".opus"] || !dedupeAudioExts[".m4a"] {
+        t.Errorf("dedupe skips formats pianotrap records: %v", dedupeAudioExts)
+    }
+}
+
+func TestDefaultConfigOptions(t *testing.T) {
+    // Uncommenting every option in the default config must cover exactly the
+    // options pianotrap reads
+    optionRe := regexp.MustCompile(`(?m)^# (\[station|\[profile|\[webhook|[a-z_]+ =)`)
+    values, err := parseConfig(optionRe.ReplaceAllString(defaultConfigFile("/music"), "$1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if unknown := unknownOptions(values); len(unknown) > 0 {
+        t.Errorf("default config has unknown options %v", unknown)
+    }
+    for key := range knownOptions {
+        if _, ok := values[key]; !ok {
+            t.Errorf("default config lacks %s", key)
+        }
+    }
+}
+
+func TestSelectProfile(t *testing.T) {
+    values, err := parseConfig(`savedir = /music
+gain = 1.5
+
+[mqtt]
+topic = home
+
+[profile.work]
+savedir = "/work music"
+pandora_user = me@work.example.com
+mqtt_topic = office
+
+[profile."car"]
+samplerate = 22050
+`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := profileNames(values); !reflect.DeepEqual(names, []string{"car", "work"}) {
+        t.Errorf("profileNames = %v", names)
+    }
+    if unknown := unknownOptions(values); len(unknown) > 0 {
+        t.Errorf("unknown options %v", unknown)
+    }
+    base, err := selectProfile(values, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := map[string]string{"savedir": "/music", "gain": "1.5", "mqtt_topic": "home"}; !reflect.DeepEqual(base, want) {
+        t.Errorf("no profile = %v, want %v", base, want)
+    }
+    work, err := selectProfile(values, "work")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := map[string]string{"savedir": "/work music", "gain": "1.5", "mqtt_topic": "office", "pandora_user": "me@work.example.com"}; !reflect.DeepEqual(work, want) {
+        t.Errorf("profile work = %v, want %v", work, want)
+    }
+    if _, err := selectProfile(values, "home"); err == nil || !strings.Contains(err.Error(), "car, work") {
+        t.Errorf("unknown profile: %v", err)
+    }
+    if _, err := parseConfig("[profile.a.b]"); err == nil {
+        t.Error("profile name with a dot accepted")
+    }
+    if unknown := unknownOptions(map[string]string{"profile.work.savdir": "x"}); !reflect.DeepEqual(unknown, []string{"profile.work.savdir"}) {
+        t.Errorf("unknown options in a profile: %v", unknown)
+    }
+}
+
+func TestSetConfigValueSections(t *testing.T) {
+    configFile := filepath.Join(t.TempDir(), "config")
+    ioutil.WriteFile(configFile, []byte("savedir = /music\n\n# Capture\n[capture]\ngain = 2\n\n[incomplete]\nseconds = 10\n\n[profile.work]\ngain = 3\n\n[profile.car]\ngain = 1\n"), 0644)
+    for key, value := range map[string]string{"gain": "-1.5", "incomplete_seconds": "25", "start_offset": "250ms", "message_prefix": "pt: ",
+        "profile.work.gain": "4", "profile.work.incomplete_seconds": "30"} {
+        if err := setConfigValue(configFile, key, value); err != nil {
+            t.Fatal(err)
+        }
+    }
+    values, err := readConfigValues(configFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string]string{"savedir": "/music", "gain": "-1.5", "incomplete_seconds": "25", "start_offset": "250ms", "message_prefix": "pt: ",
+        "profile.work.gain": "4", "profile.work.incomplete_seconds": "30", "profile.car.gain": "1"}
+    data, _ := ioutil.ReadFile(configFile)
+    if !reflect.DeepEqual(values, want) {
+        t.Errorf("config reads as %v, want %v:\n%s", values, want, data)
+    }
+    if !strings.Contains(string(data), "[profile.work]\ngain = 4\nincomplete_seconds = 30\n\n[profile.car]") {
+        t.Errorf("profile option not added to its section:\n%s", data)
+    }
+}
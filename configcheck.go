@@ -0,0 +1,216 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Whenever the config is loaded, at startup, on SIGHUP and by "pianotrap config
+// check", all of it is checked: unknown options, invalid values and paths that can't
+// work. Each problem is reported with the line of the option that causes it, and any
+// problem stops pianotrap from using the file.
+
+// pathOptions are the options naming a file or directory, and whether it's a directory
+var pathOptions = map[string]bool{
+    "savedir":          true,
+    "database":         false,
+    "beets_log":        false,
+    "announce_archive": true,
+    "schedule":         false,
+    "pianobar_dir":     true,
+    "nowplaying_file":  false,
+    "nowplaying_json":  false,
+}
+
+// configProblem is something wrong in the config file
+type configProblem struct {
+    Line    int // 0 if it isn't down to one line
+    Message string
+}
+
+// String formats p as reported at startup
+func (p configProblem) String() string {
+    if p.Line > 0 {
+        return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+    }
+    return p.Message
+}
+
+// checkPath checks the path set for option
+func checkPath(option, path string, isDir bool) error {
+    if option == "schedule" && (strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "webcal://")) {
+        return nil
+    }
+    if strings.HasPrefix(path, "~") {
+        return fmt.Errorf("%s: ~ isn't expanded, write the full path", option)
+    }
+    info, err := os.Stat(path)
+    if err == nil && isDir && !info.IsDir() {
+        return fmt.Errorf("%s: %s is not a directory", option, path)
+    }
+    if err == nil && !isDir && info.IsDir() {
+        return fmt.Errorf("%s: %s is a directory", option, path)
+    }
+    if err != nil && option == "schedule" {
+        return fmt.Errorf("schedule: %v", err)
+    }
+    // pianotrap creates directories as needed, but the beets log only as a file
+    if err != nil && option == "beets_log" {
+        if _, err := os.Stat(filepath.Dir(path)); err != nil {
+            return fmt.Errorf("beets_log: %v", err)
+        }
+    }
+    return nil
+}
+
+// valueProblems returns the invalid values in values, pinning each error to the option
+// that causes it, the one without which the error goes away or changes
+func valueProblems(values map[string]string, lines map[string]int, configFile, saveDir string) []configProblem {
+    values = copyValues(values)
+    var problems []configProblem
+    check := func() error {
+        cfg, err := configFromValues(values, configFile, saveDir)
+        if err == nil {
+            err = cfg.validateRun()
+        }
+        return err
+    }
+    // Ordered by line, so the first of several options involved is reported
+    var keys []string
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool { return lines[keys[i]] < lines[keys[j]] })
+
+    for err := check(); err != nil; err = check() {
+        culprit := ""
+        for _, key := range keys {
+            value, ok := values[key]
+            if !ok {
+                continue
+            }
+            delete(values, key)
+            changed := check()
+            values[key] = value
+            if changed == nil || changed.Error() != err.Error() {
+                culprit = key
+                break
+            }
+        }
+        if culprit == "" {
+            return append(problems, configProblem{Message: err.Error()})
+        }
+        problems = append(problems, configProblem{lines[culprit], err.Error()})
+        delete(values, culprit)
+    }
+    return problems
+}
+
+// copyValues returns a copy of values that can be changed
+func copyValues(values map[string]string) map[string]string {
+    c := make(map[string]string, len(values))
+    for key, value := range values {
+        c[key] = value
+    }
+    return c
+}
+
+// checkConfigData returns the problems of a config file's contents with profile
+// applied; saveDir applies if it sets none
+func checkConfigData(data, configFile, profile, saveDir string) []configProblem {
+    values, lines, err := parseConfigLines(data)
+    if err != nil {
+        return []configProblem{{Message: err.Error()}}
+    }
+    var problems []configProblem
+    for _, key := range unknownOptions(values) {
+        problems = append(problems, configProblem{lines[key], "unknown option " + key})
+    }
+    selected, err := selectProfile(values, profile)
+    if err != nil {
+        return append(problems, configProblem{Message: err.Error()})
+    }
+    // Options of the profile are found on its lines
+    selectedLines := make(map[string]int)
+    for key := range selected {
+        selectedLines[key] = lines[key]
+        if line, ok := lines["profile."+profile+"."+key]; ok && profile != "" {
+            selectedLines[key] = line
+        }
+    }
+    for key, value := range selected {
+        if isDir, ok := pathOptions[key]; ok && value != "" {
+            if err := checkPath(key, value, isDir); err != nil {
+                problems = append(problems, configProblem{selectedLines[key], err.Error()})
+                delete(selected, key)
+            }
+        }
+    }
+    problems = append(problems, valueProblems(selected, selectedLines, configFile, saveDir)...)
+    sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+    return problems
+}
+
+// checkConfigFile reads configFile and returns its problems with profile applied
+func checkConfigFile(configFile, profile, saveDir string) ([]configProblem, error) {
+    data, err := ioutil.ReadFile(configFile)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read config file: %v", err)
+    }
+    return checkConfigData(string(data), configFile, profile, saveDir), nil
+}
+
+// configProblemsError lists problems as one error
+func configProblemsError(configFile string, problems []configProblem) error {
+    var lines []string
+    for _, p := range problems {
+        lines = append(lines, "\n  "+p.String())
+    }
+    return fmt.Errorf("%s has %d problem(s):%s", configFile, len(problems), strings.Join(lines, ""))
+}
+
+// runConfigCheck implements "pianotrap config check": the config with -profile, or
+// without one the top-level options and every profile
+func runConfigCheck(cfg Config) error {
+    data, err := ioutil.ReadFile(cfg.ConfigFile)
+    if err != nil {
+        return fmt.Errorf("failed to read config file: %v", err)
+    }
+    profiles := []string{cfg.Profile}
+    if cfg.Profile == "" {
+        if values, err := parseConfig(string(data)); err == nil {
+            profiles = append(profiles, profileNames(values)...)
+        }
+    }
+    // Problems outside profiles show up for each of them
+    seen := make(map[configProblem]bool)
+    var problems []configProblem
+    for _, profile := range profiles {
+        for _, p := range checkConfigData(string(data), cfg.ConfigFile, profile, cfg.SaveDir) {
+            if !seen[p] {
+                seen[p] = true
+                problems = append(problems, p)
+            }
+        }
+    }
+    sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+    for _, p := range problems {
+        if p.Line > 0 {
+            fmt.Printf("%s:%d: %s\n", cfg.ConfigFile, p.Line, p.Message)
+        } else {
+            fmt.Printf("%s: %s\n", cfg.ConfigFile, p.Message)
+        }
+    }
+    if len(problems) > 0 {
+        return fmt.Errorf("%d problem(s) in %s", len(problems), cfg.ConfigFile)
+    }
+    fmt.Printf("%s is valid\n", cfg.ConfigFile)
+    return nil
+}
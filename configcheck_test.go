@@ -0,0 +1,55 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+    dir := t.TempDir()
+    notDir := filepath.Join(dir, "file")
+    ioutil.WriteFile(notDir, nil, 0644)
+    data := `savedir = ` + notDir + `
+gain = 99
+
+[mqtt]
+brokr = tcp://broker:1883
+
+[files]
+path_template = "{{.Titel}}"
+
+[keys]
+record_key = ctrl+d
+
+[profile.work]
+savedir = "` + dir + `"
+beets_log = ~/beets.log
+schedule = ` + filepath.Join(dir, "missing.ics") + `
+`
+    want := []int{1, 2, 5, 8, 11}
+    var got []int
+    for _, p := range checkConfigData(data, filepath.Join(dir, "config"), "", "/music") {
+        got = append(got, p.Line)
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("problems on lines %v, want %v: %v", got, want, checkConfigData(data, "config", "", "/music"))
+    }
+    // The profile's savedir replaces the broken one
+    want = []int{2, 5, 8, 11, 15, 16}
+    got = nil
+    for _, p := range checkConfigData(data, filepath.Join(dir, "config"), "work", "/music") {
+        got = append(got, p.Line)
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("work problems on lines %v, want %v: %v", got, want, checkConfigData(data, "config", "work", "/music"))
+    }
+    if problems := checkConfigData("gain = 1\n[mqtt\n", "config", "", "/music"); len(problems) != 1 || !strings.Contains(problems[0].Message, "line 2") {
+        t.Errorf("parse error reported as %v", problems)
+    }
+    if problems := checkConfigData("savedir = /music\nannounce = piper\nannounce_voice = en.onnx\n", "config", "", "/music"); len(problems) > 0 {
+        t.Errorf("valid config has problems %v", problems)
+    }
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "golang.org/x/term"
+)
+
+// A running pianotrap listens on a unix socket next to its config file for one-line
+// commands. "pianotrap shell" connects to it to look at the recorder's state, change
+// settings and feed it made-up pianobar output or events, which helps reproduce a
+// user's problem without their stations. Each reply ends with an empty line.
+
+// injectedOutput carries output fed in with "inject output" to the PTY reader, and
+// injectedRest is what of it didn't fit the reader's buffer yet. Only the PTY reader
+// touches injectedRest.
+var (
+    injectedOutput = make(chan string, 10)
+    injectedRest   string
+)
+
+// controlListener is the control socket of this session, if it's listening at
+// controlPath
+var (
+    controlListener net.Listener
+    controlPath     string
+)
+
+const controlHelp = `status                     what is playing, as published to MQTT
+state                      the recorder's state
+queues                     fill levels of the output and boundary taps
+settings                   the settings that can be changed at runtime
+set <key>=<value>|save     change a setting, or write them to the config file
+record|keep|discard        press the record, keep or discard key
+player skip|pause|love     send a command to pianobar
+inject output <text>       process text as if pianobar printed it (\n, \r and \x1b are unescaped)
+inject event <name> [key=value...]
+                           handle a pianobar event, e.g. songstart title="Blue in Green" artist="Miles Davis"
+help                       this list
+quit                       close the shell`
+
+// controlSocketPath is where the session using cfg's config file and profile listens
+func controlSocketPath(cfg Config) string {
+    if cfg.Profile != "" {
+        return filepath.Join(filepath.Dir(cfg.ConfigFile), "control-"+cfg.Profile+".sock")
+    }
+    return filepath.Join(filepath.Dir(cfg.ConfigFile), "control.sock")
+}
+
+// startControlSocket listens for commands until stopControlSocket is called. A
+// second session with the same config and profile gets no socket.
+func startControlSocket(cfg Config) {
+    path := controlSocketPath(cfg)
+    if conn, err := net.Dial("unix", path); err == nil {
+        conn.Close()
+        logger.Printf("Control socket %s is in use by another session", path)
+        return
+    }
+    os.Remove(path) // left over from a crash
+    listener, err := listenPrivate(path)
+    if err != nil {
+        logger.Printf("Control socket unavailable: %v", err)
+        return
+    }
+    controlListener, controlPath = listener, path
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go serveControl(cfg, conn)
+        }
+    }()
+}
+
+// listenPrivate listens on a unix socket at path that only this user can connect
+// to, as it can change settings and type into pianobar. The socket is made in a
+// private directory and only moved to path once it's locked down, so no one gets
+// in while it still has the umask's permissions.
+func listenPrivate(path string) (*net.UnixListener, error) {
+    dir, err := ioutil.TempDir(filepath.Dir(path), ".pianotrap-")
+    if err != nil {
+        return nil, err
+    }
+    defer os.RemoveAll(dir)
+    tmp := filepath.Join(dir, filepath.Base(path))
+    listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+    if err != nil {
+        return nil, err
+    }
+    // Closing would remove tmp, which is gone by then; stopControlSocket removes path
+    listener.SetUnlinkOnClose(false)
+    if err = os.Chmod(tmp, 0600); err == nil {
+        err = os.Rename(tmp, path)
+    }
+    if err != nil {
+        listener.Close()
+        return nil, err
+    }
+    return listener, nil
+}
+
+// stopControlSocket closes the control socket and removes it
+func stopControlSocket() {
+    if controlListener != nil {
+        controlListener.Close()
+        os.Remove(controlPath)
+        controlListener, controlPath = nil, ""
+    }
+}
+
+// serveControl answers the commands of one connection
+func serveControl(cfg Config, conn net.Conn) {
+    defer conn.Close()
+    scanner := bufio.NewScanner(conn)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "quit" {
+            return
+        }
+        reply, err := controlCommand(cfg, line)
+        if err != nil {
+            reply = "error: " + err.Error()
+        }
+        if reply != "" {
+            reply += "\n"
+        }
+        if _, err := io.WriteString(conn, reply+"\n"); err != nil {
+            return
+        }
+    }
+}
+
+// controlCommand carries out one command and returns the reply, without empty lines
+func controlCommand(cfg Config, line string) (string, error) {
+    name, rest := line, ""
+    if i := strings.IndexByte(line, ' '); i >= 0 {
+        name, rest = line[:i], strings.TrimSpace(line[i+1:])
+    }
+    switch name {
+    case "":
+        return "", nil
+    case "help":
+        return controlHelp, nil
+    case "status":
+        data, err := json.MarshalIndent(currentStatus(), "", "  ")
+        return string(data), err
+    case "state":
+        return recorderState(), nil
+    case "queues":
+        return tapDepths(), nil
+    case "settings":
+        return settingsLines(), nil
+    case "set":
+        if err := handleSetCommand(rest, cfg); err != nil {
+            return "", err
+        }
+        return "ok", nil
+    case "record":
+        toggleCapture()
+        return fmt.Sprintf("capture paused: %v", captureIsPaused()), nil
+    case "keep":
+        keepCurrent()
+        mu.Lock()
+        defer mu.Unlock()
+        return fmt.Sprintf("keep requested: %v", keepRequested), nil
+    case "discard":
+        discardCurrent()
+        return "ok", nil
+    case "player":
+        keys, ok := mqttCommands[rest]
+        if !ok {
+            return "", fmt.Errorf("unknown player command %q", rest)
+        }
+        if err := sendKeys(keys); err != nil {
+            return "", err
+        }
+        return "ok", nil
+    case "inject":
+        return injectCommand(rest)
+    }
+    return "", fmt.Errorf("unknown command %q (try help)", name)
+}
+
+// injectCommand feeds synthetic pianobar output or events to the session
+func injectCommand(args string) (string, error) {
+    kind, rest := args, ""
+    if i := strings.IndexByte(args, ' '); i >= 0 {
+        kind, rest = args[:i], args[i+1:]
+    }
+    switch kind {
+    case "output":
+        text, err := strconv.Unquote(`"` + strings.Replace(rest, `"`, `\"`, -1) + `"`)
+        if err != nil {
+            return "", fmt.Errorf("invalid escape in %q", rest)
+        }
+        if !strings.HasSuffix(text, "\n") {
+            text += "\n"
+        }
+        if len(text) > 1024 {
+            return "", fmt.Errorf("output longer than 1024 bytes")
+        }
+        select {
+        case injectedOutput <- text:
+        default:
+            return "", fmt.Errorf("output queue full; is pianobar running?")
+        }
+        logger.Printf("Control: injected output %q", text)
+        return "ok", nil
+    case "event":
+        fields, err := splitQuoted(rest)
+        if err != nil {
+            return "", err
+        }
+        if len(fields) == 0 {
+            return "", fmt.Errorf("usage: inject event <name> [key=value...]")
+        }
+        event := map[string]string{"event": fields[0]}
+        for _, field := range fields[1:] {
+            parts := strings.SplitN(field, "=", 2)
+            if len(parts) != 2 {
+                return "", fmt.Errorf("expected key=value, got %q", field)
+            }
+            event[parts[0]] = parts[1]
+        }
+        logger.Printf("Control: injected event %v", event)
+        handlePianobarEvent(event)
+        return "ok", nil
+    }
+    return "", fmt.Errorf("usage: inject output <text> or inject event <name> [key=value...]")
+}
+
+// splitQuoted splits s at spaces outside double quotes and removes the quotes
+func splitQuoted(s string) ([]string, error) {
+    var fields []string
+    var field strings.Builder
+    inField, quoted := false, false
+    for _, r := range s {
+        switch {
+        case r == '"':
+            quoted, inField = !quoted, true
+        case r == ' ' && !quoted:
+            if inField {
+                fields = append(fields, field.String())
+                field.Reset()
+                inField = false
+            }
+        default:
+            field.WriteRune(r)
+            inField = true
+        }
+    }
+    if quoted {
+        return nil, fmt.Errorf("unbalanced quotes in %q", s)
+    }
+    if inField {
+        fields = append(fields, field.String())
+    }
+    return fields, nil
+}
+
+// readPTY reads pianobar's output, giving output injected over the control socket
+// precedence. Injected output longer than buf comes over several reads.
+func readPTY(f *os.File, buf []byte) (int, error) {
+    if injectedRest == "" {
+        select {
+        case injectedRest = <-injectedOutput:
+        default:
+            return f.Read(buf)
+        }
+    }
+    n := copy(buf, injectedRest)
+    injectedRest = injectedRest[n:]
+    return n, nil
+}
+
+// recorderState describes the recorder's state machine and what drives it
+func recorderState() string {
+    mu.Lock()
+    state := "idle"
+    switch {
+    case recording:
+        state = "recording"
+    case capturePaused:
+        state = "paused"
+    }
+    lines := []string{
+        "state: " + state,
+        fmt.Sprintf("capture paused: %v", capturePaused),
+        fmt.Sprintf("keep requested: %v", keepRequested),
+        fmt.Sprintf("discard requested: %v", discardRequested),
+        "station: " + currentStation,
+        "file: " + currentFileName,
+        fmt.Sprintf("remaining: %v of %v", remainingTime, totalDuration),
+        fmt.Sprintf("incomplete if stopped now: %v", recording && totalDuration > 0 && songIncomplete()),
+    }
+    if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+        lines = append(lines, fmt.Sprintf("ffmpeg: pid %d", ffmpegCmd.Process.Pid))
+    } else {
+        lines = append(lines, "ffmpeg: not running")
+    }
+    if lastCountdown.IsZero() {
+        lines = append(lines, "last countdown: never")
+    } else {
+        lines = append(lines, fmt.Sprintf("last countdown: %v ago", time.Since(lastCountdown).Round(time.Second)))
+    }
+    mu.Unlock()
+    lines = append(lines, fmt.Sprintf("within schedule: %v", recordingAllowed()))
+    return strings.Join(lines, "\n")
+}
+
+// tapDepths lists how full each output and boundary tap is
+func tapDepths() string {
+    var lines []string
+    outputTapsMu.Lock()
+    for i, tap := range outputTaps {
+        lines = append(lines, fmt.Sprintf("output tap %d: %d/%d", i, len(tap), cap(tap)))
+    }
+    outputTapsMu.Unlock()
+    boundaryTapsMu.Lock()
+    for i, tap := range boundaryTaps {
+        lines = append(lines, fmt.Sprintf("boundary tap %d: %d/%d", i, len(tap), cap(tap)))
+    }
+    boundaryTapsMu.Unlock()
+    lines = append(lines, fmt.Sprintf("injected output: %d/%d", len(injectedOutput), cap(injectedOutput)))
+    return strings.Join(lines, "\n")
+}
+
+// settingsLines lists the runtime settings as key = value lines
+func settingsLines() string {
+    values := runtimeSettings()
+    var keys []string
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    var lines []string
+    for _, key := range keys {
+        lines = append(lines, key+" = "+values[key])
+    }
+    return strings.Join(lines, "\n")
+}
+
+// runShell implements "pianotrap shell"
+func runShell(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "usage: pianotrap shell [command]\n\nWithout a command, read commands from the terminal. Commands:\n\n%s\n", controlHelp)
+    }
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    path := controlSocketPath(cfg)
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        if cfg.Profile != "" {
+            return fmt.Errorf("no pianotrap running with %s, profile %s: %v", cfg.ConfigFile, cfg.Profile, err)
+        }
+        return fmt.Errorf("no pianotrap running with %s: %v", cfg.ConfigFile, err)
+    }
+    defer conn.Close()
+    replies := bufio.NewReader(conn)
+
+    // send runs a command and returns its reply
+    send := func(line string) (string, error) {
+        if _, err := fmt.Fprintln(conn, line); err != nil {
+            return "", err
+        }
+        var reply strings.Builder
+        for {
+            text, err := replies.ReadString('\n')
+            if err != nil {
+                return "", fmt.Errorf("connection closed")
+            }
+            if text == "\n" {
+                return reply.String(), nil
+            }
+            reply.WriteString(text)
+        }
+    }
+
+    if fs.NArg() > 0 {
+        reply, err := send(strings.Join(fs.Args(), " "))
+        if err != nil {
+            return err
+        }
+        if strings.HasPrefix(reply, "error: ") {
+            return fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(reply, "error: ")))
+        }
+        fmt.Print(reply)
+        return nil
+    }
+    interactive := term.IsTerminal(int(os.Stdin.Fd()))
+    if interactive {
+        fmt.Printf("Connected to %s; type help for the commands.\n", path)
+    }
+    input := bufio.NewScanner(os.Stdin)
+    for {
+        if interactive {
+            fmt.Print("pianotrap> ")
+        }
+        if !input.Scan() {
+            return input.Err()
+        }
+        line := strings.TrimSpace(input.Text())
+        if line == "quit" || line == "exit" {
+            return nil
+        }
+        if line == "" {
+            continue
+        }
+        reply, err := send(line)
+        if err != nil {
+            return err
+        }
+        fmt.Print(reply)
+    }
+}
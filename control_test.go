@@ -0,0 +1,75 @@
+package main
+
+import (
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestControlCommand(t *testing.T) {
+    cfg := Config{ConfigFile: filepath.Join(t.TempDir(), "config")}
+    if _, err := controlCommand(cfg, `inject output |>  "Song" by "Artist" on "Album"\r\x1b[2K`); err != nil {
+        t.Fatal(err)
+    }
+    if got, want := <-injectedOutput, "|>  \"Song\" by \"Artist\" on \"Album\"\r\x1b[2K\n"; got != want {
+        t.Errorf("injected %q, want %q", got, want)
+    }
+
+    fields, err := splitQuoted(`songstart title="Blue in Green" artist=Miles`)
+    if want := []string{"songstart", "title=Blue in Green", "artist=Miles"}; err != nil || !reflect.DeepEqual(fields, want) {
+        t.Errorf("splitQuoted = %q, %v; want %q", fields, err, want)
+    }
+    if _, err := splitQuoted(`title="Blue`); err == nil {
+        t.Error("unbalanced quotes accepted")
+    }
+
+    if reply, err := controlCommand(cfg, "state"); err != nil || !strings.HasPrefix(reply, "state: idle\n") {
+        t.Errorf("state = %q, %v", reply, err)
+    }
+    for _, line := range []string{"frobnicate", "player dance", "inject event", "set nonsense"} {
+        if _, err := controlCommand(cfg, line); err == nil {
+            t.Errorf("%q accepted", line)
+        }
+    }
+}
+
+func TestReadPTYInjected(t *testing.T) {
+    text := strings.Repeat("|>  x\r\n", 300)
+    injectedOutput <- text
+    var got strings.Builder
+    buf := make([]byte, 1024)
+    for got.Len() < len(text) {
+        n, err := readPTY(nil, buf)
+        if err != nil || n == 0 {
+            t.Fatalf("readPTY = %d, %v after %d bytes", n, err, got.Len())
+        }
+        got.Write(buf[:n])
+    }
+    if got.String() != text {
+        t.Errorf("injected output came back changed")
+    }
+}
+
+func TestControlSocket(t *testing.T) {
+    dir := t.TempDir()
+    cfg := Config{ConfigFile: filepath.Join(dir, "config")}
+    startControlSocket(cfg)
+    path := controlSocketPath(cfg)
+    info, err := os.Stat(path)
+    if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+        t.Fatalf("control socket = %v, %v", info, err)
+    }
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conn.Close()
+    stopControlSocket()
+    if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+        t.Errorf("left behind %s", files[0].Name())
+    }
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// The HTTP API also serves a page at / to drive pianotrap from a browser, e.g. when
+// it runs headless on a media box: what is playing with its cover art, whether it's
+// being recorded, what this session recorded so far, and buttons for the keys. The
+// page only uses the API, polling the status every two seconds.
+
+// httpStarted is when the API started serving; session statistics count from it
+var httpStarted time.Time
+
+// apiStats is what the API says about the recordings of this session
+type apiStats struct {
+    Since     time.Time      `json:"since"`
+    Outcomes  map[string]int `json:"outcomes"`
+    Recorded  int            `json:"recorded"`
+    Loved     int            `json:"loved"`
+    Bytes     int64          `json:"bytes"`
+    FreeBytes uint64         `json:"free_bytes"`
+    DaysLeft  int            `json:"days_left"`
+}
+
+// handleStats counts the songs detected since the API started, by outcome
+func handleStats(w http.ResponseWriter, r *http.Request) {
+    if db == nil {
+        writeError(w, http.StatusServiceUnavailable, fmt.Errorf("song history is disabled"))
+        return
+    }
+    stats, err := sessionStats()
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, stats)
+}
+
+// sessionStats sums up the songs detected since the API started
+func sessionStats() (apiStats, error) {
+    report, err := buildReport(currentConfig(), httpStarted, time.Now())
+    return apiStats{report.From, report.Outcomes, len(report.Saved), report.Loved, report.AddedBytes, report.FreeBytes, report.DaysLeft}, err
+}
+
+// handleCover sends the cover art of the song playing
+func handleCover(w http.ResponseWriter, r *http.Request) {
+    art := currentStatus().CoverArt
+    if art == "" {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Cache-Control", "no-cache")
+    http.ServeFile(w, r, art)
+}
+
+// handleDashboard sends the dashboard page
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    fmt.Fprint(w, dashboardPage)
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>pianotrap</title>
+<style>
+body{font-family:sans-serif;max-width:32em;margin:auto;padding:1em;background:#111;color:#eee}
+#cover{width:100%;aspect-ratio:1;object-fit:cover;background:#222;border-radius:6px}
+h1{font-size:1.4em;margin:.6em 0 .1em}p{margin:.3em 0}.dim{color:#999}
+progress{width:100%}button{font-size:1.1em;padding:.5em .8em;margin:.2em;border-radius:6px;border:0;background:#333;color:#eee}
+button:active{background:#555}#rec.on{background:#a22}#error{color:#f66}
+form{display:flex;margin:.5em 0}input{flex:1;font-size:1.1em;padding:.4em;border-radius:6px;border:0}
+</style></head><body>
+<img id="cover" alt="">
+<h1 id="title">Nothing playing</h1>
+<p id="artist"></p><p id="station" class="dim"></p>
+<progress id="progress" max="1" value="0"></progress>
+<p id="state" class="dim"></p>
+<p>
+<button data-api="pause">Play/pause</button><button data-api="skip">Skip</button><button data-api="love">Love</button>
+</p><p>
+<button id="rec" data-api="recording">Recording</button><button data-api="keep">Keep</button><button data-api="discard">Discard</button>
+</p>
+<form id="station-form"><input id="station-name" placeholder="Station"><button>Switch</button></form>
+<p id="error"></p>
+<h2>This session</h2>
+<p id="stats" class="dim">No song history</p>
+<script>
+var cover = "";
+function $(id) { return document.getElementById(id); }
+function mmss(s) { return Math.floor(s / 60) + ":" + ("0" + s % 60).slice(-2); }
+function show(s) {
+  $("title").textContent = s.title || "Nothing playing";
+  $("artist").textContent = s.artist ? s.artist + (s.album ? " — " + s.album : "") + (s.loved ? " ♥" : "") : "";
+  $("station").textContent = s.station;
+  $("progress").max = s.total_seconds || 1;
+  $("progress").value = s.total_seconds - s.remaining_seconds;
+  var state = s.state;
+  if (s.total_seconds) state += ", " + mmss(s.remaining_seconds) + " left";
+  if (s.recording_paused) state += ", recording paused";
+  else if (s.recording) state += ", recording to " + s.file;
+  $("state").textContent = state;
+  $("rec").className = s.recording_paused ? "" : "on";
+  $("rec").textContent = s.recording_paused ? "Resume recording" : "Pause recording";
+  if (s.cover_art != cover) {
+    cover = s.cover_art || "";
+    $("cover").src = cover ? "/api/cover?" + encodeURIComponent(s.title + "\n" + s.artist) : "";
+  }
+}
+function call(method, path) {
+  return fetch(path, {method: method}).then(function(r) {
+    return r.json().then(function(body) {
+      if (!r.ok) throw new Error(body.error || r.statusText);
+      return body;
+    });
+  });
+}
+function refresh() {
+  call("GET", "/api/status").then(function(s) { $("error").textContent = ""; show(s); },
+    function(e) { $("error").textContent = e.message; });
+}
+function stats() {
+  call("GET", "/api/stats").then(function(s) {
+    var text = s.recorded + " recorded (" + (s.bytes / 1048576).toFixed(1) + " MB), " + s.loved + " loved";
+    for (var o in s.outcomes) if (o != "saved") text += ", " + s.outcomes[o] + " " + o;
+    text += ". " + (s.free_bytes / 1073741824).toFixed(1) + " GB free";
+    if (s.days_left >= 0) text += ", about " + s.days_left + " days at this rate";
+    $("stats").textContent = text + ".";
+  }, function() {});
+}
+document.querySelectorAll("button[data-api]").forEach(function(b) {
+  b.onclick = function() {
+    call("POST", "/api/" + b.dataset.api).then(show, function(e) { $("error").textContent = e.message; });
+  };
+});
+$("station-form").onsubmit = function(e) {
+  e.preventDefault();
+  call("POST", "/api/station?name=" + encodeURIComponent($("station-name").value)).then(show,
+    function(e) { $("error").textContent = e.message; });
+};
+refresh(); stats();
+setInterval(refresh, 2000); setInterval(stats, 10000);
+</script>
+</body></html>
+`
@@ -0,0 +1,203 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
+    _ "modernc.org/sqlite"
+)
+
+// The recording database keeps a row for every detected song and what became of
+// it, so history survives restarts. Times are stored in UTC so they sort and
+// compare as text.
+
+var db *sql.DB
+
+// Song outcomes
+const (
+    outcomeRecording   = "recording"
+    outcomeSaved       = "saved"
+    outcomeDeleted     = "deleted"
+    outcomeSkipped     = "skipped"
+    outcomeFailed      = "failed"
+    outcomeInterrupted = "interrupted"
+    outcomeImported    = "imported"
+)
+
+// migrations upgrade the schema one version at a time; the index is tracked in
+// PRAGMA user_version
+var migrations = []string{
+    `CREATE TABLE songs (
+        id          INTEGER PRIMARY KEY,
+        title       TEXT NOT NULL,
+        artist      TEXT NOT NULL,
+        album       TEXT NOT NULL,
+        station     TEXT NOT NULL,
+        loved       INTEGER NOT NULL DEFAULT 0,
+        detected_at TIMESTAMP NOT NULL,
+        finished_at TIMESTAMP,
+        file        TEXT NOT NULL DEFAULT '',
+        outcome     TEXT NOT NULL,
+        acoustid    TEXT NOT NULL DEFAULT '',
+        fingerprint TEXT NOT NULL DEFAULT ''
+    );
+    CREATE INDEX songs_artist_title ON songs (artist, title);
+    CREATE INDEX songs_file ON songs (file);`,
+    `CREATE TABLE actions (
+        id     INTEGER PRIMARY KEY,
+        at     TIMESTAMP NOT NULL,
+        kind   TEXT NOT NULL,
+        path   TEXT NOT NULL,
+        target TEXT NOT NULL DEFAULT '',
+        undone INTEGER NOT NULL DEFAULT 0
+    );`,
+    `ALTER TABLE actions ADD COLUMN done INTEGER NOT NULL DEFAULT 1;`,
+    `ALTER TABLE songs ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+    ALTER TABLE songs ADD COLUMN verified_at TIMESTAMP;`,
+    `CREATE TABLE steps (
+        song_id INTEGER NOT NULL,
+        step    TEXT NOT NULL,
+        status  TEXT NOT NULL,
+        error   TEXT NOT NULL DEFAULT '',
+        at      TIMESTAMP NOT NULL,
+        PRIMARY KEY (song_id, step)
+    );
+    ALTER TABLE songs ADD COLUMN art_url TEXT NOT NULL DEFAULT '';`,
+    `CREATE TABLE scrobbles (
+        id          INTEGER PRIMARY KEY,
+        service     TEXT NOT NULL,
+        listened_at TIMESTAMP NOT NULL,
+        payload     TEXT NOT NULL,
+        attempts    INTEGER NOT NULL DEFAULT 0,
+        error       TEXT NOT NULL DEFAULT ''
+    );
+    CREATE INDEX scrobbles_service ON scrobbles (service, listened_at);`,
+}
+
+// openDatabase opens (creating and migrating if needed) the database at path
+func openDatabase(path string) (*sql.DB, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create database directory: %v", err)
+    }
+    conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %v", err)
+    }
+    // SQLite allows one writer; sharing a single connection avoids lock errors
+    conn.SetMaxOpenConns(1)
+
+    var version int
+    if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("failed to read database version: %v", err)
+    }
+    for ; version < len(migrations); version++ {
+        if _, err := conn.Exec(migrations[version]); err != nil {
+            conn.Close()
+            return nil, fmt.Errorf("failed to migrate database to version %d: %v", version+1, err)
+        }
+        if _, err := conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+            conn.Close()
+            return nil, fmt.Errorf("failed to migrate database to version %d: %v", version+1, err)
+        }
+    }
+    return conn, nil
+}
+
+// markInterrupted flags recordings still in progress, which belong to a previous run
+// that didn't exit cleanly
+func markInterrupted() {
+    if db == nil {
+        return
+    }
+    if _, err := db.Exec("UPDATE songs SET outcome = ? WHERE outcome = ?", outcomeInterrupted, outcomeRecording); err != nil {
+        logger.Printf("Failed to update database: %v", err)
+    }
+}
+
+// logDetectedSong adds a row for a newly detected song
+func logDetectedSong(info songInfo, station, fileName, outcome string) {
+    if db == nil {
+        return
+    }
+    _, err := db.Exec(`INSERT INTO songs (title, artist, album, station, loved, detected_at, file, outcome)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+        info.Title, info.Artist, info.Album, station, info.Loved, time.Now().UTC(), fileName, outcome)
+    if err != nil {
+        logger.Printf("Failed to log %q by %q: %v", info.Title, info.Artist, err)
+    }
+}
+
+// setSongOutcome records what happened to the latest recording of fileName
+func setSongOutcome(fileName, outcome string) {
+    if db == nil || fileName == "" {
+        return
+    }
+    _, err := db.Exec(`UPDATE songs SET outcome = ?, finished_at = ?
+        WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)`, outcome, time.Now().UTC(), fileName)
+    if err != nil {
+        logger.Printf("Failed to update %s in database: %v", fileName, err)
+    }
+}
+
+// recordSavedSong marks a recording as saved along with what tagging learned about it
+func recordSavedSong(fileName string, tags Tags) {
+    if db == nil {
+        return
+    }
+    _, err := db.Exec(`UPDATE songs SET outcome = ?, finished_at = ?, album = ?, loved = ?, acoustid = ?, fingerprint = ?
+        WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)`,
+        outcomeSaved, time.Now().UTC(), tags.Album, tags.Loved, tags.Custom["ACOUSTID_ID"], tags.Custom["ACOUSTID_FINGERPRINT"], fileName)
+    if err != nil {
+        logger.Printf("Failed to update %s in database: %v", fileName, err)
+    }
+}
+
+// renameSong points the latest row for oldName at newName with corrected metadata
+func renameSong(oldName, newName string, tags Tags) {
+    if db == nil {
+        return
+    }
+    artist := ""
+    if len(tags.Artists) > 0 {
+        artist = tags.Artists[0]
+    }
+    _, err := db.Exec(`UPDATE songs SET file = ?, title = ?, artist = ?, album = ?
+        WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)`, newName, tags.Title, artist, tags.Album, oldName)
+    if err != nil {
+        logger.Printf("Failed to update %s in database: %v", oldName, err)
+    }
+}
+
+// songInLibrary reports whether a recording of the song has already been saved or imported
+func songInLibrary(title, artist string) bool {
+    if db == nil {
+        return false
+    }
+    var n int
+    err := db.QueryRow(`SELECT COUNT(*) FROM songs WHERE outcome IN (?, ?) AND title = ? COLLATE NOCASE AND artist = ? COLLATE NOCASE`,
+        outcomeSaved, outcomeImported, title, artist).Scan(&n)
+    if err != nil {
+        logger.Printf("Failed to query database: %v", err)
+        return false
+    }
+    return n > 0
+}
+
+// savedByArtistSince counts the artist's recordings saved after since
+func savedByArtistSince(artist string, since time.Time) int {
+    if db == nil {
+        return 0
+    }
+    var n int
+    err := db.QueryRow(`SELECT COUNT(*) FROM songs WHERE outcome = ? AND artist = ? COLLATE NOCASE AND detected_at >= ?`,
+        outcomeSaved, artist, since.UTC()).Scan(&n)
+    if err != nil {
+        logger.Printf("Failed to query database: %v", err)
+        return 0
+    }
+    return n
+}
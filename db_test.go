@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestDatabaseOutcomes(t *testing.T) {
+    path := openTestDB(t)
+
+    info := songInfo{Title: "Song", Artist: "Artist", Album: "Album"}
+    logDetectedSong(info, "Station", "/music/a.mp3", outcomeRecording)
+    logDetectedSong(info, "Station", "/music/b.mp3", outcomeRecording)
+    logDetectedSong(info, "Station", "", outcomeSkipped)
+    setSongOutcome("/music/a.mp3", outcomeDeleted)
+    recordSavedSong("/music/b.mp3", Tags{Album: "Album", Custom: map[string]string{"ACOUSTID_ID": "abc"}})
+
+    want := map[string]string{"/music/a.mp3": outcomeDeleted, "/music/b.mp3": outcomeSaved, "": outcomeSkipped}
+    rows, err := db.Query("SELECT file, outcome FROM songs")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var file, outcome string
+        if err := rows.Scan(&file, &outcome); err != nil {
+            t.Fatal(err)
+        }
+        if want[file] != outcome {
+            t.Errorf("%q: outcome %q, want %q", file, outcome, want[file])
+        }
+    }
+
+    // A new run marks recordings left in progress as interrupted
+    logDetectedSong(info, "Station", "/music/c.mp3", outcomeRecording)
+    db.Close()
+    if db, err = openDatabase(path); err != nil {
+        t.Fatal(err)
+    }
+    markInterrupted()
+    var outcome string
+    if err := db.QueryRow("SELECT outcome FROM songs WHERE file = ?", "/music/c.mp3").Scan(&outcome); err != nil {
+        t.Fatal(err)
+    }
+    if outcome != outcomeInterrupted {
+        t.Errorf("outcome %q, want %q", outcome, outcomeInterrupted)
+    }
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// A minimal D-Bus client for the session bus, enough to own a name, answer method
+// calls and send signals for the MPRIS interface. Values are Go types: byte, bool,
+// int32, uint32, int64, uint64, float64 and string map to their D-Bus types, and the
+// types below to the rest. Decoded arrays are []interface{}.
+
+// dbusObjectPath is a value of type o
+type dbusObjectPath string
+
+// dbusSignature is a value of type g
+type dbusSignature string
+
+// dbusVariant is a value of type v
+type dbusVariant struct {
+    Value interface{}
+}
+
+// dbusStruct is a struct or dict entry
+type dbusStruct []interface{}
+
+// Message types
+const (
+    dbusMethodCall   = 1
+    dbusMethodReturn = 2
+    dbusError        = 3
+    dbusSignal       = 4
+)
+
+// dbusNoReplyExpected is the flag of method calls that want no reply
+const dbusNoReplyExpected = 0x1
+
+// dbusMessage is a message with its header fields
+type dbusMessage struct {
+    Type        byte
+    Flags       byte
+    Serial      uint32
+    Path        dbusObjectPath
+    Interface   string
+    Member      string
+    ErrorName   string
+    ReplySerial uint32
+    Destination string
+    Sender      string
+    Body        []interface{}
+}
+
+// dbusSig returns the signature of v
+func dbusSig(v interface{}) string {
+    switch v := v.(type) {
+    case byte:
+        return "y"
+    case bool:
+        return "b"
+    case int32:
+        return "i"
+    case uint32:
+        return "u"
+    case int64:
+        return "x"
+    case uint64:
+        return "t"
+    case float64:
+        return "d"
+    case string:
+        return "s"
+    case dbusObjectPath:
+        return "o"
+    case dbusSignature:
+        return "g"
+    case dbusVariant:
+        return "v"
+    case []string:
+        return "as"
+    case map[string]dbusVariant:
+        return "a{sv}"
+    case dbusStruct:
+        sig := "("
+        for _, field := range v {
+            sig += dbusSig(field)
+        }
+        return sig + ")"
+    case []dbusStruct:
+        return "a" + dbusSig(v[0])
+    }
+    panic(fmt.Sprintf("dbus: can't encode %T", v))
+}
+
+// dbusEncoder writes values in little-endian wire format; offsets count from the
+// start of the header or body, which both start 8-aligned
+type dbusEncoder struct {
+    b []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+    for len(e.b)%n != 0 {
+        e.b = append(e.b, 0)
+    }
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+    e.align(4)
+    e.b = binary.LittleEndian.AppendUint32(e.b, v)
+}
+
+func (e *dbusEncoder) uint64(v uint64) {
+    e.align(8)
+    e.b = binary.LittleEndian.AppendUint64(e.b, v)
+}
+
+func (e *dbusEncoder) string(s string) {
+    e.uint32(uint32(len(s)))
+    e.b = append(append(e.b, s...), 0)
+}
+
+// array writes the elements added by elems, aligned to elemAlign
+func (e *dbusEncoder) array(elemAlign int, elems func()) {
+    e.uint32(0)
+    lengthAt := len(e.b) - 4
+    e.align(elemAlign)
+    start := len(e.b)
+    elems()
+    binary.LittleEndian.PutUint32(e.b[lengthAt:], uint32(len(e.b)-start))
+}
+
+func (e *dbusEncoder) value(v interface{}) {
+    switch v := v.(type) {
+    case byte:
+        e.b = append(e.b, v)
+    case bool:
+        if v {
+            e.uint32(1)
+        } else {
+            e.uint32(0)
+        }
+    case int32:
+        e.uint32(uint32(v))
+    case uint32:
+        e.uint32(v)
+    case int64:
+        e.uint64(uint64(v))
+    case uint64:
+        e.uint64(v)
+    case float64:
+        e.uint64(math.Float64bits(v))
+    case string:
+        e.string(v)
+    case dbusObjectPath:
+        e.string(string(v))
+    case dbusSignature:
+        e.b = append(append(append(e.b, byte(len(v))), v...), 0)
+    case dbusVariant:
+        e.value(dbusSignature(dbusSig(v.Value)))
+        e.value(v.Value)
+    case []string:
+        e.array(4, func() {
+            for _, s := range v {
+                e.string(s)
+            }
+        })
+    case map[string]dbusVariant:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        e.array(8, func() {
+            for _, key := range keys {
+                e.align(8)
+                e.string(key)
+                e.value(v[key])
+            }
+        })
+    case dbusStruct:
+        e.align(8)
+        for _, field := range v {
+            e.value(field)
+        }
+    case []dbusStruct:
+        e.array(8, func() {
+            for _, s := range v {
+                e.value(s)
+            }
+        })
+    default:
+        panic(fmt.Sprintf("dbus: can't encode %T", v))
+    }
+}
+
+// marshal encodes m with the given serial
+func (m *dbusMessage) marshal(serial uint32) []byte {
+    var body dbusEncoder
+    sig := ""
+    for _, v := range m.Body {
+        sig += dbusSig(v)
+        body.value(v)
+    }
+    var fields []dbusStruct
+    field := func(code byte, v interface{}) {
+        fields = append(fields, dbusStruct{code, dbusVariant{v}})
+    }
+    if m.Path != "" {
+        field(1, m.Path)
+    }
+    for code, s := range map[byte]string{2: m.Interface, 3: m.Member, 4: m.ErrorName, 6: m.Destination} {
+        if s != "" {
+            field(code, s)
+        }
+    }
+    if m.ReplySerial != 0 {
+        field(5, m.ReplySerial)
+    }
+    if sig != "" {
+        field(8, dbusSignature(sig))
+    }
+    sort.Slice(fields, func(i, j int) bool { return fields[i][0].(byte) < fields[j][0].(byte) })
+
+    header := dbusEncoder{[]byte{'l', m.Type, m.Flags, 1}}
+    header.uint32(uint32(len(body.b)))
+    header.uint32(serial)
+    header.value(fields)
+    header.align(8)
+    return append(header.b, body.b...)
+}
+
+// dbusDecoder reads values from a message
+type dbusDecoder struct {
+    b     []byte
+    pos   int
+    order binary.ByteOrder
+}
+
+func (d *dbusDecoder) align(n int) error {
+    for d.pos%n != 0 {
+        d.pos++
+    }
+    if d.pos > len(d.b) {
+        return fmt.Errorf("dbus: truncated message")
+    }
+    return nil
+}
+
+// next returns the next n bytes, aligned to n if align is set
+func (d *dbusDecoder) next(n int, align bool) ([]byte, error) {
+    if align {
+        if err := d.align(n); err != nil {
+            return nil, err
+        }
+    }
+    if d.pos+n > len(d.b) || n < 0 {
+        return nil, fmt.Errorf("dbus: truncated message")
+    }
+    d.pos += n
+    return d.b[d.pos-n : d.pos], nil
+}
+
+// firstType splits the first complete type off a signature
+func firstType(sig string) (string, string, error) {
+    if sig == "" {
+        return "", "", fmt.Errorf("dbus: empty signature")
+    }
+    switch sig[0] {
+    case 'a':
+        elem, rest, err := firstType(sig[1:])
+        return "a" + elem, rest, err
+    case '(', '{':
+        depth := 0
+        for i, c := range sig {
+            if c == '(' || c == '{' {
+                depth++
+            } else if c == ')' || c == '}' {
+                depth--
+            }
+            if depth == 0 {
+                return sig[:i+1], sig[i+1:], nil
+            }
+        }
+        return "", "", fmt.Errorf("dbus: unbalanced signature %q", sig)
+    }
+    return sig[:1], sig[1:], nil
+}
+
+// typeAlign is the alignment of a type
+func typeAlign(sig string) int {
+    switch sig[0] {
+    case 'n', 'q':
+        return 2
+    case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+        return 4
+    case 'x', 't', 'd', '(', '{':
+        return 8
+    }
+    return 1
+}
+
+// values decodes values until sig is used up
+func (d *dbusDecoder) values(sig string) ([]interface{}, error) {
+    var values []interface{}
+    for sig != "" {
+        var typ string
+        var err error
+        if typ, sig, err = firstType(sig); err != nil {
+            return nil, err
+        }
+        v, err := d.value(typ)
+        if err != nil {
+            return nil, err
+        }
+        values = append(values, v)
+    }
+    return values, nil
+}
+
+// value decodes a value of a single complete type
+func (d *dbusDecoder) value(sig string) (interface{}, error) {
+    switch sig[0] {
+    case 'y':
+        b, err := d.next(1, false)
+        if err != nil {
+            return nil, err
+        }
+        return b[0], nil
+    case 'n', 'q':
+        b, err := d.next(2, true)
+        if err != nil {
+            return nil, err
+        }
+        if sig[0] == 'n' {
+            return int16(d.order.Uint16(b)), nil
+        }
+        return d.order.Uint16(b), nil
+    case 'b', 'i', 'u', 'h':
+        b, err := d.next(4, true)
+        if err != nil {
+            return nil, err
+        }
+        v := d.order.Uint32(b)
+        switch sig[0] {
+        case 'b':
+            return v != 0, nil
+        case 'i':
+            return int32(v), nil
+        }
+        return v, nil
+    case 'x', 't', 'd':
+        b, err := d.next(8, true)
+        if err != nil {
+            return nil, err
+        }
+        v := d.order.Uint64(b)
+        switch sig[0] {
+        case 'x':
+            return int64(v), nil
+        case 'd':
+            return math.Float64frombits(v), nil
+        }
+        return v, nil
+    case 's', 'o':
+        b, err := d.next(4, true)
+        if err != nil {
+            return nil, err
+        }
+        s, err := d.next(int(d.order.Uint32(b))+1, false)
+        if err != nil {
+            return nil, err
+        }
+        if sig[0] == 'o' {
+            return dbusObjectPath(s[:len(s)-1]), nil
+        }
+        return string(s[:len(s)-1]), nil
+    case 'g':
+        b, err := d.next(1, false)
+        if err != nil {
+            return nil, err
+        }
+        s, err := d.next(int(b[0])+1, false)
+        if err != nil {
+            return nil, err
+        }
+        return dbusSignature(s[:len(s)-1]), nil
+    case 'v':
+        s, err := d.value("g")
+        if err != nil {
+            return nil, err
+        }
+        values, err := d.values(string(s.(dbusSignature)))
+        if err != nil || len(values) != 1 {
+            return nil, fmt.Errorf("dbus: invalid variant %q", s)
+        }
+        return dbusVariant{values[0]}, nil
+    case 'a':
+        b, err := d.next(4, true)
+        if err != nil {
+            return nil, err
+        }
+        size := int(d.order.Uint32(b))
+        elem := sig[1:]
+        if err := d.align(typeAlign(elem)); err != nil {
+            return nil, err
+        }
+        end := d.pos + size
+        if end > len(d.b) {
+            return nil, fmt.Errorf("dbus: truncated message")
+        }
+        items := []interface{}{}
+        for d.pos < end {
+            v, err := d.value(elem)
+            if err != nil {
+                return nil, err
+            }
+            items = append(items, v)
+        }
+        return items, nil
+    case '(', '{':
+        if err := d.align(8); err != nil {
+            return nil, err
+        }
+        fields, err := d.values(sig[1 : len(sig)-1])
+        return dbusStruct(fields), err
+    }
+    return nil, fmt.Errorf("dbus: unsupported type %q", sig)
+}
+
+// readDBusMessage reads the next message
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+    fixed := make([]byte, 16)
+    if _, err := io.ReadFull(r, fixed); err != nil {
+        return nil, err
+    }
+    var order binary.ByteOrder = binary.LittleEndian
+    if fixed[0] == 'B' {
+        order = binary.BigEndian
+    }
+    bodyLen, fieldsLen := order.Uint32(fixed[4:]), order.Uint32(fixed[12:])
+    headerLen := (16 + int(fieldsLen) + 7) &^ 7
+    if bodyLen > 1<<26 || fieldsLen > 1<<26 {
+        return nil, fmt.Errorf("dbus: message too large")
+    }
+    data := make([]byte, headerLen+int(bodyLen))
+    copy(data, fixed)
+    if _, err := io.ReadFull(r, data[16:]); err != nil {
+        return nil, err
+    }
+
+    m := &dbusMessage{Type: fixed[1], Flags: fixed[2], Serial: order.Uint32(fixed[8:])}
+    d := &dbusDecoder{data[:16+fieldsLen], 12, order}
+    fields, err := d.value("a(yv)")
+    if err != nil {
+        return nil, err
+    }
+    sig := ""
+    for _, f := range fields.([]interface{}) {
+        field := f.(dbusStruct)
+        v := field[1].(dbusVariant).Value
+        switch field[0].(byte) {
+        case 1:
+            m.Path, _ = v.(dbusObjectPath)
+        case 2:
+            m.Interface, _ = v.(string)
+        case 3:
+            m.Member, _ = v.(string)
+        case 4:
+            m.ErrorName, _ = v.(string)
+        case 5:
+            m.ReplySerial, _ = v.(uint32)
+        case 6:
+            m.Destination, _ = v.(string)
+        case 7:
+            m.Sender, _ = v.(string)
+        case 8:
+            s, _ := v.(dbusSignature)
+            sig = string(s)
+        }
+    }
+    body := &dbusDecoder{data[headerLen:], 0, order}
+    if m.Body, err = body.values(sig); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+// dbusConn is a connection to a message bus
+type dbusConn struct {
+    conn   net.Conn
+    reader *bufio.Reader
+    wmu    sync.Mutex
+    serial uint32
+}
+
+// sessionBusAddresses returns the unix socket addresses of the session bus
+func sessionBusAddresses() []string {
+    address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+    if address == "" {
+        if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+            address = "unix:path=" + filepath.Join(dir, "bus")
+        }
+    }
+    var sockets []string
+    for _, a := range strings.Split(address, ";") {
+        rest, ok := strings.CutPrefix(a, "unix:")
+        if !ok {
+            continue
+        }
+        for _, kv := range strings.Split(rest, ",") {
+            key, value, _ := strings.Cut(kv, "=")
+            value, err := url.PathUnescape(value)
+            if err != nil {
+                continue
+            }
+            switch key {
+            case "path":
+                sockets = append(sockets, value)
+            case "abstract":
+                sockets = append(sockets, "@"+value)
+            }
+        }
+    }
+    return sockets
+}
+
+// dialSessionBus connects and authenticates to the session bus and says hello
+func dialSessionBus() (*dbusConn, error) {
+    sockets := sessionBusAddresses()
+    if len(sockets) == 0 {
+        return nil, fmt.Errorf("no session bus (DBUS_SESSION_BUS_ADDRESS isn't set)")
+    }
+    var conn net.Conn
+    var err error
+    for _, socket := range sockets {
+        if conn, err = net.Dial("unix", socket); err == nil {
+            break
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+    c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+    uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+    if _, err := conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    line, err := c.reader.ReadString('\n')
+    if err != nil || !strings.HasPrefix(line, "OK ") {
+        conn.Close()
+        return nil, fmt.Errorf("dbus: authentication failed: %q %v", strings.TrimSpace(line), err)
+    }
+    if _, err := conn.Write([]byte("BEGIN\r\n")); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// send sends m and returns its serial
+func (c *dbusConn) send(m *dbusMessage) (uint32, error) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    c.serial++
+    _, err := c.conn.Write(m.marshal(c.serial))
+    return c.serial, err
+}
+
+// call calls a method and waits for its reply, dropping anything else that arrives
+// meanwhile; only for before Serve
+func (c *dbusConn) call(dest string, path dbusObjectPath, iface, member string, args ...interface{}) ([]interface{}, error) {
+    serial, err := c.send(&dbusMessage{Type: dbusMethodCall, Path: path, Interface: iface, Member: member, Destination: dest, Body: args})
+    if err != nil {
+        return nil, err
+    }
+    for {
+        m, err := readDBusMessage(c.reader)
+        if err != nil {
+            return nil, err
+        }
+        if m.ReplySerial != serial {
+            continue
+        }
+        if m.Type == dbusError {
+            msg := ""
+            if len(m.Body) > 0 {
+                msg, _ = m.Body[0].(string)
+            }
+            return nil, fmt.Errorf("dbus: %s: %s: %s", member, m.ErrorName, msg)
+        }
+        return m.Body, nil
+    }
+}
+
+// Serve answers the method calls that arrive with handle until the connection
+// breaks. handle returns the reply's body, or the name and message of an error.
+func (c *dbusConn) Serve(handle func(m *dbusMessage) ([]interface{}, string, string)) error {
+    for {
+        m, err := readDBusMessage(c.reader)
+        if err != nil {
+            return err
+        }
+        if m.Type != dbusMethodCall {
+            continue
+        }
+        body, errName, errMsg := handle(m)
+        if m.Flags&dbusNoReplyExpected != 0 {
+            continue
+        }
+        reply := &dbusMessage{Type: dbusMethodReturn, ReplySerial: m.Serial, Destination: m.Sender, Body: body}
+        if errName != "" {
+            reply = &dbusMessage{Type: dbusError, ErrorName: errName, ReplySerial: m.Serial, Destination: m.Sender, Body: []interface{}{errMsg}}
+        }
+        if _, err := c.send(reply); err != nil {
+            return err
+        }
+    }
+}
+
+// Close closes the connection
+func (c *dbusConn) Close() error {
+    return c.conn.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+)
+
+func TestDBusMessage(t *testing.T) {
+    m := &dbusMessage{Type: dbusSignal, Path: mprisPath, Interface: dbusProps, Member: "PropertiesChanged", Body: []interface{}{
+        mprisPlayer,
+        map[string]dbusVariant{"Metadata": {map[string]dbusVariant{"xesam:artist": {[]string{"Miles Davis"}}, "mpris:length": {int64(545000000)}}}, "Rate": {1.0}},
+        []string{},
+        byte(7), true, int32(-1), uint32(3), dbusStruct{"a", uint64(9)},
+    }}
+    got, err := readDBusMessage(bytes.NewReader(m.marshal(42)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Type != dbusSignal || got.Serial != 42 || got.Path != mprisPath || got.Interface != dbusProps || got.Member != "PropertiesChanged" {
+        t.Errorf("header = %+v", got)
+    }
+    want := []interface{}{
+        mprisPlayer,
+        []interface{}{
+            dbusStruct{"Metadata", dbusVariant{[]interface{}{
+                dbusStruct{"mpris:length", dbusVariant{int64(545000000)}},
+                dbusStruct{"xesam:artist", dbusVariant{[]interface{}{"Miles Davis"}}},
+            }}},
+            dbusStruct{"Rate", dbusVariant{1.0}},
+        },
+        []interface{}{},
+        byte(7), true, int32(-1), uint32(3), dbusStruct{"a", uint64(9)},
+    }
+    if !reflect.DeepEqual(got.Body, want) {
+        t.Errorf("body = %#v\nwant %#v", got.Body, want)
+    }
+    if _, err := readDBusMessage(bytes.NewReader(m.marshal(1)[:40])); err == nil {
+        t.Error("truncated message read")
+    }
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "math/bits"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Duplicate cleanup groups the recordings in the save directory by AcoustID,
+// normalized artist and title, and optionally by comparing Chromaprint fingerprints,
+// then removes all but one recording of each group.
+
+const outcomeDuplicate = "duplicate"
+
+// dedupeFile is a recording considered for duplicate cleanup
+type dedupeFile struct {
+    Path     string
+    Size     int64
+    Tags     Tags
+    Raw      []uint32
+    Duration float64
+    Owned    bool // imported music, never removed
+}
+
+// dedupeAudioExts are the recording formats dedupe looks at: those of the format
+// option
+var dedupeAudioExts = map[string]bool{}
+
+func init() {
+    for _, f := range captureFormats {
+        dedupeAudioExts[f.Ext] = true
+    }
+}
+
+// versionSuffixRe matches trailing qualifiers like "(Remastered 2011)" or "- Live"
+var versionSuffixRe = regexp.MustCompile(`\s*(\([^)]*\)|\[[^\]]*\]|- [^-]*(remaster|version|edit|mix)[^-]*)\s*$`)
+
+// normalizeSongKey reduces artist and title to a key that survives differences
+// in case, punctuation and version qualifiers between stations
+func normalizeSongKey(artist, title string) string {
+    norm := func(s string) string {
+        s = strings.ToLower(s)
+        for {
+            trimmed := versionSuffixRe.ReplaceAllString(s, "")
+            if trimmed == s || trimmed == "" {
+                break
+            }
+            s = trimmed
+        }
+        return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+            return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+        }), " ")
+    }
+    a, t := norm(artist), norm(title)
+    if a == "" || t == "" {
+        return ""
+    }
+    return a + "|" + t
+}
+
+// rawFingerprint runs fpcalc for the uncompressed fingerprint of a file
+func rawFingerprint(path string) ([]uint32, float64, error) {
+    out, err := exec.Command("fpcalc", "-raw", "-json", path).Output()
+    if err != nil {
+        return nil, 0, fmt.Errorf("fpcalc failed for %s: %v", path, err)
+    }
+    var result struct {
+        Duration    float64  `json:"duration"`
+        Fingerprint []uint32 `json:"fingerprint"`
+    }
+    if err := json.Unmarshal(out, &result); err != nil {
+        return nil, 0, fmt.Errorf("failed to parse fpcalc output for %s: %v", path, err)
+    }
+    return result.Fingerprint, result.Duration, nil
+}
+
+// fingerprintsMatch compares two raw fingerprints, allowing for recordings that
+// start a couple of seconds apart
+func fingerprintsMatch(a, b []uint32) bool {
+    const maxShift = 24 // about three seconds
+    const maxBitError = 0.2
+    best := 1.0
+    for shift := -maxShift; shift <= maxShift; shift++ {
+        differing, compared := 0, 0
+        for i := range a {
+            j := i + shift
+            if j < 0 || j >= len(b) {
+                continue
+            }
+            differing += bits.OnesCount32(a[i] ^ b[j])
+            compared += 32
+        }
+        // Require most of the shorter recording to overlap
+        if compared < 32*min(len(a), len(b))*3/4 {
+            continue
+        }
+        if rate := float64(differing) / float64(compared); rate < best {
+            best = rate
+        }
+    }
+    return best < maxBitError
+}
+
+// groupDuplicates links files sharing an AcoustID, a normalized song key, or (when
+// fingerprints were computed) matching audio, and returns groups of two or more
+func groupDuplicates(files []dedupeFile, byTags bool) [][]dedupeFile {
+    parent := make([]int, len(files))
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i])
+        }
+        return parent[i]
+    }
+    union := func(i, j int) { parent[find(i)] = find(j) }
+
+    seen := make(map[string]int)
+    link := func(key string, i int) {
+        if j, ok := seen[key]; ok {
+            union(i, j)
+        } else {
+            seen[key] = i
+        }
+    }
+    for i, f := range files {
+        if id := f.Tags.Custom["ACOUSTID_ID"]; id != "" {
+            link("acoustid:"+id, i)
+        }
+        if byTags && len(f.Tags.Artists) > 0 {
+            if key := normalizeSongKey(f.Tags.Artists[0], f.Tags.Title); key != "" {
+                link("song:"+key, i)
+            }
+        }
+    }
+
+    // Compare fingerprints of recordings with similar lengths
+    order := make([]int, 0, len(files))
+    for i, f := range files {
+        if len(f.Raw) > 0 {
+            order = append(order, i)
+        }
+    }
+    sort.Slice(order, func(a, b int) bool { return files[order[a]].Duration < files[order[b]].Duration })
+    for a := range order {
+        for b := a + 1; b < len(order) && files[order[b]].Duration-files[order[a]].Duration < 10; b++ {
+            if find(order[a]) != find(order[b]) && fingerprintsMatch(files[order[a]].Raw, files[order[b]].Raw) {
+                union(order[a], order[b])
+            }
+        }
+    }
+
+    byRoot := make(map[int][]dedupeFile)
+    var roots []int
+    for i, f := range files {
+        r := find(i)
+        if len(byRoot[r]) == 0 {
+            roots = append(roots, r)
+        }
+        byRoot[r] = append(byRoot[r], f)
+    }
+    var groups [][]dedupeFile
+    for _, r := range roots {
+        g := byRoot[r]
+        if len(g) < 2 {
+            continue
+        }
+        rankDuplicates(g)
+        // Groups of music the user owns are left alone
+        if !g[len(g)-1].Owned {
+            groups = append(groups, g)
+        }
+    }
+    return groups
+}
+
+// rankDuplicates orders a group best first: music the user owns, loved, then the
+// largest (most complete) recording, then by path for stable output
+func rankDuplicates(g []dedupeFile) {
+    sort.SliceStable(g, func(i, j int) bool {
+        if g[i].Owned != g[j].Owned {
+            return g[i].Owned
+        }
+        if g[i].Tags.Loved != g[j].Tags.Loved {
+            return g[i].Tags.Loved
+        }
+        if g[i].Size != g[j].Size {
+            return g[i].Size > g[j].Size
+        }
+        return g[i].Path < g[j].Path
+    })
+}
+
+// runDedupe implements "pianotrap dedupe"
+func runDedupe(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+    keepBest := fs.Bool("keep-best", false, "keep the best recording of each group without asking")
+    dryRun := fs.Bool("n", false, "only show the duplicate groups")
+    useFingerprints := fs.Bool("fingerprint", false, "also compare audio fingerprints (needs fpcalc, slower)")
+    byTags := fs.Bool("tags", true, "group recordings with the same artist and title")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    beetsLog = cfg.BeetsLog
+    dir := cfg.SaveDir
+    if fs.NArg() > 0 {
+        dir = fs.Arg(0)
+    }
+
+    var files []dedupeFile
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+            return filepath.SkipDir // e.g. the trash
+        }
+        if err != nil || info.IsDir() || !dedupeAudioExts[strings.ToLower(filepath.Ext(path))] {
+            return nil
+        }
+        f := dedupeFile{Path: path, Size: info.Size()}
+        if f.Tags, err = readTags(path); err != nil {
+            logger.Printf("Skipping %s: %v", path, err)
+            return nil
+        }
+        if *useFingerprints {
+            if f.Raw, f.Duration, err = rawFingerprint(path); err != nil {
+                logger.Printf("%v", err)
+            }
+        }
+        files = append(files, f)
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("failed to scan %s: %v", dir, err)
+    }
+
+    if db == nil {
+        if err := openLibrary(cfg); err == nil {
+            defer db.Close()
+        }
+    }
+    for _, f := range importedFiles(dir) {
+        if *useFingerprints {
+            if f.Raw, f.Duration, err = rawFingerprint(f.Path); err != nil {
+                logger.Printf("%v", err)
+            }
+        }
+        files = append(files, f)
+    }
+
+    groups := groupDuplicates(files, *byTags)
+    if len(groups) == 0 {
+        fmt.Printf("No duplicates among %d recordings\n", len(files))
+        return nil
+    }
+
+    stdin := bufio.NewReader(os.Stdin)
+    removed := 0
+    for n, g := range groups {
+        fmt.Printf("\nDuplicate group %d of %d:\n", n+1, len(groups))
+        for i, f := range g {
+            note := ""
+            if f.Tags.Loved {
+                note = " <3"
+            }
+            if f.Owned {
+                note += " (imported, kept)"
+            }
+            fmt.Printf("  %d) %s (%.1f MB)%s\n", i+1, f.Path, float64(f.Size)/(1<<20), note)
+        }
+        if *dryRun {
+            continue
+        }
+        keep := 0
+        if !*keepBest {
+            fmt.Printf("Keep which? [1-%d, Enter for 1, s to skip]: ", len(g))
+            line, _ := stdin.ReadString('\n')
+            line = strings.TrimSpace(line)
+            if line == "s" {
+                continue
+            }
+            if line != "" {
+                choice, err := strconv.Atoi(line)
+                if err != nil || choice < 1 || choice > len(g) {
+                    fmt.Println("Invalid choice, skipping")
+                    continue
+                }
+                keep = choice - 1
+            }
+        }
+        for i, f := range g {
+            if i == keep || f.Owned {
+                continue
+            }
+            if err := deleteFile(f.Path); err != nil {
+                fmt.Printf("Failed to remove %s: %v\n", f.Path, err)
+                continue
+            }
+            setSongOutcome(f.Path, outcomeDuplicate)
+            logBeets("duplicate-skip", f.Path)
+            fmt.Printf("Removed %s (duplicate of %s)\n", f.Path, g[keep].Path)
+            removed++
+        }
+    }
+    fmt.Printf("\n%d duplicate groups, %d recordings removed\n", len(groups), removed)
+    return nil
+}
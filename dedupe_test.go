@@ -0,0 +1,51 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestNormalizeSongKey(t *testing.T) {
+    tests := []struct {
+        artist, title string
+        want          string
+    }{
+        {"Miles Davis", "So What", "miles davis|so what"},
+        {"MILES DAVIS", "So What (Remastered 2009)", "miles davis|so what"},
+        {"Miles Davis", "So What - 2009 Remaster", "miles davis|so what"},
+        {"Miles Davis", "So What [Live]", "miles davis|so what"},
+        {"AC/DC", "T.N.T.", "ac dc|t n t"},
+        {"", "Title", ""},
+        {"Artist", "(Intro)", "artist|intro"},
+    }
+    for _, tt := range tests {
+        if got := normalizeSongKey(tt.artist, tt.title); got != tt.want {
+            t.Errorf("normalizeSongKey(%q, %q) = %q, want %q", tt.artist, tt.title, got, tt.want)
+        }
+    }
+}
+
+func TestGroupDuplicates(t *testing.T) {
+    files := []dedupeFile{
+        {Path: "a/1.mp3", Size: 100, Tags: Tags{Title: "Song", Artists: []string{"X"}}},
+        {Path: "b/1.mp3", Size: 200, Tags: Tags{Title: "Song (Live)", Artists: []string{"x"}}},
+        {Path: "c/2.mp3", Size: 100, Tags: Tags{Title: "Other", Artists: []string{"Y"}, Custom: map[string]string{"ACOUSTID_ID": "id"}}},
+        {Path: "d/2.mp3", Size: 50, Tags: Tags{Title: "Garbled", Loved: true, Custom: map[string]string{"ACOUSTID_ID": "id"}}},
+        {Path: "e/3.mp3", Size: 100, Tags: Tags{Title: "Alone", Artists: []string{"Z"}}},
+        {Path: "owned/1.flac", Size: 10, Tags: Tags{Title: "Song", Artists: []string{"X"}}, Owned: true},
+        {Path: "owned/4.flac", Size: 10, Tags: Tags{Title: "Mine", Artists: []string{"W"}}, Owned: true},
+        {Path: "owned/4.mp3", Size: 10, Tags: Tags{Title: "Mine", Artists: []string{"W"}}, Owned: true},
+    }
+    var got [][]string
+    for _, g := range groupDuplicates(files, true) {
+        var paths []string
+        for _, f := range g {
+            paths = append(paths, f.Path)
+        }
+        got = append(got, paths)
+    }
+    want := [][]string{{"owned/1.flac", "b/1.mp3", "a/1.mp3"}, {"d/2.mp3", "c/2.mp3"}}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("groupDuplicates = %v, want %v", got, want)
+    }
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "flag"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "os/exec"
+    "os/signal"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/creack/pty"
+    "golang.org/x/term"
+)
+
+// With run -detach pianotrap keeps running in the background, like a terminal
+// multiplexer made for it: a small host process runs "pianotrap run" on a PTY of its
+// own, and "pianotrap attach" connects a terminal to that PTY until Ctrl+] detaches
+// again or the connection drops, e.g. with SSH. pianobar and the recordings carry on
+// either way. The host listens on session.sock next to the config file and keeps the
+// latest output to show on attach.
+
+const (
+    // sessionHostEnv tells a pianotrap started by run -detach to be the host
+    sessionHostEnv = "PIANOTRAP_SESSION_HOST"
+    // detachKey (Ctrl+]) detaches an attached terminal
+    detachKey byte = 0x1d
+    // sessionReplay is how much of the latest output an attach shows
+    sessionReplay = 64 << 10
+)
+
+// The attached terminal sends frames: a type, a big-endian 2-byte length and data
+const (
+    frameInput = 'i' // keys for the PTY
+    frameSize  = 'w' // rows and columns, 2 bytes each
+)
+
+// sessionSocketPath is where the host of a detached session with cfg's config file
+// and profile listens
+func sessionSocketPath(cfg Config) string {
+    if cfg.Profile != "" {
+        return filepath.Join(filepath.Dir(cfg.ConfigFile), "session-"+cfg.Profile+".sock")
+    }
+    return filepath.Join(filepath.Dir(cfg.ConfigFile), "session.sock")
+}
+
+// writeFrame sends one frame to the host
+func writeFrame(w io.Writer, kind byte, data []byte) error {
+    frame := []byte{kind, 0, 0}
+    binary.BigEndian.PutUint16(frame[1:], uint16(len(data)))
+    _, err := w.Write(append(frame, data...))
+    return err
+}
+
+// readFrame reads one frame from an attached terminal
+func readFrame(r io.Reader) (byte, []byte, error) {
+    header := make([]byte, 3)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return 0, nil, err
+    }
+    data := make([]byte, binary.BigEndian.Uint16(header[1:]))
+    _, err := io.ReadFull(r, data)
+    return header[0], data, err
+}
+
+// detachArgs are the arguments for the session's own pianotrap, those of this one
+// without -detach
+func detachArgs(args []string) []string {
+    var kept []string
+    for _, arg := range args {
+        switch arg {
+        case "-detach", "--detach", "-detach=true", "--detach=true":
+        default:
+            kept = append(kept, arg)
+        }
+    }
+    return kept
+}
+
+// startDetached starts a session host running pianotrap with args. It watches the
+// start of the session, so that errors such as a missing pianobar still show.
+func startDetached(cfg Config, args []string) error {
+    path := sessionSocketPath(cfg)
+    if conn, err := net.Dial("unix", path); err == nil {
+        conn.Close()
+        return fmt.Errorf("a detached pianotrap is already running with %s; pianotrap attach connects to it", cfg.ConfigFile)
+    }
+    os.Remove(path)
+    exe, err := os.Executable()
+    if err != nil {
+        return fmt.Errorf("failed to locate pianotrap executable: %v", err)
+    }
+    cmd := exec.Command(exe, args...)
+    cmd.Env = append(os.Environ(), sessionHostEnv+"="+path)
+    // Out of the terminal's session, so closing it doesn't hang up on pianotrap
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+    if err := cmd.Start(); err != nil {
+        return fmt.Errorf("failed to start the background session: %v", err)
+    }
+    exited := make(chan error, 1)
+    go func() { exited <- cmd.Wait() }()
+    var conn net.Conn
+    for deadline := time.Now().Add(5 * time.Second); conn == nil; time.Sleep(50 * time.Millisecond) {
+        select {
+        case err := <-exited:
+            return fmt.Errorf("the background session exited: %v", err)
+        default:
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("the background session didn't start listening on %s", path)
+        }
+        conn, _ = net.Dial("unix", path)
+    }
+    defer conn.Close()
+    conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    output, err := io.ReadAll(conn)
+    if err == nil {
+        // The session ended already
+        os.Stdout.Write(bytes.TrimPrefix(output, []byte("\x1b[H\x1b[2J")))
+        return fmt.Errorf("pianotrap exited right after starting in the background")
+    }
+    fmt.Printf("pianotrap is running in the background (pid %d); pianotrap attach connects to it.\n", cmd.Process.Pid)
+    return nil
+}
+
+// sessionHost passes a PTY between a command and the attached terminal, if any
+type sessionHost struct {
+    pty    *os.File
+    mu     sync.Mutex
+    client net.Conn
+    replay []byte
+}
+
+// runSessionHost hosts the pianotrap of a detached session if this process was
+// started as its host, and reports whether it was
+func runSessionHost() bool {
+    path := os.Getenv(sessionHostEnv)
+    if path == "" {
+        return false
+    }
+    os.Unsetenv(sessionHostEnv)
+    exe, err := os.Executable()
+    if err == nil {
+        err = hostSession(path, exec.Command(exe, os.Args[1:]...))
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    return true
+}
+
+// hostSession runs cmd on a PTY and serves it on the socket at path until cmd exits.
+// cmd starts once the first terminal, run -detach watching the start, has attached,
+// or after five seconds.
+func hostSession(path string, cmd *exec.Cmd) error {
+    // Whoever connects types into pianobar
+    listener, err := listenPrivate(path)
+    if err != nil {
+        return err
+    }
+    defer listener.Close()
+    defer os.Remove(path)
+    listener.SetDeadline(time.Now().Add(5 * time.Second))
+    first, _ := listener.Accept()
+    listener.SetDeadline(time.Time{})
+    ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 24, Cols: 80})
+    if err != nil {
+        if first != nil {
+            first.Close()
+        }
+        return fmt.Errorf("failed to start pianotrap in a PTY: %v", err)
+    }
+    defer ptmx.Close()
+    h := &sessionHost{pty: ptmx}
+    if first != nil {
+        go h.serve(first)
+    }
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go h.serve(conn)
+        }
+    }()
+    h.pump()
+    cmd.Wait()
+    // Gone before the client hears of it, so attach can tell that the session ended
+    os.Remove(path)
+    h.mu.Lock()
+    if h.client != nil {
+        h.client.Close()
+    }
+    h.mu.Unlock()
+    return nil
+}
+
+// pump passes the command's output to the attached terminal and keeps the latest
+// of it, until the command exits
+func (h *sessionHost) pump() {
+    buf := make([]byte, 32*1024)
+    for {
+        n, err := h.pty.Read(buf)
+        if n > 0 {
+            h.mu.Lock()
+            h.replay = append(h.replay, buf[:n]...)
+            if len(h.replay) > sessionReplay {
+                h.replay = append([]byte(nil), h.replay[len(h.replay)-sessionReplay:]...)
+            }
+            // A terminal that stops reading is dropped rather than holding up the output
+            if h.client != nil {
+                h.client.SetWriteDeadline(time.Now().Add(5 * time.Second))
+                if _, err := h.client.Write(buf[:n]); err != nil {
+                    h.client.Close()
+                    h.client = nil
+                }
+            }
+            h.mu.Unlock()
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+// serve attaches conn, taking over from the terminal attached before, and passes
+// its keys and window size to the command
+func (h *sessionHost) serve(conn net.Conn) {
+    h.mu.Lock()
+    if h.client != nil {
+        h.client.Write([]byte("\r\n[attached elsewhere]\r\n"))
+        h.client.Close()
+    }
+    h.client = conn
+    conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+    conn.Write([]byte("\x1b[H\x1b[2J"))
+    conn.Write(h.replay)
+    h.mu.Unlock()
+
+    r := bufio.NewReader(conn)
+    for {
+        kind, data, err := readFrame(r)
+        if err != nil {
+            break
+        }
+        switch kind {
+        case frameInput:
+            h.pty.Write(data)
+        case frameSize:
+            if len(data) == 4 {
+                pty.Setsize(h.pty, &pty.Winsize{Rows: binary.BigEndian.Uint16(data), Cols: binary.BigEndian.Uint16(data[2:])})
+            }
+        }
+    }
+    h.mu.Lock()
+    if h.client == conn {
+        h.client = nil
+    }
+    h.mu.Unlock()
+    conn.Close()
+}
+
+// runAttach implements "pianotrap attach"
+func runAttach(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("attach", flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "usage: pianotrap attach\n\nConnect the terminal to a pianotrap started with run -detach; Ctrl+] detaches again.\n")
+    }
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    path := sessionSocketPath(cfg)
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        if cfg.Profile != "" {
+            return fmt.Errorf("no detached pianotrap running with %s, profile %s: %v", cfg.ConfigFile, cfg.Profile, err)
+        }
+        return fmt.Errorf("no detached pianotrap running with %s: %v", cfg.ConfigFile, err)
+    }
+    defer conn.Close()
+    fd := int(os.Stdin.Fd())
+    state, err := term.MakeRaw(fd)
+    if err != nil {
+        return fmt.Errorf("attach needs a terminal: %v", err)
+    }
+    defer term.Restore(fd, state)
+
+    var wmu sync.Mutex
+    send := func(kind byte, data []byte) {
+        wmu.Lock()
+        defer wmu.Unlock()
+        writeFrame(conn, kind, data)
+    }
+    sendSize := func() {
+        if width, height, err := term.GetSize(fd); err == nil {
+            size := make([]byte, 4)
+            binary.BigEndian.PutUint16(size, uint16(height))
+            binary.BigEndian.PutUint16(size[2:], uint16(width))
+            send(frameSize, size)
+        }
+    }
+    sendSize()
+    winch := make(chan os.Signal, 1)
+    signal.Notify(winch, syscall.SIGWINCH)
+    defer signal.Stop(winch)
+    go func() {
+        for range winch {
+            sendSize()
+        }
+    }()
+
+    detached := make(chan struct{})
+    go func() {
+        buf := make([]byte, 1024)
+        for {
+            n, err := os.Stdin.Read(buf)
+            if err != nil {
+                conn.Close()
+                return
+            }
+            if i := bytes.IndexByte(buf[:n], detachKey); i >= 0 {
+                send(frameInput, buf[:i])
+                close(detached)
+                conn.Close()
+                return
+            }
+            send(frameInput, buf[:n])
+        }
+    }()
+    io.Copy(os.Stdout, conn)
+    term.Restore(fd, state)
+
+    select {
+    case <-detached:
+        fmt.Println("\nDetached; pianotrap keeps running. pianotrap attach connects again.")
+    default:
+        if _, err := os.Stat(path); err != nil {
+            fmt.Println("\npianotrap has exited.")
+        } else {
+            fmt.Println()
+        }
+    }
+    return nil
+}
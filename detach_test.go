@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "net"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestSessionHost(t *testing.T) {
+    if got := detachArgs([]string{"-config", "c", "run", "-detach", "-tui"}); !reflect.DeepEqual(got, []string{"-config", "c", "run", "-tui"}) {
+        t.Errorf("detachArgs = %q", got)
+    }
+
+    path := filepath.Join(t.TempDir(), "session.sock")
+    done := make(chan error, 1)
+    go func() { done <- hostSession(path, exec.Command("sh", "-c", "echo ready; stty -echo; cat")) }()
+    var conn net.Conn
+    var err error
+    for i := 0; i < 100; i++ {
+        if conn, err = net.Dial("unix", path); err == nil {
+            break
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    if info, err := os.Stat(path); err != nil {
+        t.Error(err)
+    } else if info.Mode().Perm() != 0600 {
+        t.Errorf("session socket mode = %v", info.Mode())
+    }
+    output := bufio.NewReader(conn)
+    readUntil := func(want string) {
+        t.Helper()
+        conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+        var got []byte
+        for !bytes.Contains(got, []byte(want)) {
+            b, err := output.ReadByte()
+            if err != nil {
+                t.Fatalf("read %q, want %q: %v", got, want, err)
+            }
+            got = append(got, b)
+        }
+    }
+    readUntil("ready")
+    writeFrame(conn, frameSize, []byte{0, 40, 0, 100})
+    writeFrame(conn, frameInput, []byte("hello\n"))
+    readUntil("hello")
+
+    // A second terminal takes over and sees the output so far
+    second, err := net.Dial("unix", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer second.Close()
+    readUntil("attached elsewhere")
+    second.SetReadDeadline(time.Now().Add(5 * time.Second))
+    replay := bufio.NewReader(second)
+    var got []byte
+    for !bytes.Contains(got, []byte("hello")) {
+        b, err := replay.ReadByte()
+        if err != nil {
+            t.Fatalf("replay %q: %v", got, err)
+        }
+        got = append(got, b)
+    }
+
+    // Ctrl+D ends cat and with it the session
+    writeFrame(second, frameInput, []byte{0x04})
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Error(err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("session didn't end")
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Errorf("socket left behind: %v", err)
+    }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Free space on the save directory's filesystem is checked before every recording
+// and periodically while running. Below min_free_mb pianotrap warns, stops starting
+// new recordings and, with low_space_prune, runs the retention rules.
+
+var diskSpace struct {
+    sync.Mutex
+    low bool
+}
+
+// loadDiskSpaceConfig reads min_free_mb and low_space_prune
+func loadDiskSpaceConfig(values map[string]string, cfg *Config) error {
+    mb, err := configInt(values, "min_free_mb", 500)
+    if err != nil {
+        return err
+    }
+    if mb < 0 {
+        return fmt.Errorf("invalid value for min_free_mb: %d", mb)
+    }
+    cfg.MinFreeBytes = uint64(mb) << 20
+    cfg.LowSpacePrune = values["low_space_prune"] == "true"
+    return nil
+}
+
+// freeSpace returns the bytes available to unprivileged users on path's filesystem
+func freeSpace(path string) (uint64, error) {
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(path, &st); err != nil {
+        return 0, fmt.Errorf("failed to check free space on %s: %v", path, err)
+    }
+    return st.Bavail * uint64(st.Bsize), nil
+}
+
+// checkDiskSpace updates the low-space state and reports whether there is room
+// for new recordings
+func checkDiskSpace(cfg Config) bool {
+    if cfg.MinFreeBytes == 0 {
+        return true
+    }
+    free, err := freeSpace(cfg.SaveDir)
+    if err != nil {
+        logger.Printf("%v", err)
+        return true
+    }
+    low := free < cfg.MinFreeBytes
+
+    diskSpace.Lock()
+    changed := low != diskSpace.low
+    diskSpace.low = low
+    diskSpace.Unlock()
+    if !changed {
+        return !low
+    }
+    if !low {
+        fmt.Printf("\r\nDisk space recovered (%d MB free), recording resumes\n", free>>20)
+        return true
+    }
+
+    fmt.Printf("\r\nWarning: only %d MB free on %s, not starting new recordings\n", free>>20, cfg.SaveDir)
+    if cfg.LowSpacePrune && cfg.retentionEnabled() && db != nil {
+        if freed, err := pruneRecordings(cfg, false); err != nil {
+            logger.Printf("Prune: %v", err)
+        } else if freed > 0 {
+            fmt.Printf("\r\nRetention rules freed %.1f MB\n", float64(freed)/(1<<20))
+        }
+    }
+    return false
+}
+
+// startDiskSpaceMonitor keeps checking free space while recording
+func startDiskSpaceMonitor(cfg Config) {
+    if cfg.MinFreeBytes == 0 {
+        return
+    }
+    checkDiskSpace(cfg)
+    go func() {
+        for range time.Tick(30 * time.Second) {
+            checkDiskSpace(cfg)
+        }
+    }()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// "pianotrap doctor" checks what a recording session needs before one is started:
+// the tools, the sound server, a monitor source to record from, the save directory
+// and the config. A missing piece otherwise often only shows as empty or missing
+// recordings.
+
+// doctorCheck is the outcome of one check
+type doctorCheck struct {
+    Name   string
+    Status string // ok, warn or fail
+    Detail string // what was found
+    Fix    string // what to do about a warning or failure
+}
+
+// String formats c as printed by "pianotrap doctor"
+func (c doctorCheck) String() string {
+    s := fmt.Sprintf("%-5s %s: %s\n", c.Status, c.Name, c.Detail)
+    if c.Fix != "" && c.Status != "ok" {
+        s += fmt.Sprintf("      fix: %s\n", c.Fix)
+    }
+    return s
+}
+
+// pactlServerName picks the server name out of "pactl info" output
+func pactlServerName(info string) string {
+    for _, line := range strings.Split(info, "\n") {
+        if value := strings.TrimPrefix(line, "Server Name:"); value != line {
+            return strings.TrimSpace(value)
+        }
+    }
+    return ""
+}
+
+// pianobarAccount reports whether a pianobar config sets a user and a way to get
+// the password
+func pianobarAccount(config string) bool {
+    user, password := false, false
+    for _, line := range strings.Split(config, "\n") {
+        parts := strings.SplitN(line, "=", 2)
+        if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+            continue
+        }
+        switch strings.TrimSpace(parts[0]) {
+        case "user":
+            user = true
+        case "password", "password_command":
+            password = true
+        }
+    }
+    return user && password
+}
+
+// checkConfig checks that the config file parses and its values are valid
+func checkConfig(cfg Config) doctorCheck {
+    c := doctorCheck{Name: "config", Status: "ok", Detail: cfg.ConfigFile}
+    problems, err := checkConfigFile(cfg.ConfigFile, cfg.Profile, cfg.SaveDir)
+    if err == nil && len(problems) > 0 {
+        err = configProblemsError(cfg.ConfigFile, problems)
+    }
+    if err != nil {
+        c.Status, c.Detail = "fail", err.Error()
+        c.Fix = "edit " + cfg.ConfigFile + ", or start over with pianotrap init -force; pianotrap config check lists the problems"
+    }
+    return c
+}
+
+// checkTool checks that tool is on the PATH
+func checkTool(tool, fix string) doctorCheck {
+    path, err := exec.LookPath(tool)
+    if err != nil {
+        return doctorCheck{tool, "fail", "not found on the PATH", fix}
+    }
+    return doctorCheck{tool, "ok", path, ""}
+}
+
+// checkPandoraAccount checks that pianobar can log in without asking
+func checkPandoraAccount(cfg Config) doctorCheck {
+    c := doctorCheck{Name: "pandora account", Status: "ok"}
+    if cfg.PandoraUser != "" {
+        c.Detail = cfg.PandoraUser + " from pandora_user"
+        return c
+    }
+    path, err := pianobarConfigFile()
+    if err != nil {
+        return doctorCheck{c.Name, "fail", err.Error(), ""}
+    }
+    data, err := ioutil.ReadFile(path)
+    if err != nil || !pianobarAccount(string(data)) {
+        c.Status, c.Detail = "warn", "no user and password in "+path+"; pianobar will ask for them"
+        c.Fix = "run pianotrap init, or add user and password to " + path
+        return c
+    }
+    c.Detail = "set in " + path
+    return c
+}
+
+// checkFFmpegPulse checks that ffmpeg can capture from PulseAudio
+func checkFFmpegPulse() doctorCheck {
+    c := doctorCheck{Name: "ffmpeg pulse input", Fix: "install an ffmpeg built with PulseAudio support (--enable-libpulse), e.g. your distribution's package"}
+    out, err := exec.Command(ffmpegBinary(), "-hide_banner", "-demuxers").Output()
+    if err != nil {
+        c.Status, c.Detail = "fail", fmt.Sprintf("ffmpeg -demuxers failed: %v", err)
+        return c
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        if fields := strings.Fields(line); len(fields) >= 2 && fields[1] == "pulse" {
+            c.Status, c.Detail = "ok", "available"
+            return c
+        }
+    }
+    c.Status, c.Detail = "fail", "this ffmpeg can't record from PulseAudio"
+    return c
+}
+
+// checkSoundServer checks that a PulseAudio or PipeWire server is running
+func checkSoundServer() doctorCheck {
+    c := doctorCheck{Name: "sound server", Fix: "start PulseAudio (pulseaudio --start) or PipeWire's PulseAudio server (systemctl --user start pipewire-pulse)"}
+    out, err := exec.Command("pactl", "info").CombinedOutput()
+    if err != nil {
+        c.Status, c.Detail = "fail", fmt.Sprintf("pactl info: %v: %s", err, strings.TrimSpace(string(out)))
+        return c
+    }
+    c.Status, c.Detail = "ok", pactlServerName(string(out))
+    if c.Detail == "" {
+        c.Detail = "running"
+    }
+    return c
+}
+
+// checkDefaultSink checks for the output pianotrap loops the session back to
+func checkDefaultSink() doctorCheck {
+    c := doctorCheck{Name: "default sink", Fix: "connect an output device or pick one with pactl set-default-sink"}
+    out, err := exec.Command("pactl", "get-default-sink").Output()
+    if sink := strings.TrimSpace(string(out)); err == nil && sink != "" {
+        c.Status, c.Detail = "ok", sink
+    } else {
+        c.Status, c.Detail = "fail", "none; pianotrap can't create its session sink"
+    }
+    return c
+}
+
+// checkMonitorSource creates a null sink like a session does and checks that its
+// monitor source shows up to record from
+func checkMonitorSource() doctorCheck {
+    c := doctorCheck{Name: "monitor source", Fix: "make sure the sound server has module-null-sink (PipeWire needs pipewire-pulse)"}
+    sink := fmt.Sprintf("PianotrapDoctor_%d", os.Getpid())
+    out, err := exec.Command("pactl", "load-module", "module-null-sink", "sink_name="+sink).CombinedOutput()
+    if err != nil {
+        c.Status, c.Detail = "fail", fmt.Sprintf("can't create a null sink: %v: %s", err, strings.TrimSpace(string(out)))
+        return c
+    }
+    defer exec.Command("pactl", "unload-module", strings.TrimSpace(string(out))).Run()
+    out, err = exec.Command("pactl", "list", "short", "sources").Output()
+    if err != nil {
+        c.Status, c.Detail = "fail", fmt.Sprintf("can't list sources: %v", err)
+        return c
+    }
+    for _, source := range monitorSources(string(out)) {
+        if source == sink+".monitor" {
+            c.Status, c.Detail = "ok", "session sinks get a monitor source"
+            return c
+        }
+    }
+    c.Status, c.Detail = "fail", sink+" has no monitor source"
+    return c
+}
+
+// checkSaveDir checks that recordings can be written to the save directory
+func checkSaveDir(cfg Config) doctorCheck {
+    c := doctorCheck{Name: "save directory", Fix: "create " + cfg.SaveDir + " and make it writable, or set savedir"}
+    if err := os.MkdirAll(cfg.SaveDir, 0755); err != nil {
+        c.Status, c.Detail = "fail", err.Error()
+        return c
+    }
+    f, err := ioutil.TempFile(cfg.SaveDir, ".pianotrap-doctor-")
+    if err != nil {
+        c.Status, c.Detail = "fail", fmt.Sprintf("can't write to %s: %v", cfg.SaveDir, err)
+        return c
+    }
+    f.Close()
+    os.Remove(f.Name())
+    c.Status, c.Detail = "ok", cfg.SaveDir
+    if free, err := freeSpace(cfg.SaveDir); err == nil && cfg.MinFreeBytes > 0 && free < cfg.MinFreeBytes {
+        c.Status = "warn"
+        c.Detail = fmt.Sprintf("%s has %d MB free, below min_free_mb", cfg.SaveDir, free>>20)
+        c.Fix = "free up space, or lower min_free_mb"
+    }
+    return c
+}
+
+// checkDatabase checks that the song database can be opened
+func checkDatabase(cfg Config) doctorCheck {
+    c := doctorCheck{Name: "database", Fix: "make " + filepath.Dir(cfg.Database) + " writable, or set database"}
+    conn, err := openDatabase(cfg.Database)
+    if err != nil {
+        c.Status, c.Detail = "fail", err.Error()
+        return c
+    }
+    conn.Close()
+    c.Status, c.Detail = "ok", cfg.Database
+    return c
+}
+
+// runDoctor implements "pianotrap doctor"
+func runDoctor(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "usage: pianotrap doctor\n\nCheck the tools, sound server, save directory and config a recording needs.\n")
+    }
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    failed, warned := 0, 0
+    report := func(c doctorCheck) bool {
+        fmt.Print(c)
+        switch c.Status {
+        case "fail":
+            failed++
+        case "warn":
+            warned++
+        }
+        return c.Status != "fail"
+    }
+
+    configOK := report(checkConfig(cfg))
+    if pianobar := cfg.PianobarCommand; pianobar == "" || pianobar == "pianobar" {
+        report(checkTool("pianobar", "install pianobar, e.g. sudo apt install pianobar or brew install pianobar"))
+    } else {
+        // Like exec.Cmd, a relative path is relative to the working directory
+        if strings.Contains(pianobar, "/") && !filepath.IsAbs(pianobar) && cfg.PianobarDir != "" {
+            pianobar = filepath.Join(cfg.PianobarDir, pianobar)
+        }
+        report(checkTool(pianobar, "check pianobar_command"))
+    }
+    report(checkPandoraAccount(cfg))
+    ffmpegFix := "install ffmpeg, e.g. sudo apt install ffmpeg"
+    if ffmpegBinary() != "ffmpeg" {
+        ffmpegFix = "check ffmpeg_command"
+    }
+    if report(checkTool(ffmpegBinary(), ffmpegFix)) {
+        report(checkFFmpegPulse())
+    }
+    if report(checkTool("pactl", "install pactl, e.g. sudo apt install pulseaudio-utils")) && report(checkSoundServer()) {
+        report(checkDefaultSink())
+        report(checkMonitorSource())
+    }
+    // Without a valid config the save directory and database may not be the ones meant
+    if configOK {
+        report(checkSaveDir(cfg))
+        if cfg.Database != "" {
+            report(checkDatabase(cfg))
+        }
+    }
+
+    if failed > 0 {
+        return fmt.Errorf("%d check(s) failed", failed)
+    }
+    if warned > 0 {
+        fmt.Printf("\n%d warning(s); pianotrap can record.\n", warned)
+    } else {
+        fmt.Println("\nEverything pianotrap needs is in place.")
+    }
+    return nil
+}
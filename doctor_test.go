@@ -0,0 +1,44 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDoctorChecks(t *testing.T) {
+    info := "Server String: /run/user/1000/pulse/native\nServer Name: PulseAudio (on PipeWire 1.0.5)\nDefault Sink: alsa_output\n"
+    if got := pactlServerName(info); got != "PulseAudio (on PipeWire 1.0.5)" {
+        t.Errorf("pactlServerName = %q", got)
+    }
+    for config, want := range map[string]bool{
+        "user = me@example.com\npassword = pw\n":                true,
+        "user = me@example.com\npassword_command = pass pandora": true,
+        "# user = you@example.com\n# password = secret\n":       false,
+        "user = me@example.com\npassword =\n":                   false,
+    } {
+        if got := pianobarAccount(config); got != want {
+            t.Errorf("pianobarAccount(%q) = %v", config, got)
+        }
+    }
+
+    configFile := filepath.Join(t.TempDir(), "config")
+    cfg := Config{ConfigFile: configFile, SaveDir: "/music"}
+    for data, want := range map[string]string{
+        "gain = 2\n":           "ok",
+        "gain = 2\nbrokr = x\n": "fail",
+        "gain = 99\n":          "fail",
+        "[mqtt\n":              "fail",
+    } {
+        ioutil.WriteFile(configFile, []byte(data), 0644)
+        if c := checkConfig(cfg); c.Status != want {
+            t.Errorf("checkConfig of %q = %v, want %s", data, c, want)
+        }
+    }
+    ioutil.WriteFile(configFile, []byte("gain = 2\n"), 0644)
+    cfg.Profile = "work"
+    if c := checkConfig(cfg); c.Status != "fail" || !strings.Contains(c.Detail, "unknown profile") {
+        t.Errorf("checkConfig with a missing profile = %v", c)
+    }
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Metadata enrichment fills in what Pandora doesn't provide (release year, label)
+// from MusicBrainz, falling back to Discogs when MusicBrainz has no match. The
+// provider of every field is recorded in the METADATA_SOURCES tag.
+
+var (
+    enrichMetadata bool
+    discogsToken   string
+)
+
+const enrichUserAgent = "pianotrap/1.0 (https://github.com/arthurgloer/pianotrap)"
+
+// releaseInfo is what a provider found for a recording
+type releaseInfo struct {
+    Provider string
+    Album    string
+    Year     string
+    Label    string
+}
+
+// enrichTags looks the song up and merges the result into tags. Finding no
+// release isn't an error; with enrich off it's errNotConfigured.
+func enrichTags(fileName string, tags *Tags) error {
+    if !enrichMetadata {
+        return errNotConfigured
+    }
+    if tags.Title == "" || len(tags.Artists) == 0 {
+        return nil
+    }
+    sources := map[string]string{}
+    if tags.Album != "" {
+        sources["album"] = "pandora"
+    }
+
+    info, err := lookupMusicBrainz(tags.Title, tags.Artists[0], tags.Album, tags.Custom["MUSICBRAINZ_TRACKID"])
+    if err != nil {
+        logger.Printf("MusicBrainz lookup for %s: %v", fileName, err)
+    }
+    if info.Provider == "" && discogsToken != "" {
+        if info, err = lookupDiscogs(tags.Title, tags.Artists[0], tags.Album); err != nil {
+            logger.Printf("Discogs lookup for %s: %v", fileName, err)
+        }
+    }
+    if info.Provider == "" {
+        if err != nil {
+            return err
+        }
+        logger.Printf("No release found for %s", fileName)
+        return nil
+    }
+
+    if tags.Album == "" && info.Album != "" {
+        tags.Album = info.Album
+        sources["album"] = info.Provider
+    }
+    if info.Year != "" {
+        tags.Year = info.Year
+        sources["year"] = info.Provider
+    }
+    if tags.Custom == nil {
+        tags.Custom = make(map[string]string)
+    }
+    if info.Label != "" {
+        tags.Custom["LABEL"] = info.Label
+        sources["label"] = info.Provider
+    }
+    tags.Custom["METADATA_SOURCES"] = formatSources(sources)
+    logger.Printf("Enriched %s: %s", fileName, tags.Custom["METADATA_SOURCES"])
+    return nil
+}
+
+// formatSources renders field→provider pairs as "album=pandora; year=musicbrainz"
+func formatSources(sources map[string]string) string {
+    fields := make([]string, 0, len(sources))
+    for field := range sources {
+        fields = append(fields, field)
+    }
+    sort.Strings(fields)
+    for i, field := range fields {
+        fields[i] = field + "=" + sources[field]
+    }
+    return strings.Join(fields, "; ")
+}
+
+func getJSON(requestURL string, v interface{}) error {
+    req, err := http.NewRequest("GET", requestURL, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("User-Agent", enrichUserAgent)
+    req.Header.Set("Accept", "application/json")
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("unexpected status: %s", resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// lookupMusicBrainz finds the release of a recording, by MBID when fingerprinting
+// found one and by title and artist otherwise
+func lookupMusicBrainz(title, artist, album, recordingID string) (releaseInfo, error) {
+    type release struct {
+        Title     string `json:"title"`
+        Date      string `json:"date"`
+        LabelInfo []struct {
+            Label struct {
+                Name string `json:"name"`
+            } `json:"label"`
+        } `json:"label-info"`
+    }
+    type recording struct {
+        Score    int       `json:"score"`
+        Releases []release `json:"releases"`
+    }
+
+    var recordings []recording
+    if recordingID != "" {
+        var rec recording
+        if err := getJSON("https://musicbrainz.org/ws/2/recording/"+url.PathEscape(recordingID)+"?inc=releases+labels&fmt=json", &rec); err != nil {
+            return releaseInfo{}, err
+        }
+        rec.Score = 100
+        recordings = append(recordings, rec)
+    } else {
+        query := fmt.Sprintf(`recording:"%s" AND artist:"%s"`, luceneEscape(title), luceneEscape(artist))
+        var result struct {
+            Recordings []recording `json:"recordings"`
+        }
+        if err := getJSON("https://musicbrainz.org/ws/2/recording?fmt=json&limit=10&query="+url.QueryEscape(query), &result); err != nil {
+            return releaseInfo{}, err
+        }
+        recordings = result.Recordings
+    }
+
+    // Prefer the release matching Pandora's album, otherwise the earliest one
+    var best *release
+    for _, rec := range recordings {
+        if rec.Score < 90 {
+            continue
+        }
+        for i := range rec.Releases {
+            r := &rec.Releases[i]
+            if album != "" && strings.EqualFold(r.Title, album) {
+                if best == nil || !strings.EqualFold(best.Title, album) || (r.Date != "" && (best.Date == "" || r.Date < best.Date)) {
+                    best = r
+                }
+                continue
+            }
+            if best == nil || (!strings.EqualFold(best.Title, album) && r.Date != "" && (best.Date == "" || r.Date < best.Date)) {
+                best = r
+            }
+        }
+    }
+    if best == nil {
+        return releaseInfo{}, nil
+    }
+    info := releaseInfo{Provider: "musicbrainz", Album: best.Title}
+    if len(best.Date) >= 4 {
+        info.Year = best.Date[:4]
+    }
+    if len(best.LabelInfo) > 0 {
+        info.Label = best.LabelInfo[0].Label.Name
+    }
+    return info, nil
+}
+
+// luceneEscape escapes quotes and backslashes inside a quoted search term
+func luceneEscape(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// lookupDiscogs searches Discogs releases containing the track
+func lookupDiscogs(title, artist, album string) (releaseInfo, error) {
+    params := url.Values{
+        "type":   {"release"},
+        "artist": {artist},
+        "track":  {title},
+        "token":  {discogsToken},
+    }
+    var result struct {
+        Results []struct {
+            Title string   `json:"title"`
+            Year  string   `json:"year"`
+            Label []string `json:"label"`
+        } `json:"results"`
+    }
+    if err := getJSON("https://api.discogs.com/database/search?"+params.Encode(), &result); err != nil {
+        return releaseInfo{}, err
+    }
+    if len(result.Results) == 0 {
+        return releaseInfo{}, nil
+    }
+    best := result.Results[0]
+    for _, r := range result.Results {
+        // Discogs titles are "Artist - Album"
+        if album != "" && strings.HasSuffix(strings.ToLower(r.Title), " - "+strings.ToLower(album)) {
+            best = r
+            break
+        }
+    }
+    info := releaseInfo{Provider: "discogs", Year: best.Year}
+    if i := strings.Index(best.Title, " - "); i >= 0 {
+        info.Album = best.Title[i+3:]
+    }
+    if len(best.Label) > 0 {
+        info.Label = best.Label[0]
+    }
+    return info, nil
+}
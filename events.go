@@ -0,0 +1,215 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// pianobar runs its event_command with the event name as the only argument and
+// key=value lines on stdin. pianotrap installs itself as that command and forwards
+// the payload over a unix socket to the running session.
+const (
+    eventSocketEnv = "PIANOTRAP_EVENT_SOCKET"
+    eventChainEnv  = "PIANOTRAP_EVENT_CHAIN"
+)
+
+var (
+    eventsDir string
+    // coverArts holds the art URL pianobar reported for each song, coverFiles the
+    // downloaded copy the remote integrations show
+    coverArts  = make(map[string]string)
+    coverFiles = make(map[string]string)
+)
+
+// runEventCommand handles an invocation by pianobar as its event_command and reports
+// whether this process was one
+func runEventCommand() bool {
+    socketPath := os.Getenv(eventSocketEnv)
+    if socketPath == "" || len(os.Args) != 2 {
+        return false
+    }
+    payload, _ := ioutil.ReadAll(os.Stdin)
+    if conn, err := net.Dial("unix", socketPath); err == nil {
+        fmt.Fprintf(conn, "event=%s\n", os.Args[1])
+        conn.Write(payload)
+        conn.Close()
+    }
+    // Keep the user's own event_command working
+    if chain := os.Getenv(eventChainEnv); chain != "" {
+        cmd := exec.Command(chain, os.Args[1])
+        cmd.Stdin = bytes.NewReader(payload)
+        cmd.Stdout = os.Stdout
+        cmd.Stderr = os.Stderr
+        cmd.Run()
+    }
+    return true
+}
+
+// setupPianobarEvents creates a pianobar config overlay that points event_command at
+// this binary, and at cfg's Pandora account if it sets one, and starts listening for
+// events. It returns the environment pianobar needs to pick up the overlay.
+func setupPianobarEvents(cfg Config) ([]string, error) {
+    exe, err := os.Executable()
+    if err != nil {
+        return nil, fmt.Errorf("failed to locate pianotrap executable: %v", err)
+    }
+    eventsDir, err = ioutil.TempDir("", "pianotrap-")
+    if err != nil {
+        return nil, fmt.Errorf("failed to create session directory: %v", err)
+    }
+
+    userConfigHome := os.Getenv("XDG_CONFIG_HOME")
+    if userConfigHome == "" {
+        homeDir, err := os.UserHomeDir()
+        if err != nil {
+            return nil, err
+        }
+        userConfigHome = filepath.Join(homeDir, ".config")
+    }
+    userDir := filepath.Join(userConfigHome, "pianobar")
+    overlayDir := filepath.Join(eventsDir, "pianobar")
+    if err := os.MkdirAll(overlayDir, 0700); err != nil {
+        return nil, fmt.Errorf("failed to create pianobar config overlay: %v", err)
+    }
+
+    // Link everything but the config so pianobar's state and fifo stay in place
+    entries, _ := ioutil.ReadDir(userDir)
+    for _, entry := range entries {
+        if entry.Name() == "config" {
+            continue
+        }
+        os.Symlink(filepath.Join(userDir, entry.Name()), filepath.Join(overlayDir, entry.Name()))
+    }
+
+    var chain string
+    var config strings.Builder
+    if data, err := ioutil.ReadFile(filepath.Join(userDir, "config")); err == nil {
+        for _, line := range strings.Split(string(data), "\n") {
+            parts := strings.SplitN(line, "=", 2)
+            if len(parts) == 2 && strings.TrimSpace(parts[0]) == "event_command" {
+                chain = strings.TrimSpace(parts[1])
+                continue
+            }
+            if len(parts) == 2 && cfg.PandoraUser != "" {
+                switch strings.TrimSpace(parts[0]) {
+                case "user", "password", "password_command":
+                    continue
+                }
+            }
+            config.WriteString(line + "\n")
+        }
+    }
+    if cfg.PandoraUser != "" {
+        fmt.Fprintf(&config, "user = %s\npassword = %s\n", cfg.PandoraUser, cfg.PandoraPassword)
+    }
+    fmt.Fprintf(&config, "event_command = %s\n", exe)
+    if err := ioutil.WriteFile(filepath.Join(overlayDir, "config"), []byte(config.String()), 0600); err != nil {
+        return nil, fmt.Errorf("failed to write pianobar config overlay: %v", err)
+    }
+
+    socketPath := filepath.Join(eventsDir, "events.sock")
+    listener, err := net.Listen("unix", socketPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen for pianobar events: %v", err)
+    }
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                handlePianobarEvent(readEvent(conn))
+            }()
+        }
+    }()
+
+    env := []string{
+        "XDG_CONFIG_HOME=" + eventsDir,
+        eventSocketEnv + "=" + socketPath,
+    }
+    if chain != "" {
+        env = append(env, eventChainEnv+"="+chain)
+    }
+    return env, nil
+}
+
+// cleanupPianobarEvents removes the session's config overlay and socket
+func cleanupPianobarEvents() {
+    if eventsDir != "" {
+        os.RemoveAll(eventsDir)
+    }
+}
+
+// readEvent parses the key=value lines of a forwarded pianobar event
+func readEvent(r io.Reader) map[string]string {
+    event := make(map[string]string)
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        parts := strings.SplitN(scanner.Text(), "=", 2)
+        if len(parts) == 2 {
+            event[parts[0]] = parts[1]
+        }
+    }
+    return event
+}
+
+func handlePianobarEvent(event map[string]string) {
+    logger.Printf("Pianobar event: %s", event["event"])
+    switch event["event"] {
+    case "songstart":
+        emitBoundary(boundaryStart, boundaryEventCmd, 1, event["title"], event["artist"], event["stationName"])
+        if art := event["coverArt"]; art != "" {
+            key := songKey(event["title"], event["artist"])
+            mu.Lock()
+            coverArts[key] = art
+            mu.Unlock()
+            file, err := fetchCoverArt(art)
+            if err != nil {
+                logger.Printf("Failed to fetch cover art of %s: %v", key, err)
+                break
+            }
+            mu.Lock()
+            coverFiles[key] = file
+            mu.Unlock()
+        }
+    case "songfinish":
+        emitBoundary(boundaryEnd, boundaryEventCmd, 1, event["title"], event["artist"], event["stationName"])
+    case "songlove":
+        mu.Lock()
+        isCurrent := len(currentTags.Artists) > 0 && songKey(currentTags.Title, currentTags.Artists[0]) == songKey(event["title"], event["artist"])
+        mu.Unlock()
+        if isCurrent {
+            markCurrentLoved()
+        }
+    }
+}
+
+// songKey identifies a song across the banner parser and pianobar events
+func songKey(title, artist string) string {
+    return fmt.Sprintf("%s by %s", title, artist)
+}
+
+// coverArtFor returns the cover art URL pianobar reported for a song, if any
+func coverArtFor(title, artist string) string {
+    mu.Lock()
+    defer mu.Unlock()
+    return coverArts[songKey(title, artist)]
+}
+
+// coverFileFor returns the downloaded cover art of a song, if any
+func coverFileFor(title, artist string) string {
+    mu.Lock()
+    defer mu.Unlock()
+    return coverFiles[songKey(title, artist)]
+}
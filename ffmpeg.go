@@ -0,0 +1,138 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "text/template"
+)
+
+// ffmpeg_command replaces the ffmpeg binary everywhere pianotrap runs it, e.g. with
+// avconv or a build of its own. ffmpeg_args is a template for the arguments of the
+// capture command, so filters or muxer flags can be added without a rebuild. It is
+// rendered into a shell-like command line: quotes group words, and the fields that
+// may hold spaces are quoted already.
+
+// ffmpegPath is the ffmpeg binary to run. Guarded by mu, as reloads change it.
+var ffmpegPath = "ffmpeg"
+
+// ffmpegBinary returns ffmpegPath
+func ffmpegBinary() string {
+    mu.Lock()
+    defer mu.Unlock()
+    return ffmpegPath
+}
+
+// defaultFFmpegArgs is what pianotrap runs ffmpeg with when ffmpeg_args isn't set.
+// The output format can't be guessed from the temporary file name.
+const defaultFFmpegArgs = "-f pulse {{.Input}} -i {{.Source}} -acodec {{.Codec}} {{.Output}} -f {{.Muxer}} -y {{.Trim}} {{.File}}"
+
+// defaultFFmpegTemplate is defaultFFmpegArgs parsed
+var defaultFFmpegTemplate = template.Must(template.New("ffmpeg_args").Parse(defaultFFmpegArgs))
+
+// ffmpegFields are the values ffmpeg_args can use
+type ffmpegFields struct {
+    Source string // the monitor source to record, quoted
+    File   string // the file to write, quoted
+    Input  string // sample rate and channel options for the source
+    Output string // gain and sample format options
+    Trim   string // the start offset and duration options
+    Codec  string // the encoder of the format option, e.g. mp3
+    Muxer  string // the output format of the format option, e.g. mp3
+}
+
+// loadFFmpegConfig reads ffmpeg_command and ffmpeg_args
+func loadFFmpegConfig(values map[string]string, cfg *Config) error {
+    cfg.FFmpegCommand = values["ffmpeg_command"]
+    if cfg.FFmpegCommand == "" {
+        cfg.FFmpegCommand = "ffmpeg"
+    }
+    raw := values["ffmpeg_args"]
+    if raw == "" {
+        return nil
+    }
+    t, err := template.New("ffmpeg_args").Option("missingkey=error").Parse(raw)
+    if err != nil {
+        return fmt.Errorf("invalid ffmpeg_args: %v", err)
+    }
+    // Catch mistakes now rather than when the first song plays
+    sample := ffmpegFields{Source: "Sink.monitor", File: shellQuote("/music/Station/It's a Song.mp3"), Trim: "-t 200.0", Codec: "mp3", Muxer: "mp3"}
+    args, err := renderFFmpegArgs(t, sample)
+    if err != nil {
+        return fmt.Errorf("invalid ffmpeg_args: %v", err)
+    }
+    hasSource, hasFile := false, false
+    for _, arg := range args {
+        hasSource = hasSource || arg == "Sink.monitor"
+        hasFile = hasFile || arg == "/music/Station/It's a Song.mp3"
+    }
+    if !hasSource || !hasFile {
+        return fmt.Errorf("invalid ffmpeg_args: it must use {{.Source}} and {{.File}} as arguments of their own")
+    }
+    cfg.FFmpegArgs = t
+    return nil
+}
+
+// renderFFmpegArgs evaluates an ffmpeg_args template into arguments
+func renderFFmpegArgs(t *template.Template, f ffmpegFields) ([]string, error) {
+    var b strings.Builder
+    if err := t.Execute(&b, f); err != nil {
+        return nil, err
+    }
+    return splitArgs(b.String())
+}
+
+// shellQuote quotes s for splitArgs if it needs it
+func shellQuote(s string) string {
+    if s != "" && !strings.ContainsAny(s, " \t\n'\"\\") {
+        return s
+    }
+    return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// splitArgs splits a command line at whitespace, honoring single quotes, double
+// quotes and backslash escapes like a POSIX shell
+func splitArgs(s string) ([]string, error) {
+    var args []string
+    var arg strings.Builder
+    inArg, escaped := false, false
+    var quote rune
+    for _, r := range s {
+        switch {
+        case escaped:
+            arg.WriteRune(r)
+            escaped = false
+        case quote == '\'':
+            if r == '\'' {
+                quote = 0
+            } else {
+                arg.WriteRune(r)
+            }
+        case r == '\\':
+            escaped, inArg = true, true
+        case quote == '"':
+            if r == '"' {
+                quote = 0
+            } else {
+                arg.WriteRune(r)
+            }
+        case r == '\'' || r == '"':
+            quote, inArg = r, true
+        case r == ' ' || r == '\t' || r == '\n':
+            if inArg {
+                args = append(args, arg.String())
+                arg.Reset()
+                inArg = false
+            }
+        default:
+            arg.WriteRune(r)
+            inArg = true
+        }
+    }
+    if quote != 0 || escaped {
+        return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+    }
+    if inArg {
+        args = append(args, arg.String())
+    }
+    return args, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestFFmpegArgsTemplate(t *testing.T) {
+    var cfg Config
+    err := loadFFmpegConfig(map[string]string{
+        "ffmpeg_command": "avconv",
+        "ffmpeg_args":    `-f pulse -i {{.Source}} -af "highpass=f=40, lowpass=f=16000" -acodec mp3 -b:a 320k -f mp3 -y {{.Trim}} {{.File}}`,
+    }, &cfg)
+    if err != nil || cfg.FFmpegCommand != "avconv" {
+        t.Fatalf("loadFFmpegConfig = %v, %q", err, cfg.FFmpegCommand)
+    }
+    args := buildFFmpegArgs(cfg, "src.monitor", `/music/Jazz Radio/Don't "Stop".mp3`, 10*time.Second)
+    want := []string{"-f", "pulse", "-i", "src.monitor", "-af", "highpass=f=40, lowpass=f=16000", "-acodec", "mp3", "-b:a", "320k",
+        "-f", "mp3", "-y", "-t", fmt.Sprintf("%.1f", (10*time.Second+durationPad).Seconds()), `/music/Jazz Radio/Don't "Stop".mp3`}
+    if !reflect.DeepEqual(args, want) {
+        t.Errorf("args = %q, want %q", args, want)
+    }
+    for _, bad := range []string{"-i {{.Source}} out.mp3", "-i {{.Source}} {{.Fiel}}", "-i {{.Source}} '{{.File}}", "-i {{.Source}} x{{.File}}"} {
+        if err := loadFFmpegConfig(map[string]string{"ffmpeg_args": bad}, &cfg); err == nil {
+            t.Errorf("ffmpeg_args %q accepted", bad)
+        }
+    }
+    if args, err := splitArgs(`a 'b c' "d\" e" f\ g`); err != nil || !reflect.DeepEqual(args, []string{"a", "b c", `d" e`, "f g"}) {
+        t.Errorf("splitArgs = %q, %v", args, err)
+    }
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// acoustIDKey is the AcoustID application key used to look up fingerprints; without
+// one recordings are still fingerprinted but not identified
+var acoustIDKey string
+
+// fingerprint describes a recording's Chromaprint fingerprint and, when found, the
+// matching AcoustID and MusicBrainz recording
+type fingerprint struct {
+    Duration      float64 `json:"duration"`
+    Fingerprint   string  `json:"fingerprint"`
+    AcoustID      string  `json:"-"`
+    Score         float64 `json:"-"`
+    MBRecordingID string  `json:"-"`
+
+    // Metadata of the matched recording
+    Title   string   `json:"-"`
+    Artists []string `json:"-"`
+    Album   string   `json:"-"`
+}
+
+// fingerprintFile runs fpcalc (from Chromaprint) on fileName
+func fingerprintFile(fileName string) (fingerprint, error) {
+    var fp fingerprint
+    out, err := exec.Command("fpcalc", "-json", fileName).Output()
+    if err != nil {
+        return fp, fmt.Errorf("fpcalc failed: %v", err)
+    }
+    if err := json.Unmarshal(out, &fp); err != nil {
+        return fp, fmt.Errorf("failed to parse fpcalc output: %v", err)
+    }
+    if fp.Fingerprint == "" {
+        return fp, fmt.Errorf("fpcalc returned no fingerprint")
+    }
+    return fp, nil
+}
+
+// lookupAcoustID fills in the best AcoustID match for the fingerprint
+func lookupAcoustID(fp *fingerprint) error {
+    form := url.Values{
+        "client":      {acoustIDKey},
+        "meta":        {"recordings releasegroups"},
+        "duration":    {fmt.Sprintf("%d", int(fp.Duration))},
+        "fingerprint": {fp.Fingerprint},
+    }
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.PostForm("https://api.acoustid.org/v2/lookup", form)
+    if err != nil {
+        return fmt.Errorf("AcoustID lookup failed: %v", err)
+    }
+    defer resp.Body.Close()
+
+    var result struct {
+        Status string `json:"status"`
+        Error  struct {
+            Message string `json:"message"`
+        } `json:"error"`
+        Results []struct {
+            ID         string  `json:"id"`
+            Score      float64 `json:"score"`
+            Recordings []struct {
+                ID      string `json:"id"`
+                Title   string `json:"title"`
+                Artists []struct {
+                    Name string `json:"name"`
+                } `json:"artists"`
+                ReleaseGroups []struct {
+                    Title string `json:"title"`
+                    Type  string `json:"type"`
+                } `json:"releasegroups"`
+            } `json:"recordings"`
+        } `json:"results"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return fmt.Errorf("failed to parse AcoustID response: %v", err)
+    }
+    if result.Status != "ok" {
+        return fmt.Errorf("AcoustID lookup failed: %s", result.Error.Message)
+    }
+    for _, r := range result.Results {
+        if r.Score > fp.Score {
+            fp.AcoustID, fp.Score = r.ID, r.Score
+            fp.MBRecordingID, fp.Title, fp.Artists, fp.Album = "", "", nil, ""
+            if len(r.Recordings) > 0 {
+                rec := r.Recordings[0]
+                fp.MBRecordingID, fp.Title = rec.ID, rec.Title
+                for _, a := range rec.Artists {
+                    fp.Artists = append(fp.Artists, a.Name)
+                }
+                // Prefer the album a song first appeared on over compilations
+                for _, rg := range rec.ReleaseGroups {
+                    if fp.Album == "" || rg.Type == "Album" {
+                        fp.Album = rg.Title
+                    }
+                    if rg.Type == "Album" {
+                        break
+                    }
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// minIdentifyScore is the AcoustID score needed to replace missing metadata
+const minIdentifyScore = 0.8
+
+// identifyRecording fingerprints a finished recording and records the result in its
+// tags. When pianobar's metadata was missing or garbled the song's title, artist
+// and album come from the match instead, and the file is renamed to match; the
+// returned name is the recording's final file name. Without fpcalc or an AcoustID
+// key the error is errNotConfigured.
+func identifyRecording(fileName string, tags *Tags) (string, error) {
+    if _, err := exec.LookPath("fpcalc"); err != nil {
+        return fileName, errNotConfigured
+    }
+    fp, err := fingerprintFile(fileName)
+    if err != nil {
+        return fileName, err
+    }
+    if tags.Custom == nil {
+        tags.Custom = make(map[string]string)
+    }
+    tags.Custom["ACOUSTID_FINGERPRINT"] = fp.Fingerprint
+    if acoustIDKey == "" {
+        return fileName, errNotConfigured
+    }
+    if err := lookupAcoustID(&fp); err != nil {
+        return fileName, err
+    }
+    if fp.AcoustID == "" {
+        logger.Printf("No AcoustID match for %s", fileName)
+        return fileName, nil
+    }
+    logger.Printf("AcoustID for %s: %s (score %.2f)", fileName, fp.AcoustID, fp.Score)
+    tags.Custom["ACOUSTID_ID"] = fp.AcoustID
+    if fp.MBRecordingID != "" {
+        tags.Custom["MUSICBRAINZ_TRACKID"] = fp.MBRecordingID
+    }
+
+    artist := ""
+    if len(tags.Artists) > 0 {
+        artist = tags.Artists[0]
+    }
+    if !tagsLookIncomplete(tags.Title, artist, tags.Album) || fp.Score < minIdentifyScore || fp.Title == "" || len(fp.Artists) == 0 {
+        return fileName, nil
+    }
+    logger.Printf("Identified %s as %q by %q", fileName, fp.Title, strings.Join(fp.Artists, ", "))
+    tags.Title, tags.Artists = fp.Title, fp.Artists
+    if fp.Album != "" {
+        tags.Album = fp.Album
+    }
+    newName := renamedPath(fileName, *tags)
+    if newName == fileName {
+        return fileName, nil
+    }
+    if err := renameFile(fileName, newName); err != nil {
+        logger.Printf("Failed to rename %s: %v", fileName, err)
+        return fileName, nil
+    }
+    renameSong(fileName, newName, *tags)
+    fmt.Printf("\r\nIdentified %s as %s\n", filepath.Base(fileName), filepath.Base(newName))
+    return newName, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "strings"
+)
+
+// genreKeywords are looked up in station names when no mapping is configured. Longer,
+// more specific keywords come first so "Classic Rock" isn't read as "Classical".
+var genreKeywords = []struct {
+    keyword string
+    genre   string
+}{
+    {"hip hop", "Hip-Hop"},
+    {"hip-hop", "Hip-Hop"},
+    {"rap", "Hip-Hop"},
+    {"r&b", "R&B"},
+    {"bluegrass", "Bluegrass"},
+    {"blues", "Blues"},
+    {"jazz", "Jazz"},
+    {"classical", "Classical"},
+    {"opera", "Opera"},
+    {"country", "Country"},
+    {"metal", "Metal"},
+    {"punk", "Punk"},
+    {"rock", "Rock"},
+    {"reggae", "Reggae"},
+    {"folk", "Folk"},
+    {"soul", "Soul"},
+    {"funk", "Funk"},
+    {"disco", "Disco"},
+    {"gospel", "Gospel"},
+    {"worship", "Christian"},
+    {"latin", "Latin"},
+    {"salsa", "Latin"},
+    {"indie", "Indie"},
+    {"alternative", "Alternative"},
+    {"electronic", "Electronic"},
+    {"edm", "Electronic"},
+    {"house", "House"},
+    {"techno", "Techno"},
+    {"ambient", "Ambient"},
+    {"lo-fi", "Lo-Fi"},
+    {"lofi", "Lo-Fi"},
+    {"chill", "Chillout"},
+    {"soundtrack", "Soundtrack"},
+    {"christmas", "Holiday"},
+    {"holiday", "Holiday"},
+    {"kids", "Children's"},
+    {"comedy", "Comedy"},
+    {"pop", "Pop"},
+}
+
+// loadGenreConfig reads "genre.<station> = <genre>" mappings and the derivegenre switch
+func loadGenreConfig(values map[string]string, cfg *Config) {
+    cfg.GenreMap = make(map[string]string)
+    for key, value := range values {
+        if station := strings.TrimPrefix(key, "genre."); station != key && station != "" && value != "" {
+            cfg.GenreMap[station] = value
+        }
+    }
+    cfg.DeriveGenre = values["derivegenre"] != "false"
+}
+
+// genreFor returns the genre to tag recordings from station with: a configured mapping
+// first, then a genre keyword found in the station name, or "" if neither applies
+func (cfg Config) genreFor(station string) string {
+    for name, genre := range cfg.GenreMap {
+        if strings.EqualFold(name, station) || strings.EqualFold(sanitizeFileName(name), station) {
+            return genre
+        }
+    }
+    if !cfg.DeriveGenre {
+        return ""
+    }
+    return deriveGenre(station)
+}
+
+// deriveGenre looks for a whole-word genre keyword in a station name
+func deriveGenre(station string) string {
+    words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(station), func(r rune) bool {
+        return r == ' ' || r == '_' || r == '/' || r == ',' || r == '(' || r == ')'
+    }), " ") + " "
+    for _, k := range genreKeywords {
+        if strings.Contains(words, " "+k.keyword+" ") {
+            return k.genre
+        }
+    }
+    return ""
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The HTTP API address also speaks gRPC, for typed clients in other languages;
+// pianotrap.proto describes the service. Like the MQTT client, the little of gRPC
+// and protobuf this needs is done by hand: gRPC is HTTP/2 POSTs of length-prefixed
+// protobuf messages with the outcome in trailers, and Go's HTTP server speaks
+// HTTP/2 without TLS next to HTTP/1.
+
+const grpcService = "pianotrap.v1.Pianotrap"
+
+// gRPC status codes
+const (
+    grpcOK                 = 0
+    grpcInvalidArgument    = 3
+    grpcFailedPrecondition = 9
+    grpcUnimplemented      = 12
+    grpcInternal           = 13
+    grpcUnavailable        = 14
+    grpcUnauthenticated    = 16
+)
+
+// grpcError is a failed call's status code and message
+type grpcError struct {
+    Code    int
+    Message string
+}
+
+func (e *grpcError) Error() string {
+    return e.Message
+}
+
+// protoField is a field of a decoded protobuf message; Bytes holds length-delimited
+// values, Varint the others
+type protoField struct {
+    Num    int
+    Varint uint64
+    Bytes  []byte
+}
+
+// decodeProto splits a protobuf message into its fields
+func decodeProto(data []byte) ([]protoField, error) {
+    var fields []protoField
+    for len(data) > 0 {
+        key, n := binary.Uvarint(data)
+        if n <= 0 {
+            return nil, fmt.Errorf("malformed field key")
+        }
+        data = data[n:]
+        f := protoField{Num: int(key >> 3)}
+        switch key & 7 {
+        case 0:
+            if f.Varint, n = binary.Uvarint(data); n <= 0 {
+                return nil, fmt.Errorf("malformed varint in field %d", f.Num)
+            }
+            data = data[n:]
+        case 1:
+            if len(data) < 8 {
+                return nil, fmt.Errorf("truncated field %d", f.Num)
+            }
+            f.Varint, data = binary.LittleEndian.Uint64(data), data[8:]
+        case 2:
+            size, n := binary.Uvarint(data)
+            if n <= 0 || uint64(len(data)-n) < size {
+                return nil, fmt.Errorf("truncated field %d", f.Num)
+            }
+            f.Bytes, data = data[n:n+int(size)], data[n+int(size):]
+        case 5:
+            if len(data) < 4 {
+                return nil, fmt.Errorf("truncated field %d", f.Num)
+            }
+            f.Varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+        default:
+            return nil, fmt.Errorf("unsupported wire type in field %d", f.Num)
+        }
+        fields = append(fields, f)
+    }
+    return fields, nil
+}
+
+// protoWriter encodes a protobuf message. Like proto3, it leaves out zero values.
+type protoWriter struct {
+    b []byte
+}
+
+func (w *protoWriter) key(num, wireType int) {
+    w.b = binary.AppendUvarint(w.b, uint64(num)<<3|uint64(wireType))
+}
+
+// Varint writes an integer field; negative values take ten bytes, as for int32
+// and int64
+func (w *protoWriter) Varint(num int, v int64) {
+    if v != 0 {
+        w.key(num, 0)
+        w.b = binary.AppendUvarint(w.b, uint64(v))
+    }
+}
+
+func (w *protoWriter) Uint(num int, v uint64) {
+    if v != 0 {
+        w.key(num, 0)
+        w.b = binary.AppendUvarint(w.b, v)
+    }
+}
+
+func (w *protoWriter) Bool(num int, v bool) {
+    if v {
+        w.Varint(num, 1)
+    }
+}
+
+func (w *protoWriter) String(num int, s string) {
+    if s != "" {
+        w.Message(num, []byte(s))
+    }
+}
+
+// Message writes a length-delimited field, even an empty one
+func (w *protoWriter) Message(num int, b []byte) {
+    w.key(num, 2)
+    w.b = binary.AppendUvarint(w.b, uint64(len(b)))
+    w.b = append(w.b, b...)
+}
+
+// protoTime encodes t as a google.protobuf.Timestamp
+func protoTime(t time.Time) []byte {
+    var w protoWriter
+    w.Varint(1, t.Unix())
+    w.Varint(2, int64(t.Nanosecond()))
+    return w.b
+}
+
+// encodeStatus encodes s as a Status message
+func encodeStatus(s playerStatus) []byte {
+    var w protoWriter
+    w.String(1, s.State)
+    w.String(2, s.Station)
+    w.String(3, s.Title)
+    w.String(4, s.Artist)
+    w.String(5, s.Album)
+    w.Bool(6, s.Loved)
+    w.Bool(7, s.Recording)
+    w.Bool(8, s.Paused)
+    w.String(9, s.File)
+    w.Varint(10, int64(s.Remaining))
+    w.Varint(11, int64(s.Total))
+    w.String(12, s.CoverArt)
+    return w.b
+}
+
+// encodeRecording encodes s as a Recording message
+func encodeRecording(s songRecord) []byte {
+    var w protoWriter
+    w.Varint(1, s.ID)
+    w.String(2, s.Title)
+    w.String(3, s.Artist)
+    w.String(4, s.Album)
+    w.String(5, s.Station)
+    w.Bool(6, s.Loved)
+    w.Message(7, protoTime(s.DetectedAt))
+    if s.FinishedAt.Valid {
+        w.Message(8, protoTime(s.FinishedAt.Time))
+    }
+    w.String(9, s.File)
+    w.String(10, s.Outcome)
+    return w.b
+}
+
+// encodeStats encodes s as a Stats message
+func encodeStats(s apiStats) []byte {
+    var w protoWriter
+    w.Message(1, protoTime(s.Since))
+    for outcome, n := range s.Outcomes {
+        var entry protoWriter
+        entry.String(1, outcome)
+        entry.Varint(2, int64(n))
+        w.Message(2, entry.b)
+    }
+    w.Varint(3, int64(s.Recorded))
+    w.Varint(4, int64(s.Loved))
+    w.Varint(5, s.Bytes)
+    w.Uint(6, s.FreeBytes)
+    w.Varint(7, int64(s.DaysLeft))
+    return w.b
+}
+
+// encodeEvent encodes an Event message
+func encodeEvent(at time.Time, name, msg string, status playerStatus) []byte {
+    var w protoWriter
+    w.Message(1, protoTime(at))
+    w.String(2, name)
+    w.String(3, msg)
+    w.Message(4, encodeStatus(status))
+    return w.b
+}
+
+// grpcStatusReply answers a control with the status after it
+func grpcStatusReply(err error) ([]byte, *grpcError) {
+    if err != nil {
+        return nil, &grpcError{grpcUnavailable, err.Error()}
+    }
+    return encodeStatus(currentStatus()), nil
+}
+
+// grpcPress returns a method typing the keys of an MQTT command
+func grpcPress(command string) func([]protoField) ([]byte, *grpcError) {
+    return func([]protoField) ([]byte, *grpcError) {
+        return grpcStatusReply(sendKeys(mqttCommands[command]))
+    }
+}
+
+// grpcMethods are the unary methods of the service, which get the fields of the
+// request and return the encoded response
+var grpcMethods = map[string]func([]protoField) ([]byte, *grpcError){
+    "GetStatus": func([]protoField) ([]byte, *grpcError) {
+        return encodeStatus(currentStatus()), nil
+    },
+    "ListRecordings": func(fields []protoField) ([]byte, *grpcError) {
+        f := songFilter{Limit: 20}
+        for _, field := range fields {
+            switch field.Num {
+            case 1:
+                f.Limit = int(int32(field.Varint))
+            case 2:
+                f.Outcome = string(field.Bytes)
+            case 3:
+                f.Station = string(field.Bytes)
+            }
+        }
+        if f.Limit < 1 || f.Limit > 1000 {
+            return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("invalid limit %d (1-1000)", f.Limit)}
+        }
+        if db == nil {
+            return nil, &grpcError{grpcFailedPrecondition, "song history is disabled"}
+        }
+        songs, err := recentSongs(f)
+        if err != nil {
+            return nil, &grpcError{grpcInternal, err.Error()}
+        }
+        var w protoWriter
+        for _, s := range songs {
+            w.Message(1, encodeRecording(s))
+        }
+        return w.b, nil
+    },
+    "GetStats": func([]protoField) ([]byte, *grpcError) {
+        if db == nil {
+            return nil, &grpcError{grpcFailedPrecondition, "song history is disabled"}
+        }
+        stats, err := sessionStats()
+        if err != nil {
+            return nil, &grpcError{grpcInternal, err.Error()}
+        }
+        return encodeStats(stats), nil
+    },
+    "Skip":  grpcPress("skip"),
+    "Pause": grpcPress("pause"),
+    "Love":  grpcPress("love"),
+    "Keep": func([]protoField) ([]byte, *grpcError) {
+        keepCurrent()
+        return grpcStatusReply(nil)
+    },
+    "Discard": func([]protoField) ([]byte, *grpcError) {
+        discardCurrent()
+        return grpcStatusReply(nil)
+    },
+    "SwitchStation": func(fields []protoField) ([]byte, *grpcError) {
+        name := ""
+        for _, field := range fields {
+            if field.Num == 1 {
+                name = strings.TrimSpace(string(field.Bytes))
+            }
+        }
+        if name == "" {
+            return nil, &grpcError{grpcInvalidArgument, "missing station name"}
+        }
+        return grpcStatusReply(switchStation(name))
+    },
+    "SetRecording": func(fields []protoField) ([]byte, *grpcError) {
+        set := false
+        for _, field := range fields {
+            if field.Num == 1 {
+                setCapture(field.Varint != 0)
+                set = true
+            }
+        }
+        if !set {
+            toggleCapture()
+        }
+        return grpcStatusReply(nil)
+    },
+}
+
+var (
+    // eventWatchers are the channels of the WatchEvents calls in progress
+    eventWatchers   = make(map[chan grpcEvent]bool)
+    eventWatchersMu sync.Mutex
+)
+
+// grpcEvent is a pianotrap event on its way to the watchers
+type grpcEvent struct {
+    At        time.Time
+    Name, Msg string
+}
+
+// broadcastEvent passes an event to the watchers, dropping it for those that
+// fall behind
+func broadcastEvent(name, msg string) {
+    eventWatchersMu.Lock()
+    defer eventWatchersMu.Unlock()
+    for watcher := range eventWatchers {
+        select {
+        case watcher <- grpcEvent{time.Now(), name, msg}:
+        default:
+        }
+    }
+}
+
+// grpcFrame prefixes a message with the uncompressed flag and its length
+func grpcFrame(msg []byte) []byte {
+    frame := make([]byte, 5, 5+len(msg))
+    binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+    return append(frame, msg...)
+}
+
+// grpcRequest reads the request message of a unary call
+func grpcRequest(r *http.Request) ([]protoField, *grpcError) {
+    body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    if err != nil {
+        return nil, &grpcError{grpcInternal, err.Error()}
+    }
+    if len(body) == 0 {
+        return nil, nil
+    }
+    if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+        return nil, &grpcError{grpcInvalidArgument, "malformed request frame"}
+    }
+    if body[0] != 0 {
+        return nil, &grpcError{grpcUnimplemented, "compressed messages aren't supported"}
+    }
+    fields, err := decodeProto(body[5:])
+    if err != nil {
+        return nil, &grpcError{grpcInvalidArgument, err.Error()}
+    }
+    return fields, nil
+}
+
+// writeGRPCStatus ends a call with its status in the trailers
+func writeGRPCStatus(w http.ResponseWriter, e *grpcError) {
+    if e == nil {
+        e = &grpcError{grpcOK, ""}
+    }
+    w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(e.Code))
+    if e.Message != "" {
+        w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(e.Message))
+    }
+}
+
+// handleGRPC serves the calls of the service
+func handleGRPC(w http.ResponseWriter, r *http.Request) {
+    if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+        http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+        return
+    }
+    w.Header().Set("Content-Type", "application/grpc")
+    method := r.PathValue("method")
+    if method == "WatchEvents" {
+        watchEvents(w, r)
+        return
+    }
+    call, ok := grpcMethods[method]
+    if !ok {
+        writeGRPCStatus(w, &grpcError{grpcUnimplemented, "unknown method " + method})
+        return
+    }
+    fields, e := grpcRequest(r)
+    var reply []byte
+    if e == nil {
+        reply, e = call(fields)
+    }
+    if e == nil {
+        w.Write(grpcFrame(reply))
+    }
+    writeGRPCStatus(w, e)
+}
+
+// watchEvents streams the status, then the events and status changes until the
+// client hangs up. Like the MQTT status, a countdown alone isn't a change.
+func watchEvents(w http.ResponseWriter, r *http.Request) {
+    events := make(chan grpcEvent, 16)
+    eventWatchersMu.Lock()
+    eventWatchers[events] = true
+    eventWatchersMu.Unlock()
+    defer func() {
+        eventWatchersMu.Lock()
+        delete(eventWatchers, events)
+        eventWatchersMu.Unlock()
+    }()
+
+    flusher := http.NewResponseController(w)
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+    var last string
+    for first := true; ; first = false {
+        e := grpcEvent{At: time.Now(), Name: "status"}
+        if !first {
+            select {
+            case <-r.Context().Done():
+                return
+            case e = <-events:
+            case <-ticker.C:
+            }
+        }
+        status := currentStatus()
+        compare := status
+        compare.Remaining = 0
+        key, _ := json.Marshal(compare)
+        if e.Name == "status" && string(key) == last {
+            continue
+        }
+        last = string(key)
+        if _, err := w.Write(grpcFrame(encodeEvent(e.At, e.Name, e.Msg, status))); err != nil {
+            return
+        }
+        if err := flusher.Flush(); err != nil {
+            return
+        }
+    }
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestGRPC(t *testing.T) {
+    server := httptest.NewUnstartedServer(apiHandler())
+    server.Config.Protocols = new(http.Protocols)
+    server.Config.Protocols.SetUnencryptedHTTP2(true)
+    server.Start()
+    defer server.Close()
+    protocols := new(http.Protocols)
+    protocols.SetUnencryptedHTTP2(true)
+    client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+    // call starts a call with req as its request message
+    call := func(ctx context.Context, method string, req []byte) (*http.Response, error) {
+        httpReq, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/"+grpcService+"/"+method, bytes.NewReader(grpcFrame(req)))
+        httpReq.Header.Set("Content-Type", "application/grpc")
+        return client.Do(httpReq)
+    }
+    readMessage := func(r io.Reader) ([]protoField, error) {
+        header := make([]byte, 5)
+        if _, err := io.ReadFull(r, header); err != nil {
+            return nil, err
+        }
+        msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+        if _, err := io.ReadFull(r, msg); err != nil {
+            return nil, err
+        }
+        return decodeProto(msg)
+    }
+    field := func(fields []protoField, num int) string {
+        for _, f := range fields {
+            if f.Num == num {
+                return string(f.Bytes)
+            }
+        }
+        return ""
+    }
+
+    resp, err := call(context.Background(), "GetStatus", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fields, err := readMessage(resp.Body)
+    io.Copy(io.Discard, resp.Body)
+    resp.Body.Close()
+    if err != nil || resp.ProtoMajor != 2 || resp.Trailer.Get("Grpc-Status") != "0" || field(fields, 1) != "idle" {
+        t.Errorf("GetStatus = %v, %v, HTTP/%d, trailer %v", fields, err, resp.ProtoMajor, resp.Trailer)
+    }
+
+    art := playTestSong(t, "So What", "Miles Davis")
+    resp, err = call(context.Background(), "GetStatus", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fields, err = readMessage(resp.Body)
+    io.Copy(io.Discard, resp.Body)
+    resp.Body.Close()
+    if cover, _ := ioutil.ReadFile(field(fields, 12)); err != nil || !bytes.Equal(cover, art) {
+        t.Errorf("GetStatus cover_art = %q, %v", field(fields, 12), err)
+    }
+
+    var w protoWriter
+    w.String(1, " ")
+    for _, tt := range []struct {
+        method string
+        req    []byte
+        code   string
+    }{
+        {"SwitchStation", w.b, "3"},
+        {"Skip", nil, "14"},
+        {"Rewind", nil, "12"},
+    } {
+        resp, err := call(context.Background(), tt.method, tt.req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+        status := resp.Header.Get("Grpc-Status") + resp.Trailer.Get("Grpc-Status")
+        if status != tt.code {
+            t.Errorf("%s status = %q, want %s", tt.method, status, tt.code)
+        }
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    resp, err = call(ctx, "WatchEvents", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if fields, err := readMessage(resp.Body); err != nil || field(fields, 2) != "status" {
+        t.Fatalf("first event = %v, %v", fields, err)
+    }
+    // The watcher registers before the first event is sent
+    broadcastEvent(eventSaved, "/music/Jazz/One.mp3")
+    fields, err = readMessage(resp.Body)
+    if err != nil || field(fields, 2) != eventSaved || field(fields, 3) != "/music/Jazz/One.mp3" {
+        t.Errorf("saved event = %v, %v", fields, err)
+    }
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+    var w protoWriter
+    w.String(1, "Jazz")
+    w.Varint(2, -1)
+    w.Bool(3, true)
+    w.Varint(4, 0)
+    w.Message(5, protoTime(time.Unix(1700000000, 0)))
+    fields, err := decodeProto(w.b)
+    if err != nil || len(fields) != 4 {
+        t.Fatalf("decodeProto = %v, %v", fields, err)
+    }
+    if string(fields[0].Bytes) != "Jazz" || int32(fields[1].Varint) != -1 || fields[2].Varint != 1 || fields[3].Num != 5 {
+        t.Errorf("decoded %+v", fields)
+    }
+    if ts, _ := decodeProto(fields[3].Bytes); len(ts) != 1 || ts[0].Varint != 1700000000 {
+        t.Errorf("timestamp = %+v", ts)
+    }
+    if _, err := decodeProto([]byte{0x0a, 0x05, 'a'}); err == nil {
+        t.Error("truncated message decoded")
+    }
+}
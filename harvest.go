@@ -0,0 +1,68 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// In new-music-only mode songs already saved to the library aren't recorded again
+// and are skipped after a grace period, so pianotrap keeps moving on to songs it
+// hasn't captured yet. The artist sampler similarly caps how many songs per artist
+// are saved each week.
+
+// loadNewOnlyConfig reads the new_only and new_only_grace options
+func loadNewOnlyConfig(values map[string]string, cfg *Config) error {
+    cfg.NewOnly = values["new_only"] == "true"
+    cfg.NewOnlyGrace = 5 * time.Second
+    if raw := values["new_only_grace"]; raw != "" {
+        d, err := time.ParseDuration(raw)
+        if err != nil || d < 0 {
+            return fmt.Errorf("invalid value for new_only_grace: %q", raw)
+        }
+        cfg.NewOnlyGrace = d
+    }
+    return nil
+}
+
+// loadArtistCapConfig reads artist_cap (songs per artist per week) and artist_cap_action
+func loadArtistCapConfig(values map[string]string, cfg *Config) error {
+    if raw := values["artist_cap"]; raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n < 1 {
+            return fmt.Errorf("invalid value for artist_cap: %q", raw)
+        }
+        cfg.ArtistCap = n
+    }
+    switch action := values["artist_cap_action"]; action {
+    case "", "discard":
+        cfg.ArtistCapSkip = false
+    case "skip":
+        cfg.ArtistCapSkip = true
+    default:
+        return fmt.Errorf("invalid value for artist_cap_action: %q (want discard or skip)", action)
+    }
+    return nil
+}
+
+// artistCapReached reports whether the artist already has cfg.ArtistCap recordings
+// saved in the past week
+func artistCapReached(cfg Config, artist string) bool {
+    return cfg.ArtistCap > 0 && savedByArtistSince(artist, time.Now().AddDate(0, 0, -7)) >= cfg.ArtistCap
+}
+
+// skipKnownSong skips info after the grace period unless another song has started
+func skipKnownSong(info songInfo, grace time.Duration) {
+    time.Sleep(grace)
+    mu.Lock()
+    current := nowPlaying
+    mu.Unlock()
+    if !strings.EqualFold(current.Title, info.Title) || !strings.EqualFold(current.Artist, info.Artist) {
+        return
+    }
+    logger.Printf("Skipping %q by %q", info.Title, info.Artist)
+    if err := sendKeys("n"); err != nil {
+        logger.Printf("Failed to skip song: %v", err)
+    }
+}
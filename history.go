@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "time"
+)
+
+// historyRecord is the exported form of a song database row
+type historyRecord struct {
+    ID         int64      `json:"id"`
+    DetectedAt time.Time  `json:"detected_at"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+    Station    string     `json:"station"`
+    Title      string     `json:"title"`
+    Artist     string     `json:"artist"`
+    Album      string     `json:"album"`
+    Loved      bool       `json:"loved"`
+    Outcome    string     `json:"outcome"`
+    File       string     `json:"file,omitempty"`
+    AcoustID   string     `json:"acoustid,omitempty"`
+}
+
+func newHistoryRecord(s songRecord) historyRecord {
+    r := historyRecord{
+        ID:         s.ID,
+        DetectedAt: s.DetectedAt.Local(),
+        Station:    s.Station,
+        Title:      s.Title,
+        Artist:     s.Artist,
+        Album:      s.Album,
+        Loved:      s.Loved,
+        Outcome:    s.Outcome,
+        File:       s.File,
+        AcoustID:   s.AcoustID,
+    }
+    if s.FinishedAt.Valid {
+        finished := s.FinishedAt.Time.Local()
+        r.FinishedAt = &finished
+    }
+    return r
+}
+
+// runHistory implements "pianotrap history export"
+func runHistory(cfg Config, args []string) error {
+    const usage = "usage: pianotrap history export [-format json|csv] [-since <date>] [-o <file>]"
+    if len(args) > 0 && (args[0] == "-h" || args[0] == "-help") {
+        fmt.Fprintln(os.Stderr, usage)
+        return flag.ErrHelp
+    }
+    if len(args) == 0 || args[0] != "export" {
+        return fmt.Errorf(usage)
+    }
+    fs := flag.NewFlagSet("history export", flag.ContinueOnError)
+    format := fs.String("format", "json", "output format: json or csv")
+    since := fs.String("since", "", "only songs detected after this date or age (e.g. 2024-05-01, 7d, \"last week\")")
+    output := fs.String("o", "", "write to this file instead of standard output")
+    if err := fs.Parse(args[1:]); err != nil {
+        return err
+    }
+    if *format != "json" && *format != "csv" {
+        return fmt.Errorf("invalid format %q (want json or csv)", *format)
+    }
+    sinceTime, err := parseSince(*since)
+    if err != nil {
+        return err
+    }
+
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+    songs, err := querySongs(songFilter{Outcome: "all", Since: sinceTime})
+    if err != nil {
+        return err
+    }
+
+    var w io.Writer = os.Stdout
+    if *output != "" {
+        f, err := os.Create(*output)
+        if err != nil {
+            return fmt.Errorf("failed to create %s: %v", *output, err)
+        }
+        defer f.Close()
+        w = f
+    }
+    if *format == "csv" {
+        return exportHistoryCSV(w, songs)
+    }
+    return exportHistoryJSON(w, songs)
+}
+
+func exportHistoryJSON(w io.Writer, songs []songRecord) error {
+    records := make([]historyRecord, 0, len(songs))
+    for _, s := range songs {
+        records = append(records, newHistoryRecord(s))
+    }
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(records)
+}
+
+func exportHistoryCSV(w io.Writer, songs []songRecord) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"id", "detected_at", "finished_at", "station", "title", "artist", "album", "loved", "outcome", "file", "acoustid"})
+    for _, s := range songs {
+        r := newHistoryRecord(s)
+        finished := ""
+        if r.FinishedAt != nil {
+            finished = r.FinishedAt.Format(time.RFC3339)
+        }
+        cw.Write([]string{strconv.FormatInt(r.ID, 10), r.DetectedAt.Format(time.RFC3339), finished, r.Station, r.Title,
+            r.Artist, r.Album, strconv.FormatBool(r.Loved), r.Outcome, r.File, r.AcoustID})
+    }
+    cw.Flush()
+    return cw.Error()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Importing adds music the user already owns to the song database, so new-music-only
+// mode and dedupe treat it like past recordings. Imported files are never pruned or
+// removed as duplicates.
+
+// importSong adds an existing music file to the database
+func importSong(path string, info os.FileInfo, tags Tags) error {
+    artist := ""
+    if len(tags.Artists) > 0 {
+        artist = tags.Artists[0]
+    }
+    _, err := db.Exec(`INSERT INTO songs (title, artist, album, station, loved, detected_at, finished_at, file, outcome, acoustid, fingerprint)
+        VALUES (?, ?, ?, '', ?, ?, ?, ?, ?, ?, ?)`,
+        tags.Title, artist, tags.Album, tags.Loved, info.ModTime().UTC(), info.ModTime().UTC(), path, outcomeImported,
+        tags.Custom["ACOUSTID_ID"], tags.Custom["ACOUSTID_FINGERPRINT"])
+    return err
+}
+
+// songImported reports whether path is already in the database
+func songImported(path string) bool {
+    var n int
+    db.QueryRow("SELECT COUNT(*) FROM songs WHERE file = ?", path).Scan(&n)
+    return n > 0
+}
+
+// runImport implements "pianotrap library import"
+func runImport(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("library import", flag.ContinueOnError)
+    useFingerprints := fs.Bool("fingerprint", false, "fingerprint files without an AcoustID (needs fpcalc, slower)")
+    dryRun := fs.Bool("n", false, "only show what would be imported")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if fs.NArg() != 1 {
+        return fmt.Errorf("usage: pianotrap library import [options] <dir>")
+    }
+    dir, err := filepath.Abs(fs.Arg(0))
+    if err != nil {
+        return err
+    }
+    acoustIDKey = cfg.AcoustIDKey
+
+    imported, skipped := 0, 0
+    err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+            return filepath.SkipDir
+        }
+        if err != nil || info.IsDir() || !dedupeAudioExts[strings.ToLower(filepath.Ext(path))] {
+            return nil
+        }
+        if songImported(path) {
+            return nil
+        }
+        tags, err := readTags(path)
+        if err != nil || tags.Title == "" || len(tags.Artists) == 0 {
+            // Untagged pianotrap recordings still have their tags in the name
+            named, ok := parseRecordingName(path)
+            if !ok || len(named.Artists) == 0 {
+                fmt.Printf("Skipping %s: no title or artist\n", path)
+                skipped++
+                return nil
+            }
+            tags.Title, tags.Artists = named.Title, named.Artists
+        }
+        if tags.Custom == nil {
+            tags.Custom = make(map[string]string)
+        }
+        if *useFingerprints && tags.Custom["ACOUSTID_ID"] == "" {
+            if fp, err := fingerprintFile(path); err != nil {
+                logger.Printf("Failed to fingerprint %s: %v", path, err)
+            } else {
+                tags.Custom["ACOUSTID_FINGERPRINT"] = fp.Fingerprint
+                if acoustIDKey != "" {
+                    if err := lookupAcoustID(&fp); err != nil {
+                        logger.Printf("%v", err)
+                    } else if fp.AcoustID != "" {
+                        tags.Custom["ACOUSTID_ID"] = fp.AcoustID
+                    }
+                }
+            }
+        }
+        fmt.Printf("%s - %s (%s)\n", tags.Artists[0], tags.Title, path)
+        if !*dryRun {
+            if err := importSong(path, info, tags); err != nil {
+                return fmt.Errorf("failed to add %s to database: %v", path, err)
+            }
+        }
+        imported++
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("failed to scan %s: %v", dir, err)
+    }
+    fmt.Printf("\n%d songs imported, %d skipped\n", imported, skipped)
+    return nil
+}
+
+// importedFiles returns the imported music outside dir as dedupe candidates
+func importedFiles(dir string) []dedupeFile {
+    if db == nil {
+        return nil
+    }
+    rows, err := db.Query("SELECT file, title, artist, album, acoustid FROM songs WHERE outcome = ?", outcomeImported)
+    if err != nil {
+        logger.Printf("Failed to query database: %v", err)
+        return nil
+    }
+    defer rows.Close()
+    var files []dedupeFile
+    for rows.Next() {
+        var path, acoustID string
+        tags := Tags{Custom: make(map[string]string)}
+        var artist string
+        if rows.Scan(&path, &tags.Title, &artist, &tags.Album, &acoustID) != nil {
+            continue
+        }
+        if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+            continue // found by the scan already
+        }
+        info, err := os.Stat(path)
+        if err != nil {
+            continue
+        }
+        tags.Artists = []string{artist}
+        if acoustID != "" {
+            tags.Custom["ACOUSTID_ID"] = acoustID
+        }
+        files = append(files, dedupeFile{Path: path, Size: info.Size(), Tags: tags, Owned: true})
+    }
+    return files
+}
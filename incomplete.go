@@ -0,0 +1,81 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// A recording stopped with more than incomplete_seconds or incomplete_percent of
+// the song left is incomplete. Those are deleted by default; on_incomplete can
+// keep them instead, optionally marked as partial.
+
+const (
+    incompleteDelete     = "delete"
+    incompleteKeep       = "keep"
+    incompleteKeepTagged = "keep-tagged"
+)
+
+var (
+    // onIncomplete is what happens to recordings that were cut short
+    onIncomplete = incompleteDelete
+
+    // percentThreshold is the share of the song (0-100) that may be missing
+    // before a recording counts as incomplete; 0 disables the check
+    percentThreshold float64
+)
+
+// loadIncompleteConfig reads on_incomplete, incomplete_seconds and incomplete_percent
+func loadIncompleteConfig(values map[string]string, cfg *Config) error {
+    seconds, err := configInt(values, "incomplete_seconds", 10)
+    if err != nil || seconds < 0 {
+        return fmt.Errorf("invalid value for incomplete_seconds: %q", values["incomplete_seconds"])
+    }
+    cfg.IncompleteAfter = time.Duration(seconds) * time.Second
+    if raw := values["incomplete_percent"]; raw != "" {
+        pct, err := strconv.ParseFloat(raw, 64)
+        if err != nil || pct < 0 || pct > 100 {
+            return fmt.Errorf("invalid value for incomplete_percent: %q", raw)
+        }
+        cfg.IncompletePercent = pct
+    }
+    cfg.OnIncomplete = incompleteDelete
+    switch raw := values["on_incomplete"]; raw {
+    case "":
+    case incompleteDelete, incompleteKeep, incompleteKeepTagged:
+        cfg.OnIncomplete = raw
+    default:
+        return fmt.Errorf("invalid value for on_incomplete: %q (use delete, keep or keep-tagged)", raw)
+    }
+    return nil
+}
+
+// songIncomplete reports whether stopping the recording now would cut off too much of
+// the song. The caller holds mu.
+func songIncomplete() bool {
+    if timeThreshold > 0 && remainingTime > timeThreshold {
+        return true
+    }
+    return percentThreshold > 0 && totalDuration > 0 &&
+        float64(remainingTime)*100 > percentThreshold*float64(totalDuration)
+}
+
+// markIncomplete renames a kept partial recording to "... (partial).mp3", or what
+// the collision policy makes of that name, and notes it in the comment tag
+func markIncomplete(fileName, policy string, tags *Tags) string {
+    tags.Comment = strings.TrimSpace("Incomplete recording. " + tags.Comment)
+    ext := filepath.Ext(fileName)
+    newName, ok := resolveCollision(policy, strings.TrimSuffix(fileName, ext)+" (partial)"+ext)
+    if !ok {
+        logger.Printf("Keeping %s under its name, %s exists", fileName, newName)
+        return fileName
+    }
+    if err := renameFile(fileName, newName); err != nil {
+        logger.Printf("Failed to rename %s: %v", fileName, err)
+        return fileName
+    }
+    renameSong(fileName, newName, *tags)
+    return newName
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestSongIncomplete(t *testing.T) {
+    defer func(seconds time.Duration, pct float64) {
+        timeThreshold, percentThreshold = seconds, pct
+        remainingTime, totalDuration = 0, 0
+    }(timeThreshold, percentThreshold)
+
+    tests := []struct {
+        seconds   time.Duration
+        pct       float64
+        remaining time.Duration
+        want      bool
+    }{
+        {10 * time.Second, 0, 5 * time.Second, false},
+        {10 * time.Second, 0, 20 * time.Second, true},
+        {0, 10, 20 * time.Second, false},
+        {0, 10, 30 * time.Second, true},
+        {60 * time.Second, 5, 20 * time.Second, true},
+        {0, 0, 200 * time.Second, false},
+    }
+    totalDuration = 200 * time.Second
+    for _, tt := range tests {
+        timeThreshold, percentThreshold, remainingTime = tt.seconds, tt.pct, tt.remaining
+        if got := songIncomplete(); got != tt.want {
+            t.Errorf("songIncomplete(%v, %v%%, %v left) = %v, want %v", tt.seconds, tt.pct, tt.remaining, got, tt.want)
+        }
+    }
+}
+
+func TestMarkIncomplete(t *testing.T) {
+    openTestDB(t)
+    dir := t.TempDir()
+    partial := filepath.Join(dir, "Song (partial).mp3")
+    for _, tt := range []struct {
+        policy, want string
+    }{
+        {collisionNumber, "Song (partial) (2).mp3"},
+        {collisionSkip, "Song.mp3"},
+        {collisionOverwrite, "Song (partial).mp3"},
+    } {
+        fileName := filepath.Join(dir, "Song.mp3")
+        for path, data := range map[string]string{partial: "earlier", fileName: "this one"} {
+            if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+                t.Fatal(err)
+            }
+        }
+        var tags Tags
+        got := markIncomplete(fileName, tt.policy, &tags)
+        if got != filepath.Join(dir, tt.want) || tags.Comment != "Incomplete recording." {
+            t.Errorf("%s: markIncomplete = %s, %q", tt.policy, got, tags.Comment)
+        }
+        if data, _ := ioutil.ReadFile(got); string(data) != "this one" {
+            t.Errorf("%s: %s holds %q", tt.policy, got, data)
+        }
+        if data, _ := ioutil.ReadFile(partial); tt.policy != collisionOverwrite && string(data) != "earlier" {
+            t.Errorf("%s: the earlier partial recording was overwritten", tt.policy)
+        }
+        os.Remove(filepath.Join(dir, "Song (partial) (2).mp3"))
+    }
+}
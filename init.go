@@ -0,0 +1,133 @@
+package main
+
+import (
+    _ "embed"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "golang.org/x/term"
+)
+
+// pianotrap is a single binary: the default config is embedded, pianobar is started
+// directly and nothing is looked up relative to the working directory.
+// "pianotrap init" writes the files it and pianobar need on a new machine.
+
+//go:embed config.example
+var defaultConfig string
+
+// defaultConfigFile is the commented default config saving to saveDir
+func defaultConfigFile(saveDir string) string {
+    return strings.Replace(defaultConfig, "{savedir}", formatValue(saveDir), 1)
+}
+
+// pianobarConfigFile is where pianobar reads its config
+func pianobarConfigFile() (string, error) {
+    configHome := os.Getenv("XDG_CONFIG_HOME")
+    if configHome == "" {
+        homeDir, err := os.UserHomeDir()
+        if err != nil {
+            return "", err
+        }
+        configHome = filepath.Join(homeDir, ".config")
+    }
+    return filepath.Join(configHome, "pianobar", "config"), nil
+}
+
+// pianobarConfig is a minimal pianobar config for a Pandora account
+func pianobarConfig(user, password string) string {
+    var b strings.Builder
+    b.WriteString("# Written by pianotrap init\n")
+    if user == "" {
+        b.WriteString("# user = you@example.com\n# password = secret\n")
+    } else {
+        fmt.Fprintf(&b, "user = %s\npassword = %s\n", user, password)
+    }
+    b.WriteString("audio_quality = high\n")
+    return b.String()
+}
+
+// writeNewFile writes data to path unless it exists and force is off, and reports
+// what it did
+func writeNewFile(path, data string, perm os.FileMode, force bool) error {
+    if _, err := os.Stat(path); err == nil && !force {
+        fmt.Printf("Keeping %s (-force overwrites it)\n", path)
+        return nil
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    if err := ioutil.WriteFile(path, []byte(data), perm); err != nil {
+        return fmt.Errorf("failed to write %s: %v", path, err)
+    }
+    // A file overwritten with -force keeps its mode otherwise
+    if err := os.Chmod(path, perm); err != nil {
+        return fmt.Errorf("failed to write %s: %v", path, err)
+    }
+    fmt.Printf("Wrote %s\n", path)
+    return nil
+}
+
+// runInit implements "pianotrap init"
+func runInit(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("init", flag.ContinueOnError)
+    force := fs.Bool("force", false, "overwrite existing config files")
+    saveDir := fs.String("savedir", cfg.SaveDir, "directory to save recorded songs")
+    user := fs.String("user", "", "Pandora account for pianobar's config (asked for if not given)")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+
+    // The first run writes the default config; -force starts over from it. Only the
+    // user may read it, as passwords and tokens go there.
+    if err := writeNewFile(cfg.ConfigFile, defaultConfigFile(*saveDir), 0600, *force); err != nil {
+        return err
+    }
+    if err := os.MkdirAll(*saveDir, 0755); err != nil {
+        return fmt.Errorf("failed to create save directory: %v", err)
+    }
+    fmt.Printf("Recordings go to %s\n", *saveDir)
+
+    pianobarFile, err := pianobarConfigFile()
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(pianobarFile); err != nil || *force {
+        password := ""
+        interactive := term.IsTerminal(int(os.Stdin.Fd()))
+        if *user == "" && interactive {
+            fmt.Print("Pandora email (empty to fill in later): ")
+            fmt.Scanln(user)
+        }
+        if *user != "" && interactive {
+            fmt.Print("Pandora password: ")
+            data, err := term.ReadPassword(int(os.Stdin.Fd()))
+            fmt.Println()
+            if err != nil {
+                return fmt.Errorf("failed to read password: %v", err)
+            }
+            password = string(data)
+        }
+        // It may hold the password
+        if err := writeNewFile(pianobarFile, pianobarConfig(*user, password), 0600, true); err != nil {
+            return err
+        }
+        if *user == "" {
+            fmt.Printf("Add your Pandora user and password to %s\n", pianobarFile)
+        }
+    } else {
+        fmt.Printf("Keeping %s (-force overwrites it)\n", pianobarFile)
+    }
+
+    for _, tool := range []string{cfg.PianobarCommand, ffmpegBinary(), "pactl"} {
+        if _, err := exec.LookPath(tool); err != nil {
+            fmt.Printf("Warning: %s not found; pianotrap needs it to record\n", tool)
+        }
+    }
+    fmt.Println("Run pianotrap doctor to check the rest of the setup")
+    return nil
+}
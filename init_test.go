@@ -0,0 +1,42 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestDefaultConfig(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "config")
+    if err := ioutil.WriteFile(file, []byte(defaultConfigFile("/srv/music")), 0644); err != nil {
+        t.Fatal(err)
+    }
+    values, err := readConfigValues(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Everything but the save directory is commented out, so defaults apply
+    if want := map[string]string{"savedir": "/srv/music"}; !reflect.DeepEqual(values, want) {
+        t.Errorf("default config sets %v, want %v", values, want)
+    }
+
+    // Passwords and tokens go into the config, so -force locks a readable one down
+    if err := writeNewFile(file, defaultConfigFile("/srv/music"), 0600, true); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(file); err != nil {
+        t.Error(err)
+    } else if info.Mode().Perm() != 0600 {
+        t.Errorf("config rewritten with mode %v", info.Mode())
+    }
+
+    if got := pianobarConfig("", ""); strings.Contains(got, "\nuser =") {
+        t.Errorf("pianobar config without an account sets a user:\n%s", got)
+    }
+    if got := pianobarConfig("me@example.com", "pw"); !strings.Contains(got, "user = me@example.com\npassword = pw\n") {
+        t.Errorf("pianobar config lacks the account:\n%s", got)
+    }
+}
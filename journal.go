@@ -0,0 +1,283 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// The action journal is a write-ahead log of the file operations pianotrap performs.
+// Each action is recorded before it happens and marked done afterwards, so actions
+// interrupted by a crash are found and settled at the next start. It also makes the
+// most recent destructive action undoable: discarded recordings are moved to a trash
+// directory instead of being deleted, and renames remember the original name.
+
+const (
+    actionCreate  = "create"
+    actionDiscard = "discard"
+    actionRename  = "rename"
+    actionDelete  = "delete"
+)
+
+// undoKey (Ctrl+Z) undoes the most recent action while pianotrap runs
+const undoKey = 0x1a
+
+// trashKeep is how long discarded recordings stay restorable
+const trashKeep = 7 * 24 * time.Hour
+
+// trashRoot is where discarded recordings are kept; it lives in the save directory
+// so moving files there is a cheap rename. Empty means files are deleted outright.
+var trashRoot string
+
+// beginAction records an action that is about to happen and returns its id
+func beginAction(kind, path, target string) int64 {
+    if db == nil {
+        return 0
+    }
+    res, err := db.Exec("INSERT INTO actions (at, kind, path, target, done) VALUES (?, ?, ?, ?, 0)", time.Now().UTC(), kind, path, target)
+    if err != nil {
+        logger.Printf("Failed to journal %s of %s: %v", kind, path, err)
+        return 0
+    }
+    id, _ := res.LastInsertId()
+    return id
+}
+
+// finishAction marks an action as completed, or as abandoned when it failed
+func finishAction(id int64, err error) {
+    if db == nil || id == 0 {
+        return
+    }
+    query := "UPDATE actions SET done = 1 WHERE id = ?"
+    if err != nil {
+        query = "UPDATE actions SET done = 1, undone = 1 WHERE id = ?"
+    }
+    if _, err := db.Exec(query, id); err != nil {
+        logger.Printf("Failed to update the action journal: %v", err)
+    }
+}
+
+// finishCreate marks the recording of path as no longer in progress
+func finishCreate(path string) {
+    if db == nil {
+        return
+    }
+    if _, err := db.Exec("UPDATE actions SET done = 1 WHERE kind = ? AND path = ? AND NOT done", actionCreate, path); err != nil {
+        logger.Printf("Failed to update the action journal: %v", err)
+    }
+}
+
+// deleteFile removes a file for good, e.g. when pruning
+func deleteFile(path string) error {
+    id := beginAction(actionDelete, path, "")
+    err := os.Remove(path)
+    finishAction(id, err)
+    return err
+}
+
+// trashSettings returns trashRoot and xdgTrash, which a reload may change
+func trashSettings() (string, bool) {
+    mu.Lock()
+    defer mu.Unlock()
+    return trashRoot, xdgTrash
+}
+
+// discardFile moves an unwanted recording to the trash, or deletes it when there
+// is no trash or journal to restore it from
+func discardFile(path string) error {
+    root, xdg := trashSettings()
+    return discardTo(path, root, xdg)
+}
+
+// discardTo is discardFile with the trash settings given, for callers holding mu
+func discardTo(path, trashRoot string, xdgTrash bool) error {
+    finishCreate(path)
+    if xdgTrash {
+        err := discardToXDGTrash(path)
+        if err == nil || os.IsNotExist(err) {
+            return err
+        }
+        logger.Printf("Failed to move %s to the trash: %v", path, err)
+    }
+    if trashRoot == "" || db == nil || os.MkdirAll(trashRoot, 0755) != nil {
+        return deleteFile(path)
+    }
+    target := filepath.Join(trashRoot, fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(path)))
+    id := beginAction(actionDiscard, path, target)
+    if err := os.Rename(path, target); err != nil {
+        finishAction(id, err)
+        return deleteFile(path)
+    }
+    finishAction(id, nil)
+    return nil
+}
+
+// renameFile renames a recording and journals the old name
+func renameFile(oldPath, newPath string) error {
+    if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+        return err
+    }
+    id := beginAction(actionRename, oldPath, newPath)
+    err := os.Rename(oldPath, newPath)
+    finishAction(id, err)
+    if err == nil {
+        movedRecording(oldPath, newPath)
+    }
+    return err
+}
+
+// undoLastAction reverses the most recent action that hasn't been undone yet
+func undoLastAction() (string, error) {
+    if db == nil {
+        return "", fmt.Errorf("the action journal needs the song database")
+    }
+    var id int64
+    var kind, path, target string
+    err := db.QueryRow("SELECT id, kind, path, target FROM actions WHERE done AND NOT undone AND kind IN (?, ?) ORDER BY id DESC LIMIT 1",
+        actionDiscard, actionRename).Scan(&id, &kind, &path, &target)
+    if err == sql.ErrNoRows {
+        return "", fmt.Errorf("nothing to undo")
+    } else if err != nil {
+        return "", fmt.Errorf("failed to read the action journal: %v", err)
+    }
+    if _, err := os.Stat(path); err == nil {
+        return "", fmt.Errorf("can't undo %s of %s: the file exists again", kind, path)
+    }
+
+    var done string
+    switch kind {
+    case actionDiscard:
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            return "", fmt.Errorf("failed to restore %s: %v", path, err)
+        }
+        info := trashInfoFile(target)
+        if err := os.Rename(target, path); err != nil {
+            return "", fmt.Errorf("failed to restore %s: %v", path, err)
+        }
+        if info != "" {
+            os.Remove(info)
+        }
+        setSongOutcome(path, outcomeSaved)
+        done = "Restored " + path
+    case actionRename:
+        if err := os.Rename(target, path); err != nil {
+            return "", fmt.Errorf("failed to rename %s back: %v", target, err)
+        }
+        if _, err := db.Exec("UPDATE songs SET file = ? WHERE file = ?", path, target); err != nil {
+            logger.Printf("Failed to update %s in database: %v", target, err)
+        }
+        done = fmt.Sprintf("Renamed %s back to %s", filepath.Base(target), filepath.Base(path))
+    default:
+        return "", fmt.Errorf("can't undo %s actions", kind)
+    }
+    if _, err := db.Exec("UPDATE actions SET undone = 1 WHERE id = ?", id); err != nil {
+        logger.Printf("Failed to update the action journal: %v", err)
+    }
+    return done, nil
+}
+
+// recoverJournal settles the actions a previous run started but never finished,
+// checking the file system to tell whether each one happened
+func recoverJournal() {
+    if db == nil {
+        return
+    }
+    rows, err := db.Query("SELECT id, kind, path, target FROM actions WHERE NOT done ORDER BY id")
+    if err != nil {
+        logger.Printf("Failed to read the action journal: %v", err)
+        return
+    }
+    type action struct {
+        id                 int64
+        kind, path, target string
+    }
+    var pending []action
+    for rows.Next() {
+        var a action
+        if rows.Scan(&a.id, &a.kind, &a.path, &a.target) == nil {
+            pending = append(pending, a)
+        }
+    }
+    rows.Close()
+
+    exists := func(path string) bool {
+        _, err := os.Stat(path)
+        return err == nil
+    }
+    for _, a := range pending {
+        happened := false
+        switch a.kind {
+        case actionCreate:
+            // A recording cut off by the crash is incomplete
+            if exists(a.path) {
+                fmt.Printf("\r\nDiscarding recording interrupted by a crash: %s\n", a.path)
+                finishAction(a.id, nil)
+                if err := discardFile(a.path); err != nil {
+                    logger.Printf("Failed to remove %s: %v", a.path, err)
+                }
+                continue
+            }
+        case actionRename, actionDiscard:
+            happened = !exists(a.path) && exists(a.target)
+            if happened && a.kind == actionRename {
+                db.Exec("UPDATE songs SET file = ? WHERE file = ?", a.target, a.path)
+            }
+        case actionDelete:
+            happened = !exists(a.path)
+        }
+        logger.Printf("Journal: %s of %s was interrupted, completed: %v", a.kind, a.path, happened)
+        if happened {
+            finishAction(a.id, nil)
+        } else {
+            finishAction(a.id, fmt.Errorf("interrupted"))
+        }
+    }
+}
+
+// emptyTrash deletes discarded recordings that are no longer restorable
+func emptyTrash() {
+    trashRoot, _ := trashSettings()
+    if trashRoot == "" || db == nil {
+        return
+    }
+    rows, err := db.Query("SELECT id, target FROM actions WHERE kind = ? AND NOT undone AND at < ?", actionDiscard, time.Now().Add(-trashKeep).UTC())
+    if err != nil {
+        logger.Printf("Failed to read the action journal: %v", err)
+        return
+    }
+    var expired []int64
+    for rows.Next() {
+        var id int64
+        var target string
+        if rows.Scan(&id, &target) == nil && strings.HasPrefix(target, trashRoot) {
+            os.Remove(target)
+            expired = append(expired, id)
+        }
+    }
+    rows.Close()
+    for _, id := range expired {
+        db.Exec("UPDATE actions SET undone = 1 WHERE id = ?", id)
+    }
+}
+
+// runUndo implements "pianotrap undo"
+func runUndo(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+    done, err := undoLastAction()
+    if err != nil {
+        return err
+    }
+    fmt.Println(done)
+    return nil
+}
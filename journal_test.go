@@ -0,0 +1,89 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestUndoLastAction(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+    trashRoot = filepath.Join(dir, ".trash")
+    defer func() {
+        trashRoot = ""
+    }()
+
+    partial := filepath.Join(dir, "partial.mp3")
+    kept := filepath.Join(dir, "kept.mp3")
+    renamed := filepath.Join(dir, "renamed.mp3")
+    for _, path := range []string{partial, kept} {
+        if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := discardFile(partial); err != nil {
+        t.Fatal(err)
+    }
+    if err := renameFile(kept, renamed); err != nil {
+        t.Fatal(err)
+    }
+
+    // Undo runs newest first
+    if _, err := undoLastAction(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(kept); err != nil {
+        t.Errorf("rename not undone: %v", err)
+    }
+    if _, err := undoLastAction(); err != nil {
+        t.Fatal(err)
+    }
+    if data, err := ioutil.ReadFile(partial); err != nil || string(data) != partial {
+        t.Errorf("discarded file not restored: %v", err)
+    }
+    if _, err := undoLastAction(); err == nil {
+        t.Error("undo with an empty journal succeeded")
+    }
+}
+
+func TestRecoverJournal(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+    trashRoot = filepath.Join(dir, ".trash")
+    defer func() {
+        trashRoot = ""
+    }()
+
+    path := func(name string) string { return filepath.Join(dir, name) }
+    for _, name := range []string{"renamed.mp3", "kept.mp3", "partial.mp3"} {
+        if err := ioutil.WriteFile(path(name), nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // A rename that happened, a delete that didn't and a recording cut off
+    renamed := beginAction(actionRename, path("old.mp3"), path("renamed.mp3"))
+    deleted := beginAction(actionDelete, path("kept.mp3"), "")
+    beginAction(actionCreate, path("partial.mp3"), "")
+
+    recoverJournal()
+
+    var pending int
+    db.QueryRow("SELECT COUNT(*) FROM actions WHERE NOT done").Scan(&pending)
+    if pending != 0 {
+        t.Errorf("%d actions still pending", pending)
+    }
+    var undone bool
+    db.QueryRow("SELECT undone FROM actions WHERE id = ?", renamed).Scan(&undone)
+    if undone {
+        t.Error("completed rename marked as abandoned")
+    }
+    db.QueryRow("SELECT undone FROM actions WHERE id = ?", deleted).Scan(&undone)
+    if !undone {
+        t.Error("interrupted delete not marked as abandoned")
+    }
+    if _, err := os.Stat(path("partial.mp3")); err == nil {
+        t.Error("interrupted recording was kept")
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// pianotrap's own keys are Ctrl chords pianobar doesn't use; they are handled by
+// pianotrap and never forwarded. Those that users are likely to want elsewhere can
+// be changed in the config file, e.g. record_key = ctrl+t.
+
+// The keys are guarded by mu, as reloads change them
+var (
+    // recordKey (Ctrl+R by default) pauses and resumes recording
+    recordKey byte = 0x12
+    // discardKey (Ctrl+D by default) throws away the recording in progress
+    discardKey byte = 0x04
+    // keepKey (Ctrl+K by default) protects the recording in progress
+    keepKey byte = 0x0b
+
+    // capturePaused stops new recordings while pianobar keeps playing.
+    // discardRequested makes stopRecording discard the current one whatever
+    // on_incomplete says, and keepRequested makes it keep the current one even
+    // if it's incomplete. Guarded by mu.
+    capturePaused    bool
+    discardRequested bool
+    keepRequested    bool
+)
+
+// parseKey turns "ctrl+<letter>" into the byte the terminal sends for it
+func parseKey(name string) (byte, error) {
+    name = strings.ToLower(strings.TrimSpace(name))
+    letter := strings.TrimPrefix(name, "ctrl+")
+    if letter == name || len(letter) != 1 || letter[0] < 'a' || letter[0] > 'z' {
+        return 0, fmt.Errorf("%q is not a key like ctrl+r", name)
+    }
+    key := letter[0] - 'a' + 1
+    switch key {
+    case 0x03, 0x09, 0x0a, 0x0d, editTagsKey, sourceSwitchKey, undoKey:
+        // Ctrl+C, Tab, Enter and pianotrap's fixed keys
+        return 0, fmt.Errorf("%s is already taken", name)
+    }
+    return key, nil
+}
+
+// sessionKeys returns recordKey, discardKey and keepKey
+func sessionKeys() (record, discard, keep byte) {
+    mu.Lock()
+    defer mu.Unlock()
+    return recordKey, discardKey, keepKey
+}
+
+// keyName is how a Ctrl chord is shown to the user
+func keyName(key byte) string {
+    return fmt.Sprintf("Ctrl+%c", 'A'+key-1)
+}
+
+// loadKeysConfig reads record_key, discard_key and keep_key
+func loadKeysConfig(values map[string]string, cfg *Config) error {
+    cfg.RecordKey, cfg.DiscardKey, cfg.KeepKey = recordKey, discardKey, keepKey
+    for option, key := range map[string]*byte{"record_key": &cfg.RecordKey, "discard_key": &cfg.DiscardKey, "keep_key": &cfg.KeepKey} {
+        if raw := values[option]; raw != "" {
+            k, err := parseKey(raw)
+            if err != nil {
+                return fmt.Errorf("invalid value for %s: %v", option, err)
+            }
+            *key = k
+        }
+    }
+    if cfg.RecordKey == cfg.DiscardKey || cfg.RecordKey == cfg.KeepKey || cfg.DiscardKey == cfg.KeepKey {
+        return fmt.Errorf("record_key, discard_key and keep_key must be different keys")
+    }
+    return nil
+}
+
+// toggleCapture pauses recording, ending the current one as if it was skipped,
+// or resumes it from the next song
+func toggleCapture() {
+    mu.Lock()
+    capturePaused = !capturePaused
+    paused := capturePaused
+    deleteFile := recording && totalDuration > 0 && songIncomplete()
+    mu.Unlock()
+    if !paused {
+        notice(msgInfo, "Recording resumed from the next song")
+        return
+    }
+    stopRecording(deleteFile)
+    record, _, _ := sessionKeys()
+    notice(msgInfo, "Recording paused, press %s to resume", keyName(record))
+}
+
+// captureIsPaused reports whether recording was paused with the record key
+func captureIsPaused() bool {
+    mu.Lock()
+    defer mu.Unlock()
+    return capturePaused
+}
+
+// discardCurrent stops the recording in progress and moves it to the trash, e.g.
+// for an ad; pianobar keeps playing
+func discardCurrent() {
+    mu.Lock()
+    active := recording
+    if active {
+        discardRequested, keepRequested = true, false
+    }
+    mu.Unlock()
+    if !active {
+        notice(msgInfo, "Not recording, nothing to discard")
+        return
+    }
+    stopRecording(true)
+}
+
+// keepCurrent protects the recording in progress from being deleted as incomplete,
+// e.g. when skipping away near its end; pressing the key again lifts it
+func keepCurrent() {
+    mu.Lock()
+    active, fileName := recording, currentFileName
+    if active {
+        keepRequested = !keepRequested
+    }
+    keep := keepRequested
+    mu.Unlock()
+    switch {
+    case !active:
+        notice(msgInfo, "Not recording, nothing to keep")
+    case keep:
+        notice(msgInfo, "Keeping %s even if it's cut short", fileName)
+    default:
+        notice(msgInfo, "%s is no longer protected", fileName)
+    }
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+    if key, err := parseKey("Ctrl+T"); err != nil || key != 0x14 || keyName(key) != "Ctrl+T" {
+        t.Errorf("parseKey(Ctrl+T) = %#x, %v", key, err)
+    }
+    for _, bad := range []string{"f9", "ctrl+", "ctrl+1", "ctrl+c", "ctrl+e", "ctrl+z"} {
+        if _, err := parseKey(bad); err == nil {
+            t.Errorf("parseKey(%q) accepted", bad)
+        }
+    }
+    if err := loadKeysConfig(map[string]string{"discard_key": "ctrl+r"}, &Config{}); err == nil {
+        t.Error("same key for recording and discarding accepted")
+    }
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// path_template replaces the default <station>/<Title - Artist - Album (Year)>.mp3
+// layout with a text/template evaluated per song, relative to the save directory.
+// Slashes in the result create directories; slashes in the tags don't.
+//
+// A session starts with the program and, with session_gap, again after recording
+// nothing for that long. Session playlists and the Session and Index fields follow it.
+
+// pathFields are the values available to path_template
+type pathFields struct {
+    Title, Artist, Album, Year, Genre, Station string
+    Date    string // 2006-01-02, when the recording started
+    Time    string // 15-04
+    Index   string // number of the recording in this session, 01, 02, ...
+    Session string // 2006-01-02 15.04, when the session started
+    Ext     string // without the dot
+}
+
+// group_by values inserting a directory level below the station in the default layout
+const (
+    groupByDate    = "date"
+    groupBySession = "session"
+)
+
+var (
+    // pathTemplate lays out recordings when set; nil keeps the default layout
+    pathTemplate *template.Template
+    pathRoot     string
+    groupBy      string
+
+    // sessionStart is when the current session started and lastActivity when a
+    // recording last started or finished; both are guarded by recordingStartsMu
+    sessionGap   time.Duration
+    sessionStart time.Time
+    lastActivity time.Time
+
+    // recordingStarts remembers when and as which of the session each recording
+    // started, so renames keep its date and index
+    recordingStarts   = make(map[string]recordingStart)
+    recordingStartsMu sync.Mutex
+    sessionIndex      int
+)
+
+// recordingStart is when a recording started, its index in the session and when
+// that session started
+type recordingStart struct {
+    Index   int
+    Started time.Time
+    Session time.Time
+}
+
+// setLayout applies the path template and file name profile of cfg
+func setLayout(cfg Config) {
+    pathTemplate = cfg.PathTemplate
+    pathRoot = cfg.SaveDir
+    groupBy = cfg.GroupBy
+    sessionGap = cfg.SessionGap
+    if cfg.FileNames.Unsafe != nil {
+        nameProfile = cfg.FileNames
+    }
+}
+
+// loadPathTemplateConfig reads path_template, group_by and session_gap
+func loadPathTemplateConfig(values map[string]string, cfg *Config) error {
+    switch g := values["group_by"]; g {
+    case "", "none":
+    case groupByDate, groupBySession:
+        cfg.GroupBy = g
+    default:
+        return fmt.Errorf("invalid value for group_by: %q (expected date, session or none)", g)
+    }
+    if raw := values["session_gap"]; raw != "" {
+        d, err := time.ParseDuration(raw)
+        if err != nil || d < 0 {
+            return fmt.Errorf("invalid value for session_gap: %q", raw)
+        }
+        cfg.SessionGap = d
+    }
+
+    raw := values["path_template"]
+    if raw == "" {
+        return nil
+    }
+    t, err := template.New("path_template").Option("missingkey=error").Parse(raw)
+    if err != nil {
+        return fmt.Errorf("invalid path_template: %v", err)
+    }
+    // Catch unknown fields now rather than when the first song plays
+    if _, err := renderPath(t, pathFields{Title: "Title", Ext: "mp3"}); err != nil {
+        return fmt.Errorf("invalid path_template: %v", err)
+    }
+    cfg.PathTemplate = t
+    return nil
+}
+
+// newPathFields collects the template values for a recording
+func newPathFields(tags Tags, station string, started time.Time, session recordingStart, ext string) pathFields {
+    clean := func(s string) string { return strings.TrimSpace(strings.Replace(s, "/", "_", -1)) }
+    f := pathFields{
+        Title:   clean(tags.Title),
+        Album:   clean(tags.Album),
+        Year:    clean(tags.Year),
+        Genre:   clean(tags.Genre),
+        Station: clean(station),
+        Date:    started.Format("2006-01-02"),
+        Time:    started.Format("15-04"),
+        Index:   fmt.Sprintf("%02d", session.Index),
+        Session: session.Session.Format("2006-01-02 15.04"),
+        Ext:     strings.TrimPrefix(ext, "."),
+    }
+    if len(tags.Artists) > 0 {
+        f.Artist = clean(tags.Artists[0])
+    }
+    return f
+}
+
+// renderPath evaluates the template into a relative path, dropping empty directory
+// levels and adding the extension if the template leaves it out
+func renderPath(t *template.Template, f pathFields) (string, error) {
+    var b strings.Builder
+    if err := t.Execute(&b, f); err != nil {
+        return "", err
+    }
+    var segments []string
+    for _, s := range strings.Split(b.String(), "/") {
+        if s = strings.TrimSpace(s); s != "" {
+            segments = append(segments, sanitizeFileName(s))
+        }
+    }
+    if len(segments) == 0 {
+        return "", fmt.Errorf("path_template produced an empty path")
+    }
+    path := filepath.Join(segments...)
+    if !strings.HasSuffix(path, "."+f.Ext) {
+        path += "." + f.Ext
+    }
+    return path, nil
+}
+
+// startSession begins the first session
+func startSession(now time.Time) {
+    recordingStartsMu.Lock()
+    sessionStart, lastActivity, sessionIndex = now, now, 0
+    recordingStartsMu.Unlock()
+}
+
+// nextRecording is the session and index a recording starting at now gets: a new
+// session once nothing was recorded for session_gap. Caller holds recordingStartsMu.
+func nextRecording(now time.Time) recordingStart {
+    if sessionGap > 0 && !lastActivity.IsZero() && now.Sub(lastActivity) >= sessionGap {
+        return recordingStart{Index: 1, Started: now, Session: now}
+    }
+    return recordingStart{Index: sessionIndex + 1, Started: now, Session: sessionStart}
+}
+
+// currentSession is when the current session started
+func currentSession() time.Time {
+    recordingStartsMu.Lock()
+    defer recordingStartsMu.Unlock()
+    return sessionStart
+}
+
+// recordingPath is where a new recording of tags on station is saved
+func recordingPath(saveDir, station string, tags Tags, ext string) string {
+    recordingStartsMu.Lock()
+    next := nextRecording(time.Now())
+    recordingStartsMu.Unlock()
+    if pathTemplate != nil {
+        path, err := renderPath(pathTemplate, newPathFields(tags, station, next.Started, next, ext))
+        if err == nil {
+            return filepath.Join(saveDir, path)
+        }
+        logger.Printf("Falling back to the default layout: %v", err)
+    }
+    return filepath.Join(saveDir, station, groupDir(next), recordingName(tags, ext))
+}
+
+// groupDir is the group_by directory of a recording, or empty
+func groupDir(start recordingStart) string {
+    switch groupBy {
+    case groupByDate:
+        return start.Started.Format("2006-01-02")
+    case groupBySession:
+        return start.Session.Format("2006-01-02 15.04")
+    }
+    return ""
+}
+
+// stationOf guesses the station of a recording in the default layout from its directory
+func stationOf(fileName string) string {
+    dir := filepath.Dir(fileName)
+    if groupBy != "" {
+        dir = filepath.Dir(dir)
+    }
+    return filepath.Base(dir)
+}
+
+// startedRecording assigns fileName the next index, starting a new session if
+// the previous one went idle
+func startedRecording(fileName string) {
+    recordingStartsMu.Lock()
+    next := nextRecording(time.Now())
+    if !next.Session.Equal(sessionStart) {
+        logger.Printf("Starting a new session after %v without recording", next.Started.Sub(lastActivity).Round(time.Second))
+    }
+    sessionStart, sessionIndex, lastActivity = next.Session, next.Index, next.Started
+    recordingStarts[fileName] = next
+    recordingStartsMu.Unlock()
+}
+
+// finishedRecording notes that a recording ended, for measuring session_gap
+func finishedRecording() {
+    recordingStartsMu.Lock()
+    lastActivity = time.Now()
+    recordingStartsMu.Unlock()
+}
+
+// movedRecording carries the start of a recording over to its new name
+func movedRecording(oldPath, newPath string) {
+    recordingStartsMu.Lock()
+    if start, ok := recordingStarts[oldPath]; ok {
+        delete(recordingStarts, oldPath)
+        recordingStarts[newPath] = start
+    }
+    recordingStartsMu.Unlock()
+}
+
+// renamedPath is the name an existing recording gets after its tags changed. With
+// a template the station comes from its tags, and the date from the file unless the
+// recording was made in this session.
+func renamedPath(fileName string, tags Tags) string {
+    ext := filepath.Ext(fileName)
+    if pathTemplate == nil || pathRoot == "" {
+        return filepath.Join(filepath.Dir(fileName), recordingName(tags, ext))
+    }
+    station := tags.Custom["STATION"]
+    if station == "" {
+        station = stationOf(fileName)
+    }
+    recordingStartsMu.Lock()
+    start, ok := recordingStarts[fileName]
+    recordingStartsMu.Unlock()
+    if !ok {
+        start.Started = time.Now()
+        if info, err := os.Stat(fileName); err == nil {
+            start.Started = info.ModTime()
+        }
+    }
+    if start.Session.IsZero() {
+        start.Session = start.Started
+    }
+    path, err := renderPath(pathTemplate, newPathFields(tags, station, start.Started, start, ext))
+    if err != nil {
+        logger.Printf("Not renaming %s: %v", fileName, err)
+        return fileName
+    }
+    return filepath.Join(pathRoot, path)
+}
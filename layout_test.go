@@ -0,0 +1,68 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+    "text/template"
+    "time"
+)
+
+func TestRenderPath(t *testing.T) {
+    started := time.Date(2024, 5, 6, 21, 30, 0, 0, time.Local)
+    tags := Tags{Title: "AC/DC Tribute", Artists: []string{"Band"}, Album: "", Year: "2024"}
+    f := newPathFields(tags, "Rock Radio", started, recordingStart{Index: 3, Session: started.Add(-time.Hour)}, ".mp3")
+    tests := []struct {
+        template, want string
+    }{
+        {"{{.Artist}}/{{.Album}}/{{.Title}}.{{.Ext}}", "Band/AC_DC Tribute.mp3"},
+        {"{{.Station}}/{{.Date}}/{{.Index}} - {{.Title}}", "Rock Radio/2024-05-06/03 - AC_DC Tribute.mp3"},
+        {"../{{.Title}}", "__/AC_DC Tribute.mp3"},
+        {"{{.Session}}/{{.Title}}", "2024-05-06 20.30/AC_DC Tribute.mp3"},
+    }
+    for _, tt := range tests {
+        tmpl := template.Must(template.New("").Parse(tt.template))
+        got, err := renderPath(tmpl, f)
+        if err != nil || got != filepath.FromSlash(tt.want) {
+            t.Errorf("renderPath(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+        }
+    }
+    if err := loadPathTemplateConfig(map[string]string{"path_template": "{{.Artits}}"}, &Config{}); err == nil {
+        t.Error("misspelled field accepted")
+    }
+}
+
+func TestSessions(t *testing.T) {
+    defer func(gap time.Duration, group string) {
+        sessionGap, groupBy = gap, group
+        startSession(time.Now())
+    }(sessionGap, groupBy)
+
+    start := time.Date(2024, 5, 6, 21, 30, 0, 0, time.Local)
+    sessionGap, groupBy = time.Hour, groupBySession
+    startSession(start)
+    recordingStartsMu.Lock()
+    next := nextRecording(start.Add(30 * time.Minute))
+    recordingStartsMu.Unlock()
+    if next.Index != 1 || !next.Session.Equal(start) {
+        t.Errorf("recording within the gap = %+v, want index 1 of the first session", next)
+    }
+    if got, want := groupDir(next), "2024-05-06 21.30"; got != want {
+        t.Errorf("groupDir = %q, want %q", got, want)
+    }
+
+    later := start.Add(2 * time.Hour)
+    recordingStartsMu.Lock()
+    next = nextRecording(later)
+    recordingStartsMu.Unlock()
+    if next.Index != 1 || !next.Session.Equal(later) {
+        t.Errorf("recording after the gap = %+v, want a new session", next)
+    }
+    groupBy = groupByDate
+    if got, want := groupDir(next), "2024-05-06"; got != want {
+        t.Errorf("groupDir = %q, want %q", got, want)
+    }
+
+    if err := loadPathTemplateConfig(map[string]string{"group_by": "week"}, &Config{}); err == nil {
+        t.Error("unknown group_by accepted")
+    }
+}
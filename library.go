@@ -0,0 +1,310 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+)
+
+// songRecord is one row of the song database
+type songRecord struct {
+    ID          int64
+    Title       string
+    Artist      string
+    Album       string
+    Station     string
+    Loved       bool
+    DetectedAt  time.Time
+    FinishedAt  sql.NullTime
+    File        string
+    Outcome     string
+    AcoustID    string
+    Fingerprint string
+}
+
+const songColumns = "id, title, artist, album, station, loved, detected_at, finished_at, file, outcome, acoustid, fingerprint"
+
+func scanSong(rows interface{ Scan(...interface{}) error }) (songRecord, error) {
+    var s songRecord
+    err := rows.Scan(&s.ID, &s.Title, &s.Artist, &s.Album, &s.Station, &s.Loved, &s.DetectedAt, &s.FinishedAt, &s.File, &s.Outcome, &s.AcoustID, &s.Fingerprint)
+    return s, err
+}
+
+// songFilter selects rows of the song database
+type songFilter struct {
+    Artist  string
+    Station string
+    Search  string
+    Outcome string
+    Since   time.Time
+    Until   time.Time
+    Loved   bool
+    Limit   int
+}
+
+// querySongs returns the songs matching f, oldest first
+func querySongs(f songFilter) ([]songRecord, error) {
+    var where []string
+    var args []interface{}
+    if f.Artist != "" {
+        where = append(where, "artist LIKE ?")
+        args = append(args, "%"+f.Artist+"%")
+    }
+    if f.Station != "" {
+        where = append(where, "station LIKE ?")
+        args = append(args, "%"+f.Station+"%")
+    }
+    if f.Search != "" {
+        where = append(where, "(title LIKE ? OR artist LIKE ? OR album LIKE ?)")
+        args = append(args, "%"+f.Search+"%", "%"+f.Search+"%", "%"+f.Search+"%")
+    }
+    if f.Outcome != "" && f.Outcome != "all" {
+        where = append(where, "outcome = ?")
+        args = append(args, f.Outcome)
+    }
+    if !f.Since.IsZero() {
+        where = append(where, "detected_at >= ?")
+        args = append(args, f.Since.UTC())
+    }
+    if !f.Until.IsZero() {
+        where = append(where, "detected_at < ?")
+        args = append(args, f.Until.UTC())
+    }
+    if f.Loved {
+        where = append(where, "loved")
+    }
+    query := "SELECT " + songColumns + " FROM songs"
+    if len(where) > 0 {
+        query += " WHERE " + strings.Join(where, " AND ")
+    }
+    if f.Limit > 0 {
+        // Keep the most recent rows but still list them in order
+        query = fmt.Sprintf("SELECT * FROM (%s ORDER BY detected_at DESC, id DESC LIMIT %d)", query, f.Limit)
+    }
+    query += " ORDER BY detected_at, id"
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query database: %v", err)
+    }
+    defer rows.Close()
+    var songs []songRecord
+    for rows.Next() {
+        s, err := scanSong(rows)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read database: %v", err)
+        }
+        songs = append(songs, s)
+    }
+    return songs, rows.Err()
+}
+
+// parseSince parses a point in time given as a date ("2024-05-01"), a relative age
+// ("3d", "2w", "12h") or "today", "yesterday", "last week", "last month"
+func parseSince(s string) (time.Time, error) {
+    now := time.Now()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "":
+        return time.Time{}, nil
+    case "today":
+        return today, nil
+    case "yesterday":
+        return today.AddDate(0, 0, -1), nil
+    case "last week", "week":
+        return now.AddDate(0, 0, -7), nil
+    case "last month", "month":
+        return now.AddDate(0, -1, 0), nil
+    }
+    if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+        return t, nil
+    }
+    if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+        return t, nil
+    }
+    if n := len(s); n > 1 {
+        if count, err := strconv.Atoi(s[:n-1]); err == nil && count >= 0 {
+            switch s[n-1] {
+            case 'd':
+                return now.AddDate(0, 0, -count), nil
+            case 'w':
+                return now.AddDate(0, 0, -7*count), nil
+            }
+        }
+    }
+    if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+        return now.Add(-d), nil
+    }
+    return time.Time{}, fmt.Errorf("invalid time %q (use a date like 2024-05-01, an age like 3d, or \"last week\")", s)
+}
+
+// openLibrary opens the song database for a subcommand
+func openLibrary(cfg Config) error {
+    conn, err := openDatabase(cfg.Database)
+    if err != nil {
+        return err
+    }
+    db = conn
+    return nil
+}
+
+const libraryUsage = `usage: pianotrap library <command> [options]
+
+commands:
+  list [options]          list recordings
+  search <text> [options] find recordings by title, artist or album
+  show <id>               show everything known about one recording
+  scrub [options]         verify recordings against their checksums
+  import [options] <dir>  add music you already own to the library
+`
+
+func runLibrary(cfg Config, args []string) error {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, libraryUsage)
+        return fmt.Errorf("missing library command")
+    }
+    if args[0] == "-h" || args[0] == "-help" {
+        fmt.Fprint(os.Stderr, libraryUsage)
+        return flag.ErrHelp
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+
+    switch args[0] {
+    case "list", "search":
+        fs := flag.NewFlagSet("library "+args[0], flag.ContinueOnError)
+        artist := fs.String("artist", "", "only songs by matching artists")
+        station := fs.String("station", "", "only songs from matching stations")
+        since := fs.String("since", "", "only songs detected after this date or age (e.g. 2024-05-01, 7d, \"last week\")")
+        outcome := fs.String("outcome", outcomeSaved, "only songs with this outcome (saved, deleted, skipped, failed, interrupted, imported or all)")
+        loved := fs.Bool("loved", false, "only loved songs")
+        limit := fs.Int("limit", 0, "show at most this many of the most recent songs")
+        var search string
+        rest := args[1:]
+        if args[0] == "search" {
+            if len(rest) == 0 || strings.HasPrefix(rest[0], "-") {
+                return fmt.Errorf("usage: pianotrap library search <text> [options]")
+            }
+            search, rest = rest[0], rest[1:]
+        }
+        if err := fs.Parse(rest); err != nil {
+            return err
+        }
+        sinceTime, err := parseSince(*since)
+        if err != nil {
+            return err
+        }
+        songs, err := querySongs(songFilter{Artist: *artist, Station: *station, Search: search, Outcome: *outcome, Since: sinceTime, Loved: *loved, Limit: *limit})
+        if err != nil {
+            return err
+        }
+        printSongs(songs)
+        return nil
+    case "show":
+        if len(args) != 2 {
+            return fmt.Errorf("usage: pianotrap library show <id>")
+        }
+        id, err := strconv.ParseInt(args[1], 10, 64)
+        if err != nil {
+            return fmt.Errorf("invalid id %q", args[1])
+        }
+        s, err := scanSong(db.QueryRow("SELECT "+songColumns+" FROM songs WHERE id = ?", id))
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("no recording with id %d", id)
+        } else if err != nil {
+            return fmt.Errorf("failed to query database: %v", err)
+        }
+        showSong(s)
+        return nil
+    case "import":
+        return runImport(cfg, args[1:])
+    case "scrub":
+        fs := flag.NewFlagSet("library scrub", flag.ContinueOnError)
+        all := fs.Bool("all", false, "verify every recording, not just those unverified for a week")
+        update := fs.Bool("update", false, "record checksums for recordings that have none")
+        if err := fs.Parse(args[1:]); err != nil {
+            return err
+        }
+        olderThan := time.Now().AddDate(0, 0, -7)
+        if *all {
+            olderThan = time.Now()
+        }
+        result, err := scrubRecordings(olderThan, *update, func(status, file string) {
+            fmt.Printf("%s: %s\n", status, file)
+        })
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%d ok, %d corrupt, %d missing, %d checksums added\n", result.OK, result.Corrupt, result.Missing, result.Added)
+        if result.Corrupt > 0 || result.Missing > 0 {
+            return fmt.Errorf("%d recordings failed verification", result.Corrupt+result.Missing)
+        }
+        return nil
+    default:
+        fmt.Fprint(os.Stderr, libraryUsage)
+        return fmt.Errorf("unknown library command %q", args[0])
+    }
+}
+
+func printSongs(songs []songRecord) {
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "ID\tDETECTED\tSTATION\tARTIST\tTITLE\tALBUM\tOUTCOME")
+    for _, s := range songs {
+        title := s.Title
+        if s.Loved {
+            title += " <3"
+        }
+        fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.DetectedAt.Local().Format("2006-01-02 15:04"), s.Station, s.Artist, title, s.Album, s.Outcome)
+    }
+    w.Flush()
+    fmt.Printf("%d songs\n", len(songs))
+}
+
+func showSong(s songRecord) {
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintf(w, "ID:\t%d\n", s.ID)
+    fmt.Fprintf(w, "Title:\t%s\n", s.Title)
+    fmt.Fprintf(w, "Artist:\t%s\n", s.Artist)
+    fmt.Fprintf(w, "Album:\t%s\n", s.Album)
+    fmt.Fprintf(w, "Station:\t%s\n", s.Station)
+    fmt.Fprintf(w, "Loved:\t%v\n", s.Loved)
+    fmt.Fprintf(w, "Detected:\t%s\n", s.DetectedAt.Local().Format(time.RFC1123))
+    if s.FinishedAt.Valid {
+        fmt.Fprintf(w, "Finished:\t%s\n", s.FinishedAt.Time.Local().Format(time.RFC1123))
+    }
+    fmt.Fprintf(w, "Outcome:\t%s\n", s.Outcome)
+    if s.File != "" {
+        fmt.Fprintf(w, "File:\t%s\n", s.File)
+    }
+    if s.AcoustID != "" {
+        fmt.Fprintf(w, "AcoustID:\t%s\n", s.AcoustID)
+    }
+    w.Flush()
+
+    if s.File == "" || s.Outcome != outcomeSaved {
+        return
+    }
+    info, err := os.Stat(s.File)
+    if err != nil {
+        fmt.Printf("File is missing: %v\n", err)
+        return
+    }
+    fmt.Printf("Size: %d bytes\n", info.Size())
+    if tags, err := readTags(s.File); err == nil {
+        fmt.Printf("Tags: %s / %s / %s (%s)", tags.Title, strings.Join(tags.Artists, "; "), tags.Album, tags.Year)
+        if tags.Genre != "" {
+            fmt.Printf(", genre %s", tags.Genre)
+        }
+        fmt.Println()
+        for _, key := range tags.customKeys() {
+            fmt.Printf("  %s: %s\n", key, tags.Custom[key])
+        }
+    }
+}
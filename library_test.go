@@ -0,0 +1,41 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestQuerySongs(t *testing.T) {
+    openTestDB(t)
+
+    logDetectedSong(songInfo{Title: "One", Artist: "Alpha", Album: "First"}, "Jazz Radio", "/music/1.mp3", outcomeSaved)
+    logDetectedSong(songInfo{Title: "Two", Artist: "Beta", Album: "Second"}, "Rock Radio", "/music/2.mp3", outcomeSaved)
+    logDetectedSong(songInfo{Title: "Three", Artist: "Alpha", Album: "Third", Loved: true}, "Rock Radio", "", outcomeSkipped)
+
+    tests := []struct {
+        filter songFilter
+        want   []string
+    }{
+        {songFilter{Outcome: outcomeSaved}, []string{"One", "Two"}},
+        {songFilter{Outcome: "all", Artist: "alpha"}, []string{"One", "Three"}},
+        {songFilter{Station: "Rock"}, []string{"Two", "Three"}},
+        {songFilter{Search: "second"}, []string{"Two"}},
+        {songFilter{Loved: true}, []string{"Three"}},
+        {songFilter{Limit: 2}, []string{"Two", "Three"}},
+        {songFilter{Since: time.Now().Add(time.Hour)}, nil},
+    }
+    for _, tt := range tests {
+        songs, err := querySongs(tt.filter)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var got []string
+        for _, s := range songs {
+            got = append(got, s.Title)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("querySongs(%+v) = %v, want %v", tt.filter, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// With listenbrainz_token set, pianotrap scrobbles to ListenBrainz: the song playing
+// as "playing now" and the songs listened to as listens, through the queue of
+// scrobble.go. listenbrainz_url points it at another server with the same API, e.g.
+// a self-hosted one.
+
+const defaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// loadListenBrainzConfig reads the listenbrainz_* options
+func loadListenBrainzConfig(values map[string]string, cfg *Config) error {
+    cfg.ListenBrainzToken = values["listenbrainz_token"]
+    cfg.ListenBrainzURL = strings.TrimSuffix(values["listenbrainz_url"], "/")
+    if cfg.ListenBrainzURL == "" {
+        cfg.ListenBrainzURL = defaultListenBrainzURL
+    }
+    if !strings.HasPrefix(cfg.ListenBrainzURL, "http://") && !strings.HasPrefix(cfg.ListenBrainzURL, "https://") {
+        return fmt.Errorf("invalid value for listenbrainz_url: %q (want an http(s) URL)", cfg.ListenBrainzURL)
+    }
+    return nil
+}
+
+// listenBrainz submits to the ListenBrainz server at url
+type listenBrainz struct {
+    url   string
+    token string
+}
+
+// lbPayload is a listen as ListenBrainz takes it
+type lbPayload struct {
+    ListenedAt int64 `json:"listened_at,omitempty"`
+    Track      struct {
+        Artist     string                 `json:"artist_name"`
+        Track      string                 `json:"track_name"`
+        Release    string                 `json:"release_name,omitempty"`
+        Additional map[string]interface{} `json:"additional_info"`
+    } `json:"track_metadata"`
+}
+
+// lbListen converts l, without its time for "playing now"
+func lbListen(l listen, withTime bool) lbPayload {
+    var p lbPayload
+    if withTime {
+        p.ListenedAt = l.ListenedAt.Unix()
+    }
+    p.Track.Artist, p.Track.Track, p.Track.Release = l.Artist, l.Title, l.Album
+    p.Track.Additional = map[string]interface{}{
+        "media_player":      "pianobar",
+        "submission_client": "pianotrap",
+        "music_service":     "pandora.com",
+    }
+    if l.Duration > 0 {
+        p.Track.Additional["duration_ms"] = l.Duration.Milliseconds()
+    }
+    return p
+}
+
+// post sends listens of listenType to the server
+func (lb listenBrainz) post(listenType string, payload []lbPayload) error {
+    body, _ := json.Marshal(map[string]interface{}{"listen_type": listenType, "payload": payload})
+    req, err := http.NewRequest("POST", lb.url+"/1/submit-listens", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Token "+lb.token)
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", enrichUserAgent)
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusOK {
+        return nil
+    }
+    var reply struct {
+        Error string `json:"error"`
+    }
+    data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+    json.Unmarshal(data, &reply)
+    err = fmt.Errorf("%s: %s", resp.Status, reply.Error)
+    // A bad listen stays bad; a bad token, rate limits and server trouble pass
+    if resp.StatusCode == http.StatusBadRequest {
+        return scrobbleError{err}
+    }
+    return err
+}
+
+// NowPlaying sends l as the song playing
+func (lb listenBrainz) NowPlaying(l listen) error {
+    return lb.post("playing_now", []lbPayload{lbListen(l, false)})
+}
+
+// Submit sends listens, one as a single listen and more as an import
+func (lb listenBrainz) Submit(listens []listen) error {
+    payload := make([]lbPayload, len(listens))
+    for i, l := range listens {
+        payload[i] = lbListen(l, true)
+    }
+    if len(payload) == 1 {
+        return lb.post("single", payload)
+    }
+    return lb.post("import", payload)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// Translated pianobar builds print their banners and messages in another language.
+// A locale profile holds those strings; pianobar_locale picks one, or it's taken
+// from the environment. English is always understood as well, so a wrong guess
+// doesn't break an untranslated pianobar.
+
+// localeProfile holds the pianobar strings pianotrap looks for
+type localeProfile struct {
+    Name string
+    // By and On join title, artist and album in the song banner
+    By, On string
+    // Station introduces the station banner
+    Station string
+    // SelectStation is the prompt of the station list
+    SelectStation string
+    // Interruptions are messages after which the song can't be recorded to the end
+    Interruptions []string
+}
+
+var localeProfiles = map[string]localeProfile{
+    "en": {Name: "en", By: "by", On: "on", Station: "Station", SelectStation: "Select station",
+        Interruptions: []string{"(i) Network error", "Connection lost", "Song paused"}},
+    "de": {Name: "de", By: "von", On: "auf", Station: "Sender", SelectStation: "Sender auswählen",
+        Interruptions: []string{"(i) Netzwerkfehler", "Verbindung verloren", "Lied pausiert"}},
+    "fr": {Name: "fr", By: "par", On: "sur", Station: "Station", SelectStation: "Choisir la station",
+        Interruptions: []string{"(i) Erreur réseau", "Connexion perdue", "Morceau en pause"}},
+    "es": {Name: "es", By: "de", On: "en", Station: "Emisora", SelectStation: "Seleccionar emisora",
+        Interruptions: []string{"(i) Error de red", "Conexión perdida", "Canción en pausa"}},
+}
+
+// pianobarLocale is the profile in use besides English
+var pianobarLocale = localeProfiles["en"]
+
+// loadLocaleConfig reads pianobar_locale: a profile name, or auto to use the
+// language of LC_ALL, LC_MESSAGES or LANG
+func loadLocaleConfig(values map[string]string, cfg *Config) error {
+    name := values["pianobar_locale"]
+    if name == "" || name == "auto" {
+        cfg.Locale = detectLocale(os.Getenv)
+        return nil
+    }
+    profile, ok := localeProfiles[name]
+    if !ok {
+        return fmt.Errorf("unknown pianobar_locale %q (known: en, de, fr, es, auto)", name)
+    }
+    cfg.Locale = profile
+    return nil
+}
+
+// detectLocale picks the profile for the language of the environment, falling
+// back to English
+func detectLocale(getenv func(string) string) localeProfile {
+    for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+        value := getenv(key)
+        if value == "" {
+            continue
+        }
+        // e.g. de_DE.UTF-8 or fr_CA@euro; the first variable set wins
+        lang := strings.ToLower(value)
+        if i := strings.IndexAny(lang, "_.@"); i >= 0 {
+            lang = lang[:i]
+        }
+        if profile, ok := localeProfiles[lang]; ok {
+            return profile
+        }
+        break
+    }
+    return localeProfiles["en"]
+}
+
+// alternatives is a regexp group matching the English or the translated word
+func alternatives(english, translated string) string {
+    if translated == "" || translated == english {
+        return regexp.QuoteMeta(english)
+    }
+    return "(?:" + regexp.QuoteMeta(english) + "|" + regexp.QuoteMeta(translated) + ")"
+}
+
+// setLocale makes the parsers understand profile besides English
+func setLocale(profile localeProfile) {
+    en := localeProfiles["en"]
+    pianobarLocale = profile
+    songLineRe = regexp.MustCompile(`^\|>\s+"(.*)" ` + alternatives(en.By, profile.By) + ` "(.*)" ` +
+        alternatives(en.On, profile.On) + ` "(.*)"( <3)?(?: @ .*)?$`)
+    stationLineRe = regexp.MustCompile(`^\|>\s+` + alternatives(en.Station, profile.Station) + `\s+"(.+)"(?:\s+\(\d*\))?$`)
+}
+
+// interruptsRecording reports whether output says playback broke off
+func interruptsRecording(output string) bool {
+    for _, profile := range []localeProfile{localeProfiles["en"], pianobarLocale} {
+        for _, msg := range profile.Interruptions {
+            if strings.Contains(output, msg) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// isStationPrompt reports whether output ends in the station list's prompt
+func isStationPrompt(output string) bool {
+    return strings.Contains(output, localeProfiles["en"].SelectStation) || strings.Contains(output, pianobarLocale.SelectStation)
+}
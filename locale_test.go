@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestLocaleProfiles(t *testing.T) {
+    defer setLocale(localeProfiles["en"])
+    env := map[string]string{"LANG": "de_DE.UTF-8"}
+    if got := detectLocale(func(k string) string { return env[k] }); got.Name != "de" {
+        t.Errorf("detectLocale(LANG=de_DE.UTF-8) = %s, want de", got.Name)
+    }
+    env["LC_ALL"] = "C"
+    if got := detectLocale(func(k string) string { return env[k] }); got.Name != "en" {
+        t.Errorf("detectLocale(LC_ALL=C) = %s, want en", got.Name)
+    }
+
+    setLocale(localeProfiles["de"])
+    if info, ok := parseSongLine(`|>  "Lied" von "Band" auf "Album" <3`); !ok || info.Artist != "Band" || !info.Loved {
+        t.Errorf("German song line parsed as %+v, %v", info, ok)
+    }
+    if info, ok := parseSongLine(`|>  "Song" by "Band" on "Album"`); !ok || info.Album != "Album" {
+        t.Errorf("English song line not understood with the German profile: %+v, %v", info, ok)
+    }
+    if station, ok := parseStationLine(`|>  Sender "Jazz Radio" (123)`); !ok || station != "Jazz Radio" {
+        t.Errorf("German station line parsed as %q, %v", station, ok)
+    }
+    if !interruptsRecording("(i) Netzwerkfehler") || !interruptsRecording("Song paused") || interruptsRecording("(i) Login... Ok.") {
+        t.Error("interruptions not recognized")
+    }
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// When the HTTP API listens beyond localhost, pianotrap advertises it on the LAN with
+// multicast DNS, as _pianotrap._tcp for companion clients and as _http._tcp (or
+// _https._tcp) so that Bonjour browsers list the dashboard, e.g.
+//
+//     avahi-browse -r _pianotrap._tcp
+//
+// The TXT record says whether the API wants HTTPS and credentials. Like the MQTT
+// client, the little of DNS this needs is done by hand; http_mdns = false turns it
+// off.
+
+const (
+    mdnsGroup = "224.0.0.251:5353"
+    // mdnsTTL is how long others may cache the records, as RFC 6762 suggests
+    mdnsTTL = 120
+)
+
+// DNS record types
+const (
+    dnsA    = 1
+    dnsPTR  = 12
+    dnsTXT  = 16
+    dnsAAAA = 28
+    dnsSRV  = 33
+    dnsANY  = 255
+)
+
+// dnsRecord is a resource record of class IN. Unique ones have the cache-flush bit
+// set, so that others replace what they cached rather than adding to it.
+type dnsRecord struct {
+    Name   string
+    Type   uint16
+    Unique bool
+    TTL    uint32
+    Data   []byte
+}
+
+// dnsQuestion is a question of a query
+type dnsQuestion struct {
+    Name string
+    Type uint16
+    // Unicast asks for the answer to be sent to the asker only
+    Unicast bool
+}
+
+// appendDNSName appends name, uncompressed
+func appendDNSName(b []byte, name string) []byte {
+    for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+        if label == "" {
+            continue
+        }
+        b = append(b, byte(len(label)))
+        b = append(b, label...)
+    }
+    return append(b, 0)
+}
+
+// readDNSName reads the name at off in msg, following compression pointers, and
+// returns it and the offset after it
+func readDNSName(msg []byte, off int) (string, int, error) {
+    var labels []string
+    end := -1
+    for jumps := 0; ; {
+        if off >= len(msg) {
+            return "", 0, fmt.Errorf("name runs past the message")
+        }
+        n := int(msg[off])
+        switch {
+        case n == 0:
+            if end < 0 {
+                end = off + 1
+            }
+            return strings.Join(labels, ".") + ".", end, nil
+        case n&0xc0 == 0xc0:
+            if off+1 >= len(msg) || jumps > 10 {
+                return "", 0, fmt.Errorf("bad compression pointer")
+            }
+            if end < 0 {
+                end = off + 2
+            }
+            off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+            jumps++
+        default:
+            if off+1+n > len(msg) {
+                return "", 0, fmt.Errorf("label runs past the message")
+            }
+            labels = append(labels, string(msg[off+1:off+1+n]))
+            off += 1 + n
+        }
+    }
+}
+
+// parseDNSQuery returns the ID and questions of a query, or nil questions for
+// responses
+func parseDNSQuery(msg []byte) (uint16, []dnsQuestion, error) {
+    if len(msg) < 12 {
+        return 0, nil, fmt.Errorf("short message")
+    }
+    id := binary.BigEndian.Uint16(msg)
+    if msg[2]&0x80 != 0 {
+        return id, nil, nil
+    }
+    var questions []dnsQuestion
+    off := 12
+    for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+        name, next, err := readDNSName(msg, off)
+        if err != nil {
+            return id, nil, err
+        }
+        if next+4 > len(msg) {
+            return id, nil, fmt.Errorf("short question")
+        }
+        class := binary.BigEndian.Uint16(msg[next+2:])
+        questions = append(questions, dnsQuestion{name, binary.BigEndian.Uint16(msg[next:]), class&0x8000 != 0})
+        off = next + 4
+    }
+    return id, questions, nil
+}
+
+// encodeDNSResponse encodes an authoritative response with answers and additional
+// records. Legacy queries, those not from port 5353, get their ID and questions back.
+func encodeDNSResponse(id uint16, questions []dnsQuestion, answers, additional []dnsRecord) []byte {
+    b := make([]byte, 12)
+    binary.BigEndian.PutUint16(b, id)
+    binary.BigEndian.PutUint16(b[2:], 0x8400)
+    binary.BigEndian.PutUint16(b[4:], uint16(len(questions)))
+    binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+    binary.BigEndian.PutUint16(b[10:], uint16(len(additional)))
+    for _, q := range questions {
+        b = appendDNSName(b, q.Name)
+        b = binary.BigEndian.AppendUint16(b, q.Type)
+        b = binary.BigEndian.AppendUint16(b, 1)
+    }
+    for _, r := range append(answers, additional...) {
+        b = appendDNSName(b, r.Name)
+        b = binary.BigEndian.AppendUint16(b, r.Type)
+        class := uint16(1)
+        if r.Unique {
+            class |= 0x8000
+        }
+        b = binary.BigEndian.AppendUint16(b, class)
+        b = binary.BigEndian.AppendUint32(b, r.TTL)
+        b = binary.BigEndian.AppendUint16(b, uint16(len(r.Data)))
+        b = append(b, r.Data...)
+    }
+    return b
+}
+
+// mdnsAdvert is what pianotrap advertises
+type mdnsAdvert struct {
+    Instance string // e.g. "pianotrap on mediabox"
+    Host     string // e.g. "mediabox.local."
+    Port     int
+    TLS      bool
+    Auth     string // none, token, basic or token+basic
+    IPs      []net.IP
+}
+
+// newMDNSAdvert describes the API of cfg listening on port
+func newMDNSAdvert(cfg Config, port int) mdnsAdvert {
+    hostname, _ := os.Hostname()
+    hostname = strings.TrimSuffix(strings.SplitN(hostname, ".", 2)[0], ".")
+    if hostname == "" {
+        hostname = "pianotrap"
+    }
+    a := mdnsAdvert{Instance: "pianotrap on " + hostname, Host: hostname + ".local.", Port: port, TLS: cfg.httpsEnabled()}
+    if cfg.Profile != "" {
+        a.Instance += " (" + cfg.Profile + ")"
+    }
+    // The instance name is a single label
+    a.Instance = strings.ReplaceAll(a.Instance, ".", "-")
+    var auth []string
+    if cfg.HTTPToken != "" {
+        auth = append(auth, "token")
+    }
+    if cfg.HTTPUsername != "" {
+        auth = append(auth, "basic")
+    }
+    a.Auth = "none"
+    if len(auth) > 0 {
+        a.Auth = strings.Join(auth, "+")
+    }
+    host, _, _ := net.SplitHostPort(cfg.HTTPListen)
+    if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+        a.IPs = []net.IP{ip}
+    } else if addrs, err := net.InterfaceAddrs(); err == nil {
+        for _, addr := range addrs {
+            if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+                a.IPs = append(a.IPs, ipNet.IP)
+            }
+        }
+    }
+    return a
+}
+
+// services are the service types advertised
+func (a mdnsAdvert) services() []string {
+    if a.TLS {
+        return []string{"_pianotrap._tcp.local.", "_https._tcp.local."}
+    }
+    return []string{"_pianotrap._tcp.local.", "_http._tcp.local."}
+}
+
+// records are all of the advertisement's records, with ttl
+func (a mdnsAdvert) records(ttl uint32) []dnsRecord {
+    var records []dnsRecord
+    for _, service := range a.services() {
+        instance := appendDNSName(nil, a.Instance+"."+service)
+        records = append(records,
+            dnsRecord{"_services._dns-sd._udp.local.", dnsPTR, false, ttl, appendDNSName(nil, service)},
+            dnsRecord{service, dnsPTR, false, ttl, instance})
+        srv := make([]byte, 6)
+        binary.BigEndian.PutUint16(srv[4:], uint16(a.Port))
+        records = append(records, dnsRecord{a.Instance + "." + service, dnsSRV, true, ttl, appendDNSName(srv, a.Host)})
+        proto := "http"
+        if a.TLS {
+            proto = "https"
+        }
+        var txt []byte
+        for _, entry := range []string{"txtvers=1", "path=/", "proto=" + proto, "auth=" + a.Auth} {
+            txt = append(append(txt, byte(len(entry))), entry...)
+        }
+        records = append(records, dnsRecord{a.Instance + "." + service, dnsTXT, true, ttl, txt})
+    }
+    for _, ip := range a.IPs {
+        if v4 := ip.To4(); v4 != nil {
+            records = append(records, dnsRecord{a.Host, dnsA, true, ttl, v4})
+        } else {
+            records = append(records, dnsRecord{a.Host, dnsAAAA, true, ttl, ip.To16()})
+        }
+    }
+    return records
+}
+
+// answer returns the records answering questions and the records that go with
+// them, e.g. the address of a service's host
+func (a mdnsAdvert) answer(questions []dnsQuestion) ([]dnsRecord, []dnsRecord) {
+    all := a.records(mdnsTTL)
+    var answers, additional []dnsRecord
+    added := make(map[int]bool)
+    instances := make(map[string]bool)
+    for _, q := range questions {
+        for i, r := range all {
+            if !added[i] && strings.EqualFold(r.Name, q.Name) && (q.Type == r.Type || q.Type == dnsANY) {
+                answers = append(answers, r)
+                added[i] = true
+                if r.Type == dnsPTR {
+                    target, _, _ := readDNSName(r.Data, 0)
+                    instances[target] = true
+                }
+            }
+        }
+    }
+    // A browser asking for the service gets its instance's SRV, TXT and addresses too
+    for i, r := range all {
+        if len(instances) > 0 && !added[i] && (instances[r.Name] || r.Name == a.Host) {
+            additional = append(additional, r)
+        }
+    }
+    return answers, additional
+}
+
+var mdns struct {
+    mu     sync.Mutex
+    conn   *net.UDPConn
+    advert mdnsAdvert
+}
+
+// startMDNS advertises the API of cfg listening on port for the rest of the session
+func startMDNS(cfg Config, port int) {
+    host, _, _ := net.SplitHostPort(cfg.HTTPListen)
+    if !cfg.HTTPMDNS || isLoopback(host) {
+        return
+    }
+    group, _ := net.ResolveUDPAddr("udp4", mdnsGroup)
+    conn, err := net.ListenMulticastUDP("udp4", nil, group)
+    if err != nil {
+        logger.Printf("mDNS unavailable: %v", err)
+        return
+    }
+    advert := newMDNSAdvert(cfg, port)
+    mdns.mu.Lock()
+    mdns.conn, mdns.advert = conn, advert
+    mdns.mu.Unlock()
+    logger.Printf("mDNS: advertising %q on port %d", advert.Instance, port)
+
+    go func() {
+        // Announced twice, a second apart, as RFC 6762 asks
+        for i := 0; i < 2; i++ {
+            conn.WriteToUDP(encodeDNSResponse(0, nil, advert.records(mdnsTTL), nil), group)
+            time.Sleep(time.Second)
+        }
+    }()
+    go func() {
+        buf := make([]byte, 9000)
+        for {
+            n, from, err := conn.ReadFromUDP(buf)
+            if err != nil {
+                return
+            }
+            id, questions, err := parseDNSQuery(buf[:n])
+            if err != nil || len(questions) == 0 {
+                continue
+            }
+            answers, additional := advert.answer(questions)
+            if len(answers) == 0 {
+                continue
+            }
+            switch {
+            case from.Port != 5353:
+                // A plain DNS resolver asking the group directly
+                conn.WriteToUDP(encodeDNSResponse(id, questions, answers, additional), from)
+            case questions[0].Unicast:
+                conn.WriteToUDP(encodeDNSResponse(0, nil, answers, additional), from)
+            default:
+                conn.WriteToUDP(encodeDNSResponse(0, nil, answers, additional), group)
+            }
+        }
+    }()
+}
+
+// stopMDNS withdraws the advertisement, so that browsers drop it right away
+func stopMDNS() {
+    mdns.mu.Lock()
+    defer mdns.mu.Unlock()
+    if mdns.conn == nil {
+        return
+    }
+    group, _ := net.ResolveUDPAddr("udp4", mdnsGroup)
+    mdns.conn.WriteToUDP(encodeDNSResponse(0, nil, mdns.advert.records(0), nil), group)
+    mdns.conn.Close()
+    mdns.conn = nil
+}
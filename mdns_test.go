@@ -0,0 +1,71 @@
+package main
+
+import (
+    "encoding/binary"
+    "net"
+    "strings"
+    "testing"
+)
+
+func TestMDNS(t *testing.T) {
+    a := mdnsAdvert{Instance: "pianotrap on mediabox", Host: "mediabox.local.", Port: 8337, Auth: "token", IPs: []net.IP{net.ParseIP("192.168.1.20")}}
+
+    // A query for _pianotrap._tcp.local and, compressed, the host's address
+    query := []byte{0, 7, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0}
+    query = appendDNSName(query, "_pianotrap._tcp.local.")
+    query = append(query, 0, dnsPTR, 0x80, 1)
+    query = append(query, 8)
+    query = append(query, "mediabox"...)
+    query = append(query, 0xc0, 28, 0, dnsA, 0, 1) // pointer to "local."
+    id, questions, err := parseDNSQuery(query)
+    if err != nil || id != 7 || len(questions) != 2 {
+        t.Fatalf("parseDNSQuery = %d %+v, %v", id, questions, err)
+    }
+    if q := questions[1]; q.Name != "mediabox.local." || q.Type != dnsA || q.Unicast || !questions[0].Unicast {
+        t.Errorf("questions = %+v", questions)
+    }
+
+    answers, additional := a.answer(questions[:1])
+    if len(answers) != 1 || answers[0].Type != dnsPTR || len(additional) != 3 {
+        t.Fatalf("answers %+v, additional %+v", answers, additional)
+    }
+    if name, _, err := readDNSName(answers[0].Data, 0); err != nil || name != "pianotrap on mediabox._pianotrap._tcp.local." {
+        t.Errorf("PTR to %q, %v", name, err)
+    }
+    for _, r := range additional {
+        switch r.Type {
+        case dnsSRV:
+            if port := binary.BigEndian.Uint16(r.Data[4:]); port != 8337 || !r.Unique {
+                t.Errorf("SRV port %d", port)
+            }
+        case dnsTXT:
+            if !strings.Contains(string(r.Data), "auth=token") || !strings.Contains(string(r.Data), "proto=http") {
+                t.Errorf("TXT %q", r.Data)
+            }
+        case dnsA:
+            if !net.IP(r.Data).Equal(net.ParseIP("192.168.1.20")) {
+                t.Errorf("A %v", net.IP(r.Data))
+            }
+        }
+    }
+    if answers, _ := a.answer(questions[1:]); len(answers) != 1 || answers[0].Type != dnsA {
+        t.Errorf("address answers %+v", answers)
+    }
+    if answers, _ := a.answer([]dnsQuestion{{Name: "_ipp._tcp.local.", Type: dnsPTR}}); len(answers) != 0 {
+        t.Errorf("answered for another service: %+v", answers)
+    }
+
+    response := encodeDNSResponse(7, questions[:1], answers, additional)
+    if _, got, err := parseDNSQuery(response); err != nil || got != nil {
+        t.Errorf("response taken for a query: %v", err)
+    }
+    if binary.BigEndian.Uint16(response[6:]) != 1 || binary.BigEndian.Uint16(response[10:]) != 3 {
+        t.Errorf("response counts %x", response[:12])
+    }
+    loop := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12, 0, 1, 0, 1}
+    for _, bad := range [][]byte{query[:20], loop} {
+        if _, _, err := parseDNSQuery(bad); err == nil {
+            t.Errorf("malformed query %x accepted", bad)
+        }
+    }
+}
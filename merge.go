@@ -0,0 +1,147 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Switching stations by accident and straight back cuts the song being recorded in
+// two. The first part is discarded as incomplete like any other, but when the same
+// song is recorded again on the same station within resumeWindow, the discarded part
+// is taken back out of the trash and put in front of the new recording. Audio
+// played while the other station was on is lost.
+
+const resumeWindow = 2 * time.Minute
+
+var (
+    interruptedMu sync.Mutex
+    // interrupted are recent segments discarded by a station change
+    interrupted []interruptedSegment
+    // resumed maps new recordings to the segment they continue
+    resumed = make(map[string]interruptedSegment)
+)
+
+// interruptedSegment is the discarded first part of a recording
+type interruptedSegment struct {
+    Song    string // songKey of title and artist
+    Station string
+    Trashed string // where the journal says the part was moved
+    At      time.Time
+}
+
+// noteInterrupted remembers a recording that was discarded because the station
+// changed, so it can be resumed
+func noteInterrupted(fileName, title, artist, station string) {
+    if db == nil {
+        return
+    }
+    var target string
+    err := db.QueryRow("SELECT target FROM actions WHERE kind = ? AND path = ? AND done AND NOT undone ORDER BY id DESC LIMIT 1",
+        actionDiscard, tempName(fileName)).Scan(&target)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            logger.Printf("Failed to read the action journal: %v", err)
+        }
+        return // deleted outright, nothing to resume
+    }
+    interruptedMu.Lock()
+    defer interruptedMu.Unlock()
+    interrupted = append(pruneInterrupted(interrupted, time.Now()), interruptedSegment{
+        Song: songKey(title, artist), Station: station, Trashed: target, At: time.Now(),
+    })
+}
+
+// pruneInterrupted drops segments too old to resume
+func pruneInterrupted(segments []interruptedSegment, now time.Time) []interruptedSegment {
+    var kept []interruptedSegment
+    for _, seg := range segments {
+        if now.Sub(seg.At) < resumeWindow {
+            kept = append(kept, seg)
+        }
+    }
+    return kept
+}
+
+// resumeSegment checks whether the recording just started continues a song
+// interrupted on the same station and reports whether it does
+func resumeSegment(fileName, title, artist, station string) bool {
+    interruptedMu.Lock()
+    defer interruptedMu.Unlock()
+    interrupted = pruneInterrupted(interrupted, time.Now())
+    for i, seg := range interrupted {
+        if seg.Song == songKey(title, artist) && seg.Station == station {
+            resumed[fileName] = seg
+            interrupted = append(interrupted[:i], interrupted[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// concatList is an ffmpeg concat demuxer script joining files in order
+func concatList(files ...string) string {
+    var b strings.Builder
+    for _, f := range files {
+        fmt.Fprintf(&b, "file '%s'\n", strings.Replace(f, "'", `'\''`, -1))
+    }
+    return b.String()
+}
+
+// mergeResumed puts the interrupted first part in front of the finished temporary
+// recording of fileName, if it continues one
+func mergeResumed(fileName string) {
+    interruptedMu.Lock()
+    seg, ok := resumed[fileName]
+    delete(resumed, fileName)
+    interruptedMu.Unlock()
+    if !ok {
+        return
+    }
+    if _, err := os.Stat(seg.Trashed); err != nil {
+        logger.Printf("Can't resume %s: the first part is gone: %v", fileName, err)
+        return
+    }
+
+    list, err := ioutil.TempFile("", "pianotrap-concat-")
+    if err != nil {
+        logger.Printf("Can't resume %s: %v", fileName, err)
+        return
+    }
+    defer os.Remove(list.Name())
+    list.WriteString(concatList(seg.Trashed, tempName(fileName)))
+    list.Close()
+
+    merged := tempName(fileName) + ".merged"
+    muxer := "mp3"
+    if format, ok := captureFormatOf(fileName); ok {
+        muxer = format.Muxer
+    }
+    out, err := exec.Command(ffmpegBinary(), "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", list.Name(),
+        "-c", "copy", "-f", muxer, "-y", merged).CombinedOutput()
+    if err != nil {
+        logger.Printf("Failed to join the parts of %s: %v: %s", fileName, err, out)
+        os.Remove(merged)
+        return
+    }
+    if err := os.Rename(merged, tempName(fileName)); err != nil {
+        logger.Printf("Failed to join the parts of %s: %v", fileName, err)
+        os.Remove(merged)
+        return
+    }
+    // The first part lives on in the merged recording
+    if err := deleteFile(seg.Trashed); err != nil {
+        logger.Printf("Failed to remove %s: %v", seg.Trashed, err)
+    } else {
+        os.Remove(trashInfoFile(seg.Trashed))
+    }
+    if _, err := db.Exec("UPDATE actions SET undone = 1 WHERE kind = ? AND target = ?", actionDiscard, seg.Trashed); err != nil {
+        logger.Printf("Failed to update the action journal: %v", err)
+    }
+    notice(msgInfo, "Joined both parts of %s", fileName)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestResumeSegment(t *testing.T) {
+    defer func() { interrupted, resumed = nil, make(map[string]interruptedSegment) }()
+    now := time.Now()
+    interrupted = []interruptedSegment{
+        {Song: songKey("Old", "Band"), Station: "Rock Radio", Trashed: "/trash/1-old.mp3", At: now.Add(-resumeWindow)},
+        {Song: songKey("Song", "Band"), Station: "Rock Radio", Trashed: "/trash/2-song.mp3", At: now},
+    }
+    if resumeSegment("/music/a.mp3", "Song", "Band", "Jazz Radio") {
+        t.Error("resumed a song interrupted on another station")
+    }
+    if !resumeSegment("/music/b.mp3", "Song", "Band", "Rock Radio") || resumed["/music/b.mp3"].Trashed != "/trash/2-song.mp3" {
+        t.Error("interrupted song not resumed")
+    }
+    if len(interrupted) != 0 {
+        t.Errorf("%d segments left, want the old one pruned and the resumed one taken", len(interrupted))
+    }
+    if got, want := concatList("/a/it's.mp3", "/b.mp3"), "file '/a/it'\\''s.mp3'\nfile '/b.mp3'\n"; got != want {
+        t.Errorf("concatList = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// mixtapeTrack is a selected recording and its length, counting the announcement
+// in Intro if there is one
+type mixtapeTrack struct {
+    playlistEntry
+    Duration time.Duration
+    Intro    string
+}
+
+// runMixtape implements "pianotrap mixtape": it picks the best recordings of a
+// period up to a maximum length and renders them as one continuous file with
+// normalized levels, crossfades and a cue sheet
+func runMixtape(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("mixtape", flag.ContinueOnError)
+    from := fs.String("from", "last week", "use recordings detected after this date or age (e.g. 2024-05-01, 3d, \"last week\")")
+    max := fs.Duration("max", 60*time.Minute, "maximum length of the mixtape")
+    crossfade := fs.Duration("crossfade", 3*time.Second, "crossfade between songs (0 for none)")
+    output := fs.String("o", "", "output file (default <savedir>/Mixtapes/Mixtape <date>.mp3)")
+    announce := fs.Bool("announce", false, "prepend a spoken announcement to every song (see the announce option)")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    since, err := parseSince(*from)
+    if err != nil {
+        return err
+    }
+    if *max <= 0 || *crossfade < 0 {
+        return fmt.Errorf("-max must be positive and -crossfade not negative")
+    }
+    if *output == "" {
+        *output = filepath.Join(cfg.SaveDir, "Mixtapes", "Mixtape "+time.Now().Format("2006-01-02")+".mp3")
+    }
+
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+    candidates, err := bestOfSongs(since, 1000)
+    if err != nil {
+        return err
+    }
+    var intro func(playlistEntry) (string, time.Duration, error)
+    if *announce {
+        dir, err := ioutil.TempDir("", "pianotrap-mixtape")
+        if err != nil {
+            return fmt.Errorf("failed to create temp dir: %v", err)
+        }
+        defer os.RemoveAll(dir)
+        n := 0
+        intro = func(e playlistEntry) (string, time.Duration, error) {
+            n++
+            file := filepath.Join(dir, fmt.Sprintf("intro%d.wav", n))
+            if err := cfg.Announce.synthesize(cfg.Announce.text(e), file); err != nil {
+                return "", 0, err
+            }
+            d, err := probeDuration(file)
+            return file, d, err
+        }
+    }
+    tracks, err := selectMixtapeTracks(candidates, *max, *crossfade, intro)
+    if err != nil {
+        return err
+    }
+    if len(tracks) == 0 {
+        return fmt.Errorf("no recordings since %s", since.Format("2006-01-02 15:04"))
+    }
+    var intros []string
+    for _, t := range tracks {
+        if t.Intro != "" {
+            intros = append(intros, t.Intro)
+        }
+    }
+
+    fmt.Printf("Rendering %d songs to %s\n", len(tracks), *output)
+    if err := renderCrossfadeMixtape(tracks, intros, *output, *crossfade); err != nil {
+        return err
+    }
+    cueFile := strings.TrimSuffix(*output, filepath.Ext(*output)) + ".cue"
+    if err := writeCueSheet(cueFile, *output, tracks, *crossfade); err != nil {
+        return err
+    }
+    fmt.Printf("Wrote %s and %s\n", *output, cueFile)
+    return nil
+}
+
+// selectMixtapeTracks takes ranked candidates while they fit in max and returns them
+// in capture order. intro, if given, makes the announcement of a track, which counts
+// towards max.
+func selectMixtapeTracks(candidates []playlistEntry, max, crossfade time.Duration, intro func(playlistEntry) (string, time.Duration, error)) ([]mixtapeTrack, error) {
+    var tracks []mixtapeTrack
+    var total time.Duration
+    for _, e := range candidates {
+        d, err := probeDuration(e.File)
+        if err != nil {
+            logger.Printf("Skipping %s: %v", e.File, err)
+            continue
+        }
+        // Songs shorter than two crossfades can't be faded in and out
+        if d <= 2*crossfade {
+            continue
+        }
+        length := d
+        if len(tracks) > 0 {
+            length -= crossfade
+        }
+        if total+length > max {
+            continue
+        }
+        track := mixtapeTrack{e, d, ""}
+        if intro != nil {
+            file, introLength, err := intro(e)
+            if err != nil {
+                return nil, err
+            }
+            if total+length+introLength > max {
+                continue
+            }
+            // The cue sheet starts each track at its announcement
+            track.Duration += introLength
+            track.Intro = file
+            length += introLength
+        }
+        tracks = append(tracks, track)
+        total += length
+    }
+    sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].Detected.Before(tracks[j].Detected) })
+    return tracks, nil
+}
+
+// renderCrossfadeMixtape normalizes each track's loudness and chains them with
+// crossfades, putting the matching intro (if any) in front of each track
+func renderCrossfadeMixtape(tracks []mixtapeTrack, intros []string, output string, crossfade time.Duration) error {
+    if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+        return fmt.Errorf("failed to create mixtape directory: %v", err)
+    }
+    var args []string
+    var filter strings.Builder
+    for i, t := range tracks {
+        args = append(args, "-i", t.File)
+        fmt.Fprintf(&filter, "[%d:a]loudnorm=I=-16:TP=-1.5:LRA=11,%s[n%d];", i, mixFormat, i)
+    }
+    for i, intro := range intros {
+        args = append(args, "-i", intro)
+        fmt.Fprintf(&filter, "[%d:a]%s[i%d];[i%d][n%d]concat=n=2:v=0:a=1[t%d];", len(tracks)+i, mixFormat, i, i, i, i)
+    }
+    input := func(i int) string {
+        if intros != nil {
+            return fmt.Sprintf("t%d", i)
+        }
+        return fmt.Sprintf("n%d", i)
+    }
+    last := input(0)
+    for i := 1; i < len(tracks); i++ {
+        next := fmt.Sprintf("x%d", i)
+        if crossfade > 0 {
+            fmt.Fprintf(&filter, "[%s][%s]acrossfade=d=%.3f[%s];", last, input(i), crossfade.Seconds(), next)
+        } else {
+            fmt.Fprintf(&filter, "[%s][%s]concat=n=2:v=0:a=1[%s];", last, input(i), next)
+        }
+        last = next
+    }
+    filterGraph := strings.TrimSuffix(filter.String(), ";")
+
+    tmp := output + ".tmp" + filepath.Ext(output)
+    args = append([]string{"-v", "error"}, args...)
+    args = append(args, "-filter_complex", filterGraph, "-map", "["+last+"]", "-map_metadata", "-1", "-y", tmp)
+    if out, err := exec.Command(ffmpegBinary(), args...).CombinedOutput(); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("ffmpeg failed to render %s: %v: %s", output, err, strings.TrimSpace(string(out)))
+    }
+    return os.Rename(tmp, output)
+}
+
+// writeCueSheet lists where each track starts in the rendered mixtape
+func writeCueSheet(cueFile, audioFile string, tracks []mixtapeTrack, crossfade time.Duration) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "TITLE %s\n", cueQuote(strings.TrimSuffix(filepath.Base(audioFile), filepath.Ext(audioFile))))
+    fmt.Fprintf(&b, "FILE %s MP3\n", cueQuote(filepath.Base(audioFile)))
+    var offset time.Duration
+    for i, t := range tracks {
+        fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+        fmt.Fprintf(&b, "    TITLE %s\n", cueQuote(t.Title))
+        fmt.Fprintf(&b, "    PERFORMER %s\n", cueQuote(t.Artist))
+        fmt.Fprintf(&b, "    INDEX 01 %s\n", cueTime(offset))
+        offset += t.Duration - crossfade
+    }
+    if err := ioutil.WriteFile(cueFile, []byte(b.String()), 0644); err != nil {
+        return fmt.Errorf("failed to write cue sheet: %v", err)
+    }
+    return nil
+}
+
+// cueTime formats an offset as mm:ss:ff with 75 frames per second
+func cueTime(d time.Duration) string {
+    frames := d.Milliseconds() * 75 / 1000
+    return fmt.Sprintf("%02d:%02d:%02d", frames/(75*60), frames/75%60, frames%75)
+}
+
+func cueQuote(s string) string {
+    return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+    "crypto/sha1"
+    "fmt"
+    "net/url"
+    "os"
+    "time"
+)
+
+// With mpris (on by default) pianotrap shows up on the session bus as an MPRIS media
+// player, org.mpris.MediaPlayer2.pianotrap, so desktop widgets, media keys and the
+// GNOME and KDE media controls show what is playing and can pause and skip. Play,
+// pause and next type pianobar's keys; there is no going back or seeking on Pandora.
+
+const (
+    mprisPath   = dbusObjectPath("/org/mpris/MediaPlayer2")
+    mprisRoot   = "org.mpris.MediaPlayer2"
+    mprisPlayer = "org.mpris.MediaPlayer2.Player"
+    dbusProps   = "org.freedesktop.DBus.Properties"
+    mprisNoSong = dbusObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+)
+
+const mprisIntrospection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect"><arg name="data" type="s" direction="out"/></method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get"><arg name="interface" type="s" direction="in"/><arg name="property" type="s" direction="in"/><arg name="value" type="v" direction="out"/></method>
+    <method name="GetAll"><arg name="interface" type="s" direction="in"/><arg name="properties" type="a{sv}" direction="out"/></method>
+    <method name="Set"><arg name="interface" type="s" direction="in"/><arg name="property" type="s" direction="in"/><arg name="value" type="v" direction="in"/></method>
+    <signal name="PropertiesChanged"><arg name="interface" type="s"/><arg name="changed" type="a{sv}"/><arg name="invalidated" type="as"/></signal>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2">
+    <method name="Raise"/>
+    <method name="Quit"/>
+    <property name="CanQuit" type="b" access="read"/>
+    <property name="CanRaise" type="b" access="read"/>
+    <property name="HasTrackList" type="b" access="read"/>
+    <property name="Identity" type="s" access="read"/>
+    <property name="SupportedUriSchemes" type="as" access="read"/>
+    <property name="SupportedMimeTypes" type="as" access="read"/>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2.Player">
+    <method name="Next"/>
+    <method name="Previous"/>
+    <method name="Pause"/>
+    <method name="PlayPause"/>
+    <method name="Stop"/>
+    <method name="Play"/>
+    <method name="Seek"><arg name="Offset" type="x" direction="in"/></method>
+    <method name="SetPosition"><arg name="TrackId" type="o" direction="in"/><arg name="Position" type="x" direction="in"/></method>
+    <method name="OpenUri"><arg name="Uri" type="s" direction="in"/></method>
+    <signal name="Seeked"><arg name="Position" type="x"/></signal>
+    <property name="PlaybackStatus" type="s" access="read"/>
+    <property name="Rate" type="d" access="read"/>
+    <property name="Metadata" type="a{sv}" access="read"/>
+    <property name="Volume" type="d" access="read"/>
+    <property name="Position" type="x" access="read"/>
+    <property name="MinimumRate" type="d" access="read"/>
+    <property name="MaximumRate" type="d" access="read"/>
+    <property name="CanGoNext" type="b" access="read"/>
+    <property name="CanGoPrevious" type="b" access="read"/>
+    <property name="CanPlay" type="b" access="read"/>
+    <property name="CanPause" type="b" access="read"/>
+    <property name="CanSeek" type="b" access="read"/>
+    <property name="CanControl" type="b" access="read"/>
+  </interface>
+</node>`
+
+// mprisMetadata describes the song of s
+func mprisMetadata(s playerStatus) map[string]dbusVariant {
+    if s.Title == "" {
+        return map[string]dbusVariant{"mpris:trackid": {mprisNoSong}}
+    }
+    metadata := map[string]dbusVariant{
+        "mpris:trackid": {dbusObjectPath(fmt.Sprintf("/org/pianotrap/song/%x", sha1.Sum([]byte(songKey(s.Title, s.Artist)))))},
+        "xesam:title":   {s.Title},
+        "xesam:artist":  {[]string{s.Artist}},
+        "xesam:album":   {s.Album},
+    }
+    if s.Total > 0 {
+        metadata["mpris:length"] = dbusVariant{int64(s.Total) * 1000000}
+    }
+    // CoverArt is the copy downloaded at songstart
+    if s.CoverArt != "" {
+        metadata["mpris:artUrl"] = dbusVariant{(&url.URL{Scheme: "file", Path: s.CoverArt}).String()}
+    }
+    if s.Loved {
+        metadata["xesam:userRating"] = dbusVariant{1.0}
+    }
+    return metadata
+}
+
+// mprisProperties returns the properties of an MPRIS interface for status s
+func mprisProperties(iface string, s playerStatus) map[string]dbusVariant {
+    switch iface {
+    case mprisRoot:
+        return map[string]dbusVariant{
+            "CanQuit":             {false},
+            "CanRaise":            {false},
+            "HasTrackList":        {false},
+            "Identity":            {"pianotrap"},
+            "SupportedUriSchemes": {[]string{}},
+            "SupportedMimeTypes":  {[]string{}},
+        }
+    case mprisPlayer:
+        playback := "Stopped"
+        switch s.State {
+        case "playing":
+            playback = "Playing"
+        case "paused":
+            playback = "Paused"
+        }
+        return map[string]dbusVariant{
+            "PlaybackStatus": {playback},
+            "Rate":           {1.0},
+            "MinimumRate":    {1.0},
+            "MaximumRate":    {1.0},
+            "Volume":         {1.0},
+            "Metadata":       {mprisMetadata(s)},
+            "Position":       {int64(s.Total-s.Remaining) * 1000000},
+            "CanGoNext":      {true},
+            "CanGoPrevious":  {false},
+            "CanPlay":        {true},
+            "CanPause":       {true},
+            "CanSeek":        {false},
+            "CanControl":     {true},
+        }
+    }
+    return nil
+}
+
+// mprisCall answers a method call on the MPRIS object
+func mprisCall(m *dbusMessage) ([]interface{}, string, string) {
+    if m.Path != mprisPath {
+        return nil, "org.freedesktop.DBus.Error.UnknownObject", fmt.Sprintf("no object %s", m.Path)
+    }
+    arg := func(i int) string {
+        if i < len(m.Body) {
+            s, _ := m.Body[i].(string)
+            return s
+        }
+        return ""
+    }
+    press := func(command string) ([]interface{}, string, string) {
+        if err := sendKeys(mqttCommands[command]); err != nil {
+            return nil, "org.mpris.MediaPlayer2.Error", err.Error()
+        }
+        return nil, "", ""
+    }
+    state := currentStatus().State
+
+    switch m.Interface + "." + m.Member {
+    case "org.freedesktop.DBus.Introspectable.Introspect":
+        return []interface{}{mprisIntrospection}, "", ""
+    case "org.freedesktop.DBus.Peer.Ping":
+        return nil, "", ""
+    case dbusProps + ".Get":
+        value, ok := mprisProperties(arg(0), currentStatus())[arg(1)]
+        if !ok {
+            return nil, "org.freedesktop.DBus.Error.UnknownProperty", fmt.Sprintf("no property %s.%s", arg(0), arg(1))
+        }
+        return []interface{}{value}, "", ""
+    case dbusProps + ".GetAll":
+        props := mprisProperties(arg(0), currentStatus())
+        if props == nil {
+            props = map[string]dbusVariant{}
+        }
+        return []interface{}{props}, "", ""
+    case dbusProps + ".Set":
+        return nil, "org.freedesktop.DBus.Error.PropertyReadOnly", "pianotrap's properties are read-only"
+    case mprisRoot + ".Raise", mprisRoot + ".Quit", mprisPlayer + ".Previous", mprisPlayer + ".Seek", mprisPlayer + ".SetPosition":
+        return nil, "", ""
+    case mprisPlayer + ".PlayPause":
+        return press("pause")
+    case mprisPlayer + ".Play":
+        if state == "paused" {
+            return press("pause")
+        }
+        return nil, "", ""
+    case mprisPlayer + ".Pause", mprisPlayer + ".Stop":
+        if state == "playing" {
+            return press("pause")
+        }
+        return nil, "", ""
+    case mprisPlayer + ".Next":
+        return press("skip")
+    case mprisPlayer + ".OpenUri":
+        return nil, "org.freedesktop.DBus.Error.NotSupported", "pianotrap plays Pandora stations only"
+    }
+    return nil, "org.freedesktop.DBus.Error.UnknownMethod", fmt.Sprintf("no method %s.%s", m.Interface, m.Member)
+}
+
+// mprisSignalLoop sends PropertiesChanged whenever the player's properties change;
+// like in the spec, the position moving along isn't a change
+func mprisSignalLoop(conn *dbusConn, stop chan struct{}) {
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+    last := make(map[string]string)
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+        }
+        changed := make(map[string]dbusVariant)
+        for name, value := range mprisProperties(mprisPlayer, currentStatus()) {
+            if name == "Position" {
+                continue
+            }
+            if s := fmt.Sprint(value); s != last[name] {
+                changed[name] = value
+                last[name] = s
+            }
+        }
+        if len(changed) == 0 {
+            continue
+        }
+        signal := &dbusMessage{Type: dbusSignal, Path: mprisPath, Interface: dbusProps, Member: "PropertiesChanged",
+            Body: []interface{}{mprisPlayer, changed, []string{}}}
+        if _, err := conn.send(signal); err != nil {
+            return
+        }
+    }
+}
+
+// startMPRIS registers pianotrap as a media player on the session bus, if there is
+// one, for the rest of the session
+func startMPRIS(cfg Config) {
+    if !cfg.MPRIS {
+        return
+    }
+    conn, err := dialSessionBus()
+    if err != nil {
+        logger.Printf("MPRIS unavailable: %v", err)
+        return
+    }
+    // A second pianotrap takes an instance name, as the spec suggests
+    base, name := "org.mpris.MediaPlayer2.pianotrap", ""
+    for _, candidate := range []string{base, fmt.Sprintf("%s.instance%d", base, os.Getpid())} {
+        reply, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", candidate, uint32(4))
+        if err != nil {
+            logger.Printf("MPRIS unavailable: %v", err)
+            conn.Close()
+            return
+        }
+        // 1: primary owner
+        if len(reply) == 1 && reply[0] == uint32(1) {
+            name = candidate
+            break
+        }
+    }
+    if name == "" {
+        logger.Printf("MPRIS unavailable: %s is taken", base)
+        conn.Close()
+        return
+    }
+    logger.Printf("MPRIS: registered as %s", name)
+    stop := make(chan struct{})
+    go mprisSignalLoop(conn, stop)
+    go func() {
+        err := conn.Serve(mprisCall)
+        close(stop)
+        conn.Close()
+        logger.Printf("MPRIS: session bus connection lost: %v", err)
+    }()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "net/url"
+    "testing"
+)
+
+func TestMPRIS(t *testing.T) {
+    art := playTestSong(t, "So What", "Miles Davis")
+    s := currentStatus()
+    s.Album, s.Loved, s.Total, s.Remaining = "Kind of Blue", true, 545, 75
+    props := mprisProperties(mprisPlayer, s)
+    if props["PlaybackStatus"].Value != "Paused" || props["Position"].Value != int64(470000000) {
+        t.Errorf("player properties = %v", props)
+    }
+    metadata := props["Metadata"].Value.(map[string]dbusVariant)
+    artURL, err := url.Parse(metadata["mpris:artUrl"].Value.(string))
+    if err != nil || artURL.Scheme != "file" || metadata["xesam:userRating"].Value != 1.0 {
+        t.Fatalf("metadata = %v", metadata)
+    }
+    if got, err := ioutil.ReadFile(artURL.Path); err != nil || !bytes.Equal(got, art) {
+        t.Errorf("art at %s = %q, %v", artURL, got, err)
+    }
+    if got := mprisMetadata(playerStatus{State: "idle"}); len(got) != 1 || got["mpris:trackid"].Value != mprisNoSong {
+        t.Errorf("idle metadata = %v", got)
+    }
+
+    call := func(iface, member string, args ...interface{}) ([]interface{}, string) {
+        body, errName, _ := mprisCall(&dbusMessage{Type: dbusMethodCall, Path: mprisPath, Interface: iface, Member: member, Body: args})
+        return body, errName
+    }
+    if body, errName := call(dbusProps, "Get", mprisRoot, "Identity"); errName != "" || body[0] != (dbusVariant{"pianotrap"}) {
+        t.Errorf("Identity = %v %s", body, errName)
+    }
+    if _, errName := call(dbusProps, "Get", mprisRoot, "Volume"); errName != "org.freedesktop.DBus.Error.UnknownProperty" {
+        t.Errorf("unknown property answered %q", errName)
+    }
+    if _, errName := call(mprisPlayer, "Next"); errName != "org.mpris.MediaPlayer2.Error" {
+        t.Errorf("Next without pianobar answered %q", errName)
+    }
+    // With nothing playing there's nothing to pause
+    if _, errName := call(mprisPlayer, "Pause"); errName != "" {
+        t.Errorf("Pause answered %q", errName)
+    }
+    if _, errName := call(mprisPlayer, "Rewind"); errName != "org.freedesktop.DBus.Error.UnknownMethod" {
+        t.Errorf("unknown method answered %q", errName)
+    }
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+    "bufio"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A minimal MQTT 3.1.1 client (QoS 0 only), enough to publish pianotrap's state and
+// Home Assistant discovery messages and to receive remote commands
+
+const (
+    mqttConnect    = 0x10
+    mqttConnAck    = 0x20
+    mqttPublish    = 0x30
+    mqttSubscribe  = 0x82
+    mqttSubAck     = 0x90
+    mqttPingReq    = 0xc0
+    mqttPingResp   = 0xd0
+    mqttDisconnect = 0xe0
+    mqttKeepAlive  = 60 * time.Second
+)
+
+type mqttClient struct {
+    conn   net.Conn
+    reader *bufio.Reader
+    wmu    sync.Mutex
+}
+
+// mqttMessage is an application message received from the broker
+type mqttMessage struct {
+    Topic   string
+    Payload []byte
+}
+
+func mqttString(s string) []byte {
+    return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttPacket frames a control packet with its variable-length remaining length
+func mqttPacket(header byte, body []byte) []byte {
+    packet := []byte{header}
+    n := len(body)
+    for {
+        b := byte(n % 128)
+        n /= 128
+        if n > 0 {
+            b |= 0x80
+        }
+        packet = append(packet, b)
+        if n == 0 {
+            break
+        }
+    }
+    return append(packet, body...)
+}
+
+// dialMQTT connects to a tcp:// (or mqtt://) or ssl:// (or mqtts://) broker URL and
+// registers a retained last-will message
+func dialMQTT(broker, clientID, username, password, willTopic, willPayload string) (*mqttClient, error) {
+    u, err := url.Parse(broker)
+    if err != nil || u.Host == "" {
+        return nil, fmt.Errorf("invalid MQTT broker URL %q", broker)
+    }
+    var conn net.Conn
+    switch u.Scheme {
+    case "tcp", "mqtt":
+        host := u.Host
+        if u.Port() == "" {
+            host = net.JoinHostPort(u.Hostname(), "1883")
+        }
+        conn, err = net.DialTimeout("tcp", host, 10*time.Second)
+    case "ssl", "tls", "mqtts":
+        host := u.Host
+        if u.Port() == "" {
+            host = net.JoinHostPort(u.Hostname(), "8883")
+        }
+        conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+    default:
+        return nil, fmt.Errorf("unsupported MQTT broker scheme %q", u.Scheme)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to MQTT broker: %v", err)
+    }
+
+    flags := byte(0x02) // clean session
+    if willTopic != "" {
+        flags |= 0x04 | 0x20 // will flag, will retain
+    }
+    if username != "" {
+        flags |= 0x80
+        if password != "" {
+            flags |= 0x40
+        }
+    }
+    body := mqttString("MQTT")
+    body = append(body, 4, flags, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+    body = append(body, mqttString(clientID)...)
+    if willTopic != "" {
+        body = append(body, mqttString(willTopic)...)
+        body = append(body, mqttString(willPayload)...)
+    }
+    if username != "" {
+        body = append(body, mqttString(username)...)
+        if password != "" {
+            body = append(body, mqttString(password)...)
+        }
+    }
+
+    c := &mqttClient{conn: conn, reader: bufio.NewReader(conn)}
+    conn.SetDeadline(time.Now().Add(10 * time.Second))
+    if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("failed to send MQTT connect: %v", err)
+    }
+    header, ack, err := c.readPacket()
+    if err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("failed to read MQTT connack: %v", err)
+    }
+    if header != mqttConnAck || len(ack) != 2 || ack[1] != 0 {
+        conn.Close()
+        return nil, fmt.Errorf("MQTT broker refused connection (code %v)", ack)
+    }
+    conn.SetDeadline(time.Time{})
+    return c, nil
+}
+
+func (c *mqttClient) write(packet []byte) error {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+    _, err := c.conn.Write(packet)
+    return err
+}
+
+func (c *mqttClient) readPacket() (byte, []byte, error) {
+    header, err := c.reader.ReadByte()
+    if err != nil {
+        return 0, nil, err
+    }
+    length, multiplier := 0, 1
+    for i := 0; ; i++ {
+        b, err := c.reader.ReadByte()
+        if err != nil {
+            return 0, nil, err
+        }
+        length += int(b&0x7f) * multiplier
+        multiplier *= 128
+        if b&0x80 == 0 {
+            break
+        }
+        if i == 3 {
+            return 0, nil, errors.New("malformed MQTT packet length")
+        }
+    }
+    body := make([]byte, length)
+    if _, err := io.ReadFull(c.reader, body); err != nil {
+        return 0, nil, err
+    }
+    return header, body, nil
+}
+
+// Publish sends a QoS 0 message
+func (c *mqttClient) Publish(topic string, payload []byte, retain bool) error {
+    header := byte(mqttPublish)
+    if retain {
+        header |= 0x01
+    }
+    body := append(mqttString(topic), payload...)
+    return c.write(mqttPacket(header, body))
+}
+
+// Subscribe asks for QoS 0 delivery of topic filters, in one packet so there is only
+// ever one packet identifier in use
+func (c *mqttClient) Subscribe(topics ...string) error {
+    body := []byte{0, 1} // packet identifier
+    for _, topic := range topics {
+        body = append(body, mqttString(topic)...)
+        body = append(body, 0)
+    }
+    return c.write(mqttPacket(mqttSubscribe, body))
+}
+
+// Run pings the broker and delivers incoming messages until the connection fails
+func (c *mqttClient) Run(onMessage func(mqttMessage)) error {
+    stop := make(chan struct{})
+    defer close(stop)
+    go func() {
+        ticker := time.NewTicker(mqttKeepAlive / 2)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                if err := c.write([]byte{mqttPingReq, 0}); err != nil {
+                    c.conn.Close()
+                    return
+                }
+            }
+        }
+    }()
+    for {
+        c.conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+        header, body, err := c.readPacket()
+        if err != nil {
+            return err
+        }
+        if header&0xf0 == mqttPublish && len(body) >= 2 {
+            n := int(body[0])<<8 | int(body[1])
+            if 2+n > len(body) {
+                continue
+            }
+            payload := body[2+n:]
+            if (header>>1)&0x03 > 0 && len(payload) >= 2 {
+                payload = payload[2:] // skip the packet identifier of QoS 1/2 deliveries
+            }
+            onMessage(mqttMessage{Topic: string(body[2 : 2+n]), Payload: payload})
+        }
+    }
+}
+
+// Close disconnects cleanly
+func (c *mqttClient) Close() {
+    c.write([]byte{mqttDisconnect, 0})
+    c.conn.Close()
+}
+
+// Home Assistant integration
+
+// loadMQTTConfig reads the mqtt_* options
+func loadMQTTConfig(values map[string]string, cfg *Config) {
+    cfg.MQTTBroker = values["mqtt_broker"]
+    cfg.MQTTUsername = values["mqtt_username"]
+    cfg.MQTTPassword = values["mqtt_password"]
+    cfg.MQTTTopic = values["mqtt_topic"]
+    if cfg.MQTTTopic == "" {
+        cfg.MQTTTopic = "pianotrap"
+    }
+    cfg.MQTTDiscoveryPrefix = values["mqtt_discovery_prefix"]
+    if cfg.MQTTDiscoveryPrefix == "" {
+        cfg.MQTTDiscoveryPrefix = "homeassistant"
+    }
+    cfg.MQTTNodeID = values["mqtt_node_id"]
+    if cfg.MQTTNodeID == "" {
+        hostname, _ := os.Hostname()
+        cfg.MQTTNodeID = hostname
+    }
+    cfg.MQTTNodeID = strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+            return r
+        }
+        return '_'
+    }, cfg.MQTTNodeID)
+}
+
+// mqttCommands maps command payloads to pianobar keys
+var mqttCommands = map[string]string{
+    "skip":  "n",
+    "pause": "p",
+    "love":  "+",
+}
+
+// mqttReload wakes the broker connection when a reload changes the MQTT options
+var mqttReload = make(chan struct{}, 1)
+
+// mqttSettings are the options a broker connection is made with
+func mqttSettings(cfg Config) [6]string {
+    return [6]string{cfg.MQTTBroker, cfg.MQTTUsername, cfg.MQTTPassword, cfg.MQTTTopic, cfg.MQTTDiscoveryPrefix, cfg.MQTTNodeID}
+}
+
+// reloadMQTT makes the broker connection start over with the options in use
+func reloadMQTT() {
+    select {
+    case mqttReload <- struct{}{}:
+    default:
+    }
+}
+
+// startMQTT keeps a broker connection alive for the whole session, publishing the
+// discovery config, availability and player status. Each connection is made with
+// the config in use, and a reload that changes the MQTT options reconnects.
+func startMQTT(cfg Config) {
+    // wait sleeps for d, or until a reload
+    wait := func(d time.Duration) {
+        select {
+        case <-time.After(d):
+        case <-mqttReload:
+        }
+    }
+    go func() {
+        backoff := 5 * time.Second
+        for ; ; cfg = currentConfig() {
+            if cfg.MQTTBroker == "" {
+                // Off until a reload sets a broker
+                <-mqttReload
+                continue
+            }
+            base := cfg.MQTTTopic + "/" + cfg.MQTTNodeID
+            client, err := dialMQTT(cfg.MQTTBroker, "pianotrap-"+cfg.MQTTNodeID, cfg.MQTTUsername, cfg.MQTTPassword, base+"/availability", "offline")
+            if err != nil {
+                logger.Printf("MQTT: %v, retrying in %v", err, backoff)
+                wait(backoff)
+                backoff = min(backoff*2, time.Minute)
+                continue
+            }
+            backoff = 5 * time.Second
+            logger.Printf("MQTT: connected to %s", cfg.MQTTBroker)
+
+            publishDiscovery(client, cfg, base)
+            client.Publish(base+"/availability", []byte("online"), true)
+            client.Subscribe(base+"/command", base+"/set")
+            client.Publish(base+"/settings", settingsJSON(), true)
+
+            stop := make(chan struct{})
+            go func() {
+                select {
+                case <-stop:
+                case <-mqttReload:
+                    // A clean disconnect skips the will, so say so first
+                    logger.Printf("MQTT: options changed, reconnecting")
+                    client.Publish(base+"/availability", []byte("offline"), true)
+                    client.Close()
+                }
+            }()
+            go publishStatusLoop(client, base, stop)
+            go publishBoundaries(client, base, stop)
+            go publishEvents(client, base, stop)
+            err = client.Run(func(msg mqttMessage) {
+                if msg.Topic == base+"/set" {
+                    if err := handleSetCommand(string(msg.Payload), cfg); err != nil {
+                        logger.Printf("MQTT: %v", err)
+                        return
+                    }
+                    client.Publish(base+"/settings", settingsJSON(), true)
+                    return
+                }
+                command := strings.TrimSpace(string(msg.Payload))
+                keys, ok := mqttCommands[command]
+                if !ok {
+                    logger.Printf("MQTT: unknown command %q", command)
+                    return
+                }
+                logger.Printf("MQTT: command %q", command)
+                if err := sendKeys(keys); err != nil {
+                    logger.Printf("MQTT: %v", err)
+                }
+            })
+            close(stop)
+            client.Close()
+            logger.Printf("MQTT: connection lost: %v", err)
+            wait(backoff)
+        }
+    }()
+}
+
+// publishStatusLoop publishes the player status whenever it changes, and at least every
+// ten seconds so the remaining time stays fresh
+func publishStatusLoop(client *mqttClient, base string, stop chan struct{}) {
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+    var last string
+    var lastSent time.Time
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+        }
+        status := currentStatus()
+        compare := status
+        compare.Remaining = 0
+        key, _ := json.Marshal(compare)
+        if string(key) == last && time.Since(lastSent) < 10*time.Second {
+            continue
+        }
+        payload, _ := json.Marshal(status)
+        if err := client.Publish(base+"/status", payload, true); err != nil {
+            return
+        }
+        last, lastSent = string(key), time.Now()
+    }
+}
+
+// publishEvents sends pianotrap's events to MQTT until stop is closed, for the event
+// entity
+func publishEvents(client *mqttClient, base string, stop chan struct{}) {
+    events := make(chan grpcEvent, 16)
+    eventWatchersMu.Lock()
+    eventWatchers[events] = true
+    eventWatchersMu.Unlock()
+    defer func() {
+        eventWatchersMu.Lock()
+        delete(eventWatchers, events)
+        eventWatchersMu.Unlock()
+    }()
+    for {
+        select {
+        case <-stop:
+            return
+        case e := <-events:
+            payload, _ := json.Marshal(map[string]interface{}{"event_type": e.Name, "message": e.Msg, "time": e.At.UTC()})
+            if err := client.Publish(base+"/event", payload, false); err != nil {
+                return
+            }
+        }
+    }
+}
+
+// publishDiscovery announces pianotrap's entities to Home Assistant
+func publishDiscovery(client *mqttClient, cfg Config, base string) {
+    for _, msg := range discoveryMessages(cfg, base) {
+        if err := client.Publish(msg.Topic, msg.Payload, true); err != nil {
+            logger.Printf("MQTT: failed to publish discovery to %s: %v", msg.Topic, err)
+        }
+    }
+}
+
+// discoveryMessages are the discovery configs of pianotrap's entities, by topic
+func discoveryMessages(cfg Config, base string) []mqttMessage {
+    device := map[string]interface{}{
+        "identifiers":  []string{"pianotrap_" + cfg.MQTTNodeID},
+        "name":         "pianotrap " + cfg.MQTTNodeID,
+        "manufacturer": "pianotrap",
+        "model":        "Pianobar recorder",
+    }
+    entities := []struct {
+        component string
+        object    string
+        config    map[string]interface{}
+    }{
+        {"sensor", "state", map[string]interface{}{
+            "name":                  "Player state",
+            "icon":                  "mdi:radio",
+            "state_topic":           base + "/status",
+            "value_template":        "{{ value_json.state }}",
+            "json_attributes_topic": base + "/status",
+        }},
+        {"sensor", "now_playing", map[string]interface{}{
+            "name":           "Now playing",
+            "icon":           "mdi:music",
+            "state_topic":    base + "/status",
+            "value_template": "{% if value_json.title %}{{ value_json.artist }} - {{ value_json.title }}{% else %}Nothing{% endif %}",
+        }},
+        {"sensor", "station", map[string]interface{}{
+            "name":           "Station",
+            "icon":           "mdi:radio-tower",
+            "state_topic":    base + "/status",
+            "value_template": "{{ value_json.station or 'None' }}",
+        }},
+        {"binary_sensor", "recording", map[string]interface{}{
+            "name":           "Recording",
+            "icon":           "mdi:record-rec",
+            "device_class":   "running",
+            "state_topic":    base + "/status",
+            "value_template": "{{ 'ON' if value_json.recording and not value_json.recording_paused else 'OFF' }}",
+        }},
+        {"event", "events", map[string]interface{}{
+            "name":        "Recorder event",
+            "icon":        "mdi:bell-ring",
+            "state_topic": base + "/event",
+            "event_types": []string{eventStation, eventDetected, eventRecording, eventSaved, eventDeleted, eventFailed},
+        }},
+        {"button", "skip", map[string]interface{}{
+            "name":          "Skip",
+            "icon":          "mdi:skip-next",
+            "command_topic": base + "/command",
+            "payload_press": "skip",
+        }},
+        {"button", "pause", map[string]interface{}{
+            "name":          "Play/pause",
+            "icon":          "mdi:play-pause",
+            "command_topic": base + "/command",
+            "payload_press": "pause",
+        }},
+        {"button", "love", map[string]interface{}{
+            "name":          "Love song",
+            "icon":          "mdi:heart",
+            "command_topic": base + "/command",
+            "payload_press": "love",
+        }},
+    }
+    var messages []mqttMessage
+    for _, e := range entities {
+        e.config["unique_id"] = fmt.Sprintf("pianotrap_%s_%s", cfg.MQTTNodeID, e.object)
+        e.config["availability_topic"] = base + "/availability"
+        e.config["device"] = device
+        payload, _ := json.Marshal(e.config)
+        topic := fmt.Sprintf("%s/%s/pianotrap_%s/%s/config", cfg.MQTTDiscoveryPrefix, e.component, cfg.MQTTNodeID, e.object)
+        messages = append(messages, mqttMessage{topic, payload})
+    }
+    return messages
+}
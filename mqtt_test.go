@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "encoding/json"
+    "net"
+    "testing"
+    "time"
+)
+
+func TestMQTTDiscovery(t *testing.T) {
+    cfg := Config{MQTTDiscoveryPrefix: "homeassistant", MQTTNodeID: "den"}
+    configs := make(map[string]map[string]interface{})
+    for _, msg := range discoveryMessages(cfg, "pianotrap/den") {
+        var config map[string]interface{}
+        if err := json.Unmarshal(msg.Payload, &config); err != nil {
+            t.Fatalf("%s: %v", msg.Topic, err)
+        }
+        configs[msg.Topic] = config
+    }
+    recording := configs["homeassistant/binary_sensor/pianotrap_den/recording/config"]
+    if recording["state_topic"] != "pianotrap/den/status" || recording["unique_id"] != "pianotrap_den_recording" {
+        t.Errorf("recording = %v", recording)
+    }
+    events := configs["homeassistant/event/pianotrap_den/events/config"]
+    if events["state_topic"] != "pianotrap/den/event" || len(events["event_types"].([]interface{})) != 6 {
+        t.Errorf("events = %v", events)
+    }
+    if configs["homeassistant/sensor/pianotrap_den/station/config"] == nil || configs["homeassistant/button/pianotrap_den/skip/config"] == nil {
+        t.Errorf("missing entities in %v", configs)
+    }
+
+    // Events reach the event entity's topic
+    local, remote := net.Pipe()
+    defer local.Close()
+    stop := make(chan struct{})
+    defer close(stop)
+    go publishEvents(&mqttClient{conn: local}, "pianotrap/den", stop)
+    broker := &mqttClient{conn: remote, reader: bufio.NewReader(remote)}
+    for {
+        broadcastEvent(eventSaved, "/music/So What.mp3")
+        remote.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+        header, body, err := broker.readPacket()
+        if err != nil {
+            // Not watching yet
+            continue
+        }
+        topicLength := int(binary.BigEndian.Uint16(body))
+        var payload map[string]interface{}
+        json.Unmarshal(body[2+topicLength:], &payload)
+        if header != mqttPublish || string(body[2:2+topicLength]) != "pianotrap/den/event" || payload["event_type"] != "saved" || payload["message"] != "/music/So What.mp3" {
+            t.Errorf("published %x %s", header, body)
+        }
+        break
+    }
+}
+
+func TestMQTTSubscribe(t *testing.T) {
+    local, remote := net.Pipe()
+    defer local.Close()
+    go (&mqttClient{conn: local}).Subscribe("pianotrap/den/command", "pianotrap/den/set")
+    broker := &mqttClient{conn: remote, reader: bufio.NewReader(remote)}
+    remote.SetReadDeadline(time.Now().Add(time.Second))
+    header, body, err := broker.readPacket()
+    if err != nil {
+        t.Fatal(err)
+    }
+    // One packet identifier, then each filter with its QoS
+    want := append(append([]byte{0, 1}, mqttString("pianotrap/den/command")...), 0)
+    want = append(append(want, mqttString("pianotrap/den/set")...), 0)
+    if header != mqttSubscribe || string(body) != string(want) {
+        t.Errorf("subscribed with %x %q", header, body)
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "unicode/utf16"
+    "unicode/utf8"
+)
+
+// File name profiles adapt recording names to the file system they are saved on:
+// which characters are replaced, Windows' rules about trailing dots and reserved
+// device names, Unicode normalization and the maximum name length.
+
+// fileNameProfile describes the rules of one target file system
+type fileNameProfile struct {
+    Name      string
+    Unsafe    *regexp.Regexp
+    Windows   bool   // trim trailing dots and spaces, avoid CON, NUL and the like
+    ASCII     bool   // strip accents and replace anything else outside ASCII
+    Normalize string // "nfc", "nfd" or "" to leave names as they are
+    MaxLength int    // per name, in bytes (UTF-16 units with Windows)
+}
+
+var fileNameProfiles = map[string]fileNameProfile{
+    "default": {Name: "default", Unsafe: unsafeFileCharsRe, MaxLength: 255},
+    "linux":   {Name: "linux", Unsafe: regexp.MustCompile(`[/\x00-\x1f\x7f]`), Normalize: "nfc", MaxLength: 255},
+    "windows": {Name: "windows", Unsafe: unsafeFileCharsRe, Windows: true, Normalize: "nfc", MaxLength: 255},
+    "ascii":   {Name: "ascii", Unsafe: unsafeFileCharsRe, Windows: true, ASCII: true, MaxLength: 255},
+}
+
+// nameProfile is the profile in use
+var nameProfile = fileNameProfiles["default"]
+
+// loadFileNameConfig reads filename_profile, filename_normalization and max_filename_length
+func loadFileNameConfig(values map[string]string, cfg *Config) error {
+    name := values["filename_profile"]
+    if name == "" {
+        name = "default"
+    }
+    profile, ok := fileNameProfiles[name]
+    if !ok {
+        return fmt.Errorf("invalid value for filename_profile: %q (use default, linux, windows or ascii)", name)
+    }
+    switch raw := values["filename_normalization"]; raw {
+    case "":
+    case "none":
+        profile.Normalize = ""
+    case "nfc", "nfd":
+        profile.Normalize = raw
+    default:
+        return fmt.Errorf("invalid value for filename_normalization: %q (use nfc, nfd or none)", raw)
+    }
+    n, err := configInt(values, "max_filename_length", profile.MaxLength)
+    if err != nil || n < 32 || n > 255 {
+        return fmt.Errorf("invalid value for max_filename_length: %q (32-255)", values["max_filename_length"])
+    }
+    profile.MaxLength = n
+    cfg.FileNames = profile
+    return nil
+}
+
+// windowsReserved are device names Windows won't create files for, with any extension
+var windowsReserved = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])(\..*)?$`)
+
+// asciiFallbacks spell out letters that aren't a base letter plus an accent
+var asciiFallbacks = strings.NewReplacer("ß", "ss", "Æ", "AE", "æ", "ae", "Ø", "O", "ø", "o", "Œ", "OE", "œ", "oe",
+    "Ł", "L", "ł", "l", "Đ", "D", "đ", "d", "Þ", "Th", "þ", "th", "‘", "'", "’", "'", "“", "_", "”", "_", "–", "-", "—", "-", "…", "...")
+
+// sanitizeName applies a profile to s, one path element
+func (p fileNameProfile) sanitizeName(s string) string {
+    switch {
+    case p.ASCII:
+        s = foldToASCII(s)
+    case p.Normalize == "nfc":
+        s = composeAccents(s)
+    case p.Normalize == "nfd":
+        s = decomposeAccents(s)
+    }
+    s = p.Unsafe.ReplaceAllString(s, "_")
+    if p.Windows {
+        s = strings.TrimRight(s, ". ")
+        if windowsReserved.MatchString(s) {
+            s = "_" + s
+        }
+    }
+    if strings.Trim(s, ".") == "" {
+        s = strings.Repeat("_", max(len(s), 1))
+    }
+    return p.truncate(s)
+}
+
+// nameLength measures a name the way the profile's file system does
+func (p fileNameProfile) nameLength(s string) int {
+    if p.Windows {
+        return len(utf16.Encode([]rune(s)))
+    }
+    return len(s)
+}
+
+// truncate shortens an overlong name, keeping its extension and leaving room for
+// the .partial suffix recordings carry while they are written
+func (p fileNameProfile) truncate(s string) string {
+    limit := p.MaxLength - len(partialExt)
+    if limit <= 0 || p.nameLength(s) <= limit {
+        return s
+    }
+    ext := filepath.Ext(s)
+    if len(ext) > 6 || p.nameLength(ext) >= limit {
+        ext = ""
+    }
+    stem := strings.TrimSuffix(s, ext)
+    for p.nameLength(stem)+p.nameLength(ext) > limit {
+        _, size := utf8.DecodeLastRuneInString(stem)
+        stem = stem[:len(stem)-size]
+    }
+    if p.Windows {
+        stem = strings.TrimRight(stem, ". ")
+    }
+    return stem + ext
+}
+
+// Composition pairs for the accented Latin letters found in music metadata: each
+// string lists precomposed letters, followed by their base letters and the accent.
+var accentTable = []struct {
+    composed, bases string
+    mark            rune
+}{
+    {"ÀÈÌÒÙàèìòù", "AEIOUaeiou", '\u0300'},
+    {"ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹź", "AEIOUYaeiouyCcLlNnRrSsZz", '\u0301'},
+    {"ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷ", "AEIOUaeiouCcGgHhJjSsWwYy", '\u0302'},
+    {"ÃÑÕãñõĨĩŨũ", "ANOanoIiUu", '\u0303'},
+    {"ĀāĒēĪīŌōŪū", "AaEeIiOoUu", '\u0304'},
+    {"ĂăĞğŬŭ", "AaGgUu", '\u0306'},
+    {"ĖėĠġŻżİ", "EeGgZzI", '\u0307'},
+    {"ÄËÏÖÜäëïöüÿŸ", "AEIOUaeiouyY", '\u0308'},
+    {"ÅåŮů", "AaUu", '\u030a'},
+    {"ŐőŰű", "OoUu", '\u030b'},
+    {"ČčĎďĚěŇňŘřŠšŤťŽžǍǎǏǐǑǒǓǔ", "CcDdEeNnRrSsTtZzAaIiOoUu", '\u030c'},
+    {"ÇçĢģĶķĻļŅņŖŗŞşŢţ", "CcGgKkLlNnRrSsTt", '\u0327'},
+    {"ĄąĘęĮįŲų", "AaEeIiUu", '\u0328'},
+}
+
+var (
+    composeMap   = make(map[[2]rune]rune)
+    decomposeMap = make(map[rune][2]rune)
+)
+
+func init() {
+    for _, row := range accentTable {
+        composed, bases := []rune(row.composed), []rune(row.bases)
+        if len(composed) != len(bases) {
+            panic("accentTable: " + row.composed)
+        }
+        for i, c := range composed {
+            composeMap[[2]rune{bases[i], row.mark}] = c
+            decomposeMap[c] = [2]rune{bases[i], row.mark}
+        }
+    }
+}
+
+// composeAccents turns letters followed by a combining accent into precomposed
+// letters (NFC for the letters in accentTable)
+func composeAccents(s string) string {
+    runes := []rune(s)
+    out := make([]rune, 0, len(runes))
+    for _, r := range runes {
+        if n := len(out); n > 0 {
+            if c, ok := composeMap[[2]rune{out[n-1], r}]; ok {
+                out[n-1] = c
+                continue
+            }
+        }
+        out = append(out, r)
+    }
+    return string(out)
+}
+
+// decomposeAccents splits precomposed letters into a base letter and a combining
+// accent (NFD, as macOS stores names, for the letters in accentTable)
+func decomposeAccents(s string) string {
+    var b strings.Builder
+    for _, r := range s {
+        if d, ok := decomposeMap[r]; ok {
+            b.WriteRune(d[0])
+            b.WriteRune(d[1])
+        } else {
+            b.WriteRune(r)
+        }
+    }
+    return b.String()
+}
+
+// foldToASCII strips accents and replaces other non-ASCII characters
+func foldToASCII(s string) string {
+    s = asciiFallbacks.Replace(decomposeAccents(s))
+    var b strings.Builder
+    for _, r := range s {
+        switch {
+        case r < utf8.RuneSelf:
+            b.WriteRune(r)
+        case r >= '\u0300' && r <= '\u036f':
+            // combining accent
+        default:
+            b.WriteByte('_')
+        }
+    }
+    return b.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestFileNameProfiles(t *testing.T) {
+    long := strings.Repeat("ü", 200) + ".mp3"
+    tests := []struct {
+        profile, in, want string
+    }{
+        {"linux", `What? "Yes": <No>`, `What? "Yes": <No>`},
+        {"linux", "Beyonce\u0301", "Beyoncé"},
+        {"windows", "Motorhead.", "Motorhead"},
+        {"windows", "con.mp3", "_con.mp3"},
+        {"ascii", "Sigur Rós – Hoppípolla", "Sigur Ros - Hoppipolla"},
+        {"ascii", "Straße 東京", "Strasse __"},
+        {"default", long, strings.Repeat("ü", 121) + ".mp3"},
+        {"windows", long, strings.Repeat("ü", 200) + ".mp3"},
+    }
+    for _, tt := range tests {
+        values := map[string]string{"filename_profile": tt.profile}
+        var cfg Config
+        if err := loadFileNameConfig(values, &cfg); err != nil {
+            t.Fatal(err)
+        }
+        if got := cfg.FileNames.sanitizeName(tt.in); got != tt.want {
+            t.Errorf("%s: sanitizeName(%q) = %q, want %q", tt.profile, tt.in, got, tt.want)
+        }
+    }
+
+    var cfg Config
+    loadFileNameConfig(map[string]string{"filename_normalization": "nfd"}, &cfg)
+    if got := cfg.FileNames.sanitizeName("Beyoncé"); got != "Beyonce\u0301" {
+        t.Errorf("nfd: got %q", got)
+    }
+}
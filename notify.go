@@ -0,0 +1,166 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// With notify = true pianotrap shows desktop notifications, the libnotify kind, through
+// the notification server on the session bus: the song playing with its cover art,
+// recordings saved, incomplete ones deleted and recordings that failed. song, saved,
+// deleted and errors under [notify] pick which. A new song replaces the notification
+// of the one before rather than piling up.
+
+// notifyOptions are the per-event switches, by event
+var notifyOptions = map[string]string{
+    eventDetected: "notify_song",
+    eventSaved:    "notify_saved",
+    eventDeleted:  "notify_deleted",
+    eventFailed:   "notify_errors",
+}
+
+// loadNotifyConfig reads the notify options
+func loadNotifyConfig(values map[string]string, cfg *Config) error {
+    cfg.Notify = make(map[string]bool)
+    if values["notify"] != "true" {
+        return nil
+    }
+    for event, option := range notifyOptions {
+        if values[option] != "false" {
+            cfg.Notify[event] = true
+        }
+    }
+    return nil
+}
+
+// notification is what a notification says
+type notification struct {
+    Summary string
+    Body    string
+    Icon    string
+    Urgent  bool
+}
+
+// notificationFor describes event name with msg, or reports there is nothing to show
+func notificationFor(name, msg string, s playerStatus) (notification, bool) {
+    switch name {
+    case eventDetected:
+        if s.Title == "" {
+            return notification{}, false
+        }
+        body := s.Artist
+        if s.Album != "" {
+            body += "\n" + s.Album
+        }
+        if s.Station != "" {
+            body += "\n" + s.Station
+        }
+        icon := s.CoverArt
+        if icon == "" {
+            icon = "audio-x-generic"
+        }
+        return notification{s.Title, body, icon, false}, true
+    case eventSaved:
+        return notification{"Saved " + strings.TrimSuffix(filepath.Base(msg), filepath.Ext(msg)), "to " + filepath.Dir(msg), "document-save", false}, true
+    case eventDeleted:
+        return notification{"Deleted incomplete recording", msg, "edit-delete", false}, true
+    case eventFailed:
+        return notification{"Recording failed", msg, "dialog-error", true}, true
+    }
+    return notification{}, false
+}
+
+var notifier struct {
+    mu   sync.Mutex
+    conn *dbusConn
+    // songID is the notification of the song playing, replaced by the next one
+    songID uint32
+    // failedAt is when the session bus last couldn't be reached
+    failedAt time.Time
+}
+
+// notifyEvent shows a notification for event name if the config in use asks for it
+func notifyEvent(name, msg string) {
+    if !currentConfig().Notify[name] {
+        return
+    }
+    s := currentStatus()
+    if art := coverArtFor(s.Title, s.Artist); s.CoverArt == "" && art != "" {
+        // The download at songstart may still be going; a failed one leaves the
+        // generic icon
+        s.CoverArt, _ = fetchCoverArt(art)
+    }
+    n, ok := notificationFor(name, msg, s)
+    if ok {
+        sendNotification(n, name == eventDetected)
+    }
+}
+
+// sendNotification shows n, in place of the last song's if song is set
+func sendNotification(n notification, song bool) {
+    notifier.mu.Lock()
+    defer notifier.mu.Unlock()
+    if notifier.conn == nil {
+        // Without a desktop, don't try again for every song
+        if time.Since(notifier.failedAt) < 10*time.Minute {
+            return
+        }
+        conn, err := dialSessionBus()
+        if err != nil {
+            logger.Printf("Notifications unavailable: %v", err)
+            notifier.failedAt = time.Now()
+            return
+        }
+        notifier.conn = conn
+    }
+    var replaces uint32
+    if song {
+        replaces = notifier.songID
+    }
+    // 1: normal, 2: critical
+    urgency := byte(1)
+    if n.Urgent {
+        urgency = 2
+    }
+    hints := map[string]dbusVariant{
+        "urgency":       {urgency},
+        "desktop-entry": {"pianotrap"},
+        "category":      {"x-pianotrap"},
+    }
+    if filepath.IsAbs(n.Icon) {
+        hints["image-path"] = dbusVariant{n.Icon}
+    }
+    if song {
+        // Nothing to keep in the notification history once the song is over
+        hints["transient"] = dbusVariant{true}
+    }
+    reply, err := notifier.conn.call("org.freedesktop.Notifications", "/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Notify",
+        "pianotrap", replaces, n.Icon, n.Summary, n.Body, []string{}, hints, int32(-1))
+    if err != nil {
+        logger.Printf("Notification failed: %v", err)
+        notifier.conn.Close()
+        notifier.conn = nil
+        notifier.failedAt = time.Now()
+        return
+    }
+    if len(reply) == 0 {
+        return
+    }
+    if id, ok := reply[0].(uint32); ok && song {
+        notifier.songID = id
+    }
+}
+
+// notifyEvents lists the events cfg notifies about, for the startup summary
+func notifyEvents(cfg Config) string {
+    var names []string
+    for _, event := range []string{eventDetected, eventSaved, eventDeleted, eventFailed} {
+        if cfg.Notify[event] {
+            names = append(names, strings.TrimPrefix(notifyOptions[event], "notify_"))
+        }
+    }
+    return fmt.Sprintf("notifications (%s)", strings.Join(names, ", "))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "testing"
+)
+
+func TestNotifications(t *testing.T) {
+    var cfg Config
+    loadNotifyConfig(map[string]string{"notify_song": "true"}, &cfg)
+    if len(cfg.Notify) != 0 {
+        t.Errorf("notifications without notify = true: %v", cfg.Notify)
+    }
+    loadNotifyConfig(map[string]string{"notify": "true", "notify_deleted": "false"}, &cfg)
+    if !cfg.Notify[eventDetected] || !cfg.Notify[eventFailed] || cfg.Notify[eventDeleted] || cfg.Notify[eventStation] {
+        t.Errorf("notify with deleted = false: %v", cfg.Notify)
+    }
+    if got := notifyEvents(cfg); got != "notifications (song, saved, errors)" {
+        t.Errorf("summary %q", got)
+    }
+
+    art := playTestSong(t, "So What", "Miles Davis")
+    s := currentStatus()
+    s.Album, s.Station = "Kind of Blue", "Jazz Radio"
+    if icon, err := ioutil.ReadFile(s.CoverArt); err != nil || !bytes.Equal(icon, art) {
+        t.Fatalf("cover art at %q = %q, %v", s.CoverArt, icon, err)
+    }
+    for _, tt := range []struct {
+        name, msg string
+        want      notification
+    }{
+        {eventDetected, "So What by Miles Davis", notification{"So What", "Miles Davis\nKind of Blue\nJazz Radio", s.CoverArt, false}},
+        {eventSaved, "/music/Jazz Radio/So What - Miles Davis.mp3", notification{"Saved So What - Miles Davis", "to /music/Jazz Radio", "document-save", false}},
+        {eventFailed, "/music/x.mp3 (exit status 1)", notification{"Recording failed", "/music/x.mp3 (exit status 1)", "dialog-error", true}},
+    } {
+        if got, ok := notificationFor(tt.name, tt.msg, s); !ok || got != tt.want {
+            t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+        }
+    }
+    if n, _ := notificationFor(eventDetected, "", playerStatus{Title: "Blue in Green"}); n.Icon != "audio-x-generic" {
+        t.Errorf("icon without cover art = %q", n.Icon)
+    }
+    if _, ok := notificationFor(eventDetected, "", playerStatus{}); ok {
+        t.Error("notification without a song")
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// nowplaying_file and nowplaying_json keep files up to date with what is playing, for
+// OBS text sources, conky and status bars that read a file: one line made by
+// nowplaying_template, and the song as JSON with its cover art and the countdown.
+// Files are replaced rather than written over, so readers never see half a song; when
+// pianotrap exits they are emptied.
+
+const defaultNowPlayingTemplate = "{{.Artist}} - {{.Title}}"
+
+// nowPlayingFields are what nowplaying_template sees, and what the JSON file holds
+type nowPlayingFields struct {
+    State     string `json:"state"`
+    Title     string `json:"title"`
+    Artist    string `json:"artist"`
+    Album     string `json:"album"`
+    Station   string `json:"station"`
+    Loved     bool   `json:"loved"`
+    Art       string `json:"art"`
+    Recording bool   `json:"recording"`
+    Remaining int    `json:"remaining_seconds"`
+    Total     int    `json:"total_seconds"`
+}
+
+// newNowPlayingFields picks the fields of s
+func newNowPlayingFields(s playerStatus) nowPlayingFields {
+    return nowPlayingFields{s.State, s.Title, s.Artist, s.Album, s.Station, s.Loved, s.CoverArt, s.Recording && !s.Paused, s.Remaining, s.Total}
+}
+
+// loadNowPlayingConfig reads the nowplaying_* options
+func loadNowPlayingConfig(values map[string]string, cfg *Config) error {
+    cfg.NowPlayingFile = values["nowplaying_file"]
+    cfg.NowPlayingJSON = values["nowplaying_json"]
+    raw := values["nowplaying_template"]
+    if raw == "" {
+        raw = defaultNowPlayingTemplate
+    }
+    t, err := template.New("nowplaying_template").Option("missingkey=error").Parse(raw)
+    if err != nil {
+        return fmt.Errorf("invalid nowplaying_template: %v", err)
+    }
+    // Catch unknown fields now rather than when the first song plays
+    if err := t.Execute(ioutil.Discard, nowPlayingFields{Title: "Title"}); err != nil {
+        return fmt.Errorf("invalid nowplaying_template: %v", err)
+    }
+    cfg.NowPlayingTemplate = t
+    return nil
+}
+
+// nowPlayingText renders the text file for f; nothing when nothing plays
+func nowPlayingText(t *template.Template, f nowPlayingFields) (string, error) {
+    if f.Title == "" {
+        return "", nil
+    }
+    var b strings.Builder
+    if err := t.Execute(&b, f); err != nil {
+        return "", err
+    }
+    return strings.TrimRight(b.String(), "\n") + "\n", nil
+}
+
+// writeNowPlayingFile writes data to path through a temporary file, creating its
+// directory
+func writeNowPlayingFile(path string, data []byte) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// nowPlayingWriter remembers what was written last, to write only changes
+type nowPlayingWriter struct {
+    written map[string]string
+    failed  map[string]bool
+}
+
+// write brings the files of cfg up to date with f
+func (w *nowPlayingWriter) write(cfg Config, f nowPlayingFields) {
+    contents := make(map[string]string)
+    if cfg.NowPlayingFile != "" {
+        text, err := nowPlayingText(cfg.NowPlayingTemplate, f)
+        if err != nil {
+            text = fmt.Sprintf("%s - %s\n", f.Artist, f.Title)
+        }
+        contents[cfg.NowPlayingFile] = text
+    }
+    if cfg.NowPlayingJSON != "" {
+        data, _ := json.MarshalIndent(f, "", "  ")
+        contents[cfg.NowPlayingJSON] = string(data) + "\n"
+    }
+    for path, data := range contents {
+        if w.written[path] == data {
+            continue
+        }
+        if err := writeNowPlayingFile(path, []byte(data)); err != nil {
+            // Once is enough for the log
+            if !w.failed[path] {
+                logger.Printf("Failed to write %s: %v", path, err)
+            }
+            w.failed[path] = true
+            continue
+        }
+        w.written[path] = data
+        delete(w.failed, path)
+    }
+}
+
+var nowPlayingFiles struct {
+    stop, done chan struct{}
+}
+
+// startNowPlaying keeps the now-playing files of the config in use up to date for the
+// rest of the session
+func startNowPlaying() {
+    stop, done := make(chan struct{}), make(chan struct{})
+    nowPlayingFiles.stop, nowPlayingFiles.done = stop, done
+    go func() {
+        defer close(done)
+        w := &nowPlayingWriter{make(map[string]string), make(map[string]bool)}
+        ticker := time.NewTicker(time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                w.write(currentConfig(), nowPlayingFields{State: "stopped"})
+                return
+            case <-ticker.C:
+            }
+            w.write(currentConfig(), newNowPlayingFields(currentStatus()))
+        }
+    }()
+}
+
+// stopNowPlaying empties the now-playing files
+func stopNowPlaying() {
+    if nowPlayingFiles.stop == nil {
+        return
+    }
+    close(nowPlayingFiles.stop)
+    <-nowPlayingFiles.done
+}
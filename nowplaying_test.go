@@ -0,0 +1,50 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestNowPlayingFiles(t *testing.T) {
+    for _, bad := range []string{"{{.Title", "{{.Tilte}}"} {
+        if err := loadNowPlayingConfig(map[string]string{"nowplaying_template": bad}, &Config{}); err == nil {
+            t.Errorf("%q loaded", bad)
+        }
+    }
+    dir := t.TempDir()
+    var cfg Config
+    err := loadNowPlayingConfig(map[string]string{
+        "nowplaying_file":     filepath.Join(dir, "obs", "nowplaying.txt"),
+        "nowplaying_json":     filepath.Join(dir, "nowplaying.json"),
+        "nowplaying_template": "{{.Title}}\n{{.Artist}}{{if .Recording}} (REC){{end}}\n",
+    }, &cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    read := func(name string) string {
+        data, _ := ioutil.ReadFile(name)
+        return string(data)
+    }
+    w := &nowPlayingWriter{make(map[string]string), make(map[string]bool)}
+    s := playerStatus{State: "playing", Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Station: "Jazz Radio", CoverArt: "/tmp/cover.jpg", Recording: true, Remaining: 75, Total: 545}
+    w.write(cfg, newNowPlayingFields(s))
+    if got := read(cfg.NowPlayingFile); got != "So What\nMiles Davis (REC)\n" {
+        t.Errorf("text file = %q", got)
+    }
+    var f nowPlayingFields
+    if err := json.Unmarshal([]byte(read(cfg.NowPlayingJSON)), &f); err != nil || f.Art != "/tmp/cover.jpg" || f.Station != "Jazz Radio" || f.Remaining != 75 {
+        t.Errorf("JSON file = %+v, %v", f, err)
+    }
+
+    // Nothing playing empties the text file
+    w.write(cfg, nowPlayingFields{State: "stopped"})
+    if got := read(cfg.NowPlayingFile); got != "" {
+        t.Errorf("text file = %q", got)
+    }
+    if got := read(cfg.NowPlayingJSON); !strings.Contains(got, `"state": "stopped"`) {
+        t.Errorf("JSON file = %s", got)
+    }
+}
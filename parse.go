@@ -0,0 +1,145 @@
+package main
+
+import (
+    "regexp"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// maxTagLength caps tag values so a garbled line can't produce huge arguments
+const maxTagLength = 512
+
+// songInfo holds the fields of a pianobar "now playing" line
+type songInfo struct {
+    Title  string
+    Artist string
+    Album  string
+    Loved  bool
+}
+
+// tags converts the song fields into the tag set written to a recording
+func (info songInfo) tags(year, station string) Tags {
+    tags := Tags{Title: info.Title, Album: info.Album, Year: year, Loved: info.Loved}
+    if info.Artist != "" {
+        tags.Artists = []string{info.Artist}
+    }
+    if station != "" {
+        tags.Custom = map[string]string{"STATION": station}
+    }
+    return tags
+}
+
+// songLineRe matches pianobar's default song format: |>  "%t" by "%a" on "%l"%r%@%s.
+// The groups are greedy and the line is anchored, so quotes inside a field are kept
+// as part of it instead of ending the match early.
+var songLineRe = regexp.MustCompile(`^\|>\s+"(.*)" by "(.*)" on "(.*)"( <3)?(?: @ .*)?$`)
+
+// parseSongLine extracts the song fields from a single line of pianobar output
+func parseSongLine(line string) (songInfo, bool) {
+    matches := songLineRe.FindStringSubmatch(strings.TrimSpace(line))
+    if matches == nil {
+        return songInfo{}, false
+    }
+    return songInfo{
+        Title:  matches[1],
+        Artist: matches[2],
+        Album:  matches[3],
+        Loved:  matches[4] != "",
+    }, true
+}
+
+// findSongLine returns the last song line in a chunk of output, which may hold several lines
+func findSongLine(output string) (songInfo, bool) {
+    var found songInfo
+    ok := false
+    for _, line := range strings.Split(output, "\n") {
+        if info, matched := parseSongLine(line); matched {
+            found, ok = info, true
+        }
+    }
+    return found, ok
+}
+
+// stationLineRe matches pianobar's station banner: |>  Station "%n" (%i)
+var stationLineRe = regexp.MustCompile(`^\|>\s+Station\s+"(.+)"(?:\s+\(\d*\))?$`)
+
+// parseStationLine extracts the station name from a single line of pianobar output
+func parseStationLine(line string) (string, bool) {
+    matches := stationLineRe.FindStringSubmatch(strings.TrimSpace(line))
+    if matches == nil {
+        return "", false
+    }
+    return matches[1], true
+}
+
+// findStationLine returns the last station banner in a chunk of output
+func findStationLine(output string) (string, bool) {
+    var found string
+    ok := false
+    for _, line := range strings.Split(output, "\n") {
+        if station, matched := parseStationLine(line); matched {
+            found, ok = station, true
+        }
+    }
+    return found, ok
+}
+
+// countdownRe matches pianobar's progress line, e.g. "#   -03:46/03:48" or "#  -1:02:03/1:05:00"
+var countdownRe = regexp.MustCompile(`#\s+-((?:\d{1,4}:)?\d{1,4}:\d{1,2})/((?:\d{1,4}:)?\d{1,4}:\d{1,2})`)
+
+// parseCountdown returns the remaining and total time from the most recent progress
+// update in a chunk of output
+func parseCountdown(output string) (remaining, total time.Duration, ok bool) {
+    all := countdownRe.FindAllStringSubmatch(output, -1)
+    if all == nil {
+        return 0, 0, false
+    }
+    matches := all[len(all)-1]
+    remaining, err := parseTime(matches[1])
+    if err != nil {
+        return 0, 0, false
+    }
+    total, err = parseTime(matches[2])
+    if err != nil {
+        return 0, 0, false
+    }
+    return remaining, total, true
+}
+
+// sanitizeTagValue makes a parsed value safe to use as a tag and in file names:
+// invalid UTF-8 is replaced, control characters such as newlines become spaces,
+// runs of whitespace are collapsed and overly long values are truncated
+func sanitizeTagValue(s string) string {
+    s = strings.ToValidUTF8(s, "\uFFFD")
+    s = strings.Map(func(r rune) rune {
+        if unicode.IsControl(r) || r == '\u2028' || r == '\u2029' {
+            return ' '
+        }
+        return r
+    }, s)
+    s = strings.Join(strings.Fields(s), " ")
+    if runes := []rune(s); len(runes) > maxTagLength {
+        s = strings.TrimSpace(string(runes[:maxTagLength]))
+    }
+    return s
+}
+
+// sanitizeSongInfo applies sanitizeTagValue to every text field
+func sanitizeSongInfo(info songInfo) songInfo {
+    info.Title = sanitizeTagValue(info.Title)
+    info.Artist = sanitizeTagValue(info.Artist)
+    info.Album = sanitizeTagValue(info.Album)
+    return info
+}
+
+// metadataArgs builds ffmpeg -metadata arguments. Each pair is passed as a single argv
+// entry (no shell is involved) and ffmpeg splits on the first '=', so values may contain
+// quotes, '=' and other special characters verbatim.
+func metadataArgs(tags [][2]string) []string {
+    var args []string
+    for _, tag := range tags {
+        args = append(args, "-metadata", tag[0]+"="+sanitizeTagValue(tag[1]))
+    }
+    return args
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+func TestParseSongLine(t *testing.T) {
+    tests := []struct {
+        line string
+        want songInfo
+        ok   bool
+    }{
+        {
+            line: `|>  "Something in the Orange" by "Zach Bryan" on "Something in the Orange"`,
+            want: songInfo{Title: "Something in the Orange", Artist: "Zach Bryan", Album: "Something in the Orange"},
+            ok:   true,
+        },
+        {
+            line: `|>  "The "Real" Slim Shady" by "Eminem" on "The Marshall Mathers LP"`,
+            want: songInfo{Title: `The "Real" Slim Shady`, Artist: "Eminem", Album: "The Marshall Mathers LP"},
+            ok:   true,
+        },
+        {
+            line: `|>  "Song" by "Artist "Nickname" Name" on "Album "Deluxe""`,
+            want: songInfo{Title: "Song", Artist: `Artist "Nickname" Name`, Album: `Album "Deluxe"`},
+            ok:   true,
+        },
+        {
+            line: `|>  "a=b; rm -rf / $(x) ` + "`y`" + `" by "-y" on "-metadata title=x"`,
+            want: songInfo{Title: "a=b; rm -rf / $(x) `y`", Artist: "-y", Album: "-metadata title=x"},
+            ok:   true,
+        },
+        {
+            line: `|>  "Loved Song" by "Artist" on "Album" <3`,
+            want: songInfo{Title: "Loved Song", Artist: "Artist", Album: "Album", Loved: true},
+            ok:   true,
+        },
+        {
+            line: `|>  "Mix Song" by "Artist" on "Album" @ Jazz Radio`,
+            want: songInfo{Title: "Mix Song", Artist: "Artist", Album: "Album"},
+            ok:   true,
+        },
+        {
+            line: "\r|>  \"\" by \"\" on \"\"\r",
+            want: songInfo{},
+            ok:   true,
+        },
+        {line: `|>  Station "3 Doors Down Radio" (4526334586650128956)`, ok: false},
+        {line: `#   -03:46/03:48`, ok: false},
+        {line: `"Song" by "Artist" on "Album"`, ok: false},
+    }
+    for _, tt := range tests {
+        got, ok := parseSongLine(tt.line)
+        if ok != tt.ok || got != tt.want {
+            t.Errorf("parseSongLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+func TestFindSongLineInChunk(t *testing.T) {
+    chunk := "(i) Receiving new playlist... Ok.\r\n|>  \"Say \"Hi\"\" by \"Band\" on \"LP\"\r\n#   -03:46/03:48"
+    got, ok := findSongLine(chunk)
+    want := songInfo{Title: `Say "Hi"`, Artist: "Band", Album: "LP"}
+    if !ok || got != want {
+        t.Errorf("findSongLine = %+v, %v; want %+v", got, ok, want)
+    }
+}
+
+func TestMetadataArgs(t *testing.T) {
+    got := metadataArgs([][2]string{
+        {"title", `The "Real" Slim Shady`},
+        {"artist", "a=b"},
+        {"album", "-y"},
+    })
+    want := []string{
+        "-metadata", `title=The "Real" Slim Shady`,
+        "-metadata", "artist=a=b",
+        "-metadata", "album=-y",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("metadataArgs = %q; want %q", got, want)
+    }
+}
+
+func TestSanitizeTagValue(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"Plain Title", "Plain Title"},
+        {"Line\nBreak", "Line Break"},
+        {"Tab\tand\r\nCRLF", "Tab and CRLF"},
+        {"  padded  ", "padded"},
+        {"bad\xffutf8", "bad\uFFFDutf8"},
+        {"esc\x1b[31mred", "esc [31mred"},
+        {strings.Repeat("x", maxTagLength+10), strings.Repeat("x", maxTagLength)},
+    }
+    for _, tt := range tests {
+        if got := sanitizeTagValue(tt.in); got != tt.want {
+            t.Errorf("sanitizeTagValue(%q) = %q; want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func FuzzSanitizeTagValue(f *testing.F) {
+    for _, seed := range []string{"", "Title", "a\nb", "\x00\x1b[0m", "\xff\xfe", "key=value"} {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, s string) {
+        got := sanitizeTagValue(s)
+        if !utf8.ValidString(got) {
+            t.Fatalf("sanitizeTagValue(%q) = %q is not valid UTF-8", s, got)
+        }
+        for _, r := range got {
+            if unicode.IsControl(r) {
+                t.Fatalf("sanitizeTagValue(%q) = %q contains control character %U", s, got, r)
+            }
+        }
+        if utf8.RuneCountInString(got) > maxTagLength {
+            t.Fatalf("sanitizeTagValue(%q) is %d runes long", s, utf8.RuneCountInString(got))
+        }
+        if again := sanitizeTagValue(got); again != got {
+            t.Fatalf("sanitizeTagValue is not idempotent: %q -> %q", got, again)
+        }
+    })
+}
+
+func TestParseStationLine(t *testing.T) {
+    tests := []struct {
+        line, want string
+        ok         bool
+    }{
+        {`|>  Station "3 Doors Down Radio" (4526334586650128956)`, "3 Doors Down Radio", true},
+        {`|>  Station "Bob's "Best" Mix" (12)`, `Bob's "Best" Mix`, true},
+        {"\r|>  Station \"Jazz\"\r", "Jazz", true},
+        {`|>  "Song" by "Artist" on "Album"`, "", false},
+        {`|>  Station "" (1)`, "", false},
+    }
+    for _, tt := range tests {
+        got, ok := parseStationLine(tt.line)
+        if ok != tt.ok || got != tt.want {
+            t.Errorf("parseStationLine(%q) = %q, %v; want %q, %v", tt.line, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+func TestParseCountdown(t *testing.T) {
+    tests := []struct {
+        output           string
+        remaining, total time.Duration
+        ok               bool
+    }{
+        {"#   -03:46/03:48", 3*time.Minute + 46*time.Second, 3*time.Minute + 48*time.Second, true},
+        {"#  -1:02:03/1:05:00", time.Hour + 2*time.Minute + 3*time.Second, time.Hour + 5*time.Minute, true},
+        {"#   -00:10/01:00\r#   -00:09/01:00", 9 * time.Second, time.Minute, true},
+        {"#   -99999999999999:00/01:00", 0, 0, false},
+        {"no countdown here", 0, 0, false},
+    }
+    for _, tt := range tests {
+        remaining, total, ok := parseCountdown(tt.output)
+        if ok != tt.ok || remaining != tt.remaining || total != tt.total {
+            t.Errorf("parseCountdown(%q) = %v, %v, %v; want %v, %v, %v", tt.output, remaining, total, ok, tt.remaining, tt.total, tt.ok)
+        }
+    }
+}
+
+func FuzzParseSongLine(f *testing.F) {
+    for _, seed := range []string{
+        `|>  "Song" by "Artist" on "Album"`,
+        `|>  "a" by "b" on "c" <3 @ Station`,
+        `|>  """ by """ on """`,
+        `|>  "" by "" on ""`,
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, line string) {
+        info, ok := parseSongLine(line)
+        if !ok {
+            return
+        }
+        // Every field must come from the line itself
+        for _, field := range []string{info.Title, info.Artist, info.Album} {
+            if !strings.Contains(line, field) {
+                t.Fatalf("parseSongLine(%q) produced field %q not in the input", line, field)
+            }
+        }
+    })
+}
+
+func FuzzParseStationLine(f *testing.F) {
+    for _, seed := range []string{`|>  Station "Jazz" (1)`, `|>  Station """ ()`, `|>  Station "a" (b)`} {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, line string) {
+        station, ok := parseStationLine(line)
+        if !ok {
+            return
+        }
+        if station == "" || !strings.Contains(line, station) {
+            t.Fatalf("parseStationLine(%q) = %q", line, station)
+        }
+        name := sanitizeFileName(station)
+        if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+            t.Fatalf("station %q sanitized to unsafe directory name %q", station, name)
+        }
+    })
+}
+
+func FuzzParseCountdown(f *testing.F) {
+    for _, seed := range []string{"#   -03:46/03:48", "#  -1:02:03/1:05:00", "#  -9999:99/0:0", "#   -03:4"} {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, output string) {
+        remaining, total, ok := parseCountdown(output)
+        if !ok {
+            return
+        }
+        limit := 10000*time.Hour + 10000*time.Minute + 100*time.Second
+        if remaining < 0 || total < 0 || remaining > limit || total > limit {
+            t.Fatalf("parseCountdown(%q) = %v, %v out of range", output, remaining, total)
+        }
+    })
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+)
+
+// pianotrap starts "pianobar" from the PATH unless pianobar_command names another
+// binary, e.g. a fork or a build of its own. pianobar_args, pianobar_env and
+// pianobar_dir add arguments, environment variables and a working directory. The
+// binary has to read pianobar's config and run its event_command like pianobar does.
+
+// loadPianobarConfig reads the pianobar_* options for starting pianobar
+func loadPianobarConfig(values map[string]string, cfg *Config) error {
+    cfg.PianobarCommand = values["pianobar_command"]
+    if cfg.PianobarCommand == "" {
+        cfg.PianobarCommand = "pianobar"
+    }
+    cfg.PianobarArgs = configList(values["pianobar_args"])
+    cfg.PianobarEnv = configList(values["pianobar_env"])
+    for _, v := range cfg.PianobarEnv {
+        if i := strings.IndexByte(v, '='); i < 1 {
+            return fmt.Errorf("invalid value for pianobar_env: %q (want NAME=value)", v)
+        }
+    }
+    cfg.PianobarDir = values["pianobar_dir"]
+    return nil
+}
+
+// pianobarCommand is the command that starts pianobar as cfg configures it, before
+// pianotrap adds the environment for its audio output and events
+func pianobarCommand(cfg Config) *exec.Cmd {
+    cmd := exec.Command(cfg.PianobarCommand, cfg.PianobarArgs...)
+    cmd.Env = append(os.Environ(), cfg.PianobarEnv...)
+    cmd.Dir = cfg.PianobarDir
+    return cmd
+}
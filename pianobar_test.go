@@ -0,0 +1,35 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestPianobarCommand(t *testing.T) {
+    var cfg Config
+    if err := loadPianobarConfig(map[string]string{}, &cfg); err != nil || cfg.PianobarCommand != "pianobar" || cfg.PianobarArgs != nil {
+        t.Errorf("default pianobar command = %+v, %v", cfg, err)
+    }
+    values, err := parseConfig(`[pianobar]
+command = /opt/pianobar-fork/bin/pianobar
+args = ["-v", "--opt=a,b"]
+env = ["LANG=C", "PIANOBAR_PATH=/a,/b"]
+dir = /opt/pianobar-fork
+`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := loadPianobarConfig(values, &cfg); err != nil {
+        t.Fatal(err)
+    }
+    cmd := pianobarCommand(cfg)
+    if cmd.Path != "/opt/pianobar-fork/bin/pianobar" || !reflect.DeepEqual(cmd.Args, []string{"/opt/pianobar-fork/bin/pianobar", "-v", "--opt=a,b"}) || cmd.Dir != "/opt/pianobar-fork" {
+        t.Errorf("pianobar command %q %q in %q", cmd.Path, cmd.Args, cmd.Dir)
+    }
+    if env := cmd.Env[len(cmd.Env)-2:]; !reflect.DeepEqual(env, []string{"LANG=C", "PIANOBAR_PATH=/a,/b"}) {
+        t.Errorf("pianobar environment ends with %q", env)
+    }
+    if err := loadPianobarConfig(map[string]string{"pianobar_env": "=x"}, &cfg); err == nil {
+        t.Error("pianobar_env without a name accepted")
+    }
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+    "bytes"
     "context"
     "flag"
     "fmt"
+    "io"
     "io/ioutil"
     "log"
     "os"
@@ -11,9 +13,11 @@ import (
     "os/signal"
     "path/filepath"
     "regexp"
+    "strconv"
     "strings"
     "sync"
     "syscall"
+    "text/template"
     "time"
 
     "github.com/creack/pty"
@@ -26,19 +30,164 @@ var (
     ffmpegCmd      *exec.Cmd
     currentStation string
     currentFileName string
+    currentTags    Tags
     remainingTime  time.Duration
     totalDuration  time.Duration
     timeThreshold  = 10 * time.Second
+    maxInfoRetries = 2
+    countdownSeen  chan struct{}
+    durationPad    = 2 * time.Second
     logger         *log.Logger
     logFile        *os.File
     termState      *term.State
 )
 
 type Config struct {
-    SaveDir string
+    ConfigFile string
+    Profile    string
+    SaveDir    string
+    Format     string
+    SampleRate int
+    Channels   int
+    BitDepth   int
+
+    GenreMap    map[string]string
+    DeriveGenre bool
+
+    MQTTBroker          string
+    MQTTUsername        string
+    MQTTPassword        string
+    MQTTTopic           string
+    MQTTDiscoveryPrefix string
+    MQTTNodeID          string
+
+    Schedule        string
+    ScheduleRefresh time.Duration
+
+    AcoustIDKey  string
+    Enrich       bool
+    DiscogsToken string
+
+    Database string
+    BeetsLog string
+
+    // Pandora account used instead of the one in pianobar's config
+    PandoraUser     string
+    PandoraPassword string
+
+    // How pianobar is started
+    PianobarCommand string
+    PianobarArgs    []string
+    PianobarEnv     []string
+    PianobarDir     string
+
+    RotateStations []string
+    RotateEvery    time.Duration
+    RotateSongs    int
+
+    NewOnly      bool
+    NewOnlyGrace time.Duration
+
+    ArtistCap     int
+    ArtistCapSkip bool
+
+    BestOf        []string
+    BestOfSize    int
+    BestOfMixtape bool
+    Playlists     bool
+
+    RetainMaxAge       time.Duration
+    RetainStationBytes int64
+    RetainLovedOnly    bool
+
+    Announce        announcer
+    AnnounceArchive string
+
+    MinFreeBytes  uint64
+    LowSpacePrune bool
+
+    ScrubEvery time.Duration
+
+    OnIncomplete      string
+    IncompleteAfter   time.Duration
+    IncompletePercent float64
+
+    StartOffset time.Duration
+    Gain        float64 // dB
+
+    XDGTrash    bool
+    OnCollision string
+
+    PathTemplate *template.Template
+
+    FFmpegCommand string
+    FFmpegArgs    *template.Template
+
+    HTTPListen   string
+    HTTPToken    string
+    HTTPUsername string
+    HTTPPassword string
+    // HTTPS with HTTPCert and HTTPKey, or a certificate of pianotrap's own
+    HTTPCert       string
+    HTTPKey        string
+    HTTPSelfSigned bool
+    HTTPMDNS       bool
+    MPRIS          bool
+
+    ListenBrainzToken string
+    ListenBrainzURL   string
+
+    // Notify holds the events shown as desktop notifications
+    Notify map[string]bool
+    // Webhooks are the [webhook.<name>] sections, by name
+    Webhooks []webhook
+
+    TelegramToken  string
+    TelegramUsers  []int64
+    TelegramChat   int64
+    TelegramNotify map[string]bool
+
+    // NowPlayingFile gets NowPlayingTemplate and NowPlayingJSON the song as JSON
+    NowPlayingFile     string
+    NowPlayingJSON     string
+    NowPlayingTemplate *template.Template
+
+    GroupBy      string
+    SessionGap   time.Duration
+    FileNames    fileNameProfile
+
+    WatchdogWarn    time.Duration
+    WatchdogTimeout time.Duration
+
+    SilentSource string
+    StatusBar    bool
+    TUI          bool
+    Quiet        bool
+    Accessible   bool
+    Theme        outputTheme
+    RecordKey    byte
+    DiscardKey   byte
+    KeepKey      byte
+    Locale       localeProfile
+
+    Report       string
+    ReportEmail  string
+    ReportFrom   string
+    SMTPServer   string
+    SMTPUsername string
+    SMTPPassword string
 }
 
 func main() {
+    // pianobar calls back into this binary for its events
+    if runEventCommand() {
+        return
+    }
+    // run -detach starts this binary again to host the session
+    if runSessionHost() {
+        return
+    }
+
     // Get the user's home directory
     homeDir, err := os.UserHomeDir()
     if err != nil {
@@ -47,40 +196,198 @@ func main() {
     }
     defaultSaveDir := filepath.Join(homeDir, "Music")
 
-    // Define the config file path
-    configFile := filepath.Join(homeDir, ".config", "pianotrap", "config")
+    // Define the config file path; -config before the command picks another one and
+    // -profile one of its profiles
+    configFile, profile, rest, err := globalFlags(os.Args[1:], filepath.Join(homeDir, ".config", "pianotrap", "config"))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(2)
+    }
 
-    // Load the save directory from the config file
-    saveDirFromConfig, err := loadSaveDir(configFile, defaultSaveDir)
+    // Subcommands work on the song database instead of starting pianobar; without
+    // one, pianotrap records as before
+    name, args := "run", rest
+    if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+        name, args = rest[0], rest[1:]
+    }
+    cmd, ok := subcommands[name]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+        printUsage(os.Stderr)
+        os.Exit(2)
+    }
+
+    fileCfg, err := loadConfig(configFile, profile, defaultSaveDir)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error with config file: %v\n", err)
+        // doctor and config report what is wrong with the config themselves
+        if name != "doctor" && name != "config" {
+            fmt.Fprintf(os.Stderr, "Error with config file: %v\n", err)
+            os.Exit(1)
+        }
+        fileCfg = Config{ConfigFile: configFile, Profile: profile, SaveDir: defaultSaveDir}
+    }
+    if fileCfg.FFmpegCommand != "" {
+        ffmpegPath = fileCfg.FFmpegCommand
+    }
+    if name != "run" {
+        logger = log.New(os.Stderr, "", 0)
+    }
+    if err := cmd.Run(fileCfg, args); err == flag.ErrHelp {
+        os.Exit(2)
+    } else if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
         os.Exit(1)
     }
+}
 
+// runRun implements "pianotrap run", also what pianotrap does without a command:
+// start pianobar and record it
+func runRun(fileCfg Config, args []string) error {
+    fs := flag.NewFlagSet("run", flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "usage: pianotrap [-config <file>] [-profile <name>] [run] [options]\n\noptions:\n")
+        fs.PrintDefaults()
+        fmt.Fprintf(fs.Output(), "\nRun \"pianotrap help\" for the other commands.\n")
+    }
     // Command-line flag overrides config file if provided
-    saveDir := flag.String("savedir", saveDirFromConfig, "directory to save recorded songs")
-    logging := flag.Bool("log", false, "enable diagnostic logging to pianotrap.log")
-    flag.Parse()
+    saveDir := fs.String("savedir", fileCfg.SaveDir, "directory to save recorded songs")
+    format := fs.String("format", fileCfg.Format, "what to encode recordings to: mp3, flac, ogg, opus, m4a or aac")
+    sampleRate := fs.Int("samplerate", fileCfg.SampleRate, "capture sample rate in Hz (0 keeps the source default)")
+    channels := fs.Int("channels", fileCfg.Channels, "capture channel count, e.g. 1 for mono (0 keeps the source default)")
+    bitDepth := fs.Int("bitdepth", fileCfg.BitDepth, "capture bit depth: 16, 24 or 32 (0 keeps the encoder default)")
+    incompleteSeconds := fs.Int("incomplete-seconds", int(fileCfg.IncompleteAfter/time.Second), "delete recordings stopped with more than this many seconds left (0 disables)")
+    incompletePercent := fs.Float64("incomplete-percent", fileCfg.IncompletePercent, "delete recordings stopped with more than this percentage of the song left (0 disables)")
+    startOffset := fs.Duration("start-offset", fileCfg.StartOffset, "skip this much audio at the start of each recording to match the song change (see pianotrap calibrate)")
+    noColor := fs.Bool("no-color", false, "don't color pianotrap's messages")
+    tui := fs.Bool("tui", fileCfg.TUI, "show a full-screen interface around pianobar")
+    quietFlag := fs.Bool("quiet", fileCfg.Quiet, "hide pianobar's output and print only pianotrap's events")
+    accessibleFlag := fs.Bool("accessible", fileCfg.Accessible, "plain line-by-line output for screen readers")
+    logPath := filepath.Join(filepath.Dir(fileCfg.ConfigFile), "pianotrap.log")
+    if fileCfg.Profile != "" {
+        // Sessions of different profiles may run side by side
+        logPath = filepath.Join(filepath.Dir(fileCfg.ConfigFile), "pianotrap-"+fileCfg.Profile+".log")
+    }
+    logging := fs.Bool("log", false, "enable diagnostic logging to "+logPath)
+    detach := fs.Bool("detach", false, "keep running in the background; pianotrap attach connects to it")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if fs.NArg() > 0 {
+        return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+    }
 
     if *logging {
-        logFile, err = os.OpenFile("pianotrap.log", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+        var err error
+        logFile, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
-            os.Exit(1)
+            return fmt.Errorf("failed to open log file: %v", err)
         }
         defer logFile.Close()
         logger = log.New(logFile, "", log.LstdFlags)
+    } else if *quietFlag || *accessibleFlag {
+        logger = log.New(ioutil.Discard, "", 0)
     } else {
         logger = log.New(os.Stderr, "", 0)
         logger.SetOutput(os.Stderr)
     }
 
-    cfg := Config{SaveDir: *saveDir}
-    fmt.Printf("Saving songs to: %s\n", cfg.SaveDir)
+    // The flags given override the config file, also when it's reloaded
+    commandLine := func(cfg *Config) {
+        fs.Visit(func(f *flag.Flag) {
+            switch f.Name {
+            case "savedir":
+                cfg.SaveDir = *saveDir
+            case "format":
+                cfg.Format = strings.ToLower(*format)
+            case "samplerate":
+                cfg.SampleRate = *sampleRate
+            case "channels":
+                cfg.Channels = *channels
+            case "bitdepth":
+                cfg.BitDepth = *bitDepth
+            case "incomplete-seconds":
+                cfg.IncompleteAfter = time.Duration(*incompleteSeconds) * time.Second
+            case "incomplete-percent":
+                cfg.IncompletePercent = *incompletePercent
+            case "start-offset":
+                cfg.StartOffset = *startOffset
+            case "tui":
+                cfg.TUI = *tui
+            case "quiet":
+                cfg.Quiet = *quietFlag
+            case "accessible":
+                cfg.Accessible = *accessibleFlag
+            }
+        })
+        if cfg.Quiet || cfg.Accessible {
+            cfg.TUI, cfg.StatusBar = false, false
+        }
+        if cfg.Accessible || *noColor {
+            cfg.Theme.Color = false
+        }
+    }
+    cfg := fileCfg
+    commandLine(&cfg)
+    reloadOverrides = commandLine
+    if err := cfg.validateRun(); err != nil {
+        return err
+    }
+    if *detach {
+        return startDetached(cfg, detachArgs(os.Args[1:]))
+    }
     if err := RunPianotrap(cfg); err != nil {
-        logger.Printf("Error running pianotrap: %v", err)
-        os.Exit(1)
+        if logFile != nil {
+            logger.Printf("Error running pianotrap: %v", err)
+        }
+        return fmt.Errorf("%v; pianotrap doctor checks the setup", err)
+    }
+    return nil
+}
+
+// validateRun checks the options the flags can also set
+func (cfg Config) validateRun() error {
+    if err := validateStartOffset(cfg.StartOffset); err != nil {
+        return fmt.Errorf("invalid start offset: %v", err)
+    }
+    if cfg.IncompleteAfter < 0 || cfg.IncompletePercent < 0 || cfg.IncompletePercent > 100 {
+        return fmt.Errorf("invalid incomplete-song threshold")
     }
+    if err := cfg.validateCapture(); err != nil {
+        return fmt.Errorf("invalid capture format: %v", err)
+    }
+    return nil
+}
+
+// loadConfig reads the options from configFile, writing the default config or a
+// savedir if it has none, and applies profile. Any problem with the file is an error.
+func loadConfig(configFile, profile, defaultSaveDir string) (Config, error) {
+    // Load the save directory from the config file
+    saveDir, err := loadSaveDir(configFile, defaultSaveDir)
+    if err != nil {
+        return Config{}, err
+    }
+
+    // Check all of it before loading the remaining options
+    problems, err := checkConfigFile(configFile, profile, saveDir)
+    if err != nil {
+        return Config{}, err
+    }
+    if len(problems) > 0 {
+        return Config{}, configProblemsError(configFile, problems)
+    }
+    values, err := readConfigValues(configFile)
+    if err != nil {
+        return Config{}, err
+    }
+    if values, err = selectProfile(values, profile); err != nil {
+        return Config{}, err
+    }
+    cfg, err := configFromValues(values, configFile, saveDir)
+    if err != nil {
+        return Config{}, err
+    }
+    cfg.Profile = profile
+    return cfg, nil
 }
 
 // loadSaveDir reads or initializes the save directory from the config file in Pianobar style
@@ -91,59 +398,122 @@ func loadSaveDir(configFile, defaultSaveDir string) (string, error) {
         if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
             return "", fmt.Errorf("failed to create config directory: %v", err)
         }
-        configContent := fmt.Sprintf("savedir = %s\n", defaultSaveDir)
-        if err := ioutil.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+        configContent := defaultConfigFile(defaultSaveDir)
+        if err := ioutil.WriteFile(configFile, []byte(configContent), 0600); err != nil {
             return "", fmt.Errorf("failed to write config file: %v", err)
         }
         return defaultSaveDir, nil
     }
 
-    // Read and parse the config file
-    data, err := ioutil.ReadFile(configFile)
+    values, err := readConfigValues(configFile)
     if err != nil {
-        return "", fmt.Errorf("failed to read config file: %v", err)
+        return "", err
     }
-
-    lines := strings.Split(string(data), "\n")
-    for _, line := range lines {
-        line = strings.TrimSpace(line)
-        if strings.HasPrefix(line, "savedir =") {
-            parts := strings.SplitN(line, "=", 2)
-            if len(parts) != 2 {
-                continue
-            }
-            saveDir := strings.TrimSpace(parts[1])
-            if saveDir != "" {
-                return saveDir, nil
-            }
-        }
+    if saveDir := values["savedir"]; saveDir != "" {
+        return saveDir, nil
     }
 
-    // If savedir isn't found, append it to the existing file
-    configContent := string(data) + fmt.Sprintf("savedir = %s\n", defaultSaveDir)
-    if err := ioutil.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+    // If savedir isn't found, add it to the existing file
+    if err := setConfigValue(configFile, "savedir", defaultSaveDir); err != nil {
         return "", fmt.Errorf("failed to update config file with default savedir: %v", err)
     }
     return defaultSaveDir, nil
 }
 
 func RunPianotrap(cfg Config) error {
-    monitorSource := "PianobarSink.monitor"
-    fmt.Printf("\r\nUsing PulseAudio monitor source: %s\n", monitorSource)
+    sink := sessionSinkName()
+    monitorSource := sink + ".monitor"
 
-    pianobarCmd := exec.Command("./launch_pianobar.sh")
+    rate, channels := 44100, 2
+    if cfg.SampleRate > 0 {
+        rate = cfg.SampleRate
+    }
+    if cfg.Channels > 0 {
+        channels = cfg.Channels
+    }
+    if err := createSessionSink(sink, rate, channels); err != nil {
+        return err
+    }
+    defer unloadSessionSink(sink)
+
+    pianobarCmd := pianobarCommand(cfg)
+    audioEnv, err := setupAudioOutput(sink)
+    if err != nil {
+        logger.Printf("Warning: using the default libao output: %v", err)
+    }
+    pianobarCmd.Env = append(pianobarCmd.Env, audioEnv...)
+    defer cleanupAudioOutput()
+    eventEnv, err := setupPianobarEvents(cfg)
+    if err != nil {
+        logger.Printf("Warning: pianobar events unavailable, cover art disabled: %v", err)
+    } else {
+        pianobarCmd.Env = append(pianobarCmd.Env, eventEnv...)
+    }
+    defer cleanupPianobarEvents()
     ptyFile, err := pty.Start(pianobarCmd)
     if err != nil {
-        return fmt.Errorf("error starting pianobar script in PTY: %v", err)
+        return fmt.Errorf("error starting pianobar in PTY: %v", err)
     }
     defer ptyFile.Close()
-
+    pianobarPTY = ptyFile
+    if conn, err := openDatabase(cfg.Database); err != nil {
+        fmt.Fprintf(os.Stderr, "\r\nWarning: song history disabled: %v\n", err)
+    } else {
+        db = conn
+        defer db.Close()
+        markInterrupted()
+    }
+    startMQTT(cfg)
+    startHTTP(cfg)
+    defer stopMDNS()
+    startMPRIS(cfg)
+    startTelegram(cfg)
+    startScrobbling()
+    defer stopScrobbling()
+    startNowPlaying()
+    defer stopNowPlaying()
+    startControlSocket(cfg)
+    defer stopControlSocket()
+    startSchedule(cfg)
+    startRotation(cfg)
+    startBestOf(cfg)
+    startRetention(cfg)
+    startDiskSpaceMonitor(cfg)
+    startScrub(cfg)
+    startReports(cfg)
+    startSession(time.Now())
+    applyConfig(cfg)
+    watchReload()
+    captureSource = monitorSource
+    setLocale(cfg.Locale)
+    quiet, accessible = cfg.Quiet, cfg.Accessible
+    if !quiet {
+        printStartupSummary(cfg, monitorSource)
+    }
+    recoverJournal()
+    emptyTrash()
+    if partials := listPartials(cfg.SaveDir); len(partials) > 0 {
+        fmt.Printf("\r\nFound %d incomplete recordings from an earlier run:\n", len(partials))
+        for _, p := range partials {
+            fmt.Printf("\r  %s\n", p)
+        }
+    }
     termState, err = term.MakeRaw(int(os.Stdin.Fd()))
     if err != nil {
         logger.Printf("Warning: could not set terminal to raw mode: %v", err)
     } else {
         defer term.Restore(int(os.Stdin.Fd()), termState)
     }
+    if cfg.TUI {
+        if err := startTUI(); err != nil {
+            fmt.Printf("\r\nTUI unavailable: %v\n", err)
+        } else {
+            defer stopTUI()
+        }
+    } else if cfg.StatusBar {
+        startStatusBar()
+        defer stopStatusBar()
+    }
 
     go func() {
         time.Sleep(5 * time.Second)
@@ -162,10 +532,11 @@ func RunPianotrap(cfg Config) error {
 
     go func() {
         if err := pianobarCmd.Wait(); err != nil {
-            logger.Printf("Pianobar script exited with error: %v", err)
+            logger.Printf("Pianobar exited with error: %v", err)
         }
         closeDone()
     }()
+    watchWindowSize(ptyFile, done)
 
     shutdown := make(chan struct{})
     inputDone := make(chan struct{})
@@ -187,10 +558,38 @@ func RunPianotrap(cfg Config) error {
                     }
                     return
                 }
+                if n > 0 && buf[0] == editTagsKey {
+                    editCurrentTags()
+                    continue
+                }
+                record, discard, keep := sessionKeys()
+                if n > 0 && buf[0] == keep {
+                    keepCurrent()
+                    continue
+                }
+                if n > 0 && buf[0] == discard {
+                    discardCurrent()
+                    continue
+                }
+                if n > 0 && buf[0] == record {
+                    toggleCapture()
+                    continue
+                }
+                if n > 0 && buf[0] == sourceSwitchKey {
+                    chooseCaptureSource()
+                    continue
+                }
+                if n > 0 && buf[0] == undoKey {
+                    if done, err := undoLastAction(); err != nil {
+                        fmt.Printf("\r\nUndo: %v\r\n", err)
+                    } else {
+                        fmt.Printf("\r\n%s\r\n", done)
+                    }
+                    continue
+                }
                 if n > 0 {
                     logger.Printf("Sending to PTY: %q at %v", string(buf[:n]), time.Now())
-                    fmt.Printf("%c", buf[0])
-                    os.Stdout.Sync()
+                    writeOutput(string(buf[:n]))
                     ptyFile.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
                     if _, err := ptyFile.Write(buf[:n]); err != nil {
                         logger.Printf("Error writing to PTY: %v", err)
@@ -209,6 +608,10 @@ func RunPianotrap(cfg Config) error {
                         logger.Printf("Quit command received, shutting down")
                         cleanExit(pianobarCmd, 0)
                     }
+                    if buf[0] == '+' {
+                        markCurrentLoved()
+                    }
+                    notePlayerKey(buf[0])
                 }
             }
         }
@@ -222,17 +625,14 @@ func RunPianotrap(cfg Config) error {
         cleanExit(pianobarCmd, 0)
     }()
 
-    defer func() {
-        exec.Command("pactl", "unload-module", "module-null-sink").Run()
-        exec.Command("pactl", "unload-module", "module-loopback").Run()
-    }()
-
-    outputChan := make(chan string, 1000)
-
     go func() {
         buf := make([]byte, 1024)
+        var screen vtScreen
         var lastSong string
+        countdownEnded := false
+        infoRetries := 0
         lastOutputTime := time.Now()
+        warned := false
         syscall.SetNonblock(int(ptyFile.Fd()), true)
         defer syscall.SetNonblock(int(ptyFile.Fd()), false)
         for {
@@ -242,19 +642,16 @@ func RunPianotrap(cfg Config) error {
             case <-shutdown:
                 return
             default:
-                n, err := ptyFile.Read(buf)
+                n, err := readPTY(ptyFile, buf)
                 if err != nil {
                     if errno, ok := err.(syscall.Errno); ok && (errno == syscall.EAGAIN || errno == syscall.EWOULDBLOCK) {
-                        if time.Since(lastOutputTime) > 5*time.Second {
-                            logger.Printf("No PTY output for 5s at %v, recording=%v", time.Now(), recording)
-                            if time.Since(lastOutputTime) > 15*time.Second {
-                                logger.Printf("No PTY output for 15s, forcing stop at %v", time.Now())
-                                stopRecording(true)
-                                if pianobarCmd != nil && pianobarCmd.Process != nil {
-                                    pianobarCmd.Process.Kill()
-                                }
-                                closeDone()
+                        if watchdogExpired(&lastOutputTime, &warned) {
+                            logger.Printf("No PTY output for %v, forcing stop at %v", time.Since(lastOutputTime).Round(time.Second), time.Now())
+                            stopRecording(true)
+                            if pianobarCmd != nil && pianobarCmd.Process != nil {
+                                pianobarCmd.Process.Kill()
                             }
+                            closeDone()
                         }
                         time.Sleep(100 * time.Millisecond)
                         continue
@@ -266,90 +663,171 @@ func RunPianotrap(cfg Config) error {
                     return
                 }
                 lastOutputTime = time.Now()
-                output := stripANSI(string(buf[:n]))
+                warned = false
+                // Show pianobar's output as is before looking at it, so redraws
+                // such as the countdown aren't held up by parsing
+                writeOutput(string(buf[:n]))
+                // Banners are matched once their line is finished; the countdown
+                // and prompts are redrawn in place, so the current line counts too
+                lines := screen.feed(string(buf[:n]))
+                finished := joinLines(lines)
+                output := finished
+                line, changed := screen.current()
+                if changed {
+                    output += line
+                }
+                announceOutput(lines, line, changed)
                 if output != "" {
-                    select {
-                    case outputChan <- output:
-                        logger.Printf("Sent %d bytes to outputChan at %v", len(output), time.Now())
-                    default:
-                        logger.Printf("Warning: outputChan full, dropping %d bytes at %v", len(output), time.Now())
-                    }
+                    // Read per chunk, as a SIGHUP may have changed it
+                    cfg := currentConfig()
+                    notePTYOutput(output)
+                    forwardOutput(output)
 
-                    songRe := regexp.MustCompile(`\|\>\s*"([^"]+)"\s*by\s*"([^"]+)"\s*on\s*"([^"]+)"`)
-                    if matches := songRe.FindStringSubmatch(output); matches != nil {
-                        songTitle := matches[1]
-                        artist := matches[2]
-                        album := matches[3]
+                    if info, ok := findSongLine(finished); ok {
+                        info = sanitizeSongInfo(info)
+                        emitBoundary(boundaryStart, boundaryRegex, bannerConfidence(info), info.Title, info.Artist, currentStation)
+                        songTitle := info.Title
+                        artist := info.Artist
+                        album := info.Album
                         currentSong := fmt.Sprintf("%s by %s", songTitle, artist)
-                        if currentSong != lastSong {
+                        if currentSong != lastSong && tagsLookIncomplete(songTitle, artist, album) && infoRetries < maxInfoRetries {
+                            // Ask pianobar to print the song info again and wait for the re-parse
+                            infoRetries++
+                            logger.Printf("Incomplete tags for %q by %q on %q, re-requesting song info (attempt %d)", songTitle, artist, album, infoRetries)
+                            if _, err := ptyFile.Write([]byte("i\n")); err != nil {
+                                logger.Printf("Error sending 'i' to pianobar: %v", err)
+                            }
+                        } else if currentSong != lastSong {
+                            infoRetries = 0
+                            mu.Lock()
+                            nowPlaying = info
+                            mu.Unlock()
                             logger.Printf("New song detected: %s at %v", currentSong, time.Now())
                             mu.Lock()
-                            deleteFile := recording && totalDuration > 0 && remainingTime > timeThreshold
+                            deleteFile := recording && totalDuration > 0 && songIncomplete()
                             mu.Unlock()
                             stopRecording(deleteFile)
+                            rotationSongStarted()
                             if currentStation == "" {
+                                mu.Lock()
                                 currentStation = "Unknown Station"
+                                mu.Unlock()
+                            }
+                            event(eventDetected, "%s", currentSong)
+                            if captureIsPaused() {
+                                notice(msgSkip, "Recording paused, not saving: %s", currentSong)
+                                logDetectedSong(info, currentStation, "", outcomeSkipped)
+                            } else if cfg.NewOnly && songInLibrary(songTitle, artist) {
+                                notice(msgSkip, "Already in the library, skipping: %s", currentSong)
+                                logDetectedSong(info, currentStation, "", outcomeSkipped)
+                                logBeets("duplicate-skip", recordingPath(cfg.SaveDir, currentStation, info.tags(fmt.Sprintf("%d", time.Now().Year()), currentStation), cfg.captureFormat().Ext))
+                                go skipKnownSong(info, cfg.NewOnlyGrace)
+                            } else if artistCapReached(cfg, artist) {
+                                notice(msgSkip, "Weekly limit of %d songs by %s reached, not saving: %s", cfg.ArtistCap, artist, currentSong)
+                                logDetectedSong(info, currentStation, "", outcomeSkipped)
+                                if cfg.ArtistCapSkip {
+                                    go skipKnownSong(info, cfg.NewOnlyGrace)
+                                }
+                            } else if !checkDiskSpace(cfg) {
+                                notice(msgSkip, "Low on disk space, not saving: %s", currentSong)
+                                logDetectedSong(info, currentStation, "", outcomeSkipped)
+                            } else if recordingAllowed() {
+                                tags := info.tags(fmt.Sprintf("%d", time.Now().Year()), currentStation)
+                                tags.Genre = cfg.genreFor(currentStation)
+                                fileName, ok := resolveCollision(cfg.OnCollision, recordingPath(cfg.SaveDir, currentStation, tags, cfg.captureFormat().Ext))
+                                if !ok {
+                                    notice(msgSkip, "Already recorded, not saving: %s", fileName)
+                                    logDetectedSong(info, currentStation, "", outcomeSkipped)
+                                } else {
+                                    currentFileName = fileName
+                                    startedRecording(fileName)
+                                    if resumeSegment(fileName, songTitle, artist, currentStation) {
+                                        notice(msgStart, "Back on %s, joining the earlier part of %s", currentStation, currentSong)
+                                    }
+                                    if !accessible {
+                                        notice(msgStart, "Song detected - Starting to save: %s", currentFileName)
+                                    }
+                                    event(eventRecording, "%s", currentSong)
+                                    if !quiet && !accessible {
+                                        printTagPreview(tags)
+                                    }
+                                    mu.Lock()
+                                    recording = true
+                                    currentTags = tags
+                                    countdownSeen = make(chan struct{})
+                                    mu.Unlock()
+                                    logDetectedSong(info, currentStation, currentFileName, outcomeRecording)
+                                    go saveSong(cfg, currentFileName, tags)
+                                }
+                            } else {
+                                notice(msgSkip, "Outside the recording schedule, not saving: %s", currentSong)
+                                logDetectedSong(info, currentStation, "", outcomeSkipped)
                             }
-                            defaultYear := time.Now().Year()
-                            currentFileName = filepath.Join(cfg.SaveDir, currentStation, sanitizeFileName(fmt.Sprintf("%s - %s - %s (%d).mp3", songTitle, artist, album, defaultYear)))
-                            fmt.Printf("\r\nSong detected - Starting to save: %s\n", currentFileName)
-                            mu.Lock()
-                            recording = true
-                            mu.Unlock()
-                            go saveSong(cfg, currentFileName, monitorSource, songTitle, artist, album, fmt.Sprintf("%d", defaultYear))
                             lastSong = currentSong
                         } else {
                             logger.Printf("Duplicate song skipped: %s at %v", currentSong, time.Now())
                         }
                     }
 
-                    stationRe := regexp.MustCompile(`\|\>\s*Station\s+"([^"]+)"`)
-                    if matches := stationRe.FindStringSubmatch(output); matches != nil {
-                        newStation := sanitizeFileName(matches[1])
+                    if station, ok := findStationLine(finished); ok {
+                        newStation := sanitizeFileName(station)
                         logger.Printf("Station detected: %s", newStation)
                         if newStation != currentStation {
-                            stopRecording(true)
+                            // Keep a recording that was about to finish, e.g. when rotating stations
+                            mu.Lock()
+                            deleteFile := recording && (totalDuration == 0 || songIncomplete())
+                            cutFile, cutSong := currentFileName, nowPlaying
+                            mu.Unlock()
+                            stopRecording(deleteFile)
+                            if deleteFile && onIncomplete == incompleteDelete {
+                                // Switching straight back may resume the song
+                                noteInterrupted(cutFile, cutSong.Title, cutSong.Artist, currentStation)
+                                lastSong = ""
+                            }
+                            // Only this loop sets the station, but the API and the
+                            // status bar read it
+                            mu.Lock()
                             currentStation = newStation
+                            mu.Unlock()
+                            rotationStationChanged(currentStation)
                             stationDir := filepath.Join(cfg.SaveDir, currentStation)
                             if err := os.MkdirAll(stationDir, 0755); err != nil {
                                 logger.Printf("Failed to create station dir %s: %v", stationDir, err)
                             } else {
-                                fmt.Printf("\r\nCreated station directory: %s\n", stationDir)
+                                notice(msgInfo, "Created station directory: %s", stationDir)
                             }
-                            fmt.Printf("\r\nSwitched to station: %s\n", currentStation)
+                            notice(msgInfo, "Switched to station: %s", currentStation)
+                            event(eventStation, "%s", currentStation)
                         }
                     }
 
-                    countdownRe := regexp.MustCompile(`#\s+-(?:(\d+):)?(\d+):(\d+)/(\d+):(\d+)`)
-                    if matches := countdownRe.FindStringSubmatch(output); matches != nil {
-                        remainingStr := fmt.Sprintf("%s:%s", matches[2], matches[3])
-                        if matches[1] != "" {
-                            remainingStr = fmt.Sprintf("%s:%s", matches[1], matches[2])
-                        }
-                        totalStr := fmt.Sprintf("%s:%s", matches[4], matches[5])
-                        remaining, err := parseTime(remainingStr)
-                        if err != nil {
-                            logger.Printf("Error parsing remaining time: %v", err)
-                            continue
-                        }
-                        total, err := parseTime(totalStr)
-                        if err != nil {
-                            logger.Printf("Error parsing total time: %v", err)
-                            continue
-                        }
+                    if remaining, total, ok := parseCountdown(output); ok {
                         mu.Lock()
                         remainingTime = remaining
                         totalDuration = total
+                        lastCountdown = time.Now()
+                        if countdownSeen != nil {
+                            close(countdownSeen)
+                            countdownSeen = nil
+                        }
                         shouldStop := remaining <= 0 && recording
                         logger.Printf("Countdown: remaining=%v, total=%v, recording=%v, shouldStop=%v", remaining, total, recording, shouldStop)
                         mu.Unlock()
+                        if remaining <= 0 && total > 0 && !countdownEnded {
+                            mu.Lock()
+                            song := nowPlaying
+                            mu.Unlock()
+                            emitBoundary(boundaryEnd, boundaryCountdown, 0.8, song.Title, song.Artist, currentStation)
+                        }
+                        countdownEnded = remaining <= 0
                         if shouldStop {
-                            fmt.Printf("\r\nSong finished, stopping capture\n")
+                            notice(msgStop, "Song finished, stopping capture")
                             stopRecording(false)
                         }
+                        checkRotation(remaining)
                     }
 
-                    if strings.Contains(output, "(i) Network error") || strings.Contains(output, "Connection lost") || strings.Contains(output, "Song paused") {
+                    if interruptsRecording(output) {
                         stopRecording(true)
                         lastSong = ""
                     }
@@ -358,20 +836,6 @@ func RunPianotrap(cfg Config) error {
         }
     }()
 
-    go func() {
-        for {
-            select {
-            case <-done:
-                return
-            case <-shutdown:
-                return
-            case output := <-outputChan:
-                fmt.Print(output)
-                os.Stdout.Sync()
-            }
-        }
-    }()
-
 loop:
     for {
         select {
@@ -392,7 +856,7 @@ func stopRecording(deleteFile bool) {
     defer mu.Unlock()
     logger.Printf("Entering stopRecording, ffmpegCmd=%v, recording=%v", ffmpegCmd != nil, recording)
     if ffmpegCmd != nil {
-        fmt.Printf("\r\nStopping current recording\n")
+        notice(msgStop, "Stopping current recording")
         pid := ffmpegCmd.Process.Pid
         ffmpegCmd.Process.Signal(syscall.SIGTERM)
         time.Sleep(500 * time.Millisecond)
@@ -416,20 +880,36 @@ func stopRecording(deleteFile bool) {
         case <-time.After(2 * time.Second):
             logger.Printf("FFmpeg pid %d didn’t stop after 2s, abandoning", pid)
         }
-        if deleteFile && currentFileName != "" {
-            fmt.Printf("\r\nRemoving incomplete file: %s\n", currentFileName)
-            os.Remove(currentFileName)
+        if discardRequested {
+            deleteFile = true
+        } else if keepRequested && deleteFile {
+            notice(msgStop, "Keeping protected recording: %s", currentFileName)
+            deleteFile = false
+        }
+        if deleteFile && currentFileName != "" && onIncomplete != incompleteDelete && !discardRequested {
+            notice(msgStop, "Keeping incomplete recording: %s", currentFileName)
+            go finishRecording(currentFileName, currentTags, true)
+        } else if deleteFile && currentFileName != "" {
+            notice(msgDelete, "Removing incomplete file: %s", currentFileName)
+            if err := discardTo(tempName(currentFileName), trashRoot, xdgTrash); err != nil {
+                logger.Printf("Failed to remove %s: %v", tempName(currentFileName), err)
+            }
+            setSongOutcome(currentFileName, outcomeDeleted)
+            event(eventDeleted, "%s", currentFileName)
+        } else if currentFileName != "" {
+            go finishRecording(currentFileName, currentTags, false)
         }
         ffmpegCmd = nil
     } else {
         logger.Printf("No FFmpeg process to stop")
     }
     recording = false
+    discardRequested, keepRequested = false, false
     remainingTime = 0
     totalDuration = 0
 }
 
-func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, year string) {
+func saveSong(cfg Config, fileName string, tags Tags) {
     logger.Printf("Starting saveSong for %s", fileName)
 
     ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
@@ -440,19 +920,26 @@ func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, yea
         return
     }
 
-    ffmpegArgs := []string{
-        "-f", "pulse",
-        "-i", monitorSource,
-        "-acodec", "mp3",
-        "-y",
-        "-metadata", fmt.Sprintf("title=%s", songTitle),
-        "-metadata", fmt.Sprintf("artist=%s", artist),
-        "-metadata", fmt.Sprintf("album=%s", album),
-        "-metadata", fmt.Sprintf("date=%s", year),
-        fileName,
+    mu.Lock()
+    if !recording || currentFileName != fileName {
+        mu.Unlock()
+        logger.Printf("Recording of %s was stopped before ffmpeg started", fileName)
+        setSongOutcome(fileName, outcomeSkipped)
+        return
+    }
+    remaining, source, seen := remainingTime, captureSource, countdownSeen
+    mu.Unlock()
+    if seen != nil {
+        // The countdown hasn't come yet: start now so the song's opening isn't lost,
+        // and limit the length once it's known
+        remaining = 0
     }
+
+    // Record to a temporary name so nothing picks up a half-written file
+    ffmpegArgs := buildFFmpegArgs(cfg, source, tempName(fileName), remaining)
+    beginAction(actionCreate, tempName(fileName), "")
     mu.Lock()
-    ffmpegCmd = exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
+    ffmpegCmd = exec.CommandContext(ctx, ffmpegPath, ffmpegArgs...)
     ffmpegCmd.Stdout = logFile // Log FFmpeg output
     ffmpegCmd.Stderr = logFile
     mu.Unlock()
@@ -461,6 +948,9 @@ func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, yea
     startErr := ffmpegCmd.Start()
     if startErr != nil {
         logger.Printf("Error starting FFmpeg for %s: %v", fileName, startErr)
+        setSongOutcome(fileName, outcomeFailed)
+        event(eventFailed, "%s (%v)", fileName, startErr)
+        finishCreate(tempName(fileName))
         mu.Lock()
         ffmpegCmd = nil
         mu.Unlock()
@@ -468,6 +958,9 @@ func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, yea
     }
     pid := ffmpegCmd.Process.Pid
     logger.Printf("FFmpeg started, pid=%d", pid)
+    if seen != nil {
+        go limitRecording(ctx, fileName, pid, seen)
+    }
 
     // Monitor FFmpeg progress
     done := make(chan error, 1)
@@ -478,20 +971,40 @@ func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, yea
 
     select {
     case err := <-done:
+        finishedRecording()
         mu.Lock()
-        if ffmpegCmd != nil && ffmpegCmd.Process != nil && ffmpegCmd.Process.Pid == pid {
+        stopped := ffmpegCmd == nil || ffmpegCmd.Process == nil || ffmpegCmd.Process.Pid != pid
+        if !stopped {
             ffmpegCmd = nil
         }
         mu.Unlock()
+        if stopped {
+            // stopRecording decided what happens to the file
+            return
+        }
         if err != nil {
             if ctx.Err() == context.DeadlineExceeded {
                 logger.Printf("FFmpeg for %s timed out after 15 minutes, killed", fileName)
             } else {
                 logger.Printf("Error running FFmpeg for %s: %v", fileName, err)
             }
+            setSongOutcome(fileName, outcomeFailed)
+            event(eventFailed, "%s (%v)", fileName, err)
+            if err := discardFile(tempName(fileName)); err != nil && !os.IsNotExist(err) {
+                logger.Printf("Failed to remove %s: %v", tempName(fileName), err)
+            }
             return
         }
         logger.Printf("FFmpeg completed for %s", fileName)
+        mu.Lock()
+        if currentFileName == fileName {
+            // Pick up changes made while recording, such as the song being loved
+            tags = currentTags
+        }
+        mu.Unlock()
+        mergeResumed(fileName)
+        checkCaptureSource(tempName(fileName))
+        finishRecording(fileName, tags, false)
     case <-time.After(15 * time.Minute):
         logger.Printf("FFmpeg for %s did not complete within 15 minutes, forcing stop", fileName)
         mu.Lock()
@@ -504,8 +1017,241 @@ func saveSong(cfg Config, fileName, monitorSource, songTitle, artist, album, yea
     }
 }
 
+// limitRecording stops the recording of fileName by ffmpeg pid once the song it
+// started before the first countdown tick should be over, in case pianobar goes
+// quiet before the countdown ends. ctx is done when the recording is.
+func limitRecording(ctx context.Context, fileName string, pid int, seen chan struct{}) {
+    select {
+    case <-seen:
+    case <-ctx.Done():
+        return
+    }
+    mu.Lock()
+    remaining := remainingTime
+    mu.Unlock()
+    if remaining <= 0 {
+        logger.Printf("No duration for %s, recording without a limit", fileName)
+        return
+    }
+    timer := time.NewTimer(remaining + durationPad)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+    case <-ctx.Done():
+        return
+    }
+    mu.Lock()
+    current := ffmpegCmd != nil && ffmpegCmd.Process != nil && ffmpegCmd.Process.Pid == pid && currentFileName == fileName
+    mu.Unlock()
+    if current {
+        logger.Printf("Recording of %s ran past its song, stopping", fileName)
+        stopRecording(false)
+    }
+}
+
+// partialExt marks recordings that are still being written
+const partialExt = ".partial"
+
+// tempName is where a recording is written until it's finished
+func tempName(fileName string) string {
+    return fileName + partialExt
+}
+
+// listPartials finds recordings left incomplete under dir, e.g. by a crash
+// without a journal to clean up after it
+func listPartials(dir string) []string {
+    var partials []string
+    filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return nil
+        }
+        if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+            return filepath.SkipDir
+        }
+        if !info.IsDir() && strings.HasSuffix(path, partialExt) {
+            partials = append(partials, path)
+        }
+        return nil
+    })
+    return partials
+}
+
+// minRecordingSize is the smallest file accepted as a recording; anything shorter
+// than a second of audio means ffmpeg failed to capture
+const minRecordingSize = 16 << 10
+
+// commitRecording checks a finished recording and moves it to its final name
+func commitRecording(fileName string) error {
+    tmp := tempName(fileName)
+    if err := checkRecording(tmp, fileName); err != nil {
+        if discardErr := discardFile(tmp); discardErr != nil && !os.IsNotExist(discardErr) {
+            logger.Printf("Failed to remove %s: %v", tmp, discardErr)
+        }
+        return err
+    }
+    if err := os.Rename(tmp, fileName); err != nil {
+        return fmt.Errorf("failed to move recording into place: %v", err)
+    }
+    finishCreate(tmp)
+    return nil
+}
+
+// recordingFormats are the containers a recording's extension asks for
+var recordingFormats = map[string]string{
+    ".mp3":  "mp3",
+    ".aac":  "mp3", // ADTS frames start with the same sync word
+    ".flac": "flac",
+    ".ogg":  "ogg",
+    ".oga":  "ogg",
+    ".opus": "ogg",
+    ".m4a":  "mp4",
+    ".mp4":  "mp4",
+    ".wav":  "wav",
+}
+
+// sniffFormat tells the container of a file from its first bytes
+func sniffFormat(header []byte) string {
+    switch {
+    case bytes.HasPrefix(header, []byte("ID3")) || len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0:
+        return "mp3"
+    case bytes.HasPrefix(header, []byte("fLaC")):
+        return "flac"
+    case bytes.HasPrefix(header, []byte("OggS")):
+        return "ogg"
+    case len(header) >= 8 && string(header[4:8]) == "ftyp":
+        return "mp4"
+    case bytes.HasPrefix(header, []byte("RIFF")):
+        return "wav"
+    }
+    return ""
+}
+
+// checkRecording is a basic sanity check that ffmpeg wrote the audio of fileName to
+// path, in the format its extension stands for
+func checkRecording(path, fileName string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return err
+    }
+    if info.Size() < minRecordingSize {
+        return fmt.Errorf("only %d bytes recorded", info.Size())
+    }
+    header := make([]byte, 12)
+    if _, err := io.ReadFull(f, header); err != nil {
+        return err
+    }
+    format := sniffFormat(header)
+    if format == "" {
+        return fmt.Errorf("not an audio stream")
+    }
+    if want, ok := recordingFormats[strings.ToLower(filepath.Ext(fileName))]; ok && format != want {
+        return fmt.Errorf("%s stream recorded to a %s file", format, filepath.Ext(fileName))
+    }
+    return nil
+}
+
+// buildFFmpegArgs assembles the capture command line for one recording
+// Tags are written separately once the recording is finished.
+func buildFFmpegArgs(cfg Config, monitorSource, fileName string, remaining time.Duration) []string {
+    inputArgs, outputArgs := cfg.captureArgs()
+    var trimArgs []string
+    if cfg.StartOffset > 0 {
+        // Drop audio still belonging to the previous song
+        trimArgs = append(trimArgs, "-ss", fmt.Sprintf("%.2f", cfg.StartOffset.Seconds()))
+    }
+    if remaining > 0 {
+        trimArgs = append(trimArgs, "-t", fmt.Sprintf("%.1f", (remaining+durationPad).Seconds()))
+    }
+    // A leading dash would be read as an option rather than the output file
+    if strings.HasPrefix(fileName, "-") {
+        fileName = "./" + fileName
+    }
+    format := cfg.captureFormat()
+    fields := ffmpegFields{
+        Source: shellQuote(monitorSource),
+        File:   shellQuote(fileName),
+        Input:  strings.Join(inputArgs, " "),
+        Output: strings.Join(outputArgs, " "),
+        Trim:   strings.Join(trimArgs, " "),
+        Codec:  format.Codec,
+        Muxer:  format.Muxer,
+    }
+    if cfg.FFmpegArgs != nil {
+        args, err := renderFFmpegArgs(cfg.FFmpegArgs, fields)
+        if err == nil {
+            return args
+        }
+        logger.Printf("Failed to render ffmpeg_args, using the default arguments: %v", err)
+    }
+    args, _ := renderFFmpegArgs(defaultFFmpegTemplate, fields)
+    return args
+}
+
+// markCurrentLoved flags the song being recorded as loved so it gets a rating tag
+func markCurrentLoved() {
+    mu.Lock()
+    defer mu.Unlock()
+    if recording && !currentTags.Loved {
+        currentTags.Loved = true
+        logger.Printf("Marked %s as loved", currentFileName)
+    }
+}
+
+// finishRecording post-processes a recording that was kept: it fetches the cover
+// art and writes the final tags with the writer for the file's format. Incomplete
+// recordings were cut short and kept because of on_incomplete. How each step went
+// is recorded so "pianotrap process" can retry the ones that didn't.
+func finishRecording(fileName string, tags Tags, incomplete bool) {
+    if err := commitRecording(fileName); err != nil {
+        logger.Printf("Discarding %s: %v", fileName, err)
+        notice(msgDelete, "Discarding broken recording %s: %v", fileName, err)
+        setSongOutcome(fileName, outcomeFailed)
+        event(eventDeleted, "%s (%v)", fileName, err)
+        return
+    }
+    fileName = applyRename(fileName, tags)
+    fileName, fingerprintErr := identifyRecording(fileName, &tags)
+    if incomplete && onIncomplete == incompleteKeepTagged {
+        fileName = markIncomplete(fileName, currentConfig().OnCollision, &tags)
+    }
+    enrichErr := enrichTags(fileName, &tags)
+    artURL := ""
+    if len(tags.Artists) > 0 {
+        artURL = coverArtFor(tags.Title, tags.Artists[0])
+    }
+    artErr := embedCoverArt(artURL, &tags)
+    recordSavedSong(fileName, tags)
+    // Steps are recorded once the song's row has its final name
+    setArtURL(fileName, artURL)
+    recordStep(fileName, stepFingerprint, fingerprintErr)
+    recordStep(fileName, stepEnrich, enrichErr)
+    recordStep(fileName, stepArtwork, artErr)
+    if err := writeTags(fileName, tags); err != nil {
+        recordStep(fileName, stepTags, fmt.Errorf("failed to write tags: %v", err))
+    } else {
+        logger.Printf("Wrote tags to %s (cover art: %v)", fileName, len(tags.Picture) > 0)
+        recordStep(fileName, stepTags, nil)
+    }
+    recordStep(fileName, stepChecksum, recordChecksum(fileName))
+    updatePlaylists(fileName)
+    recordStep(fileName, stepArchive, archiveRecording(fileName, tags, time.Now()))
+    noteCaptured(fileName)
+    event(eventSaved, "%s", fileName)
+}
+
 func cleanExit(pianobarCmd *exec.Cmd, code int) {
     stopRecording(true)
+    cleanupPianobarEvents()
+    stopControlSocket()
+    cleanupAudioOutput()
+    unloadSessionSink(sessionSinkName())
+    stopStatusBar()
+    stopTUI()
     if pianobarCmd != nil && pianobarCmd.Process != nil {
         pianobarCmd.Process.Kill()
     }
@@ -516,28 +1262,51 @@ func cleanExit(pianobarCmd *exec.Cmd, code int) {
     os.Exit(code)
 }
 
-func stripANSI(s string) string {
-    re := regexp.MustCompile(`\x1B\[[0-9;]*[a-zA-Z]`)
-    return re.ReplaceAllString(s, "")
+// tagsLookIncomplete reports whether the parsed song info is missing, has unbalanced quotes or looks truncated
+func tagsLookIncomplete(title, artist, album string) bool {
+    for _, field := range []string{title, artist, album} {
+        field = strings.TrimSpace(field)
+        if field == "" || strings.Count(field, "\"")%2 == 1 {
+            return true
+        }
+        if strings.HasSuffix(field, "...") || strings.HasSuffix(field, "…") {
+            return true
+        }
+    }
+    return false
 }
 
+var unsafeFileCharsRe = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f\x7f]`)
+
+// recordingName is the file name for a recording with the given tags
+func recordingName(tags Tags, ext string) string {
+    artist := ""
+    if len(tags.Artists) > 0 {
+        artist = tags.Artists[0]
+    }
+    return sanitizeFileName(fmt.Sprintf("%s - %s - %s (%s)%s", tags.Title, artist, tags.Album, tags.Year, ext))
+}
+
+// sanitizeFileName makes s safe to use as a single path element under the file name
+// profile. Names made only of dots (or nothing at all) would refer to the current or
+// parent directory, so they are replaced as well.
 func sanitizeFileName(s string) string {
-    re := regexp.MustCompile(`[<>:"/\\|?*]`)
-    return re.ReplaceAllString(s, "_")
+    return nameProfile.sanitizeName(s)
 }
 
+// parseTime parses a pianobar clock value in m:ss or h:mm:ss form
 func parseTime(s string) (time.Duration, error) {
     parts := strings.Split(s, ":")
-    if len(parts) != 2 {
+    if len(parts) != 2 && len(parts) != 3 {
         return 0, fmt.Errorf("invalid time format: %s", s)
     }
-    mins, err := time.ParseDuration(parts[0] + "m")
-    if err != nil {
-        return 0, err
-    }
-    secs, err := time.ParseDuration(parts[1] + "s")
-    if err != nil {
-        return 0, err
+    var total time.Duration
+    for _, part := range parts {
+        n, err := strconv.Atoi(part)
+        if err != nil || n < 0 || n > 9999 {
+            return 0, fmt.Errorf("invalid time format: %s", s)
+        }
+        total = total*60 + time.Duration(n)
     }
-    return mins + secs, nil
+    return total * time.Second, nil
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+    "unicode"
+)
+
+func init() {
+    // Code under test logs through the global logger
+    logger = log.New(ioutil.Discard, "", 0)
+}
+
+// openTestDB opens a song database of the test's own as db until the test ends, and
+// returns its path
+func openTestDB(t *testing.T) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "pianotrap.db")
+    conn, err := openDatabase(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    db = conn
+    t.Cleanup(func() {
+        db.Close()
+        db = nil
+    })
+    return path
+}
+
+// playTestSong makes title by artist the song playing until the test ends, announced
+// by a songstart event with cover art from a test server, and returns the art
+func playTestSong(t *testing.T, title, artist string) []byte {
+    t.Helper()
+    art := []byte("\xff\xd8\xff\xe0 cover art")
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write(art)
+    }))
+    t.Cleanup(server.Close)
+    t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+    mu.Lock()
+    playing := nowPlaying
+    nowPlaying = songInfo{Title: title, Artist: artist}
+    mu.Unlock()
+    t.Cleanup(func() {
+        mu.Lock()
+        nowPlaying = playing
+        mu.Unlock()
+    })
+    handlePianobarEvent(map[string]string{"event": "songstart", "title": title, "artist": artist, "coverArt": server.URL + "/cover.jpg"})
+    return art
+}
+
+func TestTagsLookIncomplete(t *testing.T) {
+    tests := []struct {
+        title, artist, album string
+        want                 bool
+    }{
+        {"Song", "Artist", "Album", false},
+        {`The "Real" Slim Shady`, "Eminem", "LP", false},
+        {"Song", "", "Album", true},
+        {"Song", "Artist", `Album "Deluxe`, true},
+        {"Song", "Artist", "Greatest Hits...", true},
+    }
+    for _, tt := range tests {
+        if got := tagsLookIncomplete(tt.title, tt.artist, tt.album); got != tt.want {
+            t.Errorf("tagsLookIncomplete(%q, %q, %q) = %v; want %v", tt.title, tt.artist, tt.album, got, tt.want)
+        }
+    }
+}
+
+func TestCheckRecording(t *testing.T) {
+    dir := t.TempDir()
+    for _, tt := range []struct {
+        name   string
+        header string
+        ok     bool
+    }{
+        {"song.mp3", "ID3\x04", true},
+        {"song.mp3", "\xff\xfb\x90\x64", true},
+        {"song.aac", "\xff\xf1\x50\x80", true},
+        {"song.aac", "ID3\x04", true},
+        {"song.m4a", "\x00\x00\x00\x20ftypM4A ", true},
+        {"song.opus", "OggS\x00\x02", true},
+        {"song.flac", "fLaC", true},
+        {"song.flac", "ID3\x04", false},
+        {"song.mp3", "\x00\x00\x00\x20ftypM4A ", false},
+        {"song.wma", "\x30\x26\xb2\x75", false},
+    } {
+        path := filepath.Join(dir, tt.name+".partial")
+        data := append([]byte(tt.header), make([]byte, minRecordingSize)...)
+        if err := ioutil.WriteFile(path, data, 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := checkRecording(path, tt.name); (err == nil) != tt.ok {
+            t.Errorf("checkRecording(%q, %q) = %v", tt.header, tt.name, err)
+        }
+    }
+}
+
+func TestBuildFFmpegArgsDashFileName(t *testing.T) {
+    args := buildFFmpegArgs(Config{}, "src.monitor", "-y.mp3", 0)
+    if got := args[len(args)-1]; got != "./-y.mp3" {
+        t.Errorf("output argument = %q; want %q", got, "./-y.mp3")
+    }
+}
+
+// FuzzSongLineToFFmpegArgs runs a line of pianobar output through the parser, the
+// capture command builder and the ffmpeg tag arguments, and checks the resulting argv
+// can't be corrupted by the song fields
+func FuzzSongLineToFFmpegArgs(f *testing.F) {
+    for _, seed := range []string{
+        `|>  "Song" by "Artist" on "Album"`,
+        `|>  "The "Real" Slim Shady" by "Eminem" on "LP" <3`,
+        `|>  "-y" by "-i /etc/passwd" on "x=y\r-metadata"`,
+        "|>  \"a\x00b\" by \"c\x1b[2Kd\" on \"e\u2028f\"",
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, line string) {
+        info, ok := parseSongLine(line)
+        if !ok {
+            return
+        }
+        info = sanitizeSongInfo(info)
+        fileName := filepath.Join("/music", "Station", sanitizeFileName(fmt.Sprintf("%s - %s - %s (2024).mp3", info.Title, info.Artist, info.Album)))
+        args := buildFFmpegArgs(Config{}, "src.monitor", fileName, 0)
+        want := buildFFmpegArgs(Config{}, "src.monitor", "/out.mp3", 0)
+        if len(args) != len(want) {
+            t.Fatalf("argument count changed with song fields: %q", args)
+        }
+        if args[len(args)-1] != fileName {
+            t.Fatalf("output file = %q; want %q", args[len(args)-1], fileName)
+        }
+        if strings.ContainsAny(filepath.Base(fileName), "/\n\r") {
+            t.Fatalf("file name %q contains a separator or line break", fileName)
+        }
+
+        tags := [][2]string{
+            {"title", info.Title},
+            {"artist", info.Artist},
+            {"album", info.Album},
+            {"date", "2024"},
+        }
+        args = metadataArgs(tags)
+        if len(args) != 2*len(tags) {
+            t.Fatalf("found %d metadata arguments; want %d", len(args), 2*len(tags))
+        }
+        for i := 0; i < len(args); i += 2 {
+            value := args[i+1]
+            if args[i] != "-metadata" || !strings.HasPrefix(value, tags[i/2][0]+"=") {
+                t.Fatalf("metadata argument %q does not start with key %q", value, tags[i/2][0])
+            }
+            for _, r := range value {
+                if unicode.IsControl(r) {
+                    t.Fatalf("metadata argument %q contains control character %U", value, r)
+                }
+            }
+        }
+    })
+}
+
+func TestSanitizeFileName(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"AC/DC Radio", "AC_DC Radio"},
+        {`What? "Yes": <No>`, "What_ _Yes__ _No_"},
+        {"..", "__"},
+        {".", "_"},
+        {"", "_"},
+        {"a\nb", "a_b"},
+        {"...And Justice", "...And Justice"},
+    }
+    for _, tt := range tests {
+        if got := sanitizeFileName(tt.in); got != tt.want {
+            t.Errorf("sanitizeFileName(%q) = %q; want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func FuzzSanitizeFileName(f *testing.F) {
+    for _, seed := range []string{"", ".", "..", "a/b", "..\\..", "x\x00y"} {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, s string) {
+        got := sanitizeFileName(s)
+        if got == "" || got == "." || got == ".." {
+            t.Fatalf("sanitizeFileName(%q) = %q", s, got)
+        }
+        if strings.ContainsAny(got, "/\\\x00\n") {
+            t.Fatalf("sanitizeFileName(%q) = %q contains a separator or control character", s, got)
+        }
+        if filepath.Base(filepath.Join("/music", got)) != got {
+            t.Fatalf("sanitizeFileName(%q) = %q is not a single path element", s, got)
+        }
+    })
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// playlistEntry is one track of a generated playlist
+type playlistEntry struct {
+    File     string
+    Artist   string
+    Title    string
+    Station  string
+    Detected time.Time
+}
+
+// writeM3U writes an extended M3U playlist with paths relative to the playlist
+func writeM3U(path string, entries []playlistEntry) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return fmt.Errorf("failed to create playlist directory: %v", err)
+    }
+    var b strings.Builder
+    b.WriteString("#EXTM3U\n")
+    for _, e := range entries {
+        file := e.File
+        if rel, err := filepath.Rel(filepath.Dir(path), e.File); err == nil {
+            file = rel
+        }
+        fmt.Fprintf(&b, "#EXTINF:-1,%s - %s\n%s\n", e.Artist, e.Title, file)
+    }
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+        return fmt.Errorf("failed to write playlist: %v", err)
+    }
+    return os.Rename(tmp, path)
+}
+
+// bestOfPeriods are the playlists best-of generation can maintain
+var bestOfPeriods = map[string]struct {
+    name   string
+    window time.Duration
+}{
+    "daily":  {"Best of the day", 24 * time.Hour},
+    "weekly": {"Best of the week", 7 * 24 * time.Hour},
+}
+
+// loadBestOfConfig reads "bestof = daily, weekly", bestof_size and bestof_mixtape
+func loadBestOfConfig(values map[string]string, cfg *Config) error {
+    for _, period := range configList(values["bestof"]) {
+        if period = strings.TrimSpace(period); period == "" {
+            continue
+        }
+        if _, ok := bestOfPeriods[period]; !ok {
+            return fmt.Errorf("invalid value for bestof: %q (want daily and/or weekly)", period)
+        }
+        cfg.BestOf = append(cfg.BestOf, period)
+    }
+    size, err := configInt(values, "bestof_size", 25)
+    if err != nil {
+        return err
+    }
+    if size < 1 {
+        return fmt.Errorf("invalid value for bestof_size: %d", size)
+    }
+    cfg.BestOfSize = size
+    cfg.BestOfMixtape = values["bestof_mixtape"] == "true"
+    return nil
+}
+
+// bestOfSongs ranks the songs saved after since: loved songs first, then the ones
+// Pandora played most often, then the most recent
+func bestOfSongs(since time.Time, limit int) ([]playlistEntry, error) {
+    rows, err := db.Query(`SELECT s.file, s.artist, s.title, s.station, s.detected_at FROM songs s
+        JOIN (SELECT artist, title, MAX(loved) AS loved, COUNT(*) AS plays, MAX(CASE WHEN outcome = ? THEN id END) AS latest
+              FROM songs WHERE detected_at >= ? GROUP BY artist COLLATE NOCASE, title COLLATE NOCASE) r ON s.id = r.latest
+        ORDER BY r.loved DESC, r.plays DESC, s.detected_at DESC LIMIT ?`, outcomeSaved, since.UTC(), limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query database: %v", err)
+    }
+    defer rows.Close()
+    var entries []playlistEntry
+    for rows.Next() {
+        var e playlistEntry
+        if err := rows.Scan(&e.File, &e.Artist, &e.Title, &e.Station, &e.Detected); err != nil {
+            return nil, fmt.Errorf("failed to read database: %v", err)
+        }
+        if _, err := os.Stat(e.File); err == nil {
+            entries = append(entries, e)
+        }
+    }
+    return entries, rows.Err()
+}
+
+// startBestOf regenerates the configured best-of playlists every hour
+func startBestOf(cfg Config) {
+    if len(cfg.BestOf) == 0 || db == nil {
+        return
+    }
+    go func() {
+        rendered := make(map[string]string)
+        for {
+            for _, period := range cfg.BestOf {
+                p := bestOfPeriods[period]
+                entries, err := bestOfSongs(time.Now().Add(-p.window), cfg.BestOfSize)
+                if err != nil {
+                    logger.Printf("Best of: %v", err)
+                    continue
+                }
+                base := filepath.Join(cfg.SaveDir, "Playlists", p.name)
+                if err := writeM3U(base+".m3u8", entries); err != nil {
+                    logger.Printf("Best of: %v", err)
+                    continue
+                }
+                // Only re-render the mixtape when its track list changed
+                var key strings.Builder
+                for _, e := range entries {
+                    key.WriteString(e.File + "\n")
+                }
+                if cfg.BestOfMixtape && len(entries) > 0 && rendered[period] != key.String() {
+                    if err := renderMixtape(entries, base+".m4a", p.name); err != nil {
+                        logger.Printf("Best of: %v", err)
+                    } else {
+                        rendered[period] = key.String()
+                    }
+                }
+            }
+            time.Sleep(time.Hour)
+        }
+    }()
+}
+
+// renderMixtape concatenates the entries into one AAC file with a chapter per track
+func renderMixtape(entries []playlistEntry, output, title string) error {
+    dir, err := ioutil.TempDir("", "pianotrap-mixtape")
+    if err != nil {
+        return fmt.Errorf("failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    var list, chapters strings.Builder
+    fmt.Fprintf(&chapters, ";FFMETADATA1\ntitle=%s\n", escapeFFMetadata(title))
+    var offset time.Duration
+    for _, e := range entries {
+        d, err := probeDuration(e.File)
+        if err != nil {
+            return err
+        }
+        abs, _ := filepath.Abs(e.File)
+        fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+        fmt.Fprintf(&chapters, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+            offset.Milliseconds(), (offset + d).Milliseconds(), escapeFFMetadata(e.Artist+" - "+e.Title))
+        offset += d
+    }
+    listFile := filepath.Join(dir, "list.txt")
+    metaFile := filepath.Join(dir, "chapters.txt")
+    if err := ioutil.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+        return fmt.Errorf("failed to write mixtape list: %v", err)
+    }
+    if err := ioutil.WriteFile(metaFile, []byte(chapters.String()), 0644); err != nil {
+        return fmt.Errorf("failed to write mixtape chapters: %v", err)
+    }
+
+    tmp := output + ".tmp.m4a"
+    cmd := exec.Command(ffmpegBinary(), "-v", "error", "-f", "concat", "-safe", "0", "-i", listFile,
+        "-i", metaFile, "-map_metadata", "1", "-map_chapters", "1", "-map", "0:a",
+        "-c:a", "aac", "-b:a", "192k", "-y", tmp)
+    if out, err := cmd.CombinedOutput(); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("ffmpeg failed to render %s: %v: %s", output, err, strings.TrimSpace(string(out)))
+    }
+    return os.Rename(tmp, output)
+}
+
+// probeDuration asks ffprobe for the length of an audio file
+func probeDuration(fileName string) (time.Duration, error) {
+    out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=nw=1:nk=1", fileName).Output()
+    if err != nil {
+        return 0, fmt.Errorf("ffprobe failed for %s: %v", fileName, err)
+    }
+    d, err := time.ParseDuration(strings.TrimSpace(string(out)) + "s")
+    if err != nil {
+        return 0, fmt.Errorf("invalid duration for %s: %q", fileName, strings.TrimSpace(string(out)))
+    }
+    return d, nil
+}
+
+// escapeFFMetadata escapes the characters special to ffmpeg's metadata file format
+func escapeFFMetadata(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n").Replace(s)
+}
+
+var (
+    // playlistRoot is the save directory playlists are maintained in, or empty when
+    // station and session playlists are disabled
+    playlistRoot string
+)
+
+// savedSongsBetween lists saved recordings detected in [from, to), optionally only
+// from one station, in capture order
+func savedSongsBetween(station string, from, to time.Time) ([]playlistEntry, error) {
+    songs, err := querySongs(songFilter{Station: station, Outcome: outcomeSaved, Since: from, Until: to})
+    if err != nil {
+        return nil, err
+    }
+    var entries []playlistEntry
+    for _, s := range songs {
+        if station != "" && s.Station != station {
+            continue // Station filters on substrings
+        }
+        entries = append(entries, playlistEntry{File: s.File, Artist: s.Artist, Title: s.Title, Station: s.Station, Detected: s.DetectedAt})
+    }
+    return entries, nil
+}
+
+// updatePlaylists rewrites the station's playlist for the day and the session
+// playlist after fileName was saved
+func updatePlaylists(fileName string) {
+    if playlistRoot == "" || db == nil {
+        return
+    }
+    var station string
+    var detected time.Time
+    err := db.QueryRow("SELECT station, detected_at FROM songs WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)", fileName).Scan(&station, &detected)
+    if err != nil {
+        logger.Printf("Playlists: failed to look up %s: %v", fileName, err)
+        return
+    }
+    detected = detected.Local()
+    day := time.Date(detected.Year(), detected.Month(), detected.Day(), 0, 0, 0, 0, time.Local)
+    entries, err := savedSongsBetween(station, day, day.AddDate(0, 0, 1))
+    if err == nil {
+        err = writeM3U(filepath.Join(playlistRoot, station, day.Format("2006-01-02")+".m3u8"), entries)
+    }
+    if err != nil {
+        logger.Printf("Playlists: %v", err)
+    }
+
+    session := currentSession()
+    entries, err = savedSongsBetween("", session, time.Now().Add(time.Minute))
+    if err == nil {
+        err = writeM3U(filepath.Join(playlistRoot, "Playlists", "Session "+session.Format("2006-01-02 15.04")+".m3u8"), entries)
+    }
+    if err != nil {
+        logger.Printf("Playlists: %v", err)
+    }
+}
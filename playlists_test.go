@@ -0,0 +1,41 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestBestOfSongs(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+
+    file := func(name string) string {
+        path := filepath.Join(dir, name)
+        if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+        return path
+    }
+    logDetectedSong(songInfo{Title: "Once", Artist: "A"}, "S", file("once.mp3"), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Twice", Artist: "B"}, "S", file("twice-1.mp3"), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Twice", Artist: "B"}, "S", file("twice-2.mp3"), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Loved", Artist: "C", Loved: true}, "S", file("loved.mp3"), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Gone", Artist: "D"}, "S", filepath.Join(dir, "gone.mp3"), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Skipped", Artist: "E", Loved: true}, "S", "", outcomeSkipped)
+
+    entries, err := bestOfSongs(time.Now().Add(-time.Hour), 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, e := range entries {
+        got = append(got, filepath.Base(e.File))
+    }
+    want := []string{"loved.mp3", "twice-2.mp3", "once.mp3"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("bestOfSongs = %v, want %v", got, want)
+    }
+}
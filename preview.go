@@ -0,0 +1,143 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+// editTagsKey (Ctrl+E) opens the tag editor for the song being recorded. pianobar
+// doesn't use it, so it's never forwarded.
+const editTagsKey = 0x05
+
+// renames maps recordings to the file name they get once finished, after their
+// title or artist was corrected while recording. Guarded by mu.
+var renames = make(map[string]string)
+
+// tagPreview is the part of a recording's tags shown while it's being recorded
+type tagPreview struct {
+    Title   string   `json:"title"`
+    Artists []string `json:"artists"`
+    Album   string   `json:"album"`
+    Year    string   `json:"year"`
+    Genre   string   `json:"genre,omitempty"`
+    Loved   bool     `json:"loved"`
+}
+
+func newTagPreview(tags Tags) *tagPreview {
+    return &tagPreview{Title: tags.Title, Artists: tags.Artists, Album: tags.Album, Year: tags.Year, Genre: tags.Genre, Loved: tags.Loved}
+}
+
+// printTagPreview shows the tags a new recording will get
+func printTagPreview(tags Tags) {
+    fmt.Printf("\r\nTags: %q by %q on %q (%s)", tags.Title, strings.Join(tags.Artists, ", "), tags.Album, tags.Year)
+    if tags.Genre != "" {
+        fmt.Printf(", genre %s", tags.Genre)
+    }
+    fmt.Printf(" - Ctrl+E to correct\r\n")
+}
+
+// editCurrentTags prompts for a corrected title and artist for the song being
+// recorded. It reads the terminal directly, so it must run on the stdin goroutine.
+func editCurrentTags() {
+    mu.Lock()
+    fileName, tags, active := currentFileName, currentTags, recording
+    mu.Unlock()
+    if !active {
+        fmt.Printf("\r\nNot recording, nothing to edit\r\n")
+        return
+    }
+
+    artist := ""
+    if len(tags.Artists) > 0 {
+        artist = tags.Artists[0]
+    }
+    title, ok := readLine("Title: ", tags.Title)
+    if !ok {
+        return
+    }
+    newArtist, ok := readLine("Artist: ", artist)
+    if !ok {
+        return
+    }
+    title, newArtist = sanitizeTagValue(title), sanitizeTagValue(newArtist)
+    if title == "" || newArtist == "" || (title == tags.Title && newArtist == artist) {
+        fmt.Printf("\r\nTags unchanged\r\n")
+        return
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+    if !recording || currentFileName != fileName {
+        fmt.Printf("\r\nThe song changed while editing, tags not applied\r\n")
+        return
+    }
+    currentTags.Title = title
+    if newArtist != artist {
+        currentTags.Artists = []string{newArtist}
+    }
+    renames[fileName] = renamedPath(fileName, currentTags)
+    logger.Printf("Tags of %s corrected to %q by %q", fileName, title, newArtist)
+    fmt.Printf("\r\nWill save as: %s\r\n", renames[fileName])
+}
+
+// applyRename moves a finished recording to the name chosen while editing its tags
+func applyRename(fileName string, tags Tags) string {
+    mu.Lock()
+    newName, ok := renames[fileName]
+    delete(renames, fileName)
+    mu.Unlock()
+    if !ok || newName == fileName {
+        return fileName
+    }
+    if err := renameFile(fileName, newName); err != nil {
+        logger.Printf("Failed to rename %s: %v", fileName, err)
+        return fileName
+    }
+    renameSong(fileName, newName, tags)
+    return newName
+}
+
+// readLine reads a line of input from the raw-mode terminal, starting from initial.
+// Enter accepts it; Escape or Ctrl+C cancels.
+func readLine(prompt, initial string) (string, bool) {
+    line := []rune(initial)
+    fmt.Printf("\r\n%s%s", prompt, initial)
+    buf := make([]byte, 4)
+    var pending []byte
+    for {
+        n, err := os.Stdin.Read(buf[:1])
+        if err != nil || n == 0 {
+            return "", false
+        }
+        b := buf[0]
+        switch {
+        case b == '\r' || b == '\n':
+            fmt.Printf("\r\n")
+            return string(line), true
+        case b == 0x1b || b == 0x03:
+            fmt.Printf("\r\nEdit cancelled\r\n")
+            return "", false
+        case b == 0x7f || b == 0x08:
+            if len(line) > 0 {
+                line = line[:len(line)-1]
+                fmt.Printf("\b \b")
+            }
+        case b == 0x15: // Ctrl+U clears the line
+            for range line {
+                fmt.Printf("\b \b")
+            }
+            line = line[:0]
+        case b >= 0x20:
+            // Collect multi-byte UTF-8 sequences before echoing them
+            pending = append(pending, b)
+            if s := string(pending); strings.ToValidUTF8(s, "") == s {
+                line = append(line, []rune(s)...)
+                fmt.Print(s)
+                pending = pending[:0]
+            } else if len(pending) >= 4 {
+                pending = pending[:0]
+            }
+        }
+    }
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Each post-processing step of a saved recording is recorded in the steps table as
+// done, failed or skipped (not configured at the time). "pianotrap process" lists
+// the failures, and with -retry-failed runs again just the steps that aren't done,
+// e.g. after adding an AcoustID key or fixing the announce archive, rewriting the
+// tags and checksum only when something changed.
+
+// Post-processing steps, in the order they run
+const (
+    stepFingerprint = "fingerprint"
+    stepEnrich      = "enrich"
+    stepArtwork     = "artwork"
+    stepTags        = "tags"
+    stepChecksum    = "checksum"
+    stepArchive     = "archive"
+)
+
+var processingSteps = []string{stepFingerprint, stepEnrich, stepArtwork, stepTags, stepChecksum, stepArchive}
+
+// Step statuses
+const (
+    stepDone    = "done"
+    stepFailed  = "failed"
+    stepSkipped = "skipped"
+)
+
+// errNotConfigured is returned by a step that is turned off or lacks what it needs
+var errNotConfigured = errors.New("not configured")
+
+// stepState is how a step last went
+type stepState struct {
+    Status string
+    Error  string
+}
+
+// recordStep stores the outcome of step for the latest recording of fileName
+func recordStep(fileName, step string, err error) {
+    status, msg := stepDone, ""
+    if err == errNotConfigured {
+        status = stepSkipped
+    } else if err != nil {
+        status, msg = stepFailed, err.Error()
+        logger.Printf("Step %s failed for %s: %v", step, fileName, err)
+    }
+    if db == nil {
+        return
+    }
+    _, dbErr := db.Exec(`INSERT OR REPLACE INTO steps (song_id, step, status, error, at)
+        SELECT MAX(id), ?, ?, ?, ? FROM songs WHERE file = ? HAVING MAX(id) IS NOT NULL`,
+        step, status, msg, time.Now().UTC(), fileName)
+    if dbErr != nil {
+        logger.Printf("Failed to update %s in database: %v", fileName, dbErr)
+    }
+}
+
+// setArtURL remembers the cover art pianobar reported, so the artwork step can be
+// retried in a later run
+func setArtURL(fileName, artURL string) {
+    if db == nil || artURL == "" {
+        return
+    }
+    if _, err := db.Exec("UPDATE songs SET art_url = ? WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)", artURL, fileName); err != nil {
+        logger.Printf("Failed to update %s in database: %v", fileName, err)
+    }
+}
+
+// embedCoverArt downloads the cover art at artURL into tags
+func embedCoverArt(artURL string, tags *Tags) error {
+    if artURL == "" {
+        return errNotConfigured // pianobar reported none
+    }
+    artFile, err := fetchCoverArt(artURL)
+    if err != nil {
+        return fmt.Errorf("cover art: %v", err)
+    }
+    data, err := ioutil.ReadFile(artFile)
+    if err != nil {
+        return fmt.Errorf("cover art: %v", err)
+    }
+    tags.Picture = data
+    tags.PictureMIME = http.DetectContentType(data)
+    return nil
+}
+
+// processedSong is a saved recording with the state of its steps
+type processedSong struct {
+    ID                                  int64
+    File, Title, Artist, Album, Station string
+    ArtURL                              string
+    Loved                               bool
+    DetectedAt                          time.Time
+    Steps                               map[string]stepState
+}
+
+// pending reports whether step still has to run: it failed, was skipped or never ran
+func (s processedSong) pending(step string) bool {
+    return s.Steps[step].Status != stepDone
+}
+
+// failedSteps lists the steps that failed, with their errors
+func (s processedSong) failedSteps() []string {
+    var failed []string
+    for _, step := range processingSteps {
+        if state, ok := s.Steps[step]; ok && state.Status == stepFailed {
+            failed = append(failed, fmt.Sprintf("%s (%s)", step, state.Error))
+        }
+    }
+    return failed
+}
+
+// unfinishedSongs returns the saved recordings with steps that aren't done.
+// Recordings from before steps were tracked have no rows and aren't included.
+func unfinishedSongs() ([]processedSong, error) {
+    rows, err := db.Query(`SELECT s.id, s.file, s.title, s.artist, s.album, s.station, s.art_url, s.loved, s.detected_at,
+            st.step, st.status, st.error
+        FROM songs s JOIN steps st ON st.song_id = s.id
+        WHERE s.outcome = ? ORDER BY s.id`, outcomeSaved)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query database: %v", err)
+    }
+    defer rows.Close()
+    songs := make(map[int64]*processedSong)
+    var ids []int64
+    for rows.Next() {
+        var s processedSong
+        var step string
+        var state stepState
+        if err := rows.Scan(&s.ID, &s.File, &s.Title, &s.Artist, &s.Album, &s.Station, &s.ArtURL, &s.Loved, &s.DetectedAt,
+            &step, &state.Status, &state.Error); err != nil {
+            return nil, fmt.Errorf("failed to read database: %v", err)
+        }
+        if songs[s.ID] == nil {
+            s.Steps = make(map[string]stepState)
+            songs[s.ID] = &s
+            ids = append(ids, s.ID)
+        }
+        songs[s.ID].Steps[step] = state
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read database: %v", err)
+    }
+
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    var unfinished []processedSong
+    for _, id := range ids {
+        s := *songs[id]
+        for _, step := range processingSteps {
+            if s.pending(step) {
+                unfinished = append(unfinished, s)
+                break
+            }
+        }
+    }
+    return unfinished, nil
+}
+
+// retrySteps runs the steps of s that aren't done and records how they went. Tags
+// and the checksum are rewritten when an earlier step changed something.
+func retrySteps(s processedSong) map[string]error {
+    tags, err := readTags(s.File)
+    if err != nil || tags.Title == "" {
+        // The tags were never written; start from what the database knows
+        tags = Tags{Title: s.Title, Artists: []string{s.Artist}, Album: s.Album, Loved: s.Loved}
+    }
+    if tags.Custom == nil {
+        tags.Custom = make(map[string]string)
+    }
+    if tags.Custom["STATION"] == "" {
+        tags.Custom["STATION"] = s.Station
+    }
+
+    fileName := s.File
+    results := make(map[string]error)
+    changed := false
+    if s.pending(stepFingerprint) {
+        fileName, err = identifyRecording(fileName, &tags)
+        results[stepFingerprint] = err
+        changed = changed || err == nil
+    }
+    if s.pending(stepEnrich) {
+        err = enrichTags(fileName, &tags)
+        results[stepEnrich] = err
+        changed = changed || err == nil
+    }
+    if s.pending(stepArtwork) {
+        err = embedCoverArt(s.ArtURL, &tags)
+        results[stepArtwork] = err
+        changed = changed || err == nil
+    }
+    rewritten := false
+    if s.pending(stepTags) || changed {
+        err = writeTags(fileName, tags)
+        if err != nil {
+            err = fmt.Errorf("failed to write tags: %v", err)
+        }
+        results[stepTags] = err
+        rewritten = err == nil
+    }
+    if changed {
+        updateSongMetadata(fileName, tags)
+    }
+    if s.pending(stepChecksum) || rewritten {
+        results[stepChecksum] = recordChecksum(fileName)
+    }
+    if s.pending(stepArchive) {
+        results[stepArchive] = archiveRecording(fileName, tags, s.DetectedAt)
+    }
+    for step, err := range results {
+        recordStep(fileName, step, err)
+    }
+    return results
+}
+
+// updateSongMetadata stores what retried steps learned about a recording
+func updateSongMetadata(fileName string, tags Tags) {
+    _, err := db.Exec(`UPDATE songs SET album = ?, acoustid = ?, fingerprint = ?
+        WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)`,
+        tags.Album, tags.Custom["ACOUSTID_ID"], tags.Custom["ACOUSTID_FINGERPRINT"], fileName)
+    if err != nil {
+        logger.Printf("Failed to update %s in database: %v", fileName, err)
+    }
+}
+
+// runProcess implements "pianotrap process"
+func runProcess(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("process", flag.ContinueOnError)
+    retry := fs.Bool("retry-failed", false, "run the steps that failed or were skipped again")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+    acoustIDKey = cfg.AcoustIDKey
+    enrichMetadata = cfg.Enrich
+    discogsToken = cfg.DiscogsToken
+    archive.announcer = cfg.Announce
+    archive.saveDir = cfg.SaveDir
+    archive.dir = cfg.AnnounceArchive
+    setLayout(cfg)
+
+    songs, err := unfinishedSongs()
+    if err != nil {
+        return err
+    }
+    if !*retry {
+        skipped := 0
+        for _, s := range songs {
+            if failed := s.failedSteps(); len(failed) > 0 {
+                fmt.Printf("%s: %s\n", s.File, strings.Join(failed, ", "))
+            } else {
+                skipped++
+            }
+        }
+        if skipped > 0 {
+            fmt.Printf("%d more recordings have steps that were skipped because they weren't configured\n", skipped)
+        }
+        if len(songs) > 0 {
+            fmt.Println("Run pianotrap process -retry-failed to run them again")
+        }
+        return nil
+    }
+
+    var retried, stillFailing int
+    for _, s := range songs {
+        if _, err := os.Stat(s.File); err != nil {
+            fmt.Printf("Skipping %s: %v\n", s.File, err)
+            continue
+        }
+        var done, failed []string
+        results := retrySteps(s)
+        for _, step := range processingSteps {
+            err, ok := results[step]
+            switch {
+            case !ok || err == errNotConfigured:
+            case err != nil:
+                failed = append(failed, fmt.Sprintf("%s (%v)", step, err))
+            default:
+                done = append(done, step)
+            }
+        }
+        if len(done) > 0 {
+            fmt.Printf("%s: %s done\n", s.File, strings.Join(done, ", "))
+        }
+        if len(failed) > 0 {
+            fmt.Printf("%s: %s failed\n", s.File, strings.Join(failed, ", "))
+            stillFailing++
+        }
+        retried++
+    }
+    fmt.Printf("Processed %d recordings, %d still failing\n", retried, stillFailing)
+    return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestProcessingSteps(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+
+    file := filepath.Join(dir, "song.mp3")
+    if err := ioutil.WriteFile(file, []byte("audio"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    logDetectedSong(songInfo{Title: "Song", Artist: "A"}, "S", file, outcomeSaved)
+    logDetectedSong(songInfo{Title: "Done", Artist: "B"}, "S", filepath.Join(dir, "done.mp3"), outcomeSaved)
+    for _, step := range processingSteps {
+        recordStep(filepath.Join(dir, "done.mp3"), step, nil)
+    }
+    recordStep(file, stepFingerprint, errNotConfigured)
+    recordStep(file, stepEnrich, fmt.Errorf("timeout"))
+    recordStep(file, stepTags, nil)
+    recordStep(file, stepChecksum, nil)
+    // No row for an unknown file
+    recordStep(filepath.Join(dir, "unknown.mp3"), stepTags, nil)
+
+    songs, err := unfinishedSongs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(songs) != 1 || songs[0].File != file {
+        t.Fatalf("unfinishedSongs = %v, want only %s", songs, file)
+    }
+    s := songs[0]
+    if got := s.failedSteps(); !reflect.DeepEqual(got, []string{"enrich (timeout)"}) {
+        t.Errorf("failedSteps = %v", got)
+    }
+    for step, want := range map[string]bool{stepFingerprint: true, stepEnrich: true, stepArtwork: true, stepTags: false, stepChecksum: false, stepArchive: true} {
+        if s.pending(step) != want {
+            t.Errorf("pending(%s) = %v, want %v", step, !want, want)
+        }
+    }
+
+    // With nothing configured the steps are skipped and the file is left alone
+    enrichMetadata, archive.dir = false, ""
+    results := retrySteps(s)
+    if _, ok := results[stepTags]; ok {
+        t.Error("tags rewritten although nothing changed")
+    }
+    if results[stepEnrich] != errNotConfigured {
+        t.Errorf("enrich = %v, want errNotConfigured", results[stepEnrich])
+    }
+    var status string
+    if err := db.QueryRow("SELECT status FROM steps WHERE step = ? AND song_id = ?", stepEnrich, s.ID).Scan(&status); err != nil || status != stepSkipped {
+        t.Errorf("enrich step = %q, %v; want skipped", status, err)
+    }
+    var count int
+    db.QueryRow("SELECT COUNT(*) FROM steps").Scan(&count)
+    if count != 12 {
+        t.Errorf("%d step rows, want 12", count)
+    }
+}
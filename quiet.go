@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// With -quiet (or quiet = true) pianobar's output isn't shown and pianotrap's own
+// messages go to the log only. What's left is one line per event, easy to follow
+// under a supervisor or in a small terminal:
+//
+//     2024-05-01 20:15:03 station Deep Focus Radio
+//     2024-05-01 20:15:04 detected Weightless by Marconi Union
+//     2024-05-01 20:15:04 recording Weightless by Marconi Union
+//     2024-05-01 20:23:12 saved /music/Deep Focus Radio/Weightless.mp3
+//
+// Keys still go to pianobar, but its prompts are hidden too.
+
+// quiet is set from Config.Quiet on startup
+var quiet bool
+
+const (
+    eventStation   = "station"
+    eventDetected  = "detected"
+    eventRecording = "recording"
+    eventSaved     = "saved"
+    eventDeleted   = "deleted"
+    eventFailed    = "failed"
+)
+
+// eventLine formats an event as it's printed in quiet mode
+func eventLine(at time.Time, name, msg string) string {
+    return fmt.Sprintf("%s %s %s", at.Format("2006-01-02 15:04:05"), name, msg)
+}
+
+// event prints a pianotrap event in quiet mode, or announces it in accessible
+// mode; otherwise notices already tell the user about it. gRPC watchers, webhooks,
+// desktop notifications and Telegram get it either way.
+func event(name, format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+    broadcastEvent(name, msg)
+    // Events are also reported while holding mu, so the sinks, which read the config
+    // and the player, run once it's been let go
+    go func() {
+        fireWebhooks(name, msg)
+        if name == eventDetected {
+            // The album and cover art arrive with pianobar's event, just after the
+            // song line
+            time.Sleep(time.Second)
+        }
+        notifyEvent(name, msg)
+        telegramEvent(name, msg)
+    }()
+    switch {
+    case quiet:
+        plainLine(eventLine(time.Now(), name, msg))
+    case accessible && announcements[name] != "":
+        plainLine(announcements[name] + ": " + msg)
+    }
+}
+
+// plainLine prints line on a line of its own
+func plainLine(line string) {
+    statusMu.Lock()
+    defer statusMu.Unlock()
+    // Without a terminal in raw mode, e.g. under a supervisor, keep lines clean
+    if termState != nil {
+        fmt.Printf("%s\r\n", line)
+    } else {
+        fmt.Printf("%s\n", line)
+    }
+}
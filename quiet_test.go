@@ -0,0 +1,55 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestEventLine(t *testing.T) {
+    at := time.Date(2024, 5, 1, 20, 15, 3, 0, time.Local)
+    want := "2024-05-01 20:15:03 detected Weightless by Marconi Union"
+    if got := eventLine(at, eventDetected, "Weightless by Marconi Union"); got != want {
+        t.Errorf("eventLine = %q, want %q", got, want)
+    }
+}
+
+func TestEventUnderLock(t *testing.T) {
+    hooked := make(chan string, 1)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hooked <- r.URL.Path
+    }))
+    defer server.Close()
+    old := currentConfig()
+    defer func() {
+        mu.Lock()
+        liveConfig = old
+        mu.Unlock()
+    }()
+    mu.Lock()
+    liveConfig.Webhooks = []webhook{{Name: "test", URL: server.URL + "/hook"}}
+    mu.Unlock()
+
+    // stopRecording reports deletions while holding mu
+    done := make(chan struct{})
+    go func() {
+        mu.Lock()
+        defer mu.Unlock()
+        event(eventDeleted, "%s", "/music/x.mp3")
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("event blocked on mu")
+    }
+    select {
+    case path := <-hooked:
+        if path != "/hook" {
+            t.Errorf("webhook got %s", path)
+        }
+    case <-time.After(5 * time.Second):
+        t.Error("webhook not fired")
+    }
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+    "syscall"
+)
+
+// On SIGHUP pianotrap re-reads its config file. Options read as each song starts,
+// such as the save directory, capture format, thresholds and file names, apply from
+// the next song on; the recording in progress keeps the options it started with.
+// Options used to set up pianobar or background tasks still need a restart.
+
+var (
+    // liveConfig is the config in use, guarded by mu
+    liveConfig Config
+    // reloadOverrides reapplies the command-line flags over a reloaded config
+    reloadOverrides = func(*Config) {}
+)
+
+// currentConfig returns the config in use
+func currentConfig() Config {
+    mu.Lock()
+    defer mu.Unlock()
+    return liveConfig
+}
+
+// applyConfig makes cfg the config in use and sets the options kept in globals
+func applyConfig(cfg Config) {
+    mu.Lock()
+    defer mu.Unlock()
+    liveConfig = cfg
+    acoustIDKey = cfg.AcoustIDKey
+    enrichMetadata = cfg.Enrich
+    discogsToken = cfg.DiscogsToken
+    archive.announcer = cfg.Announce
+    archive.saveDir = cfg.SaveDir
+    archive.dir = cfg.AnnounceArchive
+    trashRoot = filepath.Join(cfg.SaveDir, ".trash")
+    xdgTrash = cfg.XDGTrash
+    setLayout(cfg)
+    watchdogWarn, watchdogTimeout = cfg.WatchdogWarn, cfg.WatchdogTimeout
+    onIncomplete = cfg.OnIncomplete
+    beetsLog = cfg.BeetsLog
+    ffmpegPath = cfg.FFmpegCommand
+    timeThreshold = cfg.IncompleteAfter
+    percentThreshold = cfg.IncompletePercent
+    silentSource = cfg.SilentSource
+    theme = cfg.Theme
+    recordKey, discardKey, keepKey = cfg.RecordKey, cfg.DiscardKey, cfg.KeepKey
+    playlistRoot = ""
+    if cfg.Playlists {
+        playlistRoot = cfg.SaveDir
+    }
+}
+
+// restartOptions lists the options that changed from old to cfg but are only read
+// when pianotrap starts
+func restartOptions(old, cfg Config) []string {
+    var changed []string
+    for _, o := range []struct {
+        name     string
+        old, new interface{}
+    }{
+        {"database", old.Database, cfg.Database},
+        {"schedule", []interface{}{old.Schedule, old.ScheduleRefresh}, []interface{}{cfg.Schedule, cfg.ScheduleRefresh}},
+        {"rotate", []interface{}{old.RotateStations, old.RotateEvery, old.RotateSongs}, []interface{}{cfg.RotateStations, cfg.RotateEvery, cfg.RotateSongs}},
+        {"bestof", []interface{}{old.BestOf, old.BestOfSize, old.BestOfMixtape}, []interface{}{cfg.BestOf, cfg.BestOfSize, cfg.BestOfMixtape}},
+        {"retention", []interface{}{old.RetainMaxAge, old.RetainStationBytes, old.RetainLovedOnly}, []interface{}{cfg.RetainMaxAge, cfg.RetainStationBytes, cfg.RetainLovedOnly}},
+        {"low_space_prune", old.LowSpacePrune, cfg.LowSpacePrune},
+        {"scrub_every", old.ScrubEvery, cfg.ScrubEvery},
+        {"report", []interface{}{old.Report, old.ReportEmail, old.ReportFrom, old.SMTPServer, old.SMTPUsername, old.SMTPPassword},
+            []interface{}{cfg.Report, cfg.ReportEmail, cfg.ReportFrom, cfg.SMTPServer, cfg.SMTPUsername, cfg.SMTPPassword}},
+        {"display", []interface{}{old.TUI, old.StatusBar, old.Quiet, old.Accessible}, []interface{}{cfg.TUI, cfg.StatusBar, cfg.Quiet, cfg.Accessible}},
+        {"pianobar_locale", old.Locale, cfg.Locale},
+        {"http", []interface{}{old.HTTPListen, old.HTTPCert, old.HTTPKey, old.HTTPSelfSigned, old.HTTPMDNS},
+            []interface{}{cfg.HTTPListen, cfg.HTTPCert, cfg.HTTPKey, cfg.HTTPSelfSigned, cfg.HTTPMDNS}},
+        {"mpris", old.MPRIS, cfg.MPRIS},
+        {"telegram_token", old.TelegramToken, cfg.TelegramToken},
+        {"pianobar", []interface{}{old.PianobarCommand, old.PianobarArgs, old.PianobarEnv, old.PianobarDir},
+            []interface{}{cfg.PianobarCommand, cfg.PianobarArgs, cfg.PianobarEnv, cfg.PianobarDir}},
+        {"pandora", []interface{}{old.PandoraUser, old.PandoraPassword}, []interface{}{cfg.PandoraUser, cfg.PandoraPassword}},
+    } {
+        if fmt.Sprint(o.old) != fmt.Sprint(o.new) {
+            changed = append(changed, o.name)
+        }
+    }
+    return changed
+}
+
+// reloadConfig re-reads the config file and applies it, or keeps the config in use
+// if the file has errors
+func reloadConfig() {
+    old := currentConfig()
+    problems, err := checkConfigFile(old.ConfigFile, old.Profile, old.SaveDir)
+    if err == nil && len(problems) > 0 {
+        for _, p := range problems {
+            notice(msgInfo, "%s: %v", old.ConfigFile, p)
+        }
+        err = fmt.Errorf("%d problem(s) in %s", len(problems), old.ConfigFile)
+    }
+    var values map[string]string
+    if err == nil {
+        values, err = readConfigValues(old.ConfigFile)
+    }
+    if err != nil {
+        notice(msgInfo, "Config not reloaded: %v", err)
+        return
+    }
+    cfg := old
+    if values, err = selectProfile(values, old.Profile); err == nil {
+        cfg, err = configFromValues(values, old.ConfigFile, old.SaveDir)
+        cfg.Profile = old.Profile
+    }
+    if err == nil {
+        reloadOverrides(&cfg)
+        err = cfg.validateRun()
+    }
+    if err != nil {
+        notice(msgInfo, "Config not reloaded: %v", err)
+        return
+    }
+    pending := restartOptions(old, cfg)
+    // Keep what pianobar's output and the display were set up with
+    cfg.Locale = old.Locale
+    cfg.TUI, cfg.StatusBar, cfg.Quiet, cfg.Accessible = old.TUI, old.StatusBar, old.Quiet, old.Accessible
+    applyConfig(cfg)
+    if mqttSettings(old) != mqttSettings(cfg) {
+        reloadMQTT()
+    }
+    logger.Printf("Config reloaded from %s", cfg.ConfigFile)
+    notice(msgInfo, "Config reloaded")
+    if len(pending) > 0 {
+        notice(msgInfo, "Restart pianotrap to apply the changes to %s", strings.Join(pending, ", "))
+    }
+}
+
+// watchReload reloads the config whenever pianotrap gets SIGHUP
+func watchReload() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    go func() {
+        for range hup {
+            reloadConfig()
+        }
+    }()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestReloadConfig(t *testing.T) {
+    configFile := filepath.Join(t.TempDir(), "config")
+    write := func(data string) {
+        if err := ioutil.WriteFile(configFile, []byte(data), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    write("savedir = /music\n")
+    values, _ := readConfigValues(configFile)
+    cfg, err := configFromValues(values, configFile, "/default")
+    if err != nil {
+        t.Fatal(err)
+    }
+    applyConfig(cfg)
+    defer func() { reloadOverrides = func(*Config) {} }()
+    reloadOverrides = func(c *Config) { c.SampleRate = 22050 }
+
+    write("savedir = /elsewhere\n[incomplete]\nseconds = 20\n[mqtt]\nbroker = tcp://broker:1883\n")
+    reloadConfig()
+    got := currentConfig()
+    if got.SaveDir != "/elsewhere" || got.IncompleteAfter != 20*time.Second || got.SampleRate != 22050 {
+        t.Errorf("reloaded config: savedir %q, incomplete %v, samplerate %d", got.SaveDir, got.IncompleteAfter, got.SampleRate)
+    }
+    if timeThreshold != 20*time.Second || pathRoot != "/elsewhere" {
+        t.Errorf("reload didn't apply: threshold %v, path root %q", timeThreshold, pathRoot)
+    }
+    if pending := restartOptions(cfg, got); len(pending) > 0 {
+        t.Errorf("options needing a restart: %v", pending)
+    }
+    // The new broker is picked up by reconnecting
+    select {
+    case <-mqttReload:
+    default:
+        t.Error("MQTT not told about the new broker")
+    }
+
+    // A broken file keeps the config in use
+    write("savedir = /broken\non_incomplete = shred\n")
+    reloadConfig()
+    if currentConfig().SaveDir != "/elsewhere" {
+        t.Error("invalid config was applied")
+    }
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    htmltemplate "html/template"
+    "io/ioutil"
+    "net/smtp"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// With report = html or report = markdown pianotrap writes a summary of each week
+// to <savedir>/Reports once the week is over: what was recorded, with cover art,
+// what failed, the busiest stations and artists, and how fast the disk fills up.
+// With report_email and smtp_server it is mailed as well.
+
+const (
+    reportHTML     = "html"
+    reportMarkdown = "markdown"
+)
+
+// reportFormats maps report formats to file extensions
+var reportFormats = map[string]string{reportHTML: ".html", reportMarkdown: ".md"}
+
+// libraryReport is what a report says about a period
+type libraryReport struct {
+    From, Until time.Time
+    Outcomes    map[string]int
+    Saved       []reportSong
+    Failed      []songRecord
+    Stations    []reportCount
+    Artists     []reportCount
+    Loved       int
+    AddedBytes  int64
+    FreeBytes   uint64
+    // DaysLeft is how long the free space lasts at this period's rate, or -1
+    DaysLeft int
+}
+
+// reportSong is a recording listed in a report
+type reportSong struct {
+    songRecord
+    Size int64
+    Art  string // cover art relative to the report, if any
+}
+
+// reportCount is a station or artist with its number of recordings
+type reportCount struct {
+    Name  string
+    Count int
+}
+
+// loadReportConfig reads report, report_email, report_from and the smtp_* options
+func loadReportConfig(values map[string]string, cfg *Config) error {
+    switch raw := values["report"]; raw {
+    case "", "off":
+    case reportHTML, reportMarkdown:
+        cfg.Report = raw
+    default:
+        return fmt.Errorf("invalid value for report: %q (use html, markdown or off)", raw)
+    }
+    cfg.ReportEmail = values["report_email"]
+    cfg.ReportFrom = values["report_from"]
+    cfg.SMTPServer = values["smtp_server"]
+    cfg.SMTPUsername = values["smtp_username"]
+    cfg.SMTPPassword = values["smtp_password"]
+    if cfg.ReportEmail != "" && cfg.SMTPServer == "" {
+        return fmt.Errorf("report_email needs smtp_server (host:port)")
+    }
+    if cfg.ReportFrom == "" {
+        cfg.ReportFrom = cfg.SMTPUsername
+    }
+    return nil
+}
+
+// topCounts ranks names by count, keeping the first n
+func topCounts(counts map[string]int, n int) []reportCount {
+    var ranked []reportCount
+    for name, count := range counts {
+        ranked = append(ranked, reportCount{name, count})
+    }
+    sort.Slice(ranked, func(i, j int) bool {
+        if ranked[i].Count != ranked[j].Count {
+            return ranked[i].Count > ranked[j].Count
+        }
+        return ranked[i].Name < ranked[j].Name
+    })
+    if len(ranked) > n {
+        ranked = ranked[:n]
+    }
+    return ranked
+}
+
+// buildReport summarizes the songs detected in [from, until)
+func buildReport(cfg Config, from, until time.Time) (libraryReport, error) {
+    r := libraryReport{From: from, Until: until, Outcomes: make(map[string]int), DaysLeft: -1}
+    songs, err := querySongs(songFilter{Since: from, Until: until})
+    if err != nil {
+        return r, err
+    }
+    stations := make(map[string]int)
+    artists := make(map[string]int)
+    for _, s := range songs {
+        r.Outcomes[s.Outcome]++
+        switch s.Outcome {
+        case outcomeSaved:
+            song := reportSong{songRecord: s}
+            if info, err := os.Stat(s.File); err == nil {
+                song.Size = info.Size()
+                r.AddedBytes += song.Size
+            }
+            r.Saved = append(r.Saved, song)
+            stations[s.Station]++
+            artists[s.Artist]++
+            if s.Loved {
+                r.Loved++
+            }
+        case outcomeFailed:
+            r.Failed = append(r.Failed, s)
+        }
+    }
+    r.Stations = topCounts(stations, 5)
+    r.Artists = topCounts(artists, 5)
+
+    if free, err := freeSpace(cfg.SaveDir); err == nil {
+        r.FreeBytes = free
+        if perDay := float64(r.AddedBytes) / until.Sub(from).Hours() * 24; perDay > 0 {
+            r.DaysLeft = int(float64(free) / perDay)
+        }
+    }
+    return r, nil
+}
+
+// extractArt saves the cover art of each listed recording next to the report
+func extractArt(r *libraryReport, reportFile string) {
+    dir := filepath.Join(filepath.Dir(reportFile), "art")
+    for i, s := range r.Saved {
+        tags, err := readTags(s.File)
+        if err != nil || len(tags.Picture) == 0 {
+            continue
+        }
+        ext := ".jpg"
+        if tags.PictureMIME == "image/png" {
+            ext = ".png"
+        }
+        name := fmt.Sprintf("%d%s", s.ID, ext)
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            logger.Printf("Report: %v", err)
+            return
+        }
+        if err := ioutil.WriteFile(filepath.Join(dir, name), tags.Picture, 0644); err != nil {
+            logger.Printf("Report: %v", err)
+            continue
+        }
+        r.Saved[i].Art = "art/" + name
+    }
+}
+
+var reportFuncs = map[string]interface{}{
+    "date": func(t time.Time) string { return t.Local().Format("Mon 2006-01-02") },
+    "time": func(t time.Time) string { return t.Local().Format("Mon 15:04") },
+    "mb":   func(n interface{}) string { return fmt.Sprintf("%.1f MB", toFloat(n)/(1<<20)) },
+    "gb":   func(n interface{}) string { return fmt.Sprintf("%.1f GB", toFloat(n)/(1<<30)) },
+}
+
+func toFloat(n interface{}) float64 {
+    switch v := n.(type) {
+    case int64:
+        return float64(v)
+    case uint64:
+        return float64(v)
+    }
+    return 0
+}
+
+const markdownReport = `# pianotrap: {{date .From}} to {{date .Until}}
+
+{{len .Saved}} songs recorded ({{mb .AddedBytes}}), {{.Loved}} of them loved.
+{{- range $outcome, $n := .Outcomes}} {{$outcome}}: {{$n}}.{{end}}
+
+## Disk
+
+{{gb .FreeBytes}} free{{if ge .DaysLeft 0}}, enough for about {{.DaysLeft}} more days at this rate{{end}}.
+{{if .Stations}}
+## Top stations
+{{range .Stations}}
+- {{.Name}}: {{.Count}}{{end}}
+{{end}}{{if .Artists}}
+## Top artists
+{{range .Artists}}
+- {{.Name}}: {{.Count}}{{end}}
+{{end}}{{if .Failed}}
+## Failed
+{{range .Failed}}
+- {{time .DetectedAt}} {{.Title}} by {{.Artist}} ({{.Station}}){{end}}
+{{end}}
+## Recorded
+{{range .Saved}}
+- {{if .Art}}![]({{.Art}}) {{end}}**{{.Title}}** by {{.Artist}}, {{.Album}} ({{.Station}}, {{time .DetectedAt}}, {{mb .Size}}){{if .Loved}} ♥{{end}}{{end}}
+`
+
+const htmlReport = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>pianotrap: {{date .From}} to {{date .Until}}</title>
+<style>body{font-family:sans-serif;max-width:50em;margin:auto}img{width:48px;height:48px;vertical-align:middle;margin-right:.5em}li{list-style:none;margin:.3em 0}</style>
+</head><body>
+<h1>pianotrap: {{date .From}} to {{date .Until}}</h1>
+<p>{{len .Saved}} songs recorded ({{mb .AddedBytes}}), {{.Loved}} of them loved.
+{{- range $outcome, $n := .Outcomes}} {{$outcome}}: {{$n}}.{{end}}</p>
+<h2>Disk</h2>
+<p>{{gb .FreeBytes}} free{{if ge .DaysLeft 0}}, enough for about {{.DaysLeft}} more days at this rate{{end}}.</p>
+{{if .Stations}}<h2>Top stations</h2><ul>{{range .Stations}}<li>{{.Name}}: {{.Count}}</li>{{end}}</ul>{{end}}
+{{if .Artists}}<h2>Top artists</h2><ul>{{range .Artists}}<li>{{.Name}}: {{.Count}}</li>{{end}}</ul>{{end}}
+{{if .Failed}}<h2>Failed</h2><ul>{{range .Failed}}<li>{{time .DetectedAt}} {{.Title}} by {{.Artist}} ({{.Station}})</li>{{end}}</ul>{{end}}
+<h2>Recorded</h2>
+<ul>{{range .Saved}}<li>{{if .Art}}<img src="{{.Art}}" alt="">{{end}}<b>{{.Title}}</b> by {{.Artist}}, {{.Album}} ({{.Station}}, {{time .DetectedAt}}, {{mb .Size}}){{if .Loved}} ♥{{end}}</li>{{end}}</ul>
+</body></html>
+`
+
+// renderReport formats r as HTML or Markdown
+func renderReport(r libraryReport, format string) ([]byte, error) {
+    var b bytes.Buffer
+    var err error
+    if format == reportHTML {
+        err = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(htmlReport)).Execute(&b, r)
+    } else {
+        err = template.Must(template.New("report").Funcs(reportFuncs).Parse(markdownReport)).Execute(&b, r)
+    }
+    return b.Bytes(), err
+}
+
+// writeReport builds, renders and saves the report for [from, until) to fileName
+func writeReport(cfg Config, format string, from, until time.Time, fileName string) ([]byte, error) {
+    r, err := buildReport(cfg, from, until)
+    if err != nil {
+        return nil, err
+    }
+    extractArt(&r, fileName)
+    data, err := renderReport(r, format)
+    if err != nil {
+        return nil, fmt.Errorf("failed to render report: %v", err)
+    }
+    if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+        return nil, err
+    }
+    if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+        return nil, fmt.Errorf("failed to write report: %v", err)
+    }
+    return data, nil
+}
+
+// mailReport sends a rendered report to report_email
+func mailReport(cfg Config, format, subject string, data []byte) error {
+    contentType := "text/markdown; charset=utf-8"
+    if format == reportHTML {
+        contentType = "text/html; charset=utf-8"
+    }
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: %s\r\n\r\n",
+        cfg.ReportFrom, cfg.ReportEmail, subject, contentType)
+    msg.Write(bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1))
+
+    var auth smtp.Auth
+    if cfg.SMTPUsername != "" {
+        host := strings.Split(cfg.SMTPServer, ":")[0]
+        auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+    }
+    if err := smtp.SendMail(cfg.SMTPServer, auth, cfg.ReportFrom, []string{cfg.ReportEmail}, msg.Bytes()); err != nil {
+        return fmt.Errorf("failed to mail report: %v", err)
+    }
+    return nil
+}
+
+// lastWeek returns the bounds of the last complete week, Monday to Monday
+func lastWeek(now time.Time) (time.Time, time.Time) {
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+    return monday.AddDate(0, 0, -7), monday
+}
+
+// weeklyReportFile is where the report for the week starting at from is saved
+func weeklyReportFile(saveDir string, from time.Time, format string) string {
+    year, week := from.ISOWeek()
+    return filepath.Join(saveDir, "Reports", fmt.Sprintf("%d-W%02d%s", year, week, reportFormats[format]))
+}
+
+// startReports writes last week's report once it's over, checking every hour
+func startReports(cfg Config) {
+    if cfg.Report == "" || db == nil {
+        return
+    }
+    go func() {
+        for {
+            from, until := lastWeek(time.Now())
+            fileName := weeklyReportFile(cfg.SaveDir, from, cfg.Report)
+            if _, err := os.Stat(fileName); os.IsNotExist(err) {
+                data, err := writeReport(cfg, cfg.Report, from, until, fileName)
+                if err != nil {
+                    logger.Printf("Report: %v", err)
+                } else {
+                    logger.Printf("Wrote %s", fileName)
+                    if cfg.ReportEmail != "" {
+                        if err := mailReport(cfg, cfg.Report, "pianotrap: week of "+from.Format("2006-01-02"), data); err != nil {
+                            logger.Printf("Report: %v", err)
+                        }
+                    }
+                }
+            }
+            time.Sleep(time.Hour)
+        }
+    }()
+}
+
+// runReport implements "pianotrap report"
+func runReport(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("report", flag.ContinueOnError)
+    format := fs.String("format", cfg.Report, "html or markdown")
+    since := fs.String("since", "week", "start of the report (today, week, month, 2024-05-01 or 7d)")
+    output := fs.String("o", "", "write the report here instead of the Reports folder")
+    mail := fs.Bool("mail", false, "also send it to report_email")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *format == "" {
+        *format = reportMarkdown
+    }
+    if _, ok := reportFormats[*format]; !ok {
+        return fmt.Errorf("unknown format %q (use html or markdown)", *format)
+    }
+    from, err := parseSince(*since)
+    if err != nil {
+        return err
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+
+    until := time.Now()
+    fileName := *output
+    if fileName == "" {
+        fileName = filepath.Join(cfg.SaveDir, "Reports", fmt.Sprintf("%s to %s%s", from.Format("2006-01-02"), until.Format("2006-01-02"), reportFormats[*format]))
+    }
+    data, err := writeReport(cfg, *format, from, until, fileName)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Wrote %s\n", fileName)
+    if *mail {
+        if cfg.ReportEmail == "" {
+            return fmt.Errorf("report_email is not set")
+        }
+        return mailReport(cfg, *format, fmt.Sprintf("pianotrap: %s to %s", from.Format("2006-01-02"), until.Format("2006-01-02")), data)
+    }
+    return nil
+}
+
+const statsReport = `{{len .Saved}} songs recorded ({{mb .AddedBytes}}), {{.Loved}} of them loved.
+{{- range $outcome, $n := .Outcomes}} {{$outcome}}: {{$n}}.{{end}}
+{{gb .FreeBytes}} free{{if ge .DaysLeft 0}}, enough for about {{.DaysLeft}} more days at this rate{{end}}.
+{{- if .Stations}}
+
+Top stations:
+{{- range .Stations}}
+  {{.Count}}  {{.Name}}{{end}}{{end}}
+{{- if .Artists}}
+
+Top artists:
+{{- range .Artists}}
+  {{.Count}}  {{.Name}}{{end}}{{end}}
+`
+
+// runStats implements "pianotrap stats": the numbers of a report, on the terminal
+func runStats(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+    since := fs.String("since", "", "only songs detected after this date or age (e.g. 2024-05-01, 7d, \"last week\")")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    from, err := parseSince(*since)
+    if err != nil {
+        return err
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+
+    r, err := buildReport(cfg, from, time.Now())
+    if err != nil {
+        return err
+    }
+    if from.IsZero() {
+        // The rate is only meaningful over a given period
+        r.DaysLeft = -1
+    }
+    return template.Must(template.New("stats").Funcs(reportFuncs).Parse(statsReport)).Execute(os.Stdout, r)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestBuildReport(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+
+    file := func(name string, size int) string {
+        path := filepath.Join(dir, name)
+        if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+            t.Fatal(err)
+        }
+        return path
+    }
+    logDetectedSong(songInfo{Title: "One", Artist: "A"}, "Jazz", file("one.mp3", 1000), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Two", Artist: "A", Loved: true}, "Jazz", file("two.mp3", 2000), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Three", Artist: "B"}, "Rock", file("three.mp3", 500), outcomeSaved)
+    logDetectedSong(songInfo{Title: "Broken", Artist: "C"}, "Rock", "", outcomeFailed)
+    logDetectedSong(songInfo{Title: "Nope", Artist: "D"}, "Rock", "", outcomeSkipped)
+
+    now := time.Now()
+    r, err := buildReport(Config{SaveDir: dir}, now.Add(-24*time.Hour), now.Add(time.Minute))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(r.Saved) != 3 || r.AddedBytes != 3500 || r.Loved != 1 {
+        t.Errorf("saved %d songs, %d bytes, %d loved; want 3, 3500, 1", len(r.Saved), r.AddedBytes, r.Loved)
+    }
+    if len(r.Failed) != 1 || r.Failed[0].Title != "Broken" {
+        t.Errorf("failed = %v, want Broken", r.Failed)
+    }
+    if want := []reportCount{{"Jazz", 2}, {"Rock", 1}}; !reflect.DeepEqual(r.Stations, want) {
+        t.Errorf("stations = %v, want %v", r.Stations, want)
+    }
+    if r.Outcomes[outcomeSkipped] != 1 {
+        t.Errorf("outcomes = %v", r.Outcomes)
+    }
+
+    for _, format := range []string{reportHTML, reportMarkdown} {
+        out, err := renderReport(r, format)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, want := range []string{"3 songs recorded", "Broken", "Jazz: 2"} {
+            if !strings.Contains(string(out), want) {
+                t.Errorf("%s report lacks %q:\n%s", format, want, out)
+            }
+        }
+    }
+
+    // Week bounds run Monday to Monday
+    from, until := lastWeek(time.Date(2024, 5, 8, 15, 0, 0, 0, time.UTC))
+    if from.Format("2006-01-02") != "2024-04-29" || until.Format("2006-01-02") != "2024-05-06" {
+        t.Errorf("lastWeek = %v, %v", from, until)
+    }
+    if got := filepath.Base(weeklyReportFile(dir, from, reportHTML)); got != "2024-W18.html" {
+        t.Errorf("weeklyReportFile = %s", got)
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// recordingNameRe matches the year suffix of "Title - Artist - Album (Year).mp3"
+var recordingNameRe = regexp.MustCompile(`^(.*) \((\d{4})\)$`)
+
+// parseRecordingName recovers the tags encoded in a recording's file name. Titles
+// may contain " - " themselves, so artist and album are taken from the end.
+func parseRecordingName(fileName string) (Tags, bool) {
+    base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+    m := recordingNameRe.FindStringSubmatch(base)
+    if m == nil {
+        return Tags{}, false
+    }
+    parts := strings.Split(m[1], " - ")
+    if len(parts) < 3 {
+        return Tags{}, false
+    }
+    n := len(parts)
+    tags := Tags{
+        Title: strings.Join(parts[:n-2], " - "),
+        Album: parts[n-1],
+        Year:  m[2],
+    }
+    if parts[n-2] != "" {
+        tags.Artists = []string{parts[n-2]}
+    }
+    return tags, tags.Title != ""
+}
+
+// runRetag implements "pianotrap retag": it rewrites the tags of existing recordings
+// from their file names and, optionally, enrichment and renames them to match
+func runRetag(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("retag", flag.ContinueOnError)
+    enrich := fs.Bool("enrich", false, "look up the release year and label (see the enrich option)")
+    rename := fs.Bool("rename", false, "rename files to match their new tags")
+    dryRun := fs.Bool("n", false, "only show what would change")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    dir := cfg.SaveDir
+    if fs.NArg() > 0 {
+        dir = fs.Arg(0)
+    }
+    enrichMetadata = *enrich
+    discogsToken = cfg.DiscogsToken
+    setLayout(cfg)
+    if err := openLibrary(cfg); err == nil {
+        defer db.Close()
+    }
+
+    var retagged, failed int
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+            return filepath.SkipDir // e.g. the trash
+        }
+        if err != nil || info.IsDir() || !dedupeAudioExts[strings.ToLower(filepath.Ext(path))] {
+            return nil
+        }
+        parsed, ok := parseRecordingName(path)
+        if !ok {
+            logger.Printf("Skipping %s: name doesn't follow \"Title - Artist - Album (Year)\"", path)
+            return nil
+        }
+        // Keep what only the tags know, such as cover art, rating and AcoustIDs
+        tags, err := readTags(path)
+        if err != nil {
+            tags = Tags{}
+        }
+        station := stationOf(path)
+        tags.Title, tags.Artists, tags.Album, tags.Year = parsed.Title, parsed.Artists, parsed.Album, parsed.Year
+        if tags.Custom == nil {
+            tags.Custom = make(map[string]string)
+        }
+        if tags.Custom["STATION"] == "" {
+            tags.Custom["STATION"] = station
+        }
+        if genre := cfg.genreFor(station); genre != "" {
+            tags.Genre = genre
+        }
+        if err := enrichTags(path, &tags); err != nil && err != errNotConfigured {
+            logger.Printf("Enriching %s: %v", path, err)
+        }
+
+        newPath := path
+        if *rename {
+            newPath = renamedPath(path, tags)
+        }
+        if *dryRun {
+            fmt.Printf("%s: %q by %q on %q (%s)\n", path, tags.Title, strings.Join(tags.Artists, ", "), tags.Album, tags.Year)
+            if newPath != path {
+                fmt.Printf("  would rename to %s\n", filepath.Base(newPath))
+            }
+            return nil
+        }
+        if err := writeTags(path, tags); err != nil {
+            fmt.Printf("Failed to tag %s: %v\n", path, err)
+            failed++
+            return nil
+        }
+        if err := recordChecksum(path); err != nil && err != errNotConfigured {
+            logger.Printf("%v", err)
+        }
+        if newPath != path {
+            if _, err := os.Stat(newPath); err == nil {
+                fmt.Printf("Not renaming %s: %s exists\n", path, filepath.Base(newPath))
+            } else if err := renameFile(path, newPath); err != nil {
+                fmt.Printf("Failed to rename %s: %v\n", path, err)
+            } else {
+                renameSong(path, newPath, tags)
+                fmt.Printf("Renamed %s to %s\n", path, filepath.Base(newPath))
+            }
+        }
+        retagged++
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("failed to scan %s: %v", dir, err)
+    }
+    if !*dryRun {
+        fmt.Printf("Retagged %d recordings, %d failed\n", retagged, failed)
+    }
+    return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseRecordingName(t *testing.T) {
+    tests := []struct {
+        name string
+        want Tags
+        ok   bool
+    }{
+        {"/music/Jazz/So What - Miles Davis - Kind of Blue (2024).mp3", Tags{Title: "So What", Artists: []string{"Miles Davis"}, Album: "Kind of Blue", Year: "2024"}, true},
+        {"Part 1 - Intro - Artist - Album (1999).flac", Tags{Title: "Part 1 - Intro", Artists: []string{"Artist"}, Album: "Album", Year: "1999"}, true},
+        {"Title - Artist -  (2024).mp3", Tags{Title: "Title", Artists: []string{"Artist"}, Year: "2024"}, true},
+        {"Title - Artist (2024).mp3", Tags{}, false},
+        {"random.mp3", Tags{}, false},
+    }
+    for _, tt := range tests {
+        got, ok := parseRecordingName(tt.name)
+        if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("parseRecordingName(%q) = %+v, %v, want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+        }
+    }
+}
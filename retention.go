@@ -0,0 +1,156 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "time"
+)
+
+// Retention rules prune saved recordings: those older than retain_max_age, the
+// oldest ones of a station over retain_station_gb, and with retain_loved_only every
+// recording that isn't loved. Loved songs are never pruned by age or size.
+
+const outcomePruned = "pruned"
+
+// loadRetentionConfig reads retain_max_age, retain_station_gb and retain_loved_only
+func loadRetentionConfig(values map[string]string, cfg *Config) error {
+    if raw := values["retain_max_age"]; raw != "" {
+        cutoff, err := parseSince(raw)
+        if err != nil || cutoff.IsZero() {
+            return fmt.Errorf("invalid value for retain_max_age: %q", raw)
+        }
+        cfg.RetainMaxAge = time.Since(cutoff).Round(time.Minute)
+    }
+    if raw := values["retain_station_gb"]; raw != "" {
+        gb, err := strconv.ParseFloat(raw, 64)
+        if err != nil || gb <= 0 {
+            return fmt.Errorf("invalid value for retain_station_gb: %q", raw)
+        }
+        cfg.RetainStationBytes = int64(gb * (1 << 30))
+    }
+    cfg.RetainLovedOnly = values["retain_loved_only"] == "true"
+    return nil
+}
+
+// retentionEnabled reports whether any retention rule is configured
+func (cfg Config) retentionEnabled() bool {
+    return cfg.RetainMaxAge > 0 || cfg.RetainStationBytes > 0 || cfg.RetainLovedOnly
+}
+
+// pruneAction is a recording to remove and the rule that selected it
+type pruneAction struct {
+    Song   songRecord
+    Size   int64
+    Reason string
+}
+
+// planPrune applies the retention rules to the saved recordings
+func planPrune(cfg Config) ([]pruneAction, error) {
+    songs, err := querySongs(songFilter{Outcome: outcomeSaved})
+    if err != nil {
+        return nil, err
+    }
+    var actions []pruneAction
+    stationSize := make(map[string]int64)
+    var kept []pruneAction
+    for _, s := range songs {
+        info, err := os.Stat(s.File)
+        if err != nil {
+            continue // Already gone
+        }
+        a := pruneAction{Song: s, Size: info.Size()}
+        switch {
+        case s.Loved:
+        case cfg.RetainLovedOnly:
+            a.Reason = "not loved"
+        case cfg.RetainMaxAge > 0 && time.Since(s.DetectedAt) > cfg.RetainMaxAge:
+            a.Reason = fmt.Sprintf("older than %v", cfg.RetainMaxAge)
+        }
+        if a.Reason != "" {
+            actions = append(actions, a)
+            continue
+        }
+        stationSize[s.Station] += a.Size
+        kept = append(kept, a)
+    }
+
+    if cfg.RetainStationBytes > 0 {
+        // kept is in capture order, so the oldest recordings go first
+        for _, a := range kept {
+            if a.Song.Loved || stationSize[a.Song.Station] <= cfg.RetainStationBytes {
+                continue
+            }
+            a.Reason = fmt.Sprintf("%s over %.1f GB", a.Song.Station, float64(cfg.RetainStationBytes)/(1<<30))
+            stationSize[a.Song.Station] -= a.Size
+            actions = append(actions, a)
+        }
+    }
+    return actions, nil
+}
+
+// pruneRecordings removes what the retention rules select and returns the bytes freed
+func pruneRecordings(cfg Config, dryRun bool) (int64, error) {
+    actions, err := planPrune(cfg)
+    if err != nil {
+        return 0, err
+    }
+    var freed int64
+    for _, a := range actions {
+        if dryRun {
+            fmt.Printf("Would remove %s (%s)\n", a.Song.File, a.Reason)
+            freed += a.Size
+            continue
+        }
+        if err := deleteFile(a.Song.File); err != nil {
+            logger.Printf("Prune: failed to remove %s: %v", a.Song.File, err)
+            continue
+        }
+        logger.Printf("Prune: removed %s (%s)", a.Song.File, a.Reason)
+        setSongOutcome(a.Song.File, outcomePruned)
+        freed += a.Size
+    }
+    return freed, nil
+}
+
+// runPrune implements "pianotrap prune"
+func runPrune(cfg Config, args []string) error {
+    fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+    dryRun := fs.Bool("n", false, "only show what would be removed")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if !cfg.retentionEnabled() {
+        return fmt.Errorf("no retention rules configured (retain_max_age, retain_station_gb, retain_loved_only)")
+    }
+    if err := openLibrary(cfg); err != nil {
+        return err
+    }
+    defer db.Close()
+    // Report removals on the terminal as well
+    logger.SetOutput(os.Stdout)
+    freed, err := pruneRecordings(cfg, *dryRun)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("%.1f MB freed\n", float64(freed)/(1<<20))
+    return nil
+}
+
+// startRetention prunes once an hour while pianotrap runs
+func startRetention(cfg Config) {
+    if !cfg.retentionEnabled() || db == nil {
+        return
+    }
+    go func() {
+        for {
+            if freed, err := pruneRecordings(cfg, false); err != nil {
+                logger.Printf("Prune: %v", err)
+            } else if freed > 0 {
+                fmt.Printf("\r\nRetention rules freed %.1f MB\n", float64(freed)/(1<<20))
+            }
+            time.Sleep(time.Hour)
+        }
+    }()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestPlanPrune(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+
+    for i, name := range []string{"a", "b", "c", "d"} {
+        path := filepath.Join(dir, name+".mp3")
+        if err := ioutil.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+            t.Fatal(err)
+        }
+        logDetectedSong(songInfo{Title: name, Artist: "X", Loved: i == 0}, "S", path, outcomeSaved)
+    }
+    // Backdate a and b
+    if _, err := db.Exec("UPDATE songs SET detected_at = ? WHERE title IN ('a', 'b')", time.Now().AddDate(0, 0, -40).UTC()); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        cfg  Config
+        want []string
+    }{
+        {Config{RetainMaxAge: 30 * 24 * time.Hour}, []string{"b"}},
+        {Config{RetainStationBytes: 3500}, []string{"b"}},
+        {Config{RetainStationBytes: 1500}, []string{"b", "c", "d"}},
+        {Config{RetainLovedOnly: true}, []string{"b", "c", "d"}},
+    }
+    for _, tt := range tests {
+        actions, err := planPrune(tt.cfg)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var got []string
+        for _, a := range actions {
+            got = append(got, a.Song.Title)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("planPrune(%+v) = %v, want %v", tt.cfg, got, tt.want)
+        }
+    }
+}
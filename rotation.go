@@ -0,0 +1,128 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Station rotation cycles through a list of stations every N minutes or songs to
+// cover more of the catalog. Switches wait for the end of the current song so its
+// recording is kept.
+
+// rotationSwitchWindow is how close to the end of a song a due rotation switches
+var rotationSwitchWindow = 3 * time.Second
+
+var rotation struct {
+    sync.Mutex
+    stations   []string
+    every      time.Duration
+    songs      int
+    next       int
+    lastSwitch time.Time
+    songCount  int
+    switching  bool
+}
+
+// loadRotationConfig reads "rotate = Station A, Station B" and "rotate_every = 30m"
+// or "rotate_every = 5 songs"
+func loadRotationConfig(values map[string]string, cfg *Config) error {
+    for _, station := range configList(values["rotate"]) {
+        if station = strings.TrimSpace(station); station != "" {
+            cfg.RotateStations = append(cfg.RotateStations, station)
+        }
+    }
+    raw := strings.TrimSpace(values["rotate_every"])
+    if raw == "" {
+        cfg.RotateEvery = time.Hour
+        return nil
+    }
+    if fields := strings.Fields(raw); len(fields) == 2 && strings.HasPrefix(fields[1], "song") {
+        n, err := strconv.Atoi(fields[0])
+        if err != nil || n < 1 {
+            return fmt.Errorf("invalid value for rotate_every: %q", raw)
+        }
+        cfg.RotateSongs = n
+        return nil
+    }
+    d, err := time.ParseDuration(raw)
+    if err != nil || d < time.Minute {
+        return fmt.Errorf("invalid value for rotate_every: %q", raw)
+    }
+    cfg.RotateEvery = d
+    return nil
+}
+
+// startRotation enables rotation when at least two stations are configured
+func startRotation(cfg Config) {
+    if len(cfg.RotateStations) < 2 {
+        return
+    }
+    rotation.Lock()
+    defer rotation.Unlock()
+    rotation.stations = cfg.RotateStations
+    rotation.every = cfg.RotateEvery
+    rotation.songs = cfg.RotateSongs
+    rotation.lastSwitch = time.Now()
+    if cfg.RotateSongs > 0 {
+        fmt.Printf("\r\nRotating stations every %d songs: %s\n", cfg.RotateSongs, strings.Join(cfg.RotateStations, ", "))
+    } else {
+        fmt.Printf("\r\nRotating stations every %v: %s\n", cfg.RotateEvery, strings.Join(cfg.RotateStations, ", "))
+    }
+}
+
+// rotationSongStarted counts songs towards a song-based rotation
+func rotationSongStarted() {
+    rotation.Lock()
+    rotation.songCount++
+    rotation.Unlock()
+}
+
+// rotationStationChanged resets the rotation interval after any station change
+func rotationStationChanged(station string) {
+    rotation.Lock()
+    defer rotation.Unlock()
+    rotation.lastSwitch = time.Now()
+    rotation.songCount = 0
+    rotation.switching = false
+    for i, s := range rotation.stations {
+        if strings.EqualFold(sanitizeFileName(s), station) {
+            rotation.next = (i + 1) % len(rotation.stations)
+        }
+    }
+}
+
+// checkRotation switches to the next station if a rotation is due and the current
+// song is about to end
+func checkRotation(remaining time.Duration) {
+    rotation.Lock()
+    if len(rotation.stations) == 0 || rotation.switching || remaining > rotationSwitchWindow {
+        rotation.Unlock()
+        return
+    }
+    due := time.Since(rotation.lastSwitch) >= rotation.every
+    if rotation.songs > 0 {
+        due = rotation.songCount >= rotation.songs
+    }
+    if !due {
+        rotation.Unlock()
+        return
+    }
+    rotation.switching = true
+    station := rotation.stations[rotation.next]
+    rotation.next = (rotation.next + 1) % len(rotation.stations)
+    rotation.Unlock()
+
+    go func() {
+        fmt.Printf("\r\nRotating to station: %s\n", station)
+        if err := switchStation(station); err != nil {
+            logger.Printf("Rotation: %v", err)
+            // Try the next station at the end of the next song
+            rotation.Lock()
+            rotation.switching = false
+            rotation.Unlock()
+        }
+    }()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A recording schedule imported from an iCalendar (ICS) file or URL. While a schedule
+// is configured pianotrap only records during its events; an event's summary names
+// the station to switch to ("Jazz24" or "Record Jazz24"), and an empty summary just
+// enables recording on whatever is playing.
+
+type calendarEvent struct {
+    Summary  string
+    Start    time.Time
+    Duration time.Duration
+    AllDay   bool
+    Rule     *recurrence
+}
+
+type recurrence struct {
+    Freq     string
+    Interval int
+    Count    int
+    Until    time.Time
+    ByDay    []time.Weekday
+}
+
+var (
+    scheduleMu     sync.Mutex
+    scheduleEvents []calendarEvent
+    scheduleLoaded bool
+)
+
+// loadScheduleConfig reads the schedule and schedule_refresh options
+func loadScheduleConfig(values map[string]string, cfg *Config) error {
+    cfg.Schedule = values["schedule"]
+    cfg.ScheduleRefresh = 15 * time.Minute
+    if raw := values["schedule_refresh"]; raw != "" {
+        d, err := time.ParseDuration(raw)
+        if err != nil || d < time.Minute {
+            return fmt.Errorf("invalid value for schedule_refresh: %q", raw)
+        }
+        cfg.ScheduleRefresh = d
+    }
+    return nil
+}
+
+// recordingAllowed reports whether new recordings may start: always without a
+// schedule, otherwise only while one of its events is active
+func recordingAllowed() bool {
+    scheduleMu.Lock()
+    defer scheduleMu.Unlock()
+    if !scheduleLoaded {
+        return true
+    }
+    _, ok := activeEvent(scheduleEvents, time.Now())
+    return ok
+}
+
+// startSchedule refreshes the calendar periodically and switches stations as events begin
+func startSchedule(cfg Config) {
+    if cfg.Schedule == "" {
+        return
+    }
+    refresh := func() {
+        events, err := fetchCalendar(cfg.Schedule)
+        if err != nil {
+            logger.Printf("Schedule: %v", err)
+            return
+        }
+        scheduleMu.Lock()
+        scheduleEvents = events
+        scheduleLoaded = true
+        scheduleMu.Unlock()
+        logger.Printf("Schedule: loaded %d events from %s", len(events), cfg.Schedule)
+    }
+    refresh()
+    fmt.Printf("\r\nRecording schedule: %s\n", cfg.Schedule)
+
+    go func() {
+        lastRefresh := time.Now()
+        var lastEvent *calendarEvent
+        ticker := time.NewTicker(30 * time.Second)
+        defer ticker.Stop()
+        for range ticker.C {
+            if time.Since(lastRefresh) >= cfg.ScheduleRefresh {
+                refresh()
+                lastRefresh = time.Now()
+            }
+            scheduleMu.Lock()
+            event, ok := activeEvent(scheduleEvents, time.Now())
+            scheduleMu.Unlock()
+            if !ok {
+                if lastEvent != nil {
+                    fmt.Printf("\r\nScheduled recording ended: %s\n", lastEvent.Summary)
+                }
+                lastEvent = nil
+                continue
+            }
+            if lastEvent != nil && lastEvent.Summary == event.Summary && lastEvent.Start.Equal(event.Start) {
+                continue
+            }
+            lastEvent = &event
+            fmt.Printf("\r\nScheduled recording started: %s\n", event.Summary)
+            station := scheduledStation(event.Summary)
+            mu.Lock()
+            playing := currentStation
+            mu.Unlock()
+            if station != "" && !strings.EqualFold(sanitizeFileName(station), playing) {
+                if err := switchStation(station); err != nil {
+                    logger.Printf("Schedule: %v", err)
+                }
+            }
+        }
+    }()
+}
+
+// scheduledStation extracts the station name from an event summary
+func scheduledStation(summary string) string {
+    summary = strings.TrimSpace(summary)
+    if len(summary) > 7 && strings.EqualFold(summary[:7], "record ") {
+        summary = strings.TrimSpace(summary[7:])
+    }
+    return summary
+}
+
+// fetchCalendar loads an ICS calendar from an http(s)/webcal URL or a local file
+func fetchCalendar(source string) ([]calendarEvent, error) {
+    var data []byte
+    if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "webcal://") {
+        client := &http.Client{Timeout: 30 * time.Second}
+        resp, err := client.Get(strings.Replace(source, "webcal://", "https://", 1))
+        if err != nil {
+            return nil, fmt.Errorf("failed to fetch calendar: %v", err)
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("failed to fetch calendar: %s", resp.Status)
+        }
+        if data, err = ioutil.ReadAll(resp.Body); err != nil {
+            return nil, fmt.Errorf("failed to fetch calendar: %v", err)
+        }
+    } else {
+        var err error
+        if data, err = ioutil.ReadFile(source); err != nil {
+            return nil, fmt.Errorf("failed to read calendar: %v", err)
+        }
+    }
+    return parseICS(string(data))
+}
+
+// parseICS extracts the VEVENTs of an iCalendar document
+func parseICS(data string) ([]calendarEvent, error) {
+    // Unfold continuation lines (RFC 5545 3.1)
+    data = strings.ReplaceAll(data, "\r\n", "\n")
+    data = strings.ReplaceAll(data, "\n ", "")
+    data = strings.ReplaceAll(data, "\n\t", "")
+
+    var events []calendarEvent
+    var event *calendarEvent
+    var end time.Time
+    for _, line := range strings.Split(data, "\n") {
+        colon := strings.Index(line, ":")
+        if colon < 0 {
+            continue
+        }
+        nameParams, value := line[:colon], line[colon+1:]
+        parts := strings.Split(nameParams, ";")
+        name, params := strings.ToUpper(parts[0]), parts[1:]
+
+        switch {
+        case name == "BEGIN" && value == "VEVENT":
+            event = &calendarEvent{}
+            end = time.Time{}
+        case name == "END" && value == "VEVENT" && event != nil:
+            if event.Start.IsZero() {
+                event = nil
+                continue
+            }
+            if event.Duration == 0 && !end.IsZero() {
+                event.Duration = end.Sub(event.Start)
+            }
+            if event.Duration == 0 && event.AllDay {
+                event.Duration = 24 * time.Hour
+            }
+            if event.Duration > 0 {
+                events = append(events, *event)
+            }
+            event = nil
+        case event == nil:
+        case name == "SUMMARY":
+            event.Summary = unescapeICS(value)
+        case name == "DTSTART":
+            t, allDay, err := parseICSTime(value, params)
+            if err != nil {
+                return nil, err
+            }
+            event.Start, event.AllDay = t, allDay
+        case name == "DTEND":
+            t, _, err := parseICSTime(value, params)
+            if err != nil {
+                return nil, err
+            }
+            end = t
+        case name == "DURATION":
+            d, err := parseICSDuration(value)
+            if err != nil {
+                return nil, err
+            }
+            event.Duration = d
+        case name == "RRULE":
+            rule, err := parseRRule(value)
+            if err != nil {
+                return nil, err
+            }
+            event.Rule = rule
+        }
+    }
+    return events, nil
+}
+
+func unescapeICS(s string) string {
+    return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(s)
+}
+
+// parseICSTime parses DATE and DATE-TIME values, honoring a TZID parameter
+func parseICSTime(value string, params []string) (time.Time, bool, error) {
+    loc := time.Local
+    for _, p := range params {
+        if strings.HasPrefix(strings.ToUpper(p), "TZID=") {
+            if l, err := time.LoadLocation(strings.Trim(p[5:], `"`)); err == nil {
+                loc = l
+            }
+        }
+    }
+    switch {
+    case len(value) == 8:
+        t, err := time.ParseInLocation("20060102", value, loc)
+        return t, true, err
+    case strings.HasSuffix(value, "Z"):
+        t, err := time.Parse("20060102T150405Z", value)
+        return t, false, err
+    default:
+        t, err := time.ParseInLocation("20060102T150405", value, loc)
+        if err != nil {
+            return t, false, fmt.Errorf("invalid calendar time %q", value)
+        }
+        return t, false, nil
+    }
+}
+
+// parseICSDuration parses durations like PT1H30M or P1D
+func parseICSDuration(value string) (time.Duration, error) {
+    s := strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+    var d time.Duration
+    inTime := false
+    num := ""
+    for _, r := range s {
+        switch {
+        case r == 'T':
+            inTime = true
+        case r >= '0' && r <= '9':
+            num += string(r)
+        default:
+            n, err := strconv.Atoi(num)
+            if err != nil {
+                return 0, fmt.Errorf("invalid calendar duration %q", value)
+            }
+            num = ""
+            switch {
+            case r == 'W':
+                d += time.Duration(n) * 7 * 24 * time.Hour
+            case r == 'D':
+                d += time.Duration(n) * 24 * time.Hour
+            case r == 'H' && inTime:
+                d += time.Duration(n) * time.Hour
+            case r == 'M' && inTime:
+                d += time.Duration(n) * time.Minute
+            case r == 'S' && inTime:
+                d += time.Duration(n) * time.Second
+            default:
+                return 0, fmt.Errorf("invalid calendar duration %q", value)
+            }
+        }
+    }
+    return d, nil
+}
+
+var icsWeekdays = map[string]time.Weekday{
+    "SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+    "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRule supports the DAILY and WEEKLY rules calendars use for regular shows
+func parseRRule(value string) (*recurrence, error) {
+    rule := &recurrence{Interval: 1}
+    for _, part := range strings.Split(value, ";") {
+        kv := strings.SplitN(part, "=", 2)
+        if len(kv) != 2 {
+            continue
+        }
+        switch strings.ToUpper(kv[0]) {
+        case "FREQ":
+            rule.Freq = strings.ToUpper(kv[1])
+        case "INTERVAL":
+            n, err := strconv.Atoi(kv[1])
+            if err != nil || n < 1 {
+                return nil, fmt.Errorf("invalid RRULE interval %q", kv[1])
+            }
+            rule.Interval = n
+        case "COUNT":
+            n, err := strconv.Atoi(kv[1])
+            if err != nil || n < 1 {
+                return nil, fmt.Errorf("invalid RRULE count %q", kv[1])
+            }
+            rule.Count = n
+        case "UNTIL":
+            t, _, err := parseICSTime(kv[1], nil)
+            if err != nil {
+                return nil, err
+            }
+            rule.Until = t
+        case "BYDAY":
+            for _, day := range strings.Split(kv[1], ",") {
+                // Ignore ordinal prefixes such as 1MO; they only matter for monthly rules
+                day = strings.TrimLeft(day, "+-0123456789")
+                if wd, ok := icsWeekdays[strings.ToUpper(day)]; ok {
+                    rule.ByDay = append(rule.ByDay, wd)
+                }
+            }
+        }
+    }
+    if rule.Freq != "DAILY" && rule.Freq != "WEEKLY" {
+        return nil, fmt.Errorf("unsupported RRULE frequency %q", rule.Freq)
+    }
+    return rule, nil
+}
+
+// occurrences calls fn with each start time of the event up to limit, stopping early
+// if fn returns false
+func (e calendarEvent) occurrences(limit time.Time, fn func(time.Time) bool) {
+    if e.Rule == nil {
+        fn(e.Start)
+        return
+    }
+    count := 0
+    step := 1
+    if e.Rule.Freq == "WEEKLY" && len(e.Rule.ByDay) > 0 {
+        step = 0 // walk day by day and filter on the weekdays below
+    }
+    weekStart := e.Start.AddDate(0, 0, -int(e.Start.Weekday()))
+    for day := 0; ; {
+        start := e.Start.AddDate(0, 0, day)
+        if start.After(limit) || (!e.Rule.Until.IsZero() && start.After(e.Rule.Until)) {
+            return
+        }
+        include := true
+        if step == 0 {
+            weeks := int(start.Sub(weekStart).Hours() / (24 * 7))
+            include = weeks%e.Rule.Interval == 0 && containsWeekday(e.Rule.ByDay, start.Weekday())
+        }
+        if include {
+            count++
+            if e.Rule.Count > 0 && count > e.Rule.Count {
+                return
+            }
+            if !fn(start) {
+                return
+            }
+        }
+        switch {
+        case step == 0:
+            day++
+        case e.Rule.Freq == "WEEKLY":
+            day += 7 * e.Rule.Interval
+        default:
+            day += e.Rule.Interval
+        }
+    }
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+    for _, d := range days {
+        if d == day {
+            return true
+        }
+    }
+    return false
+}
+
+// activeEvent returns the event with an occurrence covering now, if any
+func activeEvent(events []calendarEvent, now time.Time) (calendarEvent, bool) {
+    for _, e := range events {
+        found := false
+        e.occurrences(now, func(start time.Time) bool {
+            if !now.Before(start) && now.Before(start.Add(e.Duration)) {
+                found = true
+                return false
+            }
+            return true
+        })
+        if found {
+            return e, true
+        }
+    }
+    return calendarEvent{}, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseICS(t *testing.T) {
+    newYork, err := time.LoadLocation("America/New_York")
+    if err != nil {
+        t.Skip("no time zone database:", err)
+    }
+    ics := strings.Join([]string{
+        "BEGIN:VCALENDAR",
+        "BEGIN:VEVENT",
+        "SUMMARY:Record Jazz24\\, late",
+        "  night",
+        "DTSTART;TZID=America/New_York:20240501T200000",
+        "DTEND;TZID=America/New_York:20240501T220000",
+        "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=WE,1FR;UNTIL=20240601T000000Z",
+        "END:VEVENT",
+        "BEGIN:VEVENT",
+        "SUMMARY:Morning show",
+        "DTSTART:20240502T060000Z",
+        "DURATION:PT1H30M",
+        "RRULE:FREQ=DAILY;COUNT=5",
+        "END:VEVENT",
+        "BEGIN:VEVENT",
+        "DTSTART;VALUE=DATE:20240504",
+        "END:VEVENT",
+        "BEGIN:VEVENT",
+        "SUMMARY:No start",
+        "DURATION:PT1H",
+        "END:VEVENT",
+        "BEGIN:VEVENT",
+        "SUMMARY:No length",
+        "DTSTART:20240502T060000Z",
+        "END:VEVENT",
+        "SUMMARY:Outside any event",
+        "END:VCALENDAR",
+    }, "\r\n")
+    events, err := parseICS(ics)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []calendarEvent{
+        {"Record Jazz24, late night", time.Date(2024, 5, 1, 20, 0, 0, 0, newYork), 2 * time.Hour, false,
+            &recurrence{"WEEKLY", 2, 0, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), []time.Weekday{time.Wednesday, time.Friday}}},
+        {"Morning show", time.Date(2024, 5, 2, 6, 0, 0, 0, time.UTC), 90 * time.Minute, false, &recurrence{Freq: "DAILY", Interval: 1, Count: 5}},
+        {"", time.Date(2024, 5, 4, 0, 0, 0, 0, time.Local), 24 * time.Hour, true, nil},
+    }
+    if len(events) != len(want) {
+        t.Fatalf("parseICS found %d events: %+v", len(events), events)
+    }
+    for i := range want {
+        got := events[i]
+        if got.Summary != want[i].Summary || !got.Start.Equal(want[i].Start) || got.Duration != want[i].Duration ||
+            got.AllDay != want[i].AllDay || !reflect.DeepEqual(got.Rule, want[i].Rule) {
+            t.Errorf("event %d = %+v %+v; want %+v %+v", i, got, got.Rule, want[i], want[i].Rule)
+        }
+    }
+    if got := scheduledStation(events[0].Summary); got != "Jazz24, late night" {
+        t.Errorf("scheduledStation = %q", got)
+    }
+
+    for _, bad := range []string{
+        "DTSTART:2024-05-01",
+        "DTSTART:20240501T2000",
+        "DURATION:PT1X",
+        "DURATION:PTH",
+        "RRULE:FREQ=MONTHLY",
+        "RRULE:FREQ=DAILY;INTERVAL=0",
+        "RRULE:FREQ=DAILY;COUNT=many",
+        "RRULE:FREQ=DAILY;UNTIL=soon",
+    } {
+        if _, err := parseICS("BEGIN:VEVENT\n" + bad + "\nEND:VEVENT\n"); err == nil {
+            t.Errorf("%s accepted", bad)
+        }
+    }
+}
+
+func TestParseICSDuration(t *testing.T) {
+    for _, tt := range []struct {
+        in   string
+        want time.Duration
+    }{
+        {"PT1H30M", 90 * time.Minute},
+        {"P1D", 24 * time.Hour},
+        {"P1W", 7 * 24 * time.Hour},
+        {"P1DT2H", 26 * time.Hour},
+        {"+PT45S", 45 * time.Second},
+    } {
+        if got, err := parseICSDuration(tt.in); err != nil || got != tt.want {
+            t.Errorf("parseICSDuration(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+        }
+    }
+    // Minutes only count after the T
+    if _, err := parseICSDuration("P1M"); err == nil {
+        t.Error("P1M accepted")
+    }
+}
+
+func TestRecurrence(t *testing.T) {
+    // Wednesday 1 May 2024, 20:00
+    start := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+    day := func(d int) time.Time { return start.AddDate(0, 0, d) }
+    limit := day(30)
+    for _, tt := range []struct {
+        name string
+        rule string
+        want []time.Time
+    }{
+        {"once", "", []time.Time{start}},
+        {"daily", "FREQ=DAILY;COUNT=3", []time.Time{day(0), day(1), day(2)}},
+        {"every other day", "FREQ=DAILY;INTERVAL=2;COUNT=3", []time.Time{day(0), day(2), day(4)}},
+        {"daily until", "FREQ=DAILY;UNTIL=20240503T200000Z", []time.Time{day(0), day(1), day(2)}},
+        {"weekly", "FREQ=WEEKLY;COUNT=3", []time.Time{day(0), day(7), day(14)}},
+        {"weekdays", "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4", []time.Time{day(0), day(2), day(5), day(7)}},
+        {"every other week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=WE,FR;UNTIL=20240520T000000Z", []time.Time{day(0), day(2), day(14), day(16)}},
+        {"until the limit", "FREQ=WEEKLY", []time.Time{day(0), day(7), day(14), day(21), day(28)}},
+    } {
+        e := calendarEvent{Start: start, Duration: time.Hour}
+        if tt.rule != "" {
+            rule, err := parseRRule(tt.rule)
+            if err != nil {
+                t.Fatalf("%s: %v", tt.name, err)
+            }
+            e.Rule = rule
+        }
+        var got []time.Time
+        e.occurrences(limit, func(s time.Time) bool {
+            got = append(got, s)
+            return true
+        })
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: occurrences = %v; want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestActiveEvent(t *testing.T) {
+    show := calendarEvent{Summary: "Jazz24", Start: time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC), Duration: 2 * time.Hour,
+        Rule: &recurrence{Freq: "WEEKLY", Interval: 1, ByDay: []time.Weekday{time.Wednesday}}}
+    morning := calendarEvent{Summary: "Morning", Start: time.Date(2024, 5, 2, 6, 0, 0, 0, time.UTC), Duration: time.Hour}
+    events := []calendarEvent{show, morning}
+    for _, tt := range []struct {
+        now  time.Time
+        want string
+    }{
+        {time.Date(2024, 5, 8, 21, 0, 0, 0, time.UTC), "Jazz24"},
+        {time.Date(2024, 5, 8, 20, 0, 0, 0, time.UTC), "Jazz24"},
+        {time.Date(2024, 5, 8, 22, 0, 0, 0, time.UTC), ""},
+        {time.Date(2024, 5, 9, 21, 0, 0, 0, time.UTC), ""},
+        {time.Date(2024, 5, 2, 6, 30, 0, 0, time.UTC), "Morning"},
+        {time.Date(2024, 4, 24, 21, 0, 0, 0, time.UTC), ""},
+    } {
+        e, ok := activeEvent(events, tt.now)
+        if ok != (tt.want != "") || e.Summary != tt.want {
+            t.Errorf("activeEvent at %v = %q, %v; want %q", tt.now, e.Summary, ok, tt.want)
+        }
+    }
+}
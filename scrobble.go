@@ -0,0 +1,229 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Scrobbling tells listening services what was played, recorded or not: "playing now"
+// when a song starts and a listen once it has played for half its length or four
+// minutes, as Last.fm and ListenBrainz count them. Listens wait in a queue in the song
+// database until the service took them, so they survive being offline and restarts;
+// the queue is shared by all services, each row naming its own.
+
+// listen is a song played long enough to count
+type listen struct {
+    Title      string        `json:"title"`
+    Artist     string        `json:"artist"`
+    Album      string        `json:"album"`
+    Station    string        `json:"station"`
+    Loved      bool          `json:"loved"`
+    Duration   time.Duration `json:"duration"`
+    ListenedAt time.Time     `json:"listened_at"`
+}
+
+// scrobbler submits to one listening service
+type scrobbler interface {
+    NowPlaying(l listen) error
+    Submit(listens []listen) error
+}
+
+// scrobbleError is a failure the service won't get over by trying again, e.g. a
+// listen it rejects
+type scrobbleError struct {
+    err error
+}
+
+func (e scrobbleError) Error() string {
+    return e.err.Error()
+}
+
+// scrobblersFor returns the services cfg scrobbles to, by name
+func scrobblersFor(cfg Config) map[string]scrobbler {
+    services := make(map[string]scrobbler)
+    if cfg.ListenBrainzToken != "" {
+        services["listenbrainz"] = listenBrainz{cfg.ListenBrainzURL, cfg.ListenBrainzToken}
+    }
+    return services
+}
+
+// listenCounts reports whether a song of length total played for played counts as a
+// listen. Songs under 30 seconds never count.
+func listenCounts(played, total time.Duration) bool {
+    if total > 0 && total < 30*time.Second {
+        return false
+    }
+    return played >= 4*time.Minute || total > 0 && played >= total/2
+}
+
+// queueListen adds l to the queue of each service
+func queueListen(services map[string]scrobbler, l listen) {
+    if db == nil {
+        // Without a queue, one try is all it gets
+        for name, s := range services {
+            if err := s.Submit([]listen{l}); err != nil {
+                logger.Printf("Scrobbling %q to %s failed: %v", l.Title, name, err)
+            }
+        }
+        return
+    }
+    payload, _ := json.Marshal(l)
+    for name := range services {
+        if _, err := db.Exec("INSERT INTO scrobbles (service, listened_at, payload) VALUES (?, ?, ?)", name, l.ListenedAt.UTC(), string(payload)); err != nil {
+            logger.Printf("Failed to queue %q for %s: %v", l.Title, name, err)
+        }
+    }
+}
+
+// flushScrobbles submits the queued listens of each service, oldest first, and
+// returns how many are still waiting. Listens a service rejects are dropped.
+func flushScrobbles(services map[string]scrobbler) int {
+    if db == nil {
+        return 0
+    }
+    waiting := 0
+    for name, s := range services {
+        for {
+            rows, err := db.Query("SELECT id, payload FROM scrobbles WHERE service = ? ORDER BY listened_at LIMIT 100", name)
+            if err != nil {
+                logger.Printf("Failed to read the scrobble queue: %v", err)
+                return waiting
+            }
+            var ids []string
+            var listens []listen
+            for rows.Next() {
+                var id int64
+                var payload string
+                var l listen
+                if rows.Scan(&id, &payload) == nil && json.Unmarshal([]byte(payload), &l) == nil {
+                    ids = append(ids, fmt.Sprint(id))
+                    listens = append(listens, l)
+                }
+            }
+            rows.Close()
+            if len(listens) == 0 {
+                break
+            }
+            err = s.Submit(listens)
+            if _, ok := err.(scrobbleError); err != nil && !ok {
+                db.Exec("UPDATE scrobbles SET attempts = attempts + 1, error = ? WHERE id IN ("+strings.Join(ids, ",")+")", err.Error())
+                var n int
+                db.QueryRow("SELECT COUNT(*) FROM scrobbles WHERE service = ?", name).Scan(&n)
+                logger.Printf("Scrobbling to %s failed, %d listens wait: %v", name, n, err)
+                waiting += n
+                break
+            }
+            if err != nil {
+                logger.Printf("%s rejected %d listens: %v", name, len(listens), err)
+            }
+            if _, err := db.Exec("DELETE FROM scrobbles WHERE id IN (" + strings.Join(ids, ",") + ")"); err != nil {
+                logger.Printf("Failed to update the scrobble queue: %v", err)
+                break
+            }
+        }
+    }
+    return waiting
+}
+
+// scrobbleLoop follows the player, sending "playing now" and queueing listens, until
+// stop is closed; then it queues the song playing if it counts. Services come from
+// the config in use, so a reload can add one.
+func scrobbleLoop(stop chan struct{}, flush chan struct{}) {
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+    var song playerStatus
+    var started time.Time
+    // Pandora can't seek, so the countdown tells how much was played
+    var played time.Duration
+    finish := func() {
+        if song.Title != "" && listenCounts(played, time.Duration(song.Total)*time.Second) {
+            queueListen(scrobblersFor(currentConfig()), listen{song.Title, song.Artist, song.Album, song.Station, song.Loved, time.Duration(song.Total) * time.Second, started})
+            select {
+            case flush <- struct{}{}:
+            default:
+            }
+        }
+    }
+    for {
+        select {
+        case <-stop:
+            finish()
+            return
+        case <-ticker.C:
+        }
+        services := scrobblersFor(currentConfig())
+        if len(services) == 0 {
+            continue
+        }
+        s := currentStatus()
+        if s.Title != song.Title || s.Artist != song.Artist {
+            finish()
+            song, started, played = s, time.Now(), 0
+            if s.Title == "" {
+                continue
+            }
+            for name, service := range services {
+                l := listen{s.Title, s.Artist, s.Album, s.Station, s.Loved, time.Duration(s.Total) * time.Second, started}
+                go func() {
+                    if err := service.NowPlaying(l); err != nil {
+                        logger.Printf("Playing now on %s failed: %v", name, err)
+                    }
+                }()
+            }
+        }
+        // The length shows up with the first countdown
+        if s.Total > 0 {
+            song.Total, song.Loved = s.Total, s.Loved
+            if position := time.Duration(s.Total-s.Remaining) * time.Second; position > played {
+                played = position
+            }
+        }
+    }
+}
+
+// flushLoop works through the queue when flush says there is something new, every
+// minute while listens wait, and every hour otherwise
+func flushLoop(stop chan struct{}, flush chan struct{}) {
+    timer := time.NewTimer(0)
+    defer timer.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-flush:
+        case <-timer.C:
+        }
+        next := time.Hour
+        if flushScrobbles(scrobblersFor(currentConfig())) > 0 {
+            next = time.Minute
+        }
+        timer.Reset(next)
+    }
+}
+
+var scrobbling struct {
+    stop, done chan struct{}
+}
+
+// startScrobbling scrobbles for the rest of the session
+func startScrobbling() {
+    stop, done := make(chan struct{}), make(chan struct{})
+    flush := make(chan struct{}, 1)
+    scrobbling.stop, scrobbling.done = stop, done
+    go flushLoop(stop, flush)
+    go func() {
+        scrobbleLoop(stop, flush)
+        close(done)
+    }()
+}
+
+// stopScrobbling queues the song playing if it counts, to be sent next time
+func stopScrobbling() {
+    if scrobbling.stop == nil {
+        return
+    }
+    close(scrobbling.stop)
+    <-scrobbling.done
+}
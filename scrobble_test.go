@@ -0,0 +1,74 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestScrobble(t *testing.T) {
+    for _, tt := range []struct {
+        played, total time.Duration
+        counts        bool
+    }{
+        {90 * time.Second, 3 * time.Minute, true},
+        {80 * time.Second, 3 * time.Minute, false},
+        {4 * time.Minute, 10 * time.Minute, true},
+        {20 * time.Second, 25 * time.Second, false},
+        {4 * time.Minute, 0, true},
+    } {
+        if got := listenCounts(tt.played, tt.total); got != tt.counts {
+            t.Errorf("listenCounts(%v, %v) = %v", tt.played, tt.total, got)
+        }
+    }
+
+    openTestDB(t)
+    status := http.StatusServiceUnavailable
+    var submissions []map[string]interface{}
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token t0ken" {
+            t.Errorf("%s %s with %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+        }
+        var body map[string]interface{}
+        json.NewDecoder(r.Body).Decode(&body)
+        submissions = append(submissions, body)
+        w.WriteHeader(status)
+    }))
+    defer server.Close()
+    services := scrobblersFor(Config{ListenBrainzToken: "t0ken", ListenBrainzURL: server.URL})
+
+    at := time.Date(2024, 5, 1, 20, 15, 0, 0, time.UTC)
+    queueListen(services, listen{"So What", "Miles Davis", "Kind of Blue", "Jazz Radio", false, 545 * time.Second, at})
+    queueListen(services, listen{"Blue in Green", "Miles Davis", "Kind of Blue", "Jazz Radio", false, 337 * time.Second, at.Add(10 * time.Minute)})
+    if waiting := flushScrobbles(services); waiting != 2 {
+        t.Errorf("%d listens waiting after a failure, want 2", waiting)
+    }
+    status = http.StatusOK
+    if waiting := flushScrobbles(services); waiting != 0 || len(submissions) != 2 {
+        t.Fatalf("%d listens waiting, %d submissions", waiting, len(submissions))
+    }
+    body := submissions[1]
+    payload, _ := body["payload"].([]interface{})
+    if body["listen_type"] != "import" || len(payload) != 2 {
+        t.Fatalf("submitted %v", body)
+    }
+    first := payload[0].(map[string]interface{})
+    track := first["track_metadata"].(map[string]interface{})
+    if first["listened_at"] != float64(at.Unix()) || track["track_name"] != "So What" || track["release_name"] != "Kind of Blue" {
+        t.Errorf("first listen %v", first)
+    }
+
+    // Listens the server rejects don't hold up the queue
+    status = http.StatusBadRequest
+    queueListen(services, listen{Title: "?", Artist: "", ListenedAt: at})
+    if waiting := flushScrobbles(services); waiting != 0 || submissions[2]["listen_type"] != "single" {
+        t.Errorf("%d rejected listens kept", waiting)
+    }
+    var n int
+    db.QueryRow("SELECT COUNT(*) FROM scrobbles").Scan(&n)
+    if n != 0 {
+        t.Errorf("%d listens left in the queue", n)
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "io"
+    "os"
+    "time"
+)
+
+// Checksums of finished recordings are kept in the song database; scrubbing
+// re-reads the files and reports any whose contents changed (bit rot) or vanished.
+
+// fileChecksum returns the hex SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+    h := sha256.New()
+    if _, err := io.Copy(h, f); err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// recordChecksum stores the checksum of a recording's final contents
+func recordChecksum(fileName string) error {
+    if db == nil {
+        return errNotConfigured
+    }
+    sum, err := fileChecksum(fileName)
+    if err != nil {
+        return fmt.Errorf("failed to checksum %s: %v", fileName, err)
+    }
+    if _, err := db.Exec(`UPDATE songs SET sha256 = ?, verified_at = ? WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)`,
+        sum, time.Now().UTC(), fileName); err != nil {
+        return fmt.Errorf("failed to update %s in database: %v", fileName, err)
+    }
+    return nil
+}
+
+// scrubResult counts the outcome of a scrub
+type scrubResult struct {
+    OK, Corrupt, Missing, Added int
+}
+
+// scrubRecordings verifies saved recordings last verified before olderThan. Without
+// a stored checksum, update records one instead.
+func scrubRecordings(olderThan time.Time, update bool, report func(status, file string)) (scrubResult, error) {
+    var result scrubResult
+    rows, err := db.Query(`SELECT id, file, sha256 FROM songs WHERE outcome = ? AND (verified_at IS NULL OR verified_at < ?) ORDER BY id`,
+        outcomeSaved, olderThan.UTC())
+    if err != nil {
+        return result, fmt.Errorf("failed to query database: %v", err)
+    }
+    type song struct {
+        id         int64
+        file, hash string
+    }
+    var songs []song
+    for rows.Next() {
+        var s song
+        if err := rows.Scan(&s.id, &s.file, &s.hash); err != nil {
+            rows.Close()
+            return result, fmt.Errorf("failed to read database: %v", err)
+        }
+        songs = append(songs, s)
+    }
+    rows.Close()
+
+    for _, s := range songs {
+        if s.hash == "" && !update {
+            continue
+        }
+        sum, err := fileChecksum(s.file)
+        switch {
+        case os.IsNotExist(err):
+            result.Missing++
+            report("missing", s.file)
+            continue
+        case err != nil:
+            result.Corrupt++
+            report("unreadable", s.file)
+            continue
+        case s.hash == "":
+            result.Added++
+            db.Exec("UPDATE songs SET sha256 = ?, verified_at = ? WHERE id = ?", sum, time.Now().UTC(), s.id)
+        case sum != s.hash:
+            result.Corrupt++
+            report("corrupt", s.file)
+        default:
+            result.OK++
+            db.Exec("UPDATE songs SET verified_at = ? WHERE id = ?", time.Now().UTC(), s.id)
+        }
+    }
+    return result, nil
+}
+
+// loadScrubConfig reads scrub_every, how often the running program re-verifies each recording
+func loadScrubConfig(values map[string]string, cfg *Config) error {
+    if raw := values["scrub_every"]; raw != "" {
+        cutoff, err := parseSince(raw)
+        if err != nil || cutoff.IsZero() {
+            return fmt.Errorf("invalid value for scrub_every: %q", raw)
+        }
+        cfg.ScrubEvery = time.Since(cutoff).Round(time.Minute)
+    }
+    return nil
+}
+
+// startScrub re-verifies recordings in the background every hour
+func startScrub(cfg Config) {
+    if cfg.ScrubEvery <= 0 || db == nil {
+        return
+    }
+    go func() {
+        for {
+            result, err := scrubRecordings(time.Now().Add(-cfg.ScrubEvery), false, func(status, file string) {
+                fmt.Printf("\r\nScrub: %s recording %s\n", status, file)
+                logger.Printf("Scrub: %s recording %s", status, file)
+            })
+            if err != nil {
+                logger.Printf("Scrub: %v", err)
+            } else if result.OK+result.Corrupt+result.Missing > 0 {
+                logger.Printf("Scrub: %d ok, %d corrupt, %d missing", result.OK, result.Corrupt, result.Missing)
+            }
+            time.Sleep(time.Hour)
+        }
+    }()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestScrubRecordings(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+
+    path := func(name string) string { return filepath.Join(dir, name) }
+    info := songInfo{Title: "Song", Artist: "Artist"}
+    for _, name := range []string{"good.mp3", "rotten.mp3", "gone.mp3", "old.mp3"} {
+        if err := ioutil.WriteFile(path(name), []byte(name), 0644); err != nil {
+            t.Fatal(err)
+        }
+        logDetectedSong(info, "Station", path(name), outcomeRecording)
+        recordSavedSong(path(name), Tags{})
+        if name != "old.mp3" {
+            recordChecksum(path(name))
+        }
+    }
+    ioutil.WriteFile(path("rotten.mp3"), []byte("flipped"), 0644)
+    os.Remove(path("gone.mp3"))
+
+    bad := map[string]string{}
+    result, err := scrubRecordings(time.Now(), true, func(status, file string) { bad[filepath.Base(file)] = status })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result != (scrubResult{OK: 1, Corrupt: 1, Missing: 1, Added: 1}) {
+        t.Errorf("got %+v", result)
+    }
+    if bad["rotten.mp3"] != "corrupt" || bad["gone.mp3"] != "missing" || len(bad) != 2 {
+        t.Errorf("reported %v", bad)
+    }
+
+    // Verified recordings are skipped until they're due again
+    result, _ = scrubRecordings(time.Now().Add(-time.Hour), false, func(string, string) {})
+    if result.OK != 0 {
+        t.Errorf("re-verified %d recent recordings", result.OK)
+    }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Runtime settings can be changed while pianotrap runs by publishing "key=value" to
+// the MQTT set topic, and written back to the config file with "save". Only options
+// that are read live are adjustable; the rest still need a restart.
+
+// runtimeSettings returns the adjustable options with their current values
+func runtimeSettings() map[string]string {
+    mu.Lock()
+    defer mu.Unlock()
+    return map[string]string{
+        "incomplete_seconds": strconv.Itoa(int(timeThreshold / time.Second)),
+        "incomplete_percent": strconv.FormatFloat(percentThreshold, 'f', -1, 64),
+        "on_incomplete":      onIncomplete,
+        "watchdog_warn":      watchdogWarn.String(),
+        "watchdog_timeout":   watchdogTimeout.String(),
+    }
+}
+
+// changeSetting validates and applies a new value for one runtime setting
+func changeSetting(key, value string) error {
+    values := runtimeSettings()
+    if _, ok := values[key]; !ok {
+        var keys []string
+        for k := range values {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        return fmt.Errorf("%q can't be changed at runtime (adjustable: %s)", key, strings.Join(keys, ", "))
+    }
+    values[key] = value
+    var c Config
+    if err := loadIncompleteConfig(values, &c); err != nil {
+        return err
+    }
+    if err := loadWatchdogConfig(values, &c); err != nil {
+        return err
+    }
+    mu.Lock()
+    timeThreshold = c.IncompleteAfter
+    percentThreshold = c.IncompletePercent
+    onIncomplete = c.OnIncomplete
+    watchdogWarn, watchdogTimeout = c.WatchdogWarn, c.WatchdogTimeout
+    mu.Unlock()
+    logger.Printf("Setting %s changed to %s", key, value)
+    return nil
+}
+
+// saveSettings writes the current runtime settings to cfg's config file, in its
+// profile if it has one
+func saveSettings(cfg Config) error {
+    for key, value := range runtimeSettings() {
+        if cfg.Profile != "" {
+            key = "profile." + cfg.Profile + "." + key
+        }
+        if err := setConfigValue(cfg.ConfigFile, key, value); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// settingsJSON renders the runtime settings for publishing
+func settingsJSON() []byte {
+    data, _ := json.Marshal(runtimeSettings())
+    return data
+}
+
+// handleSetCommand applies a "key=value" or "save" message
+func handleSetCommand(payload string, cfg Config) error {
+    payload = strings.TrimSpace(payload)
+    if payload == "save" {
+        return saveSettings(cfg)
+    }
+    parts := strings.SplitN(payload, "=", 2)
+    if len(parts) != 2 {
+        return fmt.Errorf("expected key=value or save, got %q", payload)
+    }
+    return changeSetting(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+}
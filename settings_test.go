@@ -0,0 +1,34 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestChangeSetting(t *testing.T) {
+    defer func(seconds time.Duration, pct float64, mode string) {
+        timeThreshold, percentThreshold, onIncomplete = seconds, pct, mode
+    }(timeThreshold, percentThreshold, onIncomplete)
+
+    if err := handleSetCommand("incomplete_seconds = 25", Config{}); err != nil || timeThreshold != 25*time.Second {
+        t.Errorf("incomplete_seconds not applied: %v, %v", err, timeThreshold)
+    }
+    for _, bad := range []string{"on_incomplete=shred", "samplerate=48000", "nonsense"} {
+        if err := handleSetCommand(bad, Config{}); err == nil {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+    if timeThreshold != 25*time.Second || onIncomplete == "shred" {
+        t.Error("rejected change was applied")
+    }
+
+    configFile := filepath.Join(t.TempDir(), "config")
+    if err := handleSetCommand("save", Config{ConfigFile: configFile}); err != nil {
+        t.Fatal(err)
+    }
+    values, _ := readConfigValues(configFile)
+    if values["incomplete_seconds"] != "25" {
+        t.Errorf("saved config %v", values)
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// Every run plays pianobar into a null sink of its own, so a second pianotrap or
+// another program never ends up in the recordings. A loopback to the default output
+// keeps it audible. pianobar plays through libao, which only reads ~/.libao, so it
+// runs with HOME pointing at a generated libao config for that sink.
+
+var audioDir string
+
+// sessionSinkName is the PulseAudio sink pianobar plays to in this run
+func sessionSinkName() string {
+    return fmt.Sprintf("PianobarSink_%d", os.Getpid())
+}
+
+// libaoConfig points libao's pulse driver at sink
+func libaoConfig(sink string) string {
+    return fmt.Sprintf("default_driver=pulse\ndev=%s\nquiet\n", sink)
+}
+
+// createSessionSink creates the null sink pianobar plays to and the loopback that
+// lets it be heard on the default output
+func createSessionSink(sink string, rate, channels int) error {
+    out, err := exec.Command("pactl", "get-default-sink").Output()
+    original := strings.TrimSpace(string(out))
+    if err != nil || original == "" {
+        return fmt.Errorf("could not determine the default sink: %v", err)
+    }
+    // A sink of that name can only be left over from a crashed run with our pid
+    unloadSessionSink(sink)
+
+    format := []string{fmt.Sprintf("rate=%d", rate), fmt.Sprintf("channels=%d", channels)}
+    args := append([]string{"load-module", "module-null-sink", "sink_name=" + sink, "sink_properties=device.description=" + sink}, format...)
+    if out, err := exec.Command("pactl", args...).CombinedOutput(); err != nil {
+        return fmt.Errorf("failed to create %s: %v: %s", sink, err, strings.TrimSpace(string(out)))
+    }
+    exec.Command("pactl", "set-sink-volume", sink, "65536").Run()
+    exec.Command("pactl", "set-sink-mute", sink, "0").Run()
+
+    args = append([]string{"load-module", "module-loopback", "sink=" + original, "source=" + sink + ".monitor"}, format...)
+    args = append(args, "latency_msec=20", "adjust_time=0")
+    if out, err := exec.Command("pactl", args...).CombinedOutput(); err != nil {
+        logger.Printf("Warning: failed to create loopback to %s: %v: %s", original, err, strings.TrimSpace(string(out)))
+    }
+    logger.Printf("Created %s with a loopback to %s", sink, original)
+    return nil
+}
+
+// setupAudioOutput writes the session's libao config and returns the environment
+// pianobar needs to play to sink
+func setupAudioOutput(sink string) ([]string, error) {
+    env := []string{"PULSE_SINK=" + sink}
+    var err error
+    audioDir, err = ioutil.TempDir("", "pianotrap-audio-")
+    if err != nil {
+        return env, fmt.Errorf("failed to create audio config directory: %v", err)
+    }
+    if err := ioutil.WriteFile(filepath.Join(audioDir, ".libao"), []byte(libaoConfig(sink)), 0600); err != nil {
+        return env, fmt.Errorf("failed to write libao config: %v", err)
+    }
+    // pianobar's own config is still found where it was
+    configHome := os.Getenv("XDG_CONFIG_HOME")
+    if configHome == "" {
+        homeDir, err := os.UserHomeDir()
+        if err != nil {
+            return env, err
+        }
+        configHome = filepath.Join(homeDir, ".config")
+    }
+    return append(env, "HOME="+audioDir, "XDG_CONFIG_HOME="+configHome), nil
+}
+
+// cleanupAudioOutput removes the session's libao config
+func cleanupAudioOutput() {
+    if audioDir != "" {
+        os.RemoveAll(audioDir)
+    }
+}
+
+// sessionModules picks the null sink and loopback of sink from "pactl list short
+// modules" output
+func sessionModules(output, sink string) []string {
+    var ids []string
+    for _, line := range strings.Split(output, "\n") {
+        fields := strings.SplitN(line, "\t", 3)
+        if len(fields) < 3 {
+            continue
+        }
+        for _, arg := range strings.Fields(fields[2]) {
+            if arg == "sink_name="+sink || arg == "source="+sink+".monitor" {
+                ids = append(ids, fields[0])
+                break
+            }
+        }
+    }
+    return ids
+}
+
+// unloadSessionSink removes the sink and loopback of this session, leaving those
+// of other sessions alone
+func unloadSessionSink(sink string) {
+    out, err := exec.Command("pactl", "list", "short", "modules").Output()
+    if err != nil {
+        return
+    }
+    for _, id := range sessionModules(string(out), sink) {
+        exec.Command("pactl", "unload-module", id).Run()
+    }
+}
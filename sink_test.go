@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestSessionModules(t *testing.T) {
+    out := "22\tmodule-null-sink\tsink_name=PianobarSink_41 sink_properties=device.description=PianobarSink_41 rate=44100 channels=2\n" +
+        "23\tmodule-loopback\tsink=alsa_output.pci source=PianobarSink_41.monitor rate=44100 channels=2\n" +
+        "24\tmodule-null-sink\tsink_name=PianobarSink_410 rate=44100\n" +
+        "25\tmodule-loopback\tsink=alsa_output.pci source=PianobarSink_410.monitor\n"
+    if got := sessionModules(out, "PianobarSink_41"); len(got) != 2 || got[0] != "22" || got[1] != "23" {
+        t.Errorf("sessionModules = %q, want [22 23]", got)
+    }
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+    "fmt"
+    "os/exec"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// A wrong monitor source records silence all night. The first complete recording of
+// a run is therefore checked; if it is silent, the other monitor sources are tried
+// briefly and, depending on silent_source, pianotrap offers or makes the switch.
+
+const (
+    silentSourceAsk  = "ask"
+    silentSourceAuto = "auto"
+    silentSourceOff  = "off"
+
+    // silenceLevel is the peak in dBFS below which a recording counts as silent
+    silenceLevel = -60.0
+    // probeSeconds is how long each alternative source is listened to
+    probeSeconds = 3
+)
+
+// sourceSwitchKey (Ctrl+O) switches to a monitor source found to have audio after
+// the recording was silent. pianobar doesn't use it, so it's never forwarded.
+const sourceSwitchKey = 0x0f
+
+var (
+    // captureSource is the PulseAudio source recordings are made from and
+    // suggestedSources those found to have audio while it was silent; guarded by mu
+    captureSource    string
+    suggestedSources []sourceLevel
+
+    silentSource  = silentSourceAsk
+    sourceChecked sync.Once
+)
+
+// sourceLevel is the peak level heard on a source
+type sourceLevel struct {
+    Name string
+    Peak float64
+}
+
+var maxVolumeRe = regexp.MustCompile(`max_volume: (-?[0-9.]+|-inf) dB`)
+
+// loadSourceConfig reads silent_source
+func loadSourceConfig(values map[string]string, cfg *Config) error {
+    cfg.SilentSource = silentSourceAsk
+    switch raw := values["silent_source"]; raw {
+    case "":
+    case silentSourceAsk, silentSourceAuto, silentSourceOff:
+        cfg.SilentSource = raw
+    default:
+        return fmt.Errorf("invalid value for silent_source: %q (use ask, auto or off)", raw)
+    }
+    return nil
+}
+
+// parseMaxVolume extracts the peak level from ffmpeg's volumedetect output
+func parseMaxVolume(output string) (float64, error) {
+    m := maxVolumeRe.FindStringSubmatch(output)
+    if m == nil {
+        return 0, fmt.Errorf("no volume reported")
+    }
+    if m[1] == "-inf" {
+        return -200, nil
+    }
+    return strconv.ParseFloat(m[1], 64)
+}
+
+// peakLevel runs ffmpeg's volumedetect over input, given as ffmpeg input arguments
+func peakLevel(input ...string) (float64, error) {
+    args := append([]string{"-hide_banner", "-nostats"}, input...)
+    args = append(args, "-af", "volumedetect", "-f", "null", "-")
+    out, err := exec.Command(ffmpegBinary(), args...).CombinedOutput()
+    if err != nil {
+        return 0, fmt.Errorf("ffmpeg: %v", err)
+    }
+    return parseMaxVolume(string(out))
+}
+
+// monitorSources lists the monitor sources in "pactl list short sources" output
+func monitorSources(output string) []string {
+    var sources []string
+    for _, line := range strings.Split(output, "\n") {
+        fields := strings.Split(line, "\t")
+        if len(fields) >= 2 && strings.HasSuffix(fields[1], ".monitor") {
+            sources = append(sources, fields[1])
+        }
+    }
+    return sources
+}
+
+// checkCaptureSource measures the first complete recording of the run and, if it
+// is silent, looks for a monitor source that has audio
+func checkCaptureSource(fileName string) {
+    if silentSource == silentSourceOff {
+        return
+    }
+    sourceChecked.Do(func() {
+        peak, err := peakLevel("-i", fileName)
+        if err != nil {
+            logger.Printf("Failed to measure %s: %v", fileName, err)
+            return
+        }
+        logger.Printf("First recording peaks at %.1f dBFS", peak)
+        if peak > silenceLevel {
+            return
+        }
+        go probeSources()
+    })
+}
+
+// probeSources listens to each other monitor source briefly and offers or
+// switches to the loudest one
+func probeSources() {
+    mu.Lock()
+    current := captureSource
+    mu.Unlock()
+    fmt.Printf("\r\nThe last recording from %s is silent, trying other sources\r\n", current)
+
+    out, err := exec.Command("pactl", "list", "short", "sources").Output()
+    if err != nil {
+        fmt.Printf("\r\nCan't list PulseAudio sources: %v\r\n", err)
+        return
+    }
+    var found []sourceLevel
+    for _, name := range monitorSources(string(out)) {
+        if name == current {
+            continue
+        }
+        peak, err := peakLevel("-f", "pulse", "-t", strconv.Itoa(probeSeconds), "-i", name)
+        if err != nil {
+            logger.Printf("Failed to probe %s: %v", name, err)
+            continue
+        }
+        logger.Printf("Source %s peaks at %.1f dBFS", name, peak)
+        if peak > silenceLevel {
+            found = append(found, sourceLevel{Name: name, Peak: peak})
+        }
+    }
+    if len(found) == 0 {
+        fmt.Printf("\r\nNo other monitor source has audio; check that pianobar plays to %s\r\n", strings.TrimSuffix(current, ".monitor"))
+        return
+    }
+    sort.Slice(found, func(i, j int) bool { return found[i].Peak > found[j].Peak })
+
+    if silentSource == silentSourceAuto {
+        switchCaptureSource(found[0].Name)
+        return
+    }
+    mu.Lock()
+    suggestedSources = found
+    mu.Unlock()
+    fmt.Printf("\r\nAudio found on %s - Ctrl+O to switch\r\n", found[0].Name)
+}
+
+// switchCaptureSource records from source from the next song on
+func switchCaptureSource(source string) {
+    mu.Lock()
+    captureSource = source
+    suggestedSources = nil
+    mu.Unlock()
+    logger.Printf("Capture source switched to %s", source)
+    fmt.Printf("\r\nRecording from %s from the next song on\r\n", source)
+}
+
+// chooseCaptureSource prompts for one of the suggested sources. It reads the
+// terminal directly, so it must run on the stdin goroutine.
+func chooseCaptureSource() {
+    mu.Lock()
+    found := suggestedSources
+    mu.Unlock()
+    if len(found) == 0 {
+        fmt.Printf("\r\nNo other source to switch to\r\n")
+        return
+    }
+    for i, s := range found {
+        fmt.Printf("\r\n  %d) %s (%.1f dBFS)", i+1, s.Name, s.Peak)
+    }
+    answer, ok := readLine("Source: ", "1")
+    if !ok {
+        return
+    }
+    n, err := strconv.Atoi(strings.TrimSpace(answer))
+    if err != nil || n < 1 || n > len(found) {
+        fmt.Printf("\r\nNo such source\r\n")
+        return
+    }
+    switchCaptureSource(found[n-1].Name)
+}
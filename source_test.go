@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestSourceProbing(t *testing.T) {
+    out := "0\tPianobarSink.monitor\tmodule-null-sink.c\ts16le 2ch 44100Hz\tIDLE\n" +
+        "1\talsa_output.pci.analog-stereo.monitor\tmodule-alsa-card.c\ts16le 2ch 44100Hz\tRUNNING\n" +
+        "2\talsa_input.pci.analog-stereo\tmodule-alsa-card.c\ts16le 2ch 44100Hz\tSUSPENDED\n"
+    got := monitorSources(out)
+    if len(got) != 2 || got[0] != "PianobarSink.monitor" || got[1] != "alsa_output.pci.analog-stereo.monitor" {
+        t.Errorf("monitorSources = %q", got)
+    }
+
+    tests := []struct {
+        output string
+        want   float64
+        ok     bool
+    }{
+        {"[Parsed_volumedetect_0 @ 0x1] mean_volume: -20.5 dB\n[Parsed_volumedetect_0 @ 0x1] max_volume: -3.2 dB\n", -3.2, true},
+        {"[Parsed_volumedetect_0 @ 0x1] max_volume: -inf dB\n", -200, true},
+        {"Output file is empty, nothing was encoded\n", 0, false},
+    }
+    for _, tt := range tests {
+        got, err := parseMaxVolume(tt.output)
+        if (err == nil) != tt.ok || got != tt.want {
+            t.Errorf("parseMaxVolume(%q) = %v, %v, want %v", tt.output, got, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "time"
+)
+
+var (
+    pianobarPTY   *os.File
+    nowPlaying    songInfo
+    lastCountdown time.Time
+)
+
+// playerStatus is a snapshot of what pianobar is playing and what pianotrap is doing,
+// shared with the remote integrations
+type playerStatus struct {
+    State     string      `json:"state"`
+    Station   string      `json:"station"`
+    Title     string      `json:"title"`
+    Artist    string      `json:"artist"`
+    Album     string      `json:"album"`
+    Loved     bool        `json:"loved"`
+    CoverArt  string      `json:"cover_art,omitempty"`
+    Recording bool        `json:"recording"`
+    Paused    bool        `json:"recording_paused"`
+    File      string      `json:"file,omitempty"`
+    Tags      *tagPreview `json:"tags,omitempty"`
+    Remaining int         `json:"remaining_seconds"`
+    Total     int         `json:"total_seconds"`
+}
+
+// currentStatus returns the current player status. pianobar redraws its countdown every
+// second while playing, so a song without recent ticks is treated as paused.
+func currentStatus() playerStatus {
+    mu.Lock()
+    status := playerStatus{
+        Station:   currentStation,
+        Title:     nowPlaying.Title,
+        Artist:    nowPlaying.Artist,
+        Album:     nowPlaying.Album,
+        Loved:     nowPlaying.Loved || (recording && currentTags.Loved),
+        Recording: recording,
+        Paused:    capturePaused,
+        Remaining: int(remainingTime.Seconds()),
+        Total:     int(totalDuration.Seconds()),
+    }
+    if recording {
+        status.File = currentFileName
+        if newName, ok := renames[currentFileName]; ok {
+            status.File = newName
+        }
+        status.Tags = newTagPreview(currentTags)
+    }
+    ticking := time.Since(lastCountdown) < 3*time.Second
+    mu.Unlock()
+
+    switch {
+    case status.Title == "":
+        status.State = "idle"
+    case ticking:
+        status.State = "playing"
+    default:
+        status.State = "paused"
+    }
+    status.CoverArt = coverFileFor(status.Title, status.Artist)
+    return status
+}
+
+// sendKeys types keys into pianobar as if they came from the terminal
+func sendKeys(keys string) error {
+    if pianobarPTY == nil {
+        return fmt.Errorf("pianobar is not running")
+    }
+    logger.Printf("Sending to PTY: %q", keys)
+    if _, err := pianobarPTY.Write([]byte(keys)); err != nil {
+        return fmt.Errorf("failed to write to pianobar: %v", err)
+    }
+    for _, key := range keys {
+        if key == '+' {
+            markCurrentLoved()
+        }
+        if key < 0x80 {
+            notePlayerKey(byte(key))
+        }
+    }
+    return nil
+}
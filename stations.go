@@ -0,0 +1,131 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    outputTapsMu sync.Mutex
+    outputTaps   []chan string
+)
+
+// tapOutput registers a channel that receives copies of pianobar's output until the
+// returned function is called
+func tapOutput() (<-chan string, func()) {
+    ch := make(chan string, 100)
+    outputTapsMu.Lock()
+    outputTaps = append(outputTaps, ch)
+    outputTapsMu.Unlock()
+    return ch, func() {
+        outputTapsMu.Lock()
+        defer outputTapsMu.Unlock()
+        for i, tap := range outputTaps {
+            if tap == ch {
+                outputTaps = append(outputTaps[:i], outputTaps[i+1:]...)
+                break
+            }
+        }
+    }
+}
+
+// forwardOutput copies a chunk of pianobar output to every tap without blocking
+func forwardOutput(output string) {
+    outputTapsMu.Lock()
+    defer outputTapsMu.Unlock()
+    for _, tap := range outputTaps {
+        select {
+        case tap <- output:
+        default:
+        }
+    }
+}
+
+// stationListRe matches an entry of pianobar's station list: "\t 3) q   Jazz Radio"
+var stationListRe = regexp.MustCompile(`^\s*(\d+)\) (.{3}) (.+)$`)
+
+// stationSwitchMu keeps two switches from interleaving their keystrokes
+var stationSwitchMu sync.Mutex
+
+// switchStation opens pianobar's station list and selects the station whose name
+// matches name, see matchStation
+func switchStation(name string) error {
+    stationSwitchMu.Lock()
+    defer stationSwitchMu.Unlock()
+
+    output, untap := tapOutput()
+    defer untap()
+    if err := sendKeys("s"); err != nil {
+        return err
+    }
+
+    stations := make(map[int]string)
+    var pending string
+    timeout := time.After(10 * time.Second)
+    for {
+        select {
+        case chunk := <-output:
+            pending += chunk
+        case <-timeout:
+            sendKeys("\n")
+            return fmt.Errorf("timed out waiting for pianobar's station list")
+        }
+        lines := strings.Split(pending, "\n")
+        pending = lines[len(lines)-1]
+        for _, line := range lines[:len(lines)-1] {
+            if m := stationListRe.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+                n, _ := strconv.Atoi(m[1])
+                stations[n] = strings.TrimSpace(m[3])
+            }
+        }
+        if isStationPrompt(pending) {
+            break
+        }
+    }
+
+    choice, err := matchStation(stations, name)
+    if err != nil {
+        // An empty answer cancels the prompt
+        sendKeys("\n")
+        return err
+    }
+    logger.Printf("Switching to station %d (%s)", choice, stations[choice])
+    return sendKeys(fmt.Sprintf("%d\n", choice))
+}
+
+// matchStation picks the station named name from pianobar's numbered list, ignoring
+// case. Without such a station it takes the one whose name contains name, and
+// refuses to guess when several do.
+func matchStation(stations map[int]string, name string) (int, error) {
+    numbers := make([]int, 0, len(stations))
+    for n := range stations {
+        numbers = append(numbers, n)
+    }
+    sort.Ints(numbers)
+
+    var matches []int
+    for _, n := range numbers {
+        if strings.EqualFold(stations[n], name) {
+            return n, nil
+        }
+        if strings.Contains(strings.ToLower(stations[n]), strings.ToLower(name)) {
+            matches = append(matches, n)
+        }
+    }
+    switch len(matches) {
+    case 0:
+        return -1, fmt.Errorf("no station matching %q", name)
+    case 1:
+        return matches[0], nil
+    }
+    names := make([]string, len(matches))
+    for i, n := range matches {
+        names[i] = stations[n]
+    }
+    return -1, fmt.Errorf("%q matches several stations: %s", name, strings.Join(names, ", "))
+}
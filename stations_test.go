@@ -0,0 +1,34 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestMatchStation(t *testing.T) {
+    stations := map[int]string{0: "Jazz Radio", 1: "Smooth Jazz Radio", 2: "Deep Focus Radio", 3: "Jazz"}
+    for _, tt := range []struct {
+        name string
+        want int
+    }{
+        {"jazz radio", 0},
+        {"JAZZ", 3},
+        {"focus", 2},
+        {"smooth", 1},
+    } {
+        if got, err := matchStation(stations, tt.name); err != nil || got != tt.want {
+            t.Errorf("matchStation(%q) = %d, %v, want %d", tt.name, got, err, tt.want)
+        }
+    }
+
+    // Two stations contain "jazz r", so neither is picked at random
+    for i := 0; i < 10; i++ {
+        _, err := matchStation(stations, "jazz r")
+        if err == nil || !strings.Contains(err.Error(), "Jazz Radio, Smooth Jazz Radio") {
+            t.Fatalf("ambiguous name: %v", err)
+        }
+    }
+    if _, err := matchStation(stations, "rock"); err == nil {
+        t.Error("station matched that isn't in the list")
+    }
+}
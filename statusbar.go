@@ -0,0 +1,185 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/term"
+)
+
+// Unless status_bar is off, the bottom line of the terminal shows what pianotrap is
+// doing. pianobar's output is written straight through and scrolls in a region
+// above it; routine messages go to the bar and the log instead of between its lines.
+
+var (
+    // statusMu serializes terminal writes while the bar is shown
+    statusMu     sync.Mutex
+    statusActive bool
+    statusHeight int
+    statusStop   chan struct{}
+
+    // lastNotice is shown on the bar for noticeTime after it was given
+    lastNotice string
+    noticeAt   time.Time
+)
+
+const noticeTime = 5 * time.Second
+
+// startStatusBar reserves the bottom line of the terminal and redraws it every second
+func startStatusBar() {
+    width, height, err := term.GetSize(int(os.Stdout.Fd()))
+    if err != nil || height < 3 || width < 20 {
+        logger.Printf("Status bar disabled: terminal too small or not a terminal")
+        return
+    }
+    statusMu.Lock()
+    statusActive, statusHeight = true, height
+    statusStop = make(chan struct{})
+    setScrollRegion(height)
+    statusMu.Unlock()
+    drawStatusBar()
+
+    stop := statusStop
+    go func() {
+        ticker := time.NewTicker(time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                drawStatusBar()
+            }
+        }
+    }()
+}
+
+// stopStatusBar gives the whole terminal back to the scrolling output
+func stopStatusBar() {
+    statusMu.Lock()
+    defer statusMu.Unlock()
+    if !statusActive {
+        return
+    }
+    statusActive = false
+    close(statusStop)
+    fmt.Printf("\x1b7\x1b[r\x1b[%d;1H\x1b[2K\x1b8", statusHeight)
+}
+
+// setScrollRegion limits scrolling to all but the last of height lines and moves the
+// cursor into the region. Caller holds statusMu.
+func setScrollRegion(height int) {
+    fmt.Printf("\x1b[1;%dr\x1b[%d;1H", height-1, height-1)
+}
+
+// writeOutput passes pianobar's output to the terminal without it tearing into the
+// status bar. In quiet and accessible mode it's left to announceOutput.
+func writeOutput(output string) {
+    if quiet || accessible {
+        return
+    }
+    statusMu.Lock()
+    io.WriteString(os.Stdout, output)
+    statusMu.Unlock()
+}
+
+// notice shows a routine message: on the status bar when it is shown, otherwise
+// as a line of its own in the theme's style. In quiet mode it is only logged, in
+// accessible mode printed plain.
+func notice(kind messageKind, format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+    if quiet {
+        logger.Printf("%s", msg)
+        return
+    }
+    if accessible {
+        plainLine(msg)
+        return
+    }
+    statusMu.Lock()
+    active := statusActive
+    if active {
+        lastNotice, noticeAt = msg, time.Now()
+    }
+    statusMu.Unlock()
+    if !active {
+        fmt.Printf("\r\n%s\n", theme.styled(kind, msg))
+        return
+    }
+    logger.Printf("%s", msg)
+    // Callers may hold mu, which drawing needs
+    go drawStatusBar()
+}
+
+// statusLine formats the status bar for status and a recent notice, fitted to width
+func statusLine(status playerStatus, size int64, notice string, width int) string {
+    state := "not recording"
+    if status.Recording {
+        state = "REC"
+        if size > 0 {
+            state += fmt.Sprintf(" %.1f MB", float64(size)/(1<<20))
+        }
+    } else if status.Paused {
+        state = "recording off"
+    } else if status.State == "paused" {
+        state = "paused"
+    }
+    parts := []string{state}
+    if status.Station != "" {
+        parts = append(parts, status.Station)
+    }
+    if status.Title != "" {
+        parts = append(parts, fmt.Sprintf("%s - %s", status.Title, status.Artist))
+    }
+    if status.Total > 0 {
+        elapsed := status.Total - status.Remaining
+        parts = append(parts, fmt.Sprintf("%d:%02d/%d:%02d", elapsed/60, elapsed%60, status.Total/60, status.Total%60))
+    }
+    if notice != "" {
+        parts = append(parts, notice)
+    }
+    line := []rune(strings.Join(parts, " | "))
+    if len(line) > width {
+        line = append(line[:width-1], '…')
+    }
+    return string(line)
+}
+
+// drawStatusBar redraws the bottom line, adapting to a resized terminal
+func drawStatusBar() {
+    status := currentStatus()
+    mu.Lock()
+    fileName := currentFileName
+    mu.Unlock()
+    var size int64
+    if status.Recording {
+        if info, err := os.Stat(tempName(fileName)); err == nil {
+            size = info.Size()
+        }
+    }
+
+    statusMu.Lock()
+    defer statusMu.Unlock()
+    if !statusActive {
+        return
+    }
+    width, height, err := term.GetSize(int(os.Stdout.Fd()))
+    if err != nil || height < 3 || width < 20 {
+        return
+    }
+    if height != statusHeight {
+        fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K\x1b8", statusHeight)
+        statusHeight = height
+        setScrollRegion(height)
+    }
+    notice := ""
+    if time.Since(noticeAt) < noticeTime {
+        notice = lastNotice
+    }
+    // Save the cursor, draw in reverse video on the last line and restore it
+    fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K\x1b[7m%s\x1b[0m\x1b8", height, statusLine(status, size, notice, width))
+}
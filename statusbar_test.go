@@ -0,0 +1,21 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestStatusLine(t *testing.T) {
+    status := playerStatus{State: "playing", Station: "Jazz Radio", Title: "So What", Artist: "Miles Davis", Recording: true, Remaining: 100, Total: 545}
+    got := statusLine(status, 3<<20, "", 80)
+    if want := "REC 3.0 MB | Jazz Radio | So What - Miles Davis | 7:25/9:05"; got != want {
+        t.Errorf("statusLine = %q, want %q", got, want)
+    }
+    if got := statusLine(status, 0, "", 20); len([]rune(got)) != 20 || !strings.HasSuffix(got, "…") {
+        t.Errorf("statusLine not fitted to 20 columns: %q", got)
+    }
+    status.Recording, status.State = false, "paused"
+    if got := statusLine(status, 0, "Switched to station: Jazz Radio", 200); !strings.HasPrefix(got, "paused |") || !strings.HasSuffix(got, "| Switched to station: Jazz Radio") {
+        t.Errorf("statusLine = %q", got)
+    }
+}
@@ -0,0 +1,1123 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "unicode/utf16"
+)
+
+// Tags is the metadata written into a finished recording
+type Tags struct {
+    Title       string
+    Artists     []string
+    Album       string
+    Year        string
+    Genre       string
+    Comment     string
+    Loved       bool
+    Custom      map[string]string
+    Picture     []byte
+    PictureMIME string
+}
+
+// tagWriter writes a complete tag set into a file of one container format,
+// replacing whatever tags the encoder left behind
+type tagWriter interface {
+    WriteTags(fileName string, tags Tags) error
+}
+
+type id3Writer struct{}
+type flacWriter struct{}
+type oggWriter struct{}
+type mp4Writer struct{}
+type ffmpegTagWriter struct {
+    format string
+}
+
+// tagWriterFor picks the writer for a file based on its extension
+func tagWriterFor(fileName string) tagWriter {
+    switch strings.ToLower(filepath.Ext(fileName)) {
+    case ".mp3", ".aac":
+        // Players read an ID3v2 tag in front of ADTS frames too, and remuxing them
+        // into MP4 would leave an .aac file that isn't one
+        return id3Writer{}
+    case ".flac":
+        return flacWriter{}
+    case ".m4a", ".mp4":
+        return mp4Writer{}
+    case ".ogg", ".oga", ".opus":
+        return oggWriter{}
+    }
+    return ffmpegTagWriter{format: strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")}
+}
+
+// writeTags writes tags into fileName with the writer for its format
+func writeTags(fileName string, tags Tags) error {
+    return tagWriterFor(fileName).WriteTags(fileName, tags)
+}
+
+// readTags reads the text tags of an MP3, AAC, FLAC, Ogg or MP4 file
+func readTags(fileName string) (Tags, error) {
+    switch strings.ToLower(filepath.Ext(fileName)) {
+    case ".mp3", ".aac":
+        return id3Writer{}.ReadTags(fileName)
+    case ".flac":
+        return flacWriter{}.ReadTags(fileName)
+    case ".ogg", ".oga", ".opus":
+        return oggWriter{}.ReadTags(fileName)
+    case ".m4a", ".mp4":
+        return mp4Writer{}.ReadTags(fileName)
+    }
+    return Tags{}, fmt.Errorf("reading tags from %s files is not supported", filepath.Ext(fileName))
+}
+
+// customKeys returns the custom field names in a stable order
+func (t Tags) customKeys() []string {
+    keys := make([]string, 0, len(t.Custom))
+    for key := range t.Custom {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// replaceFile writes a new version of fileName through a temporary file: write gets
+// the temporary file, and the original is only replaced if write succeeds
+func replaceFile(fileName string, write func(out *os.File) error) error {
+    tmpFile := fileName + ".tags.tmp"
+    out, err := os.Create(tmpFile)
+    if err != nil {
+        return fmt.Errorf("failed to create %s: %v", tmpFile, err)
+    }
+    if err := write(out); err != nil {
+        out.Close()
+        os.Remove(tmpFile)
+        return err
+    }
+    if err := out.Close(); err != nil {
+        os.Remove(tmpFile)
+        return fmt.Errorf("failed to write %s: %v", tmpFile, err)
+    }
+    if err := os.Rename(tmpFile, fileName); err != nil {
+        os.Remove(tmpFile)
+        return fmt.Errorf("failed to replace %s: %v", fileName, err)
+    }
+    return nil
+}
+
+// Loved songs are rated as five stars: POPM 255 in ID3 and RATING=5 in Vorbis comments
+const (
+    popmEmail   = "pianotrap"
+    popmLoved   = 255
+    vorbisLoved = "5"
+)
+
+// ID3v2.4
+
+func syncsafe(n int) []byte {
+    return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+func unsyncsafe(b []byte) int {
+    return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Frame encodes one ID3v2.4 frame
+func id3Frame(id string, body []byte) []byte {
+    frame := append([]byte(id), syncsafe(len(body))...)
+    frame = append(frame, 0, 0)
+    return append(frame, body...)
+}
+
+// id3TextFrame encodes a UTF-8 text frame; multiple values are NUL separated as v2.4 allows
+func id3TextFrame(id string, values ...string) []byte {
+    body := []byte{3}
+    body = append(body, strings.Join(values, "\x00")...)
+    return id3Frame(id, body)
+}
+
+// buildID3 renders a complete ID3v2.4 tag
+func buildID3(tags Tags) []byte {
+    var frames []byte
+    if tags.Title != "" {
+        frames = append(frames, id3TextFrame("TIT2", tags.Title)...)
+    }
+    if len(tags.Artists) > 0 {
+        frames = append(frames, id3TextFrame("TPE1", tags.Artists...)...)
+    }
+    if tags.Album != "" {
+        frames = append(frames, id3TextFrame("TALB", tags.Album)...)
+    }
+    if tags.Year != "" {
+        frames = append(frames, id3TextFrame("TDRC", tags.Year)...)
+    }
+    if tags.Genre != "" {
+        frames = append(frames, id3TextFrame("TCON", tags.Genre)...)
+    }
+    if tags.Comment != "" {
+        body := append([]byte{3}, "eng"...)
+        body = append(body, 0)
+        body = append(body, tags.Comment...)
+        frames = append(frames, id3Frame("COMM", body)...)
+    }
+    for _, key := range tags.customKeys() {
+        body := append([]byte{3}, key...)
+        body = append(body, 0)
+        body = append(body, tags.Custom[key]...)
+        frames = append(frames, id3Frame("TXXX", body)...)
+    }
+    if tags.Loved {
+        body := append([]byte(popmEmail), 0, popmLoved)
+        frames = append(frames, id3Frame("POPM", body)...)
+    }
+    if len(tags.Picture) > 0 {
+        body := append([]byte{3}, tags.PictureMIME...)
+        body = append(body, 0, 3, 0) // front cover, empty description
+        body = append(body, tags.Picture...)
+        frames = append(frames, id3Frame("APIC", body)...)
+    }
+    header := append([]byte("ID3"), 4, 0, 0)
+    header = append(header, syncsafe(len(frames))...)
+    return append(header, frames...)
+}
+
+// id3Size returns the length of the ID3v2 tag at the start of header, or 0 if there is none
+func id3Size(header []byte) int {
+    if len(header) < 10 || string(header[:3]) != "ID3" {
+        return 0
+    }
+    size := 10 + unsyncsafe(header[6:10])
+    if header[5]&0x10 != 0 {
+        size += 10 // footer
+    }
+    return size
+}
+
+func (id3Writer) WriteTags(fileName string, tags Tags) error {
+    in, err := os.Open(fileName)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %v", fileName, err)
+    }
+    defer in.Close()
+    header := make([]byte, 10)
+    n, _ := io.ReadFull(in, header)
+    skip := id3Size(header[:n])
+    if _, err := in.Seek(int64(skip), io.SeekStart); err != nil {
+        return fmt.Errorf("failed to read %s: %v", fileName, err)
+    }
+    return replaceFile(fileName, func(out *os.File) error {
+        if _, err := out.Write(buildID3(tags)); err != nil {
+            return fmt.Errorf("failed to write tags: %v", err)
+        }
+        if _, err := io.Copy(out, in); err != nil {
+            return fmt.Errorf("failed to copy audio: %v", err)
+        }
+        return nil
+    })
+}
+
+// decodeID3Text decodes a text frame body according to its encoding byte
+func decodeID3Text(body []byte) []string {
+    if len(body) == 0 {
+        return nil
+    }
+    enc, data := body[0], body[1:]
+    var text string
+    switch enc {
+    case 1, 2:
+        order := binary.ByteOrder(binary.BigEndian)
+        if enc == 1 && len(data) >= 2 {
+            if data[0] == 0xff && data[1] == 0xfe {
+                order = binary.LittleEndian
+            }
+            if (data[0] == 0xff && data[1] == 0xfe) || (data[0] == 0xfe && data[1] == 0xff) {
+                data = data[2:]
+            }
+        }
+        units := make([]uint16, len(data)/2)
+        for i := range units {
+            units[i] = order.Uint16(data[2*i:])
+        }
+        text = string(utf16.Decode(units))
+    case 3:
+        text = string(data)
+    default:
+        runes := make([]rune, len(data))
+        for i, b := range data {
+            runes[i] = rune(b)
+        }
+        text = string(runes)
+    }
+    return strings.Split(strings.TrimRight(text, "\x00"), "\x00")
+}
+
+func (id3Writer) ReadTags(fileName string) (Tags, error) {
+    var tags Tags
+    f, err := os.Open(fileName)
+    if err != nil {
+        return tags, err
+    }
+    defer f.Close()
+    header := make([]byte, 10)
+    if _, err := io.ReadFull(f, header); err != nil || id3Size(header) == 0 {
+        return tags, fmt.Errorf("%s has no ID3v2 tag", fileName)
+    }
+    version := header[3]
+    data := make([]byte, unsyncsafe(header[6:10]))
+    if _, err := io.ReadFull(f, data); err != nil {
+        return tags, fmt.Errorf("truncated ID3v2 tag in %s", fileName)
+    }
+    for len(data) >= 10 && data[0] != 0 {
+        id := string(data[:4])
+        size := int(binary.BigEndian.Uint32(data[4:8]))
+        if version >= 4 {
+            size = unsyncsafe(data[4:8])
+        }
+        if size < 0 || 10+size > len(data) {
+            break
+        }
+        body := data[10 : 10+size]
+        data = data[10+size:]
+        switch id {
+        case "TIT2":
+            tags.Title = strings.Join(decodeID3Text(body), " ")
+        case "TPE1":
+            tags.Artists = decodeID3Text(body)
+        case "TALB":
+            tags.Album = strings.Join(decodeID3Text(body), " ")
+        case "TDRC", "TYER":
+            tags.Year = strings.Join(decodeID3Text(body), " ")
+        case "TCON":
+            tags.Genre = strings.Join(decodeID3Text(body), " ")
+        case "TXXX":
+            if values := decodeID3Text(body); len(values) == 2 {
+                if tags.Custom == nil {
+                    tags.Custom = make(map[string]string)
+                }
+                tags.Custom[values[0]] = values[1]
+            }
+        case "POPM":
+            if i := bytes.IndexByte(body, 0); i >= 0 && i+1 < len(body) {
+                tags.Loved = body[i+1] >= 196 // four stars or more
+            }
+        case "COMM":
+            if len(body) > 4 {
+                values := decodeID3Text(append([]byte{body[0]}, body[4:]...))
+                tags.Comment = values[len(values)-1]
+            }
+        }
+    }
+    return tags, nil
+}
+
+// FLAC
+
+const (
+    flacStreamInfo    = 0
+    flacPadding       = 1
+    flacVorbisComment = 4
+    flacPicture       = 6
+)
+
+// vorbisComments renders the comment list shared by FLAC and Ogg containers
+func vorbisComments(tags Tags) []string {
+    var comments []string
+    add := func(key, value string) {
+        if value != "" {
+            comments = append(comments, key+"="+value)
+        }
+    }
+    add("TITLE", tags.Title)
+    for _, artist := range tags.Artists {
+        add("ARTIST", artist)
+    }
+    add("ALBUM", tags.Album)
+    add("DATE", tags.Year)
+    add("GENRE", tags.Genre)
+    add("COMMENT", tags.Comment)
+    if tags.Loved {
+        add("RATING", vorbisLoved)
+    }
+    for _, key := range tags.customKeys() {
+        add(strings.ToUpper(key), tags.Custom[key])
+    }
+    return comments
+}
+
+// vorbisCommentBlock renders the comment header of FLAC and Ogg files, without the
+// framing Ogg Vorbis adds
+func vorbisCommentBlock(comments []string) []byte {
+    var b bytes.Buffer
+    vendor := "pianotrap"
+    binary.Write(&b, binary.LittleEndian, uint32(len(vendor)))
+    b.WriteString(vendor)
+    binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+    for _, c := range comments {
+        binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+        b.WriteString(c)
+    }
+    return b.Bytes()
+}
+
+// flacPictureBlock renders the cover art as a FLAC picture block, which Ogg files
+// carry base64 encoded in a comment
+func flacPictureBlock(tags Tags) []byte {
+    var pic bytes.Buffer
+    binary.Write(&pic, binary.BigEndian, uint32(3)) // front cover
+    binary.Write(&pic, binary.BigEndian, uint32(len(tags.PictureMIME)))
+    pic.WriteString(tags.PictureMIME)
+    binary.Write(&pic, binary.BigEndian, uint32(0)) // description
+    binary.Write(&pic, binary.BigEndian, [4]uint32{}) // width, height, depth, colors unknown
+    binary.Write(&pic, binary.BigEndian, uint32(len(tags.Picture)))
+    pic.Write(tags.Picture)
+    return pic.Bytes()
+}
+
+func flacBlock(blockType byte, last bool, body []byte) []byte {
+    header := blockType
+    if last {
+        header |= 0x80
+    }
+    n := len(body)
+    return append([]byte{header, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+func (flacWriter) WriteTags(fileName string, tags Tags) error {
+    in, err := os.Open(fileName)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %v", fileName, err)
+    }
+    defer in.Close()
+    magic := make([]byte, 4)
+    if _, err := io.ReadFull(in, magic); err != nil || string(magic) != "fLaC" {
+        return fmt.Errorf("%s is not a FLAC file", fileName)
+    }
+
+    // Keep stream info and any other blocks, dropping the old comments, pictures and padding
+    type block struct {
+        kind byte
+        body []byte
+    }
+    var kept []block
+    for {
+        header := make([]byte, 4)
+        if _, err := io.ReadFull(in, header); err != nil {
+            return fmt.Errorf("truncated FLAC metadata in %s", fileName)
+        }
+        body := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+        if _, err := io.ReadFull(in, body); err != nil {
+            return fmt.Errorf("truncated FLAC metadata in %s", fileName)
+        }
+        kind := header[0] & 0x7f
+        if kind != flacVorbisComment && kind != flacPicture && kind != flacPadding {
+            kept = append(kept, block{kind, body})
+        }
+        if header[0]&0x80 != 0 {
+            break
+        }
+    }
+    if len(kept) == 0 || kept[0].kind != flacStreamInfo {
+        return fmt.Errorf("%s has no FLAC stream info", fileName)
+    }
+
+    kept = append(kept, block{flacVorbisComment, vorbisCommentBlock(vorbisComments(tags))})
+    if len(tags.Picture) > 0 {
+        kept = append(kept, block{flacPicture, flacPictureBlock(tags)})
+    }
+
+    return replaceFile(fileName, func(out *os.File) error {
+        data := []byte("fLaC")
+        for i, b := range kept {
+            data = append(data, flacBlock(b.kind, i == len(kept)-1, b.body)...)
+        }
+        if _, err := out.Write(data); err != nil {
+            return fmt.Errorf("failed to write tags: %v", err)
+        }
+        if _, err := io.Copy(out, in); err != nil {
+            return fmt.Errorf("failed to copy audio: %v", err)
+        }
+        return nil
+    })
+}
+
+func (flacWriter) ReadTags(fileName string) (Tags, error) {
+    var tags Tags
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return tags, err
+    }
+    if len(data) < 4 || string(data[:4]) != "fLaC" {
+        return tags, fmt.Errorf("%s is not a FLAC file", fileName)
+    }
+    data = data[4:]
+    for len(data) >= 4 {
+        last := data[0]&0x80 != 0
+        kind := data[0] & 0x7f
+        size := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+        if 4+size > len(data) {
+            break
+        }
+        body := data[4 : 4+size]
+        data = data[4+size:]
+        if kind == flacVorbisComment {
+            parseVorbisComments(body, &tags)
+        }
+        if last {
+            break
+        }
+    }
+    return tags, nil
+}
+
+// parseVorbisComments fills tags from a Vorbis comment block
+func parseVorbisComments(body []byte, tags *Tags) {
+    next := func() ([]byte, bool) {
+        if len(body) < 4 {
+            return nil, false
+        }
+        n := int(binary.LittleEndian.Uint32(body))
+        if n < 0 || 4+n > len(body) {
+            return nil, false
+        }
+        field := body[4 : 4+n]
+        body = body[4+n:]
+        return field, true
+    }
+    if _, ok := next(); !ok { // vendor
+        return
+    }
+    if len(body) < 4 {
+        return
+    }
+    count := int(binary.LittleEndian.Uint32(body))
+    body = body[4:]
+    for i := 0; i < count; i++ {
+        field, ok := next()
+        if !ok {
+            return
+        }
+        parts := strings.SplitN(string(field), "=", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        switch key, value := strings.ToUpper(parts[0]), parts[1]; key {
+        case "TITLE":
+            tags.Title = value
+        case "ARTIST":
+            tags.Artists = append(tags.Artists, value)
+        case "ALBUM":
+            tags.Album = value
+        case "DATE":
+            tags.Year = value
+        case "GENRE":
+            tags.Genre = value
+        case "COMMENT":
+            tags.Comment = value
+        case "RATING":
+            tags.Loved = value == vorbisLoved
+        case oggPictureKey:
+            // Pictures aren't read back, as for the other formats
+        default:
+            if tags.Custom == nil {
+                tags.Custom = make(map[string]string)
+            }
+            tags.Custom[key] = value
+        }
+    }
+}
+
+// Ogg
+
+// oggPictureKey is the comment holding the cover art of an Ogg file
+const oggPictureKey = "METADATA_BLOCK_PICTURE"
+
+// oggCodec is what tells the header packets of a codec in Ogg apart
+type oggCodec struct {
+    ident   string // how the first packet starts
+    comment string // how the comment packet starts
+    headers int    // how many header packets come before the audio
+    framing bool   // whether the comment packet ends with a framing bit
+}
+
+var oggCodecs = []oggCodec{
+    {"\x01vorbis", "\x03vorbis", 3, true},
+    {"OpusHead", "OpusTags", 2, false},
+}
+
+// oggPage is one page of an Ogg stream
+type oggPage struct {
+    flags    byte
+    granule  uint64
+    serial   uint32
+    sequence uint32
+    segments []byte
+    body     []byte
+}
+
+// oggContinued marks a page that begins with the rest of the previous page's packet
+const oggContinued = 0x01
+
+// oggCRCTable is for the CRC-32 of Ogg pages, which unlike hash/crc32 is not
+// bit-reflected
+var oggCRCTable = func() (table [256]uint32) {
+    for i := range table {
+        r := uint32(i) << 24
+        for j := 0; j < 8; j++ {
+            if r&0x80000000 != 0 {
+                r = r<<1 ^ 0x04c11db7
+            } else {
+                r <<= 1
+            }
+        }
+        table[i] = r
+    }
+    return table
+}()
+
+func oggCRC(data []byte) uint32 {
+    var crc uint32
+    for _, b := range data {
+        crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+    }
+    return crc
+}
+
+// parseOggPages splits data into its pages, returning what follows the last whole
+// one, such as a page cut short
+func parseOggPages(data []byte) ([]oggPage, []byte) {
+    var pages []oggPage
+    for len(data) >= 27 && string(data[:4]) == "OggS" {
+        n := int(data[26])
+        if len(data) < 27+n {
+            break
+        }
+        size := 0
+        for _, s := range data[27 : 27+n] {
+            size += int(s)
+        }
+        if len(data) < 27+n+size {
+            break
+        }
+        pages = append(pages, oggPage{
+            flags:    data[5],
+            granule:  binary.LittleEndian.Uint64(data[6:]),
+            serial:   binary.LittleEndian.Uint32(data[14:]),
+            sequence: binary.LittleEndian.Uint32(data[18:]),
+            segments: data[27 : 27+n],
+            body:     data[27+n : 27+n+size],
+        })
+        data = data[27+n+size:]
+    }
+    return pages, data
+}
+
+// bytes encodes the page with its checksum
+func (p oggPage) bytes() []byte {
+    b := make([]byte, 27, 27+len(p.segments)+len(p.body))
+    copy(b, "OggS")
+    b[5] = p.flags
+    binary.LittleEndian.PutUint64(b[6:], p.granule)
+    binary.LittleEndian.PutUint32(b[14:], p.serial)
+    binary.LittleEndian.PutUint32(b[18:], p.sequence)
+    b[26] = byte(len(p.segments))
+    b = append(b, p.segments...)
+    b = append(b, p.body...)
+    binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+    return b
+}
+
+// oggHeaders reads the header packets of the stream starting on pages[0] and
+// returns them with their codec and the number of pages they take up. The audio
+// has to start on a page of its own, as both Vorbis and Opus require.
+func oggHeaders(pages []oggPage) ([][]byte, oggCodec, int, error) {
+    var packets [][]byte
+    var packet []byte
+    var codec oggCodec
+    for i, p := range pages {
+        if p.serial != pages[0].serial {
+            return nil, codec, 0, fmt.Errorf("Ogg files with more than one stream are not supported")
+        }
+        offset := 0
+        for j, s := range p.segments {
+            packet = append(packet, p.body[offset:offset+int(s)]...)
+            offset += int(s)
+            if s == 255 {
+                continue
+            }
+            packets = append(packets, packet)
+            packet = nil
+            if len(packets) == 1 {
+                found := false
+                for _, c := range oggCodecs {
+                    if strings.HasPrefix(string(packets[0]), c.ident) {
+                        codec, found = c, true
+                    }
+                }
+                if !found {
+                    return nil, codec, 0, fmt.Errorf("unsupported codec in Ogg file")
+                }
+            }
+            if len(packets) == codec.headers {
+                if j != len(p.segments)-1 {
+                    return nil, codec, 0, fmt.Errorf("audio shares a page with the Ogg headers")
+                }
+                return packets, codec, i + 1, nil
+            }
+        }
+    }
+    return nil, codec, 0, fmt.Errorf("truncated Ogg headers")
+}
+
+// oggPackPages lays packets out on pages of serial numbered from sequence, each
+// packet starting on a new page
+func oggPackPages(packets [][]byte, serial, sequence uint32) []oggPage {
+    var pages []oggPage
+    for _, packet := range packets {
+        page := oggPage{serial: serial, sequence: sequence, granule: ^uint64(0)}
+        for {
+            n := len(packet)
+            if n > 255 {
+                n = 255
+            }
+            page.segments = append(page.segments, byte(n))
+            page.body = append(page.body, packet[:n]...)
+            packet = packet[n:]
+            last := n < 255
+            if last {
+                // Header pages are before the first sample
+                page.granule = 0
+            }
+            if last || len(page.segments) == 255 {
+                pages = append(pages, page)
+                sequence++
+                page = oggPage{flags: oggContinued, serial: serial, sequence: sequence, granule: ^uint64(0)}
+            }
+            if last {
+                break
+            }
+        }
+    }
+    return pages
+}
+
+func (oggWriter) WriteTags(fileName string, tags Tags) error {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %v", fileName, err)
+    }
+    pages, rest := parseOggPages(data)
+    if len(pages) == 0 {
+        return fmt.Errorf("%s is not an Ogg file", fileName)
+    }
+    headers, codec, n, err := oggHeaders(pages)
+    if err != nil {
+        return fmt.Errorf("%s: %v", fileName, err)
+    }
+
+    comments := vorbisComments(tags)
+    if len(tags.Picture) > 0 {
+        comments = append(comments, oggPictureKey+"="+base64.StdEncoding.EncodeToString(flacPictureBlock(tags)))
+    }
+    comment := append([]byte(codec.comment), vorbisCommentBlock(comments)...)
+    if codec.framing {
+        comment = append(comment, 1)
+    }
+    headers[1] = comment
+
+    // The identification header keeps its page; the others go on new pages, and the
+    // audio pages after them are renumbered
+    newPages := append([]oggPage{pages[0]}, oggPackPages(headers[1:], pages[0].serial, pages[0].sequence+1)...)
+    shift := uint32(len(newPages) - n)
+    for _, p := range pages[n:] {
+        p.sequence += shift
+        newPages = append(newPages, p)
+    }
+    return replaceFile(fileName, func(out *os.File) error {
+        for _, p := range newPages {
+            if _, err := out.Write(p.bytes()); err != nil {
+                return fmt.Errorf("failed to write tags: %v", err)
+            }
+        }
+        if _, err := out.Write(rest); err != nil {
+            return fmt.Errorf("failed to copy audio: %v", err)
+        }
+        return nil
+    })
+}
+
+func (oggWriter) ReadTags(fileName string) (Tags, error) {
+    var tags Tags
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return tags, err
+    }
+    pages, _ := parseOggPages(data)
+    if len(pages) == 0 {
+        return tags, fmt.Errorf("%s is not an Ogg file", fileName)
+    }
+    headers, codec, _, err := oggHeaders(pages)
+    if err != nil {
+        return tags, fmt.Errorf("%s: %v", fileName, err)
+    }
+    if !strings.HasPrefix(string(headers[1]), codec.comment) {
+        return tags, fmt.Errorf("%s has no comment header", fileName)
+    }
+    parseVorbisComments(headers[1][len(codec.comment):], &tags)
+    return tags, nil
+}
+
+// MP4
+
+// mp4Atom is a box of an MP4 file: its type, its contents and all of it as read
+type mp4Atom struct {
+    kind string
+    body []byte
+    raw  []byte
+}
+
+// mp4Containers are the atoms on the way to the chunk offsets
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// MP4 data atom types
+const (
+    mp4Text = 1
+    mp4JPEG = 13
+    mp4PNG  = 14
+)
+
+// mp4Freeform is the mean of the iTunes-style atoms holding the rating and the
+// custom fields
+const mp4Freeform = "com.apple.iTunes"
+
+// parseMP4Atoms splits data into its atoms
+func parseMP4Atoms(data []byte) ([]mp4Atom, error) {
+    var atoms []mp4Atom
+    for len(data) > 0 {
+        if len(data) < 8 {
+            return nil, fmt.Errorf("truncated MP4 atom")
+        }
+        size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+        switch size {
+        case 0:
+            // The atom runs to the end of the file
+            size = uint64(len(data))
+        case 1:
+            if len(data) < 16 {
+                return nil, fmt.Errorf("truncated MP4 atom")
+            }
+            size, header = binary.BigEndian.Uint64(data[8:]), 16
+        }
+        if size < header || size > uint64(len(data)) {
+            return nil, fmt.Errorf("truncated MP4 atom %q", data[4:8])
+        }
+        atoms = append(atoms, mp4Atom{string(data[4:8]), data[header:size], data[:size]})
+        data = data[size:]
+    }
+    return atoms, nil
+}
+
+// mp4Box encodes an atom made of parts
+func mp4Box(kind string, parts ...[]byte) []byte {
+    size := 8
+    for _, p := range parts {
+        size += len(p)
+    }
+    b := make([]byte, 8, size)
+    binary.BigEndian.PutUint32(b, uint32(size))
+    copy(b[4:], kind)
+    for _, p := range parts {
+        b = append(b, p...)
+    }
+    return b
+}
+
+// mp4Data encodes the data atom of a tag
+func mp4Data(kind uint32, value []byte) []byte {
+    header := make([]byte, 8) // type, then locale
+    binary.BigEndian.PutUint32(header, kind)
+    return mp4Box("data", header, value)
+}
+
+// buildMP4Meta renders the meta atom holding tags as an iTunes-style item list
+func buildMP4Meta(tags Tags) []byte {
+    var items [][]byte
+    text := func(kind string, values ...string) {
+        var data [][]byte
+        for _, v := range values {
+            if v != "" {
+                data = append(data, mp4Data(mp4Text, []byte(v)))
+            }
+        }
+        if len(data) > 0 {
+            items = append(items, mp4Box(kind, data...))
+        }
+    }
+    freeform := func(name, value string) {
+        if value != "" {
+            items = append(items, mp4Box("----",
+                mp4Box("mean", make([]byte, 4), []byte(mp4Freeform)),
+                mp4Box("name", make([]byte, 4), []byte(name)),
+                mp4Data(mp4Text, []byte(value))))
+        }
+    }
+    text("\xa9nam", tags.Title)
+    text("\xa9ART", tags.Artists...)
+    text("\xa9alb", tags.Album)
+    text("\xa9day", tags.Year)
+    text("\xa9gen", tags.Genre)
+    text("\xa9cmt", tags.Comment)
+    if tags.Loved {
+        freeform("RATING", vorbisLoved)
+    }
+    for _, key := range tags.customKeys() {
+        freeform(key, tags.Custom[key])
+    }
+    if len(tags.Picture) > 0 {
+        kind := uint32(mp4JPEG)
+        if tags.PictureMIME == "image/png" {
+            kind = mp4PNG
+        }
+        items = append(items, mp4Box("covr", mp4Data(kind, tags.Picture)))
+    }
+    // The handler says the items are iTunes metadata
+    hdlr := mp4Box("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+    return mp4Box("meta", make([]byte, 4), hdlr, mp4Box("ilst", items...))
+}
+
+// mp4ShiftOffsets moves the chunk offsets in atoms that point past from by delta,
+// for the audio moved by a bigger or smaller moov atom
+func mp4ShiftOffsets(atoms []mp4Atom, from uint64, delta int64) error {
+    for _, a := range atoms {
+        switch {
+        case mp4Containers[a.kind]:
+            children, err := parseMP4Atoms(a.body)
+            if err != nil {
+                return err
+            }
+            if err := mp4ShiftOffsets(children, from, delta); err != nil {
+                return err
+            }
+        case a.kind == "stco" || a.kind == "co64":
+            width := 4
+            if a.kind == "co64" {
+                width = 8
+            }
+            if len(a.body) < 8 {
+                return fmt.Errorf("truncated %s atom", a.kind)
+            }
+            count := int(binary.BigEndian.Uint32(a.body[4:]))
+            if 8+count*width > len(a.body) {
+                return fmt.Errorf("truncated %s atom", a.kind)
+            }
+            for i := 0; i < count; i++ {
+                entry := a.body[8+i*width:]
+                if width == 4 {
+                    offset := uint64(binary.BigEndian.Uint32(entry))
+                    if offset > from {
+                        offset = uint64(int64(offset) + delta)
+                        if offset > 0xffffffff {
+                            return fmt.Errorf("chunk offset out of range")
+                        }
+                        binary.BigEndian.PutUint32(entry, uint32(offset))
+                    }
+                } else if offset := binary.BigEndian.Uint64(entry); offset > from {
+                    binary.BigEndian.PutUint64(entry, uint64(int64(offset)+delta))
+                }
+            }
+        }
+    }
+    return nil
+}
+
+func (mp4Writer) WriteTags(fileName string, tags Tags) error {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %v", fileName, err)
+    }
+    atoms, err := parseMP4Atoms(data)
+    if err != nil {
+        return fmt.Errorf("%s: %v", fileName, err)
+    }
+    var moov *mp4Atom
+    var moovAt uint64
+    offset := uint64(0)
+    for i := range atoms {
+        if atoms[i].kind == "moov" {
+            moov, moovAt = &atoms[i], offset
+        }
+        offset += uint64(len(atoms[i].raw))
+    }
+    if moov == nil {
+        return fmt.Errorf("%s has no moov atom", fileName)
+    }
+
+    // Keep the moov atom but for the old tags, which go with the meta atom in udta
+    children, err := parseMP4Atoms(moov.body)
+    if err != nil {
+        return fmt.Errorf("%s: %v", fileName, err)
+    }
+    var parts, udta [][]byte
+    for _, c := range children {
+        if c.kind != "udta" {
+            parts = append(parts, c.raw)
+            continue
+        }
+        kept, err := parseMP4Atoms(c.body)
+        if err != nil {
+            return fmt.Errorf("%s: %v", fileName, err)
+        }
+        for _, k := range kept {
+            if k.kind != "meta" {
+                udta = append(udta, k.raw)
+            }
+        }
+    }
+    udta = append(udta, buildMP4Meta(tags))
+    parts = append(parts, mp4Box("udta", udta...))
+    newMoov := mp4Box("moov", parts...)
+
+    // Audio after the moov atom moves with its size
+    if delta := int64(len(newMoov)) - int64(len(moov.raw)); delta != 0 {
+        if err := mp4ShiftOffsets([]mp4Atom{{kind: "moov", body: newMoov[8:]}}, moovAt, delta); err != nil {
+            return fmt.Errorf("%s: %v", fileName, err)
+        }
+    }
+    return replaceFile(fileName, func(out *os.File) error {
+        for _, a := range atoms {
+            b := a.raw
+            if a.kind == "moov" {
+                b = newMoov
+            }
+            if _, err := out.Write(b); err != nil {
+                return fmt.Errorf("failed to write tags: %v", err)
+            }
+        }
+        return nil
+    })
+}
+
+func (mp4Writer) ReadTags(fileName string) (Tags, error) {
+    var tags Tags
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return tags, err
+    }
+    // find returns the first atom on path
+    find := func(data []byte, path ...string) ([]byte, bool) {
+        for _, kind := range path {
+            atoms, err := parseMP4Atoms(data)
+            if err != nil {
+                return nil, false
+            }
+            found := false
+            for _, a := range atoms {
+                if a.kind == kind {
+                    data, found = a.body, true
+                    break
+                }
+            }
+            if !found {
+                return nil, false
+            }
+            if kind == "meta" {
+                // meta has a version and flags before its atoms
+                if len(data) < 4 {
+                    return nil, false
+                }
+                data = data[4:]
+            }
+        }
+        return data, true
+    }
+    ilst, ok := find(data, "moov", "udta", "meta", "ilst")
+    if !ok {
+        return tags, fmt.Errorf("%s has no MP4 tags", fileName)
+    }
+    items, err := parseMP4Atoms(ilst)
+    if err != nil {
+        return tags, fmt.Errorf("%s: %v", fileName, err)
+    }
+    for _, item := range items {
+        children, err := parseMP4Atoms(item.body)
+        if err != nil {
+            continue
+        }
+        var values []string
+        name := ""
+        for _, c := range children {
+            switch {
+            case c.kind == "data" && len(c.body) >= 8:
+                values = append(values, string(c.body[8:]))
+            case c.kind == "name" && len(c.body) >= 4:
+                name = string(c.body[4:])
+            }
+        }
+        if len(values) == 0 {
+            continue
+        }
+        switch item.kind {
+        case "\xa9nam":
+            tags.Title = values[0]
+        case "\xa9ART":
+            tags.Artists = append(tags.Artists, values...)
+        case "\xa9alb":
+            tags.Album = values[0]
+        case "\xa9day":
+            tags.Year = values[0]
+        case "\xa9gen":
+            tags.Genre = values[0]
+        case "\xa9cmt":
+            tags.Comment = values[0]
+        case "----":
+            if name == "RATING" {
+                tags.Loved = values[0] == vorbisLoved
+            } else if name != "" {
+                if tags.Custom == nil {
+                    tags.Custom = make(map[string]string)
+                }
+                tags.Custom[name] = values[0]
+            }
+        }
+    }
+    return tags, nil
+}
+
+// Other containers, such as WAV, are rewritten by ffmpeg with a stream copy
+
+func (w ffmpegTagWriter) WriteTags(fileName string, tags Tags) error {
+    args := []string{"-y", "-i", fileName, "-map", "0:a", "-c", "copy", "-map_metadata", "-1"}
+    pairs := [][2]string{
+        {"title", tags.Title},
+        {"artist", strings.Join(tags.Artists, "; ")},
+        {"album", tags.Album},
+        {"date", tags.Year},
+        {"genre", tags.Genre},
+        {"comment", tags.Comment},
+    }
+    if tags.Loved {
+        pairs = append(pairs, [2]string{"rating", vorbisLoved})
+    }
+    for _, key := range tags.customKeys() {
+        pairs = append(pairs, [2]string{key, tags.Custom[key]})
+    }
+    args = append(args, metadataArgs(pairs)...)
+    args = append(args, "-f", w.format)
+    return replaceFile(fileName, func(out *os.File) error {
+        cmd := exec.Command(ffmpegBinary(), append(args, out.Name())...)
+        cmd.Stdout = logFile
+        cmd.Stderr = logFile
+        if err := cmd.Run(); err != nil {
+            return fmt.Errorf("ffmpeg failed to write tags: %v", err)
+        }
+        return nil
+    })
+}
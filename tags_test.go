@@ -0,0 +1,245 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+var testTags = Tags{
+    Title:       `The "Real" Song`,
+    Artists:     []string{"First Artist", "Second Artist"},
+    Album:       "Album ünïcode",
+    Year:        "2024",
+    Genre:       "Jazz",
+    Loved:       true,
+    Custom:      map[string]string{"STATION": "Jazz Radio"},
+    Picture:     []byte{0xff, 0xd8, 0xff, 0xe0, 1, 2, 3},
+    PictureMIME: "image/jpeg",
+}
+
+func TestID3RoundTrip(t *testing.T) {
+    // ADTS streams take an ID3v2 tag in front just like MP3s
+    for name, audio := range map[string][]byte{
+        "song.mp3": {0xff, 0xfb, 0x90, 0x64, 1, 2, 3, 4, 5},
+        "song.aac": {0xff, 0xf1, 0x50, 0x80, 1, 2, 3, 4, 5},
+    } {
+        // Start with an encoder-written tag that must be replaced, not kept
+        old := buildID3(Tags{Title: "old title"})
+        fileName := filepath.Join(t.TempDir(), name)
+        if err := ioutil.WriteFile(fileName, append(old, audio...), 0644); err != nil {
+            t.Fatal(err)
+        }
+
+        if err := writeTags(fileName, testTags); err != nil {
+            t.Fatalf("%s: writeTags: %v", name, err)
+        }
+        data, err := ioutil.ReadFile(fileName)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.HasSuffix(data, audio) || len(data) != id3Size(data)+len(audio) {
+            t.Fatalf("%s: audio data not preserved after the new tag", name)
+        }
+        if !bytes.Contains(data, testTags.Picture) {
+            t.Errorf("%s: cover art not embedded", name)
+        }
+
+        got, err := readTags(fileName)
+        if err != nil {
+            t.Fatalf("%s: readTags: %v", name, err)
+        }
+        want := testTags
+        want.Picture, want.PictureMIME = nil, ""
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: readTags = %+v; want %+v", name, got, want)
+        }
+    }
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+    streamInfo := flacBlock(flacStreamInfo, false, make([]byte, 34))
+    oldComment := flacBlock(flacVorbisComment, false, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+    padding := flacBlock(flacPadding, true, make([]byte, 16))
+    audio := []byte{0xff, 0xf8, 1, 2, 3}
+    data := append([]byte("fLaC"), streamInfo...)
+    data = append(data, oldComment...)
+    data = append(data, padding...)
+    data = append(data, audio...)
+    fileName := filepath.Join(t.TempDir(), "song.flac")
+    if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := writeTags(fileName, testTags); err != nil {
+        t.Fatalf("writeTags: %v", err)
+    }
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.HasSuffix(data, audio) {
+        t.Fatalf("audio frames not preserved")
+    }
+    if !bytes.Contains(data, testTags.Picture) {
+        t.Errorf("picture block not written")
+    }
+
+    got, err := readTags(fileName)
+    if err != nil {
+        t.Fatalf("readTags: %v", err)
+    }
+    want := testTags
+    want.Picture, want.PictureMIME = nil, ""
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("readTags = %+v; want %+v", got, want)
+    }
+}
+
+// oggStream lays out header packets and one page of audio the way an encoder does
+func oggStream(headers ...[]byte) ([]byte, oggPage) {
+    pages := oggPackPages(headers[:1], 7, 0)
+    pages[0].flags = 0x02 // beginning of the stream
+    pages = append(pages, oggPackPages(headers[1:], 7, 1)...)
+    audio := oggPage{serial: 7, sequence: uint32(len(pages)), granule: 960, segments: []byte{5}, body: []byte{1, 2, 3, 4, 5}}
+    var data []byte
+    for _, p := range append(pages, audio) {
+        data = append(data, p.bytes()...)
+    }
+    return data, audio
+}
+
+func TestOggRoundTrip(t *testing.T) {
+    bigCover := testTags
+    bigCover.Picture = bytes.Repeat([]byte{0xff, 0xd8}, 50000)
+    setup := append([]byte("\x05vorbis"), bytes.Repeat([]byte{9}, 600)...)
+    for _, tt := range []struct {
+        name    string
+        headers [][]byte
+        tags    Tags
+    }{
+        {"song.opus", [][]byte{append([]byte("OpusHead"), make([]byte, 11)...), append([]byte("OpusTags"), vorbisCommentBlock([]string{"TITLE=old title"})...)}, testTags},
+        {"song.ogg", [][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...), append(append([]byte("\x03vorbis"), vorbisCommentBlock(nil)...), 1), setup}, bigCover},
+    } {
+        data, audio := oggStream(tt.headers...)
+        fileName := filepath.Join(t.TempDir(), tt.name)
+        if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := writeTags(fileName, tt.tags); err != nil {
+            t.Fatalf("%s: writeTags: %v", tt.name, err)
+        }
+        data, err := ioutil.ReadFile(fileName)
+        if err != nil {
+            t.Fatal(err)
+        }
+        pages, rest := parseOggPages(data)
+        var again []byte
+        for i, p := range pages {
+            if p.sequence != uint32(i) {
+                t.Errorf("%s: page %d numbered %d", tt.name, i, p.sequence)
+            }
+            again = append(again, p.bytes()...)
+        }
+        if len(rest) != 0 || !bytes.Equal(again, data) {
+            t.Errorf("%s: pages don't check out", tt.name)
+        }
+        last := pages[len(pages)-1]
+        if !bytes.Equal(last.body, audio.body) || last.granule != audio.granule {
+            t.Errorf("%s: audio page not preserved", tt.name)
+        }
+        headers, _, _, err := oggHeaders(pages)
+        if err != nil || len(headers) != len(tt.headers) {
+            t.Fatalf("%s: headers = %d, %v", tt.name, len(headers), err)
+        }
+        for i := range headers {
+            if i != 1 && !bytes.Equal(headers[i], tt.headers[i]) {
+                t.Errorf("%s: header %d not preserved", tt.name, i)
+            }
+        }
+        if !bytes.Contains(headers[1], []byte(oggPictureKey+"=")) {
+            t.Errorf("%s: cover art not embedded", tt.name)
+        }
+
+        got, err := readTags(fileName)
+        if err != nil {
+            t.Fatalf("%s: readTags: %v", tt.name, err)
+        }
+        want := tt.tags
+        want.Picture, want.PictureMIME = nil, ""
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: readTags = %+v; want %+v", tt.name, got, want)
+        }
+    }
+}
+
+// mp4File builds an MP4 file with old tags and one chunk of audio, its moov atom
+// before or after the audio
+func mp4File(moovFirst bool, audio []byte) []byte {
+    moov := func(offset uint32) []byte {
+        stco := make([]byte, 12)
+        binary.BigEndian.PutUint32(stco[4:], 1)
+        binary.BigEndian.PutUint32(stco[8:], offset)
+        oldTags := mp4Box("meta", make([]byte, 4), mp4Box("ilst", mp4Box("\xa9nam", mp4Data(mp4Text, []byte("old title")))))
+        return mp4Box("moov", mp4Box("mvhd", make([]byte, 100)),
+            mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stco", stco))))),
+            mp4Box("udta", oldTags))
+    }
+    ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+    mdat := mp4Box("mdat", audio)
+    if moovFirst {
+        offset := len(ftyp) + len(moov(0)) + 8
+        return bytes.Join([][]byte{ftyp, moov(uint32(offset)), mdat}, nil)
+    }
+    return bytes.Join([][]byte{ftyp, mdat, moov(uint32(len(ftyp) + 8))}, nil)
+}
+
+func TestMP4RoundTrip(t *testing.T) {
+    audio := []byte("audio frames")
+    for _, moovFirst := range []bool{true, false} {
+        fileName := filepath.Join(t.TempDir(), "song.m4a")
+        if err := ioutil.WriteFile(fileName, mp4File(moovFirst, audio), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := writeTags(fileName, testTags); err != nil {
+            t.Fatalf("moov first %v: writeTags: %v", moovFirst, err)
+        }
+        data, err := ioutil.ReadFile(fileName)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Contains(data, testTags.Picture) || bytes.Contains(data, []byte("old title")) {
+            t.Errorf("moov first %v: tags not replaced", moovFirst)
+        }
+        // The chunk offset still has to point at the audio
+        body := data
+        for _, kind := range []string{"moov", "trak", "mdia", "minf", "stbl", "stco"} {
+            atoms, _ := parseMP4Atoms(body)
+            body = nil
+            for _, a := range atoms {
+                if a.kind == kind {
+                    body = a.body
+                }
+            }
+        }
+        if len(body) < 12 {
+            t.Fatalf("moov first %v: no chunk offsets", moovFirst)
+        }
+        if offset := binary.BigEndian.Uint32(body[8:]); !bytes.HasPrefix(data[offset:], audio) {
+            t.Errorf("moov first %v: chunk offset %d misses the audio", moovFirst, offset)
+        }
+
+        got, err := readTags(fileName)
+        if err != nil {
+            t.Fatalf("moov first %v: readTags: %v", moovFirst, err)
+        }
+        want := testTags
+        want.Picture, want.PictureMIME = nil, ""
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("moov first %v: readTags = %+v; want %+v", moovFirst, got, want)
+        }
+    }
+}
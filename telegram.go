@@ -0,0 +1,248 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// With telegram_token set, pianotrap runs as a Telegram bot: it sends the songs
+// playing and recordings saved to a chat and takes commands from the users listed in
+// telegram_users, and from no one else. Talk to @BotFather for the token and to
+// @userinfobot for your user ID. The bot polls Telegram, so it needs no open port.
+
+// telegramAPI is where the Bot API lives
+var telegramAPI = "https://api.telegram.org"
+
+// telegramEvents are the events telegram_notify can list, by name
+var telegramEvents = map[string]string{
+    "song":    eventDetected,
+    "saved":   eventSaved,
+    "deleted": eventDeleted,
+    "errors":  eventFailed,
+}
+
+const telegramHelp = `/status - what is playing
+/skip - skip the song
+/pause - pause or resume
+/love - love the song
+/station <name> - switch stations
+/record - stop or resume recording`
+
+// loadTelegramConfig reads the telegram_* options
+func loadTelegramConfig(values map[string]string, cfg *Config) error {
+    cfg.TelegramToken = values["telegram_token"]
+    cfg.TelegramUsers = nil
+    for _, user := range configList(values["telegram_users"]) {
+        id, err := strconv.ParseInt(user, 10, 64)
+        if err != nil {
+            return fmt.Errorf("invalid user in telegram_users: %q (want a numeric user ID)", user)
+        }
+        cfg.TelegramUsers = append(cfg.TelegramUsers, id)
+    }
+    if cfg.TelegramToken != "" && len(cfg.TelegramUsers) == 0 {
+        return fmt.Errorf("telegram_token needs telegram_users, the user IDs allowed to control pianotrap")
+    }
+    cfg.TelegramChat = 0
+    if raw := values["telegram_chat"]; raw != "" {
+        chat, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil {
+            return fmt.Errorf("invalid value for telegram_chat: %q (want a numeric chat ID)", raw)
+        }
+        cfg.TelegramChat = chat
+    } else if len(cfg.TelegramUsers) > 0 {
+        // A user's private chat with the bot has the user's ID
+        cfg.TelegramChat = cfg.TelegramUsers[0]
+    }
+    cfg.TelegramNotify = make(map[string]bool)
+    notify := "song, saved"
+    if raw, ok := values["telegram_notify"]; ok {
+        notify = raw
+    }
+    for _, name := range configList(notify) {
+        event, ok := telegramEvents[name]
+        if !ok {
+            return fmt.Errorf("invalid event in telegram_notify: %q (use song, saved, deleted or errors)", name)
+        }
+        cfg.TelegramNotify[event] = true
+    }
+    return nil
+}
+
+// telegramCall calls Bot API method with params and decodes its result into result,
+// if given. Long polls take up to timeout.
+func telegramCall(token, method string, params url.Values, result interface{}, timeout time.Duration) error {
+    client := &http.Client{Timeout: timeout + 10*time.Second}
+    resp, err := client.PostForm(telegramAPI+"/bot"+token+"/"+method, params)
+    if err != nil {
+        // The error repeats the URL, and with it the token
+        if e, ok := err.(*url.Error); ok {
+            err = e.Err
+        }
+        return err
+    }
+    defer resp.Body.Close()
+    var reply struct {
+        OK          bool            `json:"ok"`
+        Description string          `json:"description"`
+        Result      json.RawMessage `json:"result"`
+    }
+    data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err := json.Unmarshal(data, &reply); err != nil {
+        return fmt.Errorf("%s: %v", resp.Status, err)
+    }
+    if !reply.OK {
+        return fmt.Errorf("%s: %s", method, reply.Description)
+    }
+    if result != nil {
+        return json.Unmarshal(reply.Result, result)
+    }
+    return nil
+}
+
+// sendTelegram sends text to chat
+func sendTelegram(token string, chat int64, text string) error {
+    params := url.Values{"chat_id": {strconv.FormatInt(chat, 10)}, "text": {text}}
+    return telegramCall(token, "sendMessage", params, nil, 0)
+}
+
+// telegramUpdate is a Bot API update, of which pianotrap only asks for messages
+type telegramUpdate struct {
+    ID      int64 `json:"update_id"`
+    Message *struct {
+        From struct {
+            ID int64 `json:"id"`
+        } `json:"from"`
+        Chat struct {
+            ID int64 `json:"id"`
+        } `json:"chat"`
+        Text string `json:"text"`
+    } `json:"message"`
+}
+
+// telegramStatus describes s for /status
+func telegramStatus(s playerStatus) string {
+    if s.Title == "" {
+        return "Nothing playing"
+    }
+    text := fmt.Sprintf("%s by %s", s.Title, s.Artist)
+    if s.Station != "" {
+        text += "\n" + s.Station
+    }
+    switch {
+    case s.Paused:
+        text += "\nRecording paused"
+    case s.Recording:
+        text += "\nRecording"
+    }
+    return text
+}
+
+// telegramCommand runs a command sent by an allowed user and returns the reply
+func telegramCommand(text string) string {
+    command, arg, _ := strings.Cut(strings.TrimSpace(text), " ")
+    // In groups commands come as /skip@mybot
+    command, _, _ = strings.Cut(command, "@")
+    arg = strings.TrimSpace(arg)
+    switch command {
+    case "/status":
+        return telegramStatus(currentStatus())
+    case "/skip", "/pause", "/love":
+        if err := sendKeys(mqttCommands[command[1:]]); err != nil {
+            return err.Error()
+        }
+        return "OK"
+    case "/station":
+        if arg == "" {
+            return "Usage: /station <name>"
+        }
+        if err := switchStation(arg); err != nil {
+            return err.Error()
+        }
+        return "Switching to " + arg
+    case "/record":
+        toggleCapture()
+        if captureIsPaused() {
+            return "Recording paused"
+        }
+        return "Recording resumes with the next song"
+    }
+    return telegramHelp
+}
+
+// telegramLoop polls for messages for the rest of the session, answering the allowed
+// users of the config in use
+func telegramLoop(token string) {
+    var offset int64
+    backoff := 5 * time.Second
+    for {
+        params := url.Values{
+            "offset":          {strconv.FormatInt(offset, 10)},
+            "timeout":         {"50"},
+            "allowed_updates": {`["message"]`},
+        }
+        var updates []telegramUpdate
+        if err := telegramCall(token, "getUpdates", params, &updates, 50*time.Second); err != nil {
+            logger.Printf("Telegram: %v, retrying in %v", err, backoff)
+            time.Sleep(backoff)
+            backoff = min(backoff*2, 5*time.Minute)
+            continue
+        }
+        backoff = 5 * time.Second
+        for _, u := range updates {
+            offset = u.ID + 1
+            handleTelegramUpdate(token, u, currentConfig().TelegramUsers)
+        }
+    }
+}
+
+// handleTelegramUpdate answers a message from one of users, and ignores everyone else
+func handleTelegramUpdate(token string, u telegramUpdate, users []int64) {
+    if u.Message == nil || u.Message.Text == "" {
+        return
+    }
+    allowed := false
+    for _, user := range users {
+        allowed = allowed || user == u.Message.From.ID
+    }
+    if !allowed {
+        logger.Printf("Telegram: ignoring %q from user %d, who isn't in telegram_users", u.Message.Text, u.Message.From.ID)
+        return
+    }
+    logger.Printf("Telegram: command %q", u.Message.Text)
+    if err := sendTelegram(token, u.Message.Chat.ID, telegramCommand(u.Message.Text)); err != nil {
+        logger.Printf("Telegram: %v", err)
+    }
+}
+
+// startTelegram runs the bot if cfg has a token
+func startTelegram(cfg Config) {
+    if cfg.TelegramToken == "" {
+        return
+    }
+    go telegramLoop(cfg.TelegramToken)
+}
+
+// telegramEvent sends event name to the chat if the config in use asks for it
+func telegramEvent(name, msg string) {
+    cfg := currentConfig()
+    if cfg.TelegramToken == "" || !cfg.TelegramNotify[name] {
+        return
+    }
+    n, ok := notificationFor(name, msg, currentStatus())
+    if !ok {
+        return
+    }
+    text := n.Summary
+    if n.Body != "" {
+        text += "\n" + n.Body
+    }
+    if err := sendTelegram(cfg.TelegramToken, cfg.TelegramChat, text); err != nil {
+        logger.Printf("Telegram: %v", err)
+    }
+}
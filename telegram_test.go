@@ -0,0 +1,63 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "testing"
+)
+
+func TestTelegram(t *testing.T) {
+    var cfg Config
+    if err := loadTelegramConfig(map[string]string{"telegram_token": "123:abc", "telegram_users": "42, 7"}, &cfg); err != nil {
+        t.Fatal(err)
+    }
+    if cfg.TelegramChat != 42 || !cfg.TelegramNotify[eventDetected] || !cfg.TelegramNotify[eventSaved] || cfg.TelegramNotify[eventFailed] {
+        t.Errorf("cfg = %+v", cfg)
+    }
+    for _, bad := range []map[string]string{
+        {"telegram_token": "123:abc"},
+        {"telegram_users": "@me"},
+        {"telegram_users": "42", "telegram_chat": "mine"},
+        {"telegram_users": "42", "telegram_notify": "song, lyrics"},
+    } {
+        if err := loadTelegramConfig(bad, &Config{}); err == nil {
+            t.Errorf("%v loaded", bad)
+        }
+    }
+
+    if got := telegramStatus(playerStatus{Title: "So What", Artist: "Miles Davis", Station: "Jazz Radio", Recording: true}); got != "So What by Miles Davis\nJazz Radio\nRecording" {
+        t.Errorf("telegramStatus = %q", got)
+    }
+    if got := telegramCommand("/station@pianotrap_bot"); got != "Usage: /station <name>" {
+        t.Errorf("/station = %q", got)
+    }
+    if got := telegramCommand("/start"); got != telegramHelp {
+        t.Errorf("/start = %q", got)
+    }
+
+    var sent []url.Values
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/bot123:abc/sendMessage" {
+            t.Errorf("%s %s", r.Method, r.URL.Path)
+        }
+        r.ParseForm()
+        sent = append(sent, r.PostForm)
+        io.WriteString(w, `{"ok": true, "result": {"message_id": 1}}`)
+    }))
+    defer server.Close()
+    defer func(api string) { telegramAPI = api }(telegramAPI)
+    telegramAPI = server.URL
+
+    // Only the allowed users get answers
+    var u telegramUpdate
+    json.Unmarshal([]byte(`{"update_id": 5, "message": {"from": {"id": 13}, "chat": {"id": 13}, "text": "/skip"}}`), &u)
+    handleTelegramUpdate("123:abc", u, cfg.TelegramUsers)
+    json.Unmarshal([]byte(`{"update_id": 6, "message": {"from": {"id": 7}, "chat": {"id": -100}, "text": "/help"}}`), &u)
+    handleTelegramUpdate("123:abc", u, cfg.TelegramUsers)
+    if len(sent) != 1 || sent[0].Get("chat_id") != "-100" || sent[0].Get("text") != telegramHelp {
+        t.Errorf("sent %v", sent)
+    }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+
+    "golang.org/x/term"
+)
+
+// pianotrap's own messages are colored by kind and can carry a prefix, so they stand
+// out from pianobar's output. color_<kind> picks the color of each kind,
+// message_prefix the prefix, and color = never or -no-color turns colors off.
+
+type messageKind string
+
+const (
+    msgInfo   messageKind = "info"
+    msgStart  messageKind = "start"  // a recording started
+    msgStop   messageKind = "stop"   // a recording stopped
+    msgSkip   messageKind = "skip"   // a song isn't recorded
+    msgDelete messageKind = "delete" // a recording was discarded
+)
+
+// ansiColors are the color names accepted in the config file
+var ansiColors = map[string]string{
+    "none":    "",
+    "bold":    "1",
+    "black":   "30",
+    "red":     "31",
+    "green":   "32",
+    "yellow":  "33",
+    "blue":    "34",
+    "magenta": "35",
+    "cyan":    "36",
+    "white":   "37",
+    "gray":    "90",
+}
+
+// outputTheme is how pianotrap's messages look
+type outputTheme struct {
+    Color  bool
+    Prefix string
+    Colors map[messageKind]string // SGR parameters
+}
+
+// theme is the style used for messages; without a config it's plain text
+var theme outputTheme
+
+// defaultTheme colors messages when the terminal supports it
+func defaultTheme() outputTheme {
+    return outputTheme{
+        Color: term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("NO_COLOR") == "",
+        Colors: map[messageKind]string{
+            msgInfo:   ansiColors["cyan"],
+            msgStart:  ansiColors["green"],
+            msgStop:   ansiColors["yellow"],
+            msgSkip:   ansiColors["gray"],
+            msgDelete: ansiColors["red"],
+        },
+    }
+}
+
+// parseColor turns a color name like "red" or "bright-red" into SGR parameters
+func parseColor(name string) (string, bool) {
+    name = strings.ToLower(strings.TrimSpace(name))
+    if bright := strings.TrimPrefix(name, "bright-"); bright != name {
+        code, ok := ansiColors[bright]
+        if !ok || len(code) != 2 || code[0] != '3' {
+            return "", false
+        }
+        return "9" + code[1:], true
+    }
+    code, ok := ansiColors[name]
+    return code, ok
+}
+
+// loadThemeConfig reads color, message_prefix and color_<kind>
+func loadThemeConfig(values map[string]string, cfg *Config) error {
+    cfg.Theme = defaultTheme()
+    switch raw := values["color"]; raw {
+    case "", "auto":
+    case "always":
+        cfg.Theme.Color = true
+    case "never":
+        cfg.Theme.Color = false
+    default:
+        return fmt.Errorf("invalid value for color: %q (use auto, always or never)", raw)
+    }
+    cfg.Theme.Prefix = values["message_prefix"]
+    for _, kind := range []messageKind{msgInfo, msgStart, msgStop, msgSkip, msgDelete} {
+        key := "color_" + string(kind)
+        if raw := values[key]; raw != "" {
+            code, ok := parseColor(raw)
+            if !ok {
+                return fmt.Errorf("invalid value for %s: %q", key, raw)
+            }
+            cfg.Theme.Colors[kind] = code
+        }
+    }
+    return nil
+}
+
+// styled applies the theme to a message of kind
+func (t outputTheme) styled(kind messageKind, msg string) string {
+    msg = t.Prefix + msg
+    if code := t.Colors[kind]; t.Color && code != "" {
+        return "\x1b[" + code + "m" + msg + "\x1b[0m"
+    }
+    return msg
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestThemeConfig(t *testing.T) {
+    var cfg Config
+    values := map[string]string{"color": "always", "message_prefix": "pianotrap: ", "color_start": "bright-green", "color_skip": "none"}
+    if err := loadThemeConfig(values, &cfg); err != nil {
+        t.Fatal(err)
+    }
+    if got, want := cfg.Theme.styled(msgStart, "Recording"), "\x1b[92mpianotrap: Recording\x1b[0m"; got != want {
+        t.Errorf("styled start = %q, want %q", got, want)
+    }
+    if got, want := cfg.Theme.styled(msgSkip, "Skipped"), "pianotrap: Skipped"; got != want {
+        t.Errorf("styled skip = %q, want %q", got, want)
+    }
+    cfg.Theme.Color = false
+    if got, want := cfg.Theme.styled(msgDelete, "Removed"), "pianotrap: Removed"; got != want {
+        t.Errorf("styled without color = %q, want %q", got, want)
+    }
+    for _, bad := range []map[string]string{{"color": "sometimes"}, {"color_stop": "bright-bold"}, {"color_info": "teal"}} {
+        if err := loadThemeConfig(bad, &Config{}); err == nil {
+            t.Errorf("%v accepted", bad)
+        }
+    }
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// The HTTP API can be served over HTTPS, which browsers want before they offer some
+// features to a page and which keeps the token off the network: http_cert and
+// http_key name a certificate and its key, or http_tls = self-signed has pianotrap
+// make a certificate of its own. That one is kept next to the config file, so a
+// browser that was told to trust it once keeps trusting it, and is made again when
+// it expires or the machine's names and addresses no longer match it.
+
+// loadTLSConfig reads the http_tls, http_cert and http_key options
+func loadTLSConfig(values map[string]string, cfg *Config) error {
+    cfg.HTTPCert = values["http_cert"]
+    cfg.HTTPKey = values["http_key"]
+    if (cfg.HTTPCert == "") != (cfg.HTTPKey == "") {
+        return fmt.Errorf("http_cert and http_key go together")
+    }
+    switch values["http_tls"] {
+    case "", "off":
+    case "self-signed":
+        if cfg.HTTPCert != "" {
+            return fmt.Errorf("http_tls = self-signed and http_cert exclude each other")
+        }
+        cfg.HTTPSelfSigned = true
+    default:
+        return fmt.Errorf("invalid value for http_tls: %q (use off or self-signed)", values["http_tls"])
+    }
+    return nil
+}
+
+// httpsEnabled reports whether cfg serves the API over HTTPS
+func (cfg Config) httpsEnabled() bool {
+    return cfg.HTTPCert != "" || cfg.HTTPSelfSigned
+}
+
+// selfSignedPaths are where the self-signed certificate and its key are kept
+func selfSignedPaths(cfg Config) (string, string) {
+    dir := filepath.Dir(cfg.ConfigFile)
+    return filepath.Join(dir, "http-cert.pem"), filepath.Join(dir, "http-key.pem")
+}
+
+// apiCertificate loads the API's certificate, making the self-signed one if need be
+func apiCertificate(cfg Config) (tls.Certificate, error) {
+    if !cfg.HTTPSelfSigned {
+        cert, err := tls.LoadX509KeyPair(cfg.HTTPCert, cfg.HTTPKey)
+        if err != nil {
+            return cert, fmt.Errorf("failed to load http_cert and http_key: %v", err)
+        }
+        return cert, nil
+    }
+    certFile, keyFile := selfSignedPaths(cfg)
+    hosts := certificateHosts(cfg.HTTPListen)
+    if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && certificateCovers(cert, hosts) {
+        return cert, nil
+    }
+    certPEM, keyPEM, err := selfSignedCertificate(hosts, time.Now())
+    if err != nil {
+        return tls.Certificate{}, err
+    }
+    if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+        return tls.Certificate{}, fmt.Errorf("failed to save the certificate's key: %v", err)
+    }
+    if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+        return tls.Certificate{}, fmt.Errorf("failed to save the certificate: %v", err)
+    }
+    logger.Printf("HTTP API: made a self-signed certificate for %s in %s", strings.Join(hosts, ", "), certFile)
+    return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certificateHosts are the names and addresses a client may reach the API at
+func certificateHosts(listen string) []string {
+    hosts := []string{"localhost", "127.0.0.1", "::1"}
+    if name, err := os.Hostname(); err == nil {
+        hosts = append(hosts, name)
+        if !strings.Contains(name, ".") {
+            hosts = append(hosts, name+".local")
+        }
+    }
+    host, _, _ := net.SplitHostPort(listen)
+    if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+        hosts = append(hosts, host)
+    } else if addrs, err := net.InterfaceAddrs(); err == nil {
+        // Listening on all addresses: any of them
+        for _, addr := range addrs {
+            if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+                hosts = append(hosts, ipNet.IP.String())
+            }
+        }
+    }
+    var unique []string
+    seen := make(map[string]bool)
+    for _, h := range hosts {
+        if !seen[h] {
+            seen[h] = true
+            unique = append(unique, h)
+        }
+    }
+    return unique
+}
+
+// certificateCovers reports whether cert is valid for another day for all of hosts
+func certificateCovers(cert tls.Certificate, hosts []string) bool {
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil || time.Now().Add(24*time.Hour).After(leaf.NotAfter) {
+        return false
+    }
+    for _, h := range hosts {
+        if leaf.VerifyHostname(h) != nil {
+            return false
+        }
+    }
+    return true
+}
+
+// selfSignedCertificate makes a certificate for hosts, valid for two years from
+// now, and returns it and its key PEM-encoded
+func selfSignedCertificate(hosts []string, now time.Time) ([]byte, []byte, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+    serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+    if err != nil {
+        return nil, nil, err
+    }
+    template := &x509.Certificate{
+        SerialNumber:          serial,
+        Subject:               pkix.Name{CommonName: "pianotrap", Organization: []string{"pianotrap"}},
+        NotBefore:             now.Add(-time.Hour),
+        NotAfter:              now.AddDate(2, 0, 0),
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        BasicConstraintsValid: true,
+        // Its own CA, so that it can be imported as one
+        IsCA: true,
+    }
+    for _, h := range hosts {
+        if ip := net.ParseIP(h); ip != nil {
+            template.IPAddresses = append(template.IPAddresses, ip)
+        } else {
+            template.DNSNames = append(template.DNSNames, h)
+        }
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to make a certificate: %v", err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        return nil, nil, err
+    }
+    return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+        pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// certificateFingerprint is the SHA-256 fingerprint browsers show for cert
+func certificateFingerprint(cert tls.Certificate) string {
+    sum := sha256.Sum256(cert.Certificate[0])
+    parts := make([]string, len(sum))
+    for i, b := range sum {
+        parts[i] = fmt.Sprintf("%02X", b)
+    }
+    return strings.Join(parts, ":")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestAPICertificate(t *testing.T) {
+    for _, tt := range []struct {
+        values map[string]string
+        ok     bool
+    }{
+        {map[string]string{"http_tls": "self-signed"}, true},
+        {map[string]string{"http_cert": "/etc/cert.pem", "http_key": "/etc/key.pem"}, true},
+        {map[string]string{"http_cert": "/etc/cert.pem"}, false},
+        {map[string]string{"http_tls": "self-signed", "http_cert": "/etc/cert.pem", "http_key": "/etc/key.pem"}, false},
+        {map[string]string{"http_tls": "yes"}, false},
+    } {
+        var cfg Config
+        if err := loadTLSConfig(tt.values, &cfg); (err == nil) != tt.ok {
+            t.Errorf("%v: %v", tt.values, err)
+        }
+    }
+
+    cfg := Config{ConfigFile: filepath.Join(t.TempDir(), "config"), HTTPListen: "127.0.0.1:0", HTTPSelfSigned: true}
+    cert, err := apiCertificate(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, err := apiCertificate(cfg)
+    if err != nil || certificateFingerprint(again) != certificateFingerprint(cert) {
+        t.Errorf("self-signed certificate not kept: %v", err)
+    }
+
+    server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+    server.StartTLS()
+    defer server.Close()
+    certFile, _ := selfSignedPaths(cfg)
+    data, err := ioutil.ReadFile(certFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pool := x509.NewCertPool()
+    pool.AppendCertsFromPEM(data)
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+    for _, host := range []string{"127.0.0.1", "localhost"} {
+        resp, err := client.Get("https://" + strings.Replace(server.Listener.Addr().String(), "127.0.0.1", host, 1))
+        if err != nil {
+            t.Errorf("HTTPS to %s with the self-signed certificate: %v", host, err)
+            continue
+        }
+        resp.Body.Close()
+    }
+}
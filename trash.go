@@ -0,0 +1,118 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// With trash = xdg discarded recordings go to the desktop trash described by the
+// freedesktop.org Trash specification, where file managers can restore them, rather
+// than to pianotrap's own trash directory.
+
+// xdgTrash enables the freedesktop.org trash
+var xdgTrash bool
+
+// loadTrashConfig reads the trash option
+func loadTrashConfig(values map[string]string, cfg *Config) error {
+    switch raw := values["trash"]; raw {
+    case "", "pianotrap":
+    case "xdg":
+        cfg.XDGTrash = true
+    default:
+        return fmt.Errorf("invalid value for trash: %q (use pianotrap or xdg)", raw)
+    }
+    return nil
+}
+
+// xdgTrashDir is the home trash: $XDG_DATA_HOME/Trash, by default ~/.local/share/Trash
+func xdgTrashDir() (string, error) {
+    if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+        return filepath.Join(dataHome, "Trash"), nil
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+// reserveXDGTrash picks a free name in the trash for path and writes its .trashinfo,
+// returning where the file itself has to be moved
+func reserveXDGTrash(path string) (string, error) {
+    trash, err := xdgTrashDir()
+    if err != nil {
+        return "", err
+    }
+    for _, dir := range []string{"files", "info"} {
+        if err := os.MkdirAll(filepath.Join(trash, dir), 0700); err != nil {
+            return "", err
+        }
+    }
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return "", err
+    }
+    segments := strings.Split(abs, "/")
+    for i, s := range segments {
+        segments[i] = url.PathEscape(s)
+    }
+    info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+        strings.Join(segments, "/"), time.Now().Format("2006-01-02T15:04:05"))
+
+    // Creating the info file exclusively claims the name
+    base := filepath.Base(path)
+    name := base
+    for n := 2; ; n++ {
+        f, err := os.OpenFile(filepath.Join(trash, "info", name+".trashinfo"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+        if err == nil {
+            _, err = f.WriteString(info)
+            if closeErr := f.Close(); err == nil {
+                err = closeErr
+            }
+            if err != nil {
+                os.Remove(f.Name())
+                return "", err
+            }
+            return filepath.Join(trash, "files", name), nil
+        }
+        if !os.IsExist(err) || n > 1000 {
+            return "", err
+        }
+        ext := filepath.Ext(base)
+        name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), n, ext)
+    }
+}
+
+// trashInfoFile returns the .trashinfo belonging to a file in the XDG trash, or ""
+func trashInfoFile(target string) string {
+    files := filepath.Dir(target)
+    if filepath.Base(files) != "files" {
+        return ""
+    }
+    info := filepath.Join(filepath.Dir(files), "info", filepath.Base(target)+".trashinfo")
+    if _, err := os.Stat(info); err != nil {
+        return ""
+    }
+    return info
+}
+
+// discardToXDGTrash moves path to the XDG trash
+func discardToXDGTrash(path string) error {
+    target, err := reserveXDGTrash(path)
+    if err != nil {
+        return err
+    }
+    id := beginAction(actionDiscard, path, target)
+    if err := os.Rename(path, target); err != nil {
+        // Most likely on another file system than the home trash
+        finishAction(id, err)
+        os.Remove(trashInfoFile(target))
+        return err
+    }
+    finishAction(id, nil)
+    return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestXDGTrash(t *testing.T) {
+    dir := t.TempDir()
+    openTestDB(t)
+    xdgTrash = true
+    t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+    defer func() {
+        xdgTrash = false
+    }()
+
+    song := filepath.Join(dir, "Station", "a b.mp3")
+    os.MkdirAll(filepath.Dir(song), 0755)
+    for i := 0; i < 2; i++ {
+        if err := ioutil.WriteFile(song, []byte("audio"), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := discardFile(song); err != nil {
+            t.Fatal(err)
+        }
+    }
+    trash := filepath.Join(dir, "data", "Trash")
+    for _, name := range []string{"files/a b.mp3", "files/a b.2.mp3", "info/a b.mp3.trashinfo", "info/a b.2.mp3.trashinfo"} {
+        if _, err := os.Stat(filepath.Join(trash, name)); err != nil {
+            t.Errorf("missing %s in trash", name)
+        }
+    }
+    info, _ := ioutil.ReadFile(filepath.Join(trash, "info", "a b.mp3.trashinfo"))
+    if want := "Path=" + strings.Replace(filepath.Join(dir, "Station"), " ", "%20", -1) + "/a%20b.mp3\n"; !strings.Contains(string(info), want) {
+        t.Errorf("trashinfo %q lacks %q", info, want)
+    }
+
+    if _, err := undoLastAction(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(song); err != nil {
+        t.Error("recording not restored")
+    }
+    if _, err := os.Stat(filepath.Join(trash, "info", "a b.2.mp3.trashinfo")); err == nil {
+        t.Error("trashinfo left behind after restoring")
+    }
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/term"
+)
+
+// The TUI (tui = true or -tui) takes over the terminal with a now-playing panel,
+// pianobar's output in a scrolling pane, the recordings made so far and key hints.
+// Keys still go to pianobar. Everything pianotrap and pianobar would print goes
+// through a pipe into the output pane, so nothing writes over the screen.
+
+const (
+    tuiLogLines    = 500
+    tuiRecentFiles = 5
+)
+
+var (
+    tuiMu     sync.Mutex
+    tuiActive bool
+    tuiTerm   *os.File // the real terminal while os.Stdout is redirected
+    tuiStop   chan struct{}
+    tuiLog    = &logPane{}
+    tuiRecent []capturedFile
+
+    savedStdout, savedStderr *os.File
+)
+
+// capturedFile is a finished recording shown in the TUI
+type capturedFile struct {
+    Path string
+    Size int64
+}
+
+// logPane collects terminal output as lines the way a terminal would show them,
+// so pianobar's countdown stays on one line
+type logPane struct {
+    lines  []string
+    screen vtScreen
+}
+
+func (p *logPane) Write(b []byte) (int, error) {
+    p.lines = append(p.lines, p.screen.feed(string(b))...)
+    if len(p.lines) > tuiLogLines {
+        p.lines = p.lines[len(p.lines)-tuiLogLines:]
+    }
+    return len(b), nil
+}
+
+// tail returns the last n lines, including the one being written
+func (p *logPane) tail(n int) []string {
+    lines := p.lines
+    if current, _ := p.screen.current(); current != "" {
+        lines = append(lines[:len(lines):len(lines)], current)
+    }
+    if len(lines) > n {
+        lines = lines[len(lines)-n:]
+    }
+    return lines
+}
+
+// startTUI switches to the alternate screen and routes all output into the log pane
+func startTUI() error {
+    if !term.IsTerminal(int(os.Stdout.Fd())) {
+        return fmt.Errorf("standard output is not a terminal")
+    }
+    r, w, err := os.Pipe()
+    if err != nil {
+        return fmt.Errorf("failed to redirect output: %v", err)
+    }
+    tuiMu.Lock()
+    tuiTerm = os.Stdout
+    savedStdout, savedStderr = os.Stdout, os.Stderr
+    os.Stdout, os.Stderr = w, w
+    if logFile == nil {
+        logger.SetOutput(w)
+    }
+    tuiActive = true
+    tuiStop = make(chan struct{})
+    fmt.Fprint(tuiTerm, "\x1b[?1049h\x1b[?25l")
+    tuiMu.Unlock()
+
+    go func() {
+        buf := make([]byte, 4096)
+        for {
+            n, err := r.Read(buf)
+            if n > 0 {
+                tuiMu.Lock()
+                tuiLog.Write(buf[:n])
+                tuiMu.Unlock()
+            }
+            if err != nil {
+                return
+            }
+        }
+    }()
+    stop := tuiStop
+    go func() {
+        ticker := time.NewTicker(250 * time.Millisecond)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                drawTUI()
+            }
+        }
+    }()
+    return nil
+}
+
+// stopTUI restores the normal screen and output
+func stopTUI() {
+    tuiMu.Lock()
+    defer tuiMu.Unlock()
+    if !tuiActive {
+        return
+    }
+    tuiActive = false
+    close(tuiStop)
+    fmt.Fprint(tuiTerm, "\x1b[?25h\x1b[?1049l")
+    pipe := os.Stdout
+    os.Stdout, os.Stderr = savedStdout, savedStderr
+    if logFile == nil {
+        logger.SetOutput(os.Stderr)
+    }
+    pipe.Close()
+}
+
+// noteCaptured adds a finished recording to the TUI's list
+func noteCaptured(fileName string) {
+    var size int64
+    if info, err := os.Stat(fileName); err == nil {
+        size = info.Size()
+    }
+    tuiMu.Lock()
+    tuiRecent = append(tuiRecent, capturedFile{Path: fileName, Size: size})
+    if len(tuiRecent) > tuiRecentFiles {
+        tuiRecent = tuiRecent[len(tuiRecent)-tuiRecentFiles:]
+    }
+    tuiMu.Unlock()
+}
+
+// progressBar draws elapsed out of total seconds in width cells
+func progressBar(elapsed, total, width int) string {
+    if width < 1 {
+        return ""
+    }
+    filled := 0
+    if total > 0 {
+        filled = elapsed * width / total
+    }
+    if filled > width {
+        filled = width
+    }
+    if filled < 0 {
+        filled = 0
+    }
+    return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// fit pads or shortens s to exactly width columns
+func fit(s string, width int) string {
+    r := []rune(s)
+    if len(r) > width {
+        if width < 1 {
+            return ""
+        }
+        return string(append(r[:width-1], '…'))
+    }
+    return s + strings.Repeat(" ", width-len(r))
+}
+
+// tuiHelp is the line of keys at the bottom of the screen
+func tuiHelp(record, discard, keep byte) string {
+    return fmt.Sprintf(" q quit  n next  + love  s station  %s record on/off  %s discard  %s keep  Ctrl+E edit tags  Ctrl+Z undo  Ctrl+O source",
+        keyName(record), keyName(discard), keyName(keep))
+}
+
+// tuiScreen lays out the whole screen as lines of exactly width columns, help last
+func tuiScreen(status playerStatus, size int64, log []string, recent []capturedFile, help string, width, height int) []string {
+    var screen []string
+    add := func(s string) { screen = append(screen, fit(s, width)) }
+
+    state := "not recording"
+    if status.Recording {
+        state = fmt.Sprintf("● REC %.1f MB", float64(size)/(1<<20))
+    } else if status.Paused {
+        state = "recording off"
+    } else if status.State != "playing" {
+        state = status.State
+    }
+    add(fmt.Sprintf(" pianotrap  %s", state))
+    add(fmt.Sprintf(" Station: %s", status.Station))
+    add(fmt.Sprintf(" Song:    %s", status.Title))
+    add(fmt.Sprintf(" Artist:  %s", status.Artist))
+    add(fmt.Sprintf(" Album:   %s", status.Album))
+    elapsed := status.Total - status.Remaining
+    clock := fmt.Sprintf(" %d:%02d / %d:%02d", elapsed/60, elapsed%60, status.Total/60, status.Total%60)
+    add(" " + progressBar(elapsed, status.Total, width-len(clock)-2) + clock)
+    add(strings.Repeat("─", width))
+
+    recentRows := len(recent)
+    if recentRows > 0 {
+        recentRows += 2 // separator and heading
+    }
+    logRows := height - len(screen) - recentRows - 1
+    if logRows < 1 {
+        logRows = 1
+    }
+    lines := log
+    if len(lines) > logRows {
+        lines = lines[len(lines)-logRows:]
+    }
+    for i := len(lines); i < logRows; i++ {
+        add("")
+    }
+    for _, line := range lines {
+        add(line)
+    }
+
+    if len(recent) > 0 {
+        add(strings.Repeat("─", width))
+        add(" Recorded:")
+        for i := len(recent) - 1; i >= 0; i-- {
+            f := recent[i]
+            add(fmt.Sprintf("  %6.1f MB  %s", float64(f.Size)/(1<<20), filepath.Base(f.Path)))
+        }
+    }
+    add(help)
+    if len(screen) > height {
+        screen = screen[len(screen)-height:]
+    }
+    return screen
+}
+
+// drawTUI redraws the screen from the current state
+func drawTUI() {
+    status := currentStatus()
+    mu.Lock()
+    fileName := currentFileName
+    help := tuiHelp(recordKey, discardKey, keepKey)
+    mu.Unlock()
+    var size int64
+    if status.Recording {
+        if info, err := os.Stat(tempName(fileName)); err == nil {
+            size = info.Size()
+        }
+    }
+
+    tuiMu.Lock()
+    defer tuiMu.Unlock()
+    if !tuiActive {
+        return
+    }
+    width, height, err := term.GetSize(int(tuiTerm.Fd()))
+    if err != nil || width < 20 || height < 10 {
+        return
+    }
+    screen := tuiScreen(status, size, tuiLog.tail(height), tuiRecent, help, width, height)
+    var b strings.Builder
+    b.WriteString("\x1b[H")
+    for i, line := range screen {
+        if i == 0 || i == len(screen)-1 {
+            b.WriteString("\x1b[7m" + line + "\x1b[0m")
+        } else {
+            b.WriteString(line)
+        }
+        if i < len(screen)-1 {
+            b.WriteString("\r\n")
+        }
+    }
+    io.WriteString(tuiTerm, b.String())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestTUILayout(t *testing.T) {
+    pane := &logPane{}
+    pane.Write([]byte("|> Station \"Jazz Radio\"\r\n#  -04:10/04:10\r#  -04:09/04:10\x1b[0K"))
+    if got := pane.tail(5); len(got) != 2 || got[1] != "#  -04:09/04:10" {
+        t.Errorf("log pane = %q, want the countdown overwritten in place", got)
+    }
+
+    status := playerStatus{State: "playing", Station: "Jazz Radio", Title: "So What", Recording: true, Remaining: 60, Total: 120}
+    recent := []capturedFile{{Path: "/music/Jazz Radio/Blue in Green.mp3", Size: 5 << 20}}
+    screen := tuiScreen(status, 1<<20, pane.tail(20), recent, tuiHelp(sessionKeys()), 60, 20)
+    if len(screen) != 20 {
+        t.Fatalf("screen has %d lines, want 20", len(screen))
+    }
+    for i, line := range screen {
+        if n := len([]rune(line)); n != 60 {
+            t.Errorf("line %d is %d columns wide: %q", i, n, line)
+        }
+    }
+    if !strings.Contains(screen[0], "REC 1.0 MB") || !strings.Contains(screen[len(screen)-2], "Blue in Green.mp3") || !strings.HasPrefix(screen[len(screen)-1], " q quit") {
+        t.Errorf("screen = %q", screen)
+    }
+    if got := progressBar(60, 120, 10); got != "█████░░░░░" {
+        t.Errorf("progressBar = %q", got)
+    }
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// pianobar redraws its countdown in place with carriage returns and erase-line
+// sequences, and a read from the PTY can end anywhere, even inside an escape
+// sequence or a UTF-8 character. vtScreen interprets the output the way a terminal
+// would for a single line, so the parsers see whole logical lines as they ended up
+// on screen. The terminal itself still gets pianobar's output byte for byte.
+
+// vtMaxColumn limits cursor movement, so a bogus sequence can't pad a line
+// with millions of spaces
+const vtMaxColumn = 1024
+
+// vtState is where the parser is within an escape sequence
+type vtState int
+
+const (
+    vtGround vtState = iota
+    vtEscape         // after ESC
+    vtCSI            // ESC [ parameters
+    vtOSC            // ESC ] text, ended by BEL or ESC \
+    vtOSCEscape      // ESC inside an OSC, usually the start of ESC \
+)
+
+// vtScreen tracks the line being written and the cursor within it
+type vtScreen struct {
+    line    []rune
+    col     int
+    state   vtState
+    params  []byte
+    pending []byte // start of a UTF-8 character split between reads
+    changed bool
+}
+
+// feed interprets a chunk of output and returns the lines it finished
+func (s *vtScreen) feed(chunk string) []string {
+    var lines []string
+    s.changed = false
+    for i := 0; i < len(chunk); i++ {
+        b := chunk[i]
+        switch s.state {
+        case vtEscape:
+            switch {
+            case b == '[':
+                s.state, s.params = vtCSI, s.params[:0]
+            case b == ']':
+                s.state = vtOSC
+            case b >= 0x20 && b <= 0x2f:
+                // Intermediate bytes, e.g. ESC ( B; wait for the final byte
+            default:
+                s.state = vtGround
+            }
+        case vtCSI:
+            switch {
+            case b >= 0x40 && b <= 0x7e:
+                s.control(b, string(s.params))
+                s.state = vtGround
+            case b >= 0x20 && b <= 0x3f:
+                s.params = append(s.params, b)
+            default:
+                s.state = vtGround // malformed
+            }
+        case vtOSC:
+            if b == 0x07 {
+                s.state = vtGround
+            } else if b == 0x1b {
+                s.state = vtOSCEscape
+            }
+        case vtOSCEscape:
+            s.state = vtGround
+        default:
+            switch {
+            case b == 0x1b:
+                s.pending = s.pending[:0]
+                s.state = vtEscape
+            case b == '\n':
+                lines = append(lines, string(s.line))
+                s.line, s.col = s.line[:0], 0
+                s.changed = false
+            case b == '\r':
+                s.col = 0
+            case b == '\b':
+                if s.col > 0 {
+                    s.col--
+                }
+            case b == '\t':
+                s.moveTo(s.col + 8 - s.col%8)
+            case b < 0x20 || b == 0x7f:
+                // Other control characters don't draw anything
+            case b < utf8.RuneSelf:
+                s.put(rune(b))
+            default:
+                s.pending = append(s.pending, b)
+                if utf8.FullRune(s.pending) {
+                    r, _ := utf8.DecodeRune(s.pending)
+                    s.put(r)
+                    s.pending = s.pending[:0]
+                }
+            }
+        }
+    }
+    return lines
+}
+
+// current returns the line being written and whether the last feed changed it
+func (s *vtScreen) current() (string, bool) {
+    return string(s.line), s.changed
+}
+
+// put writes r at the cursor, overwriting what was there
+func (s *vtScreen) put(r rune) {
+    s.moveTo(s.col)
+    if s.col < len(s.line) {
+        s.line[s.col] = r
+    } else {
+        s.line = append(s.line, r)
+    }
+    s.col++
+    s.changed = true
+}
+
+// moveTo moves the cursor to col, padding the line with spaces if it's shorter
+func (s *vtScreen) moveTo(col int) {
+    if col < 0 {
+        col = 0
+    }
+    for len(s.line) < col {
+        s.line = append(s.line, ' ')
+    }
+    s.col = col
+}
+
+// control carries out the CSI sequences that change the line; the rest, such as
+// colors, are dropped
+func (s *vtScreen) control(final byte, params string) {
+    n, err := strconv.Atoi(strings.SplitN(params, ";", 2)[0])
+    if err != nil {
+        n = 0
+    }
+    count := n
+    if count < 1 {
+        count = 1
+    }
+    switch final {
+    case 'K': // erase in line
+        switch n {
+        case 0:
+            if s.col < len(s.line) {
+                s.line = s.line[:s.col]
+            }
+        case 1:
+            for i := 0; i < s.col && i < len(s.line); i++ {
+                s.line[i] = ' '
+            }
+        case 2:
+            s.line = s.line[:0]
+        }
+        s.changed = true
+    case 'G': // cursor to column
+        s.col = count - 1
+    case 'C': // cursor forward
+        s.col += count
+        if count > vtMaxColumn {
+            s.col = vtMaxColumn
+        }
+    case 'D': // cursor back
+        s.col -= count
+    }
+    if s.col < 0 {
+        s.col = 0
+    }
+    if s.col > vtMaxColumn {
+        s.col = vtMaxColumn
+    }
+}
+
+// joinLines turns finished lines back into text, each ending in a newline
+func joinLines(lines []string) string {
+    if len(lines) == 0 {
+        return ""
+    }
+    return strings.Join(lines, "\n") + "\n"
+}
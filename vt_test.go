@@ -0,0 +1,72 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "unicode/utf8"
+)
+
+func TestVTScreen(t *testing.T) {
+    tests := []struct {
+        chunks  []string
+        lines   []string
+        current string
+    }{
+        {[]string{"\x1b[2K|>  Station\r\n"}, []string{"|>  Station"}, ""},
+        {[]string{"\x1b[?25lhidden\x1b[?25h"}, nil, "hidden"},
+        {[]string{"\x1b]0;title\x07text"}, nil, "text"},
+        {[]string{"\x1b[1;32mgreen\x1b[0m"}, nil, "green"},
+        {[]string{"\x1b(Bcharset"}, nil, "charset"},
+        // The countdown is redrawn in place
+        {[]string{"#   -03:46/03:48\r\x1b[2K#   -03:45/03:48"}, nil, "#   -03:45/03:48"},
+        {[]string{"#   -10:00/10:00\r#   -9:59/10:00\x1b[K"}, nil, "#   -9:59/10:00"},
+        {[]string{"abc\b\bX"}, nil, "aXc"},
+        {[]string{"ab\x1b[5Gc"}, nil, "ab  c"},
+        // Reads split lines, escape sequences and characters anywhere
+        {[]string{"|>  \"Song\" by \"Art", "ist\" on \"Album\"\r\n"}, []string{`|>  "Song" by "Artist" on "Album"`}, ""},
+        {[]string{"\x1b[", "2Kdone"}, nil, "done"},
+        {[]string{"\xc3", "\xa9t\xc3\xa9"}, nil, "été"},
+    }
+    for _, tt := range tests {
+        var s vtScreen
+        var lines []string
+        for _, chunk := range tt.chunks {
+            lines = append(lines, s.feed(chunk)...)
+        }
+        current, _ := s.current()
+        if !reflect.DeepEqual(lines, tt.lines) || current != tt.current {
+            t.Errorf("feed(%q) = %q, %q; want %q, %q", tt.chunks, lines, current, tt.lines, tt.current)
+        }
+    }
+
+    // Only a changed current line counts as new output
+    var s vtScreen
+    s.feed("#   -03:46/03:48")
+    if _, changed := s.current(); !changed {
+        t.Error("countdown not reported as changed")
+    }
+    s.feed("\x1b[?25h")
+    if _, changed := s.current(); changed {
+        t.Error("line reported as changed by a mode switch")
+    }
+}
+
+func FuzzVTScreen(f *testing.F) {
+    for _, seed := range []string{"", "plain", "\x1b[2K|>  x", "\x1b[", "\x1b]0;t\x07", "\x1b\x1b[0m[", "a\rb\n", "\x1b[999999999999C"} {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, in string) {
+        var s vtScreen
+        lines := s.feed(in)
+        current, _ := s.current()
+        plain := true
+        for _, r := range in {
+            if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+                plain = false
+            }
+        }
+        if plain && (len(lines) != 0 || current != in) {
+            t.Fatalf("feed(%q) = %q, %q changed text without control characters", in, lines, current)
+        }
+    })
+}
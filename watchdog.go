@@ -0,0 +1,100 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+// The watchdog ends the session when pianobar stops producing output, which means it
+// hung. pianobar is legitimately quiet while paused and while it waits at a prompt
+// such as station selection, so the watchdog doesn't count those periods.
+
+var (
+    watchdogWarn    = 5 * time.Second
+    watchdogTimeout = 15 * time.Second
+
+    // userPaused is set by pause keys sent to pianobar; pausedAt tells countdown
+    // ticks still in flight from ticks after playback resumed
+    userPaused bool
+    pausedAt   time.Time
+    atPrompt   bool
+)
+
+// loadWatchdogConfig reads watchdog_warn and watchdog_timeout (0 disables it)
+func loadWatchdogConfig(values map[string]string, cfg *Config) error {
+    cfg.WatchdogWarn = 5 * time.Second
+    cfg.WatchdogTimeout = 15 * time.Second
+    for key, d := range map[string]*time.Duration{"watchdog_warn": &cfg.WatchdogWarn, "watchdog_timeout": &cfg.WatchdogTimeout} {
+        if raw := values[key]; raw != "" {
+            v, err := time.ParseDuration(raw)
+            if err != nil || v < 0 {
+                return fmt.Errorf("invalid value for %s: %q", key, raw)
+            }
+            *d = v
+        }
+    }
+    if cfg.WatchdogTimeout > 0 && cfg.WatchdogTimeout < 5*time.Second {
+        return fmt.Errorf("watchdog_timeout %v is too short (at least 5s)", cfg.WatchdogTimeout)
+    }
+    return nil
+}
+
+// notePlayerKey tracks pause and resume keys sent to pianobar
+func notePlayerKey(key byte) {
+    mu.Lock()
+    defer mu.Unlock()
+    switch key {
+    case 'p':
+        userPaused = !userPaused
+    case 'S':
+        userPaused = true
+    case 'P':
+        userPaused = false
+    default:
+        return
+    }
+    pausedAt = time.Now()
+}
+
+// notePTYOutput remembers whether pianobar's output ends at an input prompt
+func notePTYOutput(output string) {
+    lines := strings.Split(strings.TrimRight(output, "\r\n "), "\n")
+    last := lines[len(lines)-1]
+    mu.Lock()
+    atPrompt = strings.Contains(last, "[?]")
+    mu.Unlock()
+}
+
+// quietOnPurpose reports whether pianobar is paused or waiting for input
+func quietOnPurpose() bool {
+    mu.Lock()
+    defer mu.Unlock()
+    if atPrompt {
+        return true
+    }
+    // Playback resumed if the countdown kept ticking after the pause key
+    if userPaused && lastCountdown.After(pausedAt.Add(2*time.Second)) {
+        userPaused = false
+    }
+    return userPaused
+}
+
+// watchdogExpired checks the silence since lastOutput, logging a warning once per
+// silence, and reports whether the session should be ended. Quiet periods on
+// purpose move lastOutput forward so they don't count.
+func watchdogExpired(lastOutput *time.Time, warned *bool) bool {
+    mu.Lock()
+    warn, timeout := watchdogWarn, watchdogTimeout
+    mu.Unlock()
+    if timeout == 0 || quietOnPurpose() {
+        *lastOutput = time.Now()
+        return false
+    }
+    silence := time.Since(*lastOutput)
+    if warn > 0 && silence > warn && !*warned {
+        logger.Printf("No PTY output for %v, recording=%v", warn, recording)
+        *warned = true
+    }
+    return silence > timeout
+}
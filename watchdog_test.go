@@ -0,0 +1,38 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestWatchdog(t *testing.T) {
+    defer func() {
+        userPaused, atPrompt, lastCountdown = false, false, time.Time{}
+    }()
+    warned := false
+    last := time.Now().Add(-20 * time.Second)
+    if !watchdogExpired(&last, &warned) {
+        t.Error("20s of silence while playing didn't expire the watchdog")
+    }
+
+    notePlayerKey('p')
+    last = time.Now().Add(-20 * time.Second)
+    if watchdogExpired(&last, &warned) {
+        t.Error("watchdog expired while paused")
+    }
+    if time.Since(last) > time.Second {
+        t.Error("pause counted as silence")
+    }
+    notePlayerKey('p')
+
+    notePTYOutput("\r\n  0) q   Deep Focus\r\n[?] Select station: ")
+    last = time.Now().Add(-20 * time.Second)
+    if watchdogExpired(&last, &warned) {
+        t.Error("watchdog expired at a prompt")
+    }
+    notePTYOutput("#   -03:10/04:00\r")
+    last = time.Now().Add(-20 * time.Second)
+    if !watchdogExpired(&last, &warned) {
+        t.Error("watchdog suspended after the prompt was answered")
+    }
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Webhooks POST JSON to URLs of the user's choosing when something happens, to wire
+// pianotrap into n8n, IFTTT, Home Assistant automations or scripts of their own:
+//
+//     [webhook.n8n]
+//     url = https://n8n.example.com/webhook/pianotrap
+//     events = [songstart, songsaved]
+//     template = '{"text": {{json .Title}}, "by": {{json .Artist}}}'
+//     headers = ["Authorization: Bearer s3cret"]
+//
+// Without a template the payload is webhookPayload as JSON. Failed deliveries are
+// tried again with growing pauses, unless the receiver turned them down.
+
+// webhookEvents are the events webhooks can have, by pianotrap event
+var webhookEvents = map[string]string{
+    eventDetected: "songstart",
+    eventSaved:    "songsaved",
+    eventDeleted:  "songdeleted",
+    eventFailed:   "error",
+    eventStation:  "stationchange",
+}
+
+// webhookOptions are the options of a [webhook.<name>] section
+var webhookOptions = map[string]bool{"url": true, "events": true, "template": true, "headers": true}
+
+// webhookAttempts is how often a delivery is tried, the pauses doubling from
+// webhookBackoff
+var (
+    webhookAttempts = 5
+    webhookBackoff  = 2 * time.Second
+)
+
+// webhook is a [webhook.<name>] section
+type webhook struct {
+    Name     string
+    URL      string
+    Events   map[string]bool
+    Template *template.Template
+    Headers  http.Header
+}
+
+// webhookPayload is what a webhook gets, and what its template sees
+type webhookPayload struct {
+    Event   string    `json:"event"`
+    Time    time.Time `json:"time"`
+    Message string    `json:"message"`
+    Station string    `json:"station"`
+    Title   string    `json:"title,omitempty"`
+    Artist  string    `json:"artist,omitempty"`
+    Album   string    `json:"album,omitempty"`
+    Loved   bool      `json:"loved,omitempty"`
+    File    string    `json:"file,omitempty"`
+}
+
+var webhookFuncs = template.FuncMap{
+    // json quotes a value for the payload, e.g. {{json .Title}}
+    "json": func(v interface{}) (string, error) {
+        data, err := json.Marshal(v)
+        return string(data), err
+    },
+}
+
+// isWebhookSecret reports whether key is a webhook's url or headers, which tend to
+// carry its token
+func isWebhookSecret(key string) bool {
+    return isWebhookOption(key) && !strings.HasSuffix(key, ".events") && !strings.HasSuffix(key, ".template")
+}
+
+// isWebhookOption reports whether key is an option of a [webhook.<name>] section
+func isWebhookOption(key string) bool {
+    parts := strings.SplitN(key, ".", 3)
+    return len(parts) == 3 && parts[0] == "webhook" && webhookOptions[parts[2]]
+}
+
+// loadWebhookConfig reads the [webhook.<name>] sections
+func loadWebhookConfig(values map[string]string, cfg *Config) error {
+    hooks := make(map[string]*webhook)
+    for key, value := range values {
+        if !isWebhookOption(key) {
+            continue
+        }
+        parts := strings.SplitN(key, ".", 3)
+        h := hooks[parts[1]]
+        if h == nil {
+            h = &webhook{Name: parts[1], Headers: make(http.Header)}
+            hooks[parts[1]] = h
+        }
+        switch parts[2] {
+        case "url":
+            if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+                return fmt.Errorf("invalid url for webhook %s: %q (want an http(s) URL)", h.Name, value)
+            }
+            h.URL = value
+        case "events":
+            h.Events = make(map[string]bool)
+            for _, e := range configList(value) {
+                known := false
+                for _, name := range webhookEvents {
+                    known = known || name == e
+                }
+                if !known {
+                    return fmt.Errorf("invalid event for webhook %s: %q (use songstart, songsaved, songdeleted, error or stationchange)", h.Name, e)
+                }
+                h.Events[e] = true
+            }
+        case "template":
+            t, err := template.New("webhook." + h.Name).Funcs(webhookFuncs).Option("missingkey=error").Parse(value)
+            if err != nil {
+                return fmt.Errorf("invalid template for webhook %s: %v", h.Name, err)
+            }
+            // Catch mistakes now rather than when the first song plays
+            sample := webhookPayload{"songstart", time.Now(), `So What by "Miles" Davis`, "Jazz Radio", "So What", `"Miles" Davis`, "Kind of Blue", true, "/music/So What.mp3"}
+            if _, err := renderWebhook(t, sample); err != nil {
+                return fmt.Errorf("invalid template for webhook %s: %v", h.Name, err)
+            }
+            h.Template = t
+        case "headers":
+            for _, header := range configList(value) {
+                name, v, ok := strings.Cut(header, ":")
+                if !ok || strings.TrimSpace(name) == "" {
+                    return fmt.Errorf("invalid header for webhook %s: %q (want Name: value)", h.Name, header)
+                }
+                h.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+            }
+        }
+    }
+    cfg.Webhooks = nil
+    for _, h := range hooks {
+        if h.URL == "" {
+            return fmt.Errorf("webhook %s has no url", h.Name)
+        }
+        cfg.Webhooks = append(cfg.Webhooks, *h)
+    }
+    sort.Slice(cfg.Webhooks, func(i, j int) bool { return cfg.Webhooks[i].Name < cfg.Webhooks[j].Name })
+    return nil
+}
+
+// renderWebhook evaluates a payload template, which must come out as JSON
+func renderWebhook(t *template.Template, p webhookPayload) ([]byte, error) {
+    var b bytes.Buffer
+    if err := t.Execute(&b, p); err != nil {
+        return nil, err
+    }
+    if !json.Valid(b.Bytes()) {
+        return nil, fmt.Errorf("it doesn't make JSON: %s (quote values with {{json .Title}})", b.String())
+    }
+    return b.Bytes(), nil
+}
+
+// newWebhookPayload describes event name with msg. Events about a recording carry its
+// file, maybe followed by the error in parentheses, and the song is looked up by it.
+func newWebhookPayload(name, msg string, at time.Time) webhookPayload {
+    s := currentStatus()
+    p := webhookPayload{Event: webhookEvents[name], Time: at.UTC(), Message: msg, Station: s.Station}
+    switch name {
+    case eventDetected:
+        p.Title, p.Artist, p.Album, p.Loved = s.Title, s.Artist, s.Album, s.Loved
+    case eventSaved, eventDeleted, eventFailed:
+        p.File = msg
+        if i := strings.LastIndex(msg, " ("); i > 0 && strings.HasSuffix(msg, ")") {
+            p.File = msg[:i]
+        }
+        if song, err := songByFile(p.File); err == nil {
+            p.Title, p.Artist, p.Album, p.Station, p.Loved = song.Title, song.Artist, song.Album, song.Station, song.Loved
+        }
+    }
+    return p
+}
+
+// songByFile returns the latest song recorded to fileName. By the time its recording
+// is saved the player may well be on the next song.
+func songByFile(fileName string) (songRecord, error) {
+    var s songRecord
+    if db == nil {
+        return s, fmt.Errorf("no song database")
+    }
+    err := db.QueryRow("SELECT title, artist, album, station, loved FROM songs WHERE id = (SELECT MAX(id) FROM songs WHERE file = ?)", fileName).
+        Scan(&s.Title, &s.Artist, &s.Album, &s.Station, &s.Loved)
+    return s, err
+}
+
+// fireWebhooks delivers event name to the webhooks of the config in use that want it
+func fireWebhooks(name, msg string) {
+    event, ok := webhookEvents[name]
+    if !ok {
+        return
+    }
+    var p *webhookPayload
+    for _, h := range currentConfig().Webhooks {
+        if h.Events != nil && !h.Events[event] {
+            continue
+        }
+        if p == nil {
+            payload := newWebhookPayload(name, msg, time.Now())
+            p = &payload
+        }
+        body, err := json.Marshal(p)
+        if h.Template != nil {
+            body, err = renderWebhook(h.Template, *p)
+        }
+        if err != nil {
+            logger.Printf("Webhook %s: %v", h.Name, err)
+            continue
+        }
+        go deliverWebhook(h, body)
+    }
+}
+
+// deliverWebhook POSTs body to h, trying again after network errors, rate limits
+// and server errors
+func deliverWebhook(h webhook, body []byte) {
+    client := &http.Client{Timeout: 30 * time.Second}
+    pause := webhookBackoff
+    for attempt := 1; ; attempt++ {
+        retry, wait, err := postWebhook(client, h, body)
+        if err == nil {
+            return
+        }
+        if !retry || attempt == webhookAttempts {
+            logger.Printf("Webhook %s failed after %d attempts: %v", h.Name, attempt, err)
+            return
+        }
+        if wait == 0 {
+            wait = pause
+        }
+        logger.Printf("Webhook %s: %v, trying again in %v", h.Name, err, wait)
+        time.Sleep(wait)
+        pause *= 2
+    }
+}
+
+// postWebhook makes one delivery, and reports whether a failure is worth trying
+// again and after how long the receiver wants that, if it said
+func postWebhook(client *http.Client, h webhook, body []byte) (bool, time.Duration, error) {
+    req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+    if err != nil {
+        return false, 0, err
+    }
+    for name, values := range h.Headers {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", enrichUserAgent)
+    resp, err := client.Do(req)
+    if err != nil {
+        return true, 0, err
+    }
+    resp.Body.Close()
+    switch {
+    case resp.StatusCode < 300:
+        return false, 0, nil
+    case resp.StatusCode == http.StatusTooManyRequests:
+        var wait time.Duration
+        if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 && seconds <= 300 {
+            wait = time.Duration(seconds) * time.Second
+        }
+        return true, wait, fmt.Errorf("%s", resp.Status)
+    case resp.StatusCode >= 500:
+        return true, 0, fmt.Errorf("%s", resp.Status)
+    }
+    return false, 0, fmt.Errorf("%s", resp.Status)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestWebhooks(t *testing.T) {
+    values, err := parseConfig(`[webhook.n8n]
+url = https://n8n.example.com/hook
+events = ["songsaved", "error"]
+template = '{"text": {{json .Title}}, "loved": {{.Loved}}}'
+headers = ["Authorization: Bearer s3cret", "X-Source: pianotrap"]
+
+[webhook."ha"]
+url = http://ha.local:8123/api/webhook/x
+`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var cfg Config
+    if err := loadWebhookConfig(values, &cfg); err != nil {
+        t.Fatal(err)
+    }
+    if len(cfg.Webhooks) != 2 || cfg.Webhooks[0].Name != "ha" || cfg.Webhooks[0].Events != nil {
+        t.Fatalf("webhooks = %+v", cfg.Webhooks)
+    }
+    n8n := cfg.Webhooks[1]
+    if !n8n.Events["songsaved"] || n8n.Events["songstart"] || n8n.Headers.Get("X-Source") != "pianotrap" {
+        t.Errorf("n8n = %+v", n8n)
+    }
+    if !isWebhookSecret("webhook.n8n.headers") || isWebhookSecret("webhook.n8n.events") {
+        t.Error("isWebhookSecret")
+    }
+    for _, bad := range []map[string]string{
+        {"webhook.x.events": "songstart"},
+        {"webhook.x.url": "ftp://example.com"},
+        {"webhook.x.url": "http://x", "webhook.x.events": "songend"},
+        {"webhook.x.url": "http://x", "webhook.x.template": `{"text": {{.Title}}}`},
+        {"webhook.x.url": "http://x", "webhook.x.template": `{"text": {{json .Tilte}}}`},
+        {"webhook.x.url": "http://x", "webhook.x.headers": "Bearer s3cret"},
+    } {
+        if err := loadWebhookConfig(bad, &cfg); err == nil {
+            t.Errorf("%v loaded", bad)
+        }
+    }
+
+    // A saved recording is described by its song, whatever plays by then
+    openTestDB(t)
+    logDetectedSong(songInfo{Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Loved: true}, "Jazz Radio", "/music/So What.mp3", outcomeRecording)
+    p := newWebhookPayload(eventFailed, "/music/So What.mp3 (exit status 1)", time.Now())
+    if p.Event != "error" || p.File != "/music/So What.mp3" || p.Title != "So What" || p.Station != "Jazz Radio" || !p.Loved {
+        t.Errorf("payload = %+v", p)
+    }
+    body, err := renderWebhook(n8n.Template, p)
+    if err != nil || string(body) != `{"text": "So What", "loved": true}` {
+        t.Errorf("renderWebhook = %s, %v", body, err)
+    }
+
+    // Server errors are tried again, rejections aren't
+    defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+    webhookBackoff = time.Millisecond
+    statuses := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+    var requests []*http.Request
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests = append(requests, r)
+        w.WriteHeader(statuses[0])
+        statuses = statuses[1:]
+    }))
+    defer server.Close()
+    n8n.URL = server.URL
+    deliverWebhook(n8n, body)
+    if len(requests) != 2 || requests[1].Header.Get("Authorization") != "Bearer s3cret" || requests[1].Header.Get("Content-Type") != "application/json" {
+        t.Errorf("requests = %v", requests)
+    }
+    deliverWebhook(n8n, body)
+    if len(requests) != 3 {
+        t.Errorf("a rejected webhook was sent %d times", len(requests)-2)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/creack/pty"
+    "golang.org/x/term"
+)
+
+// pianobar wraps lines and lays out the station list for the size of its PTY, so
+// the PTY follows the terminal's size, less the lines the status bar or the TUI
+// take. A resized window is passed on as it happens.
+
+// tuiReservedRows are the TUI's lines around the output pane: the now-playing
+// panel, its separator and the key hints
+const tuiReservedRows = 8
+
+// ptyRows is how many of the terminal's height lines pianobar gets
+func ptyRows(height int, statusBar, tui bool) int {
+    switch {
+    case tui:
+        height -= tuiReservedRows
+    case statusBar:
+        height--
+    }
+    if height < 1 {
+        height = 1
+    }
+    return height
+}
+
+// resizePTY sets ptyFile to the controlling terminal's size
+func resizePTY(ptyFile *os.File) {
+    width, height, err := term.GetSize(int(os.Stdin.Fd()))
+    if err != nil {
+        return // not a terminal
+    }
+    statusMu.Lock()
+    statusBar := statusActive
+    statusMu.Unlock()
+    tuiMu.Lock()
+    tui := tuiActive
+    tuiMu.Unlock()
+    rows := ptyRows(height, statusBar, tui)
+    if err := pty.Setsize(ptyFile, &pty.Winsize{Rows: uint16(rows), Cols: uint16(width)}); err != nil {
+        logger.Printf("Failed to resize the PTY: %v", err)
+        return
+    }
+    logger.Printf("PTY resized to %dx%d", width, rows)
+}
+
+// watchWindowSize sizes the PTY now and whenever the terminal is resized, and
+// redraws what pianotrap draws around it
+func watchWindowSize(ptyFile *os.File, done <-chan struct{}) {
+    resizePTY(ptyFile)
+    winch := make(chan os.Signal, 1)
+    signal.Notify(winch, syscall.SIGWINCH)
+    go func() {
+        defer signal.Stop(winch)
+        for {
+            select {
+            case <-done:
+                return
+            case <-winch:
+                resizePTY(ptyFile)
+                drawStatusBar()
+                drawTUI()
+            }
+        }
+    }()
+}
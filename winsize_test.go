@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestPTYRows(t *testing.T) {
+    tests := []struct {
+        height         int
+        statusBar, tui bool
+        want           int
+    }{
+        {40, false, false, 40},
+        {40, true, false, 39},
+        {40, true, true, 32},
+        {5, false, true, 1},
+    }
+    for _, tt := range tests {
+        if got := ptyRows(tt.height, tt.statusBar, tt.tui); got != tt.want {
+            t.Errorf("ptyRows(%d, %v, %v) = %d, want %d", tt.height, tt.statusBar, tt.tui, got, tt.want)
+        }
+    }
+}